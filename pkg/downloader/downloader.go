@@ -6,7 +6,6 @@ import (
 	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"sort"
 	"strings"
 	"time"
@@ -63,7 +62,7 @@ type LocalFilterInfo struct {
 	DownloadedDuplicateID int // number of duplicate occurrences beyond the first for each ID
 	TopDuplicateIDs       []IDCount
 
-	StorageFile     string
+	StorageBlock    string
 	StorageExists   bool
 	StorageModTime  string
 	StoredFlats     int
@@ -87,7 +86,7 @@ func (i *LocalFilterInfo) String() string {
 			i.DownloadedFlats, i.DownloadedUniqueIDs, i.DownloadedZeroIDs, i.DownloadedDuplicateID),
 		fmt.Sprintf("pagesFetched=%d/%d", i.PagesFetched, i.LastPage),
 		fmt.Sprintf("storage=%q (exists=%t, modTime=%s, stored=%d flats, uniqueIDs=%d, zeroIDs=%d)",
-			i.StorageFile, i.StorageExists, i.StorageModTime, i.StoredFlats, i.StoredUniqueIDs, i.StoredZeroIDs),
+			i.StorageBlock, i.StorageExists, i.StorageModTime, i.StoredFlats, i.StoredUniqueIDs, i.StoredZeroIDs),
 		fmt.Sprintf("overlapUniqueIDs=%d, newUniqueIDs=%d", i.OverlapUniqueIDs, i.NewUniqueIDs),
 		fmt.Sprintf("newFlatsAfterIDFilter=%d, returnedMessages=%d", i.NewFlatsAfterIDFilter, i.ReturnedMessages),
 	}
@@ -366,7 +365,7 @@ func summarizeFlatIDs(flats []flatstorage.Flat) (uniqueIDs map[int64]int, zeroID
 	return uniqueIDs, zeroIDs, duplicateOccurrences, dups
 }
 
-func GetFlats(blockID int64) (messages []string, updateCallback func() error, info *LocalFilterInfo, err error) {
+func GetFlats(blockID int64) (updates *flatstorage.FlatUpdates, updateCallback func() ([]flatstorage.FlatEvent, error), info *LocalFilterInfo, err error) {
 	u, err := url.Parse(fmt.Sprintf("%v/%v", PikUrl, blockID))
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to build flats url: %w", err)
@@ -445,14 +444,14 @@ func GetFlats(blockID int64) (messages []string, updateCallback func() error, in
 	info.DownloadedDuplicateID = downloadedDupOccur
 	info.TopDuplicateIDs = topDup
 
-	info.StorageFile = flatstorage.GetStorageFileName(origMsgData)
-	if info.StorageFile != "" {
-		if st, statErr := os.Stat(info.StorageFile); statErr == nil {
+	info.StorageBlock = origMsgData.GetBlockSlug()
+	if info.StorageBlock != "" {
+		if st, statErr := flatstorage.Store.Stat(info.StorageBlock); statErr == nil && st.Exists {
 			info.StorageExists = true
-			info.StorageModTime = st.ModTime().Format(time.RFC3339)
+			info.StorageModTime = st.ModTime.Format(time.RFC3339)
 		}
 
-		if oldMsg, readErr := flatstorage.ReadFlatStorage(info.StorageFile); readErr == nil && oldMsg != nil {
+		if oldMsg, readErr := flatstorage.Store.Load(info.StorageBlock); readErr == nil && oldMsg != nil {
 			info.StoredFlats = len(oldMsg.Flats)
 			oldIDs, oldZero, _, _ := summarizeFlatIDs(oldMsg.Flats)
 			info.StoredUniqueIDs = len(oldIDs)
@@ -469,20 +468,19 @@ func GetFlats(blockID int64) (messages []string, updateCallback func() error, in
 		}
 	}
 
-	// filter through local file (MVP)
-	var res []string
-	res, err = flatstorage.FilterWithFlatStorage(msgData)
+	// filter through the stored flats
+	updates, err = flatstorage.FilterWithFlatStorage(msgData)
 	if err != nil {
 		return nil, nil, info, fmt.Errorf("err while reading/updating local Flats file: %v", err)
 	}
 
-	updateCallback = func() error {
-		_, err = flatstorage.UpdateFlatStorage(origMsgData)
-		return err
+	updateCallback = func() ([]flatstorage.FlatEvent, error) {
+		_, events, err := flatstorage.UpdateFlatStorage(origMsgData)
+		return events, err
 	}
 
 	info.NewFlatsAfterIDFilter = len(msgData.Flats)
-	info.ReturnedMessages = len(res)
+	info.ReturnedMessages = len(updates.Strings())
 
-	return res, updateCallback, info, nil
+	return updates, updateCallback, info, nil
 }
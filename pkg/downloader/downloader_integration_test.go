@@ -3,7 +3,6 @@ package downloader
 import (
 	"encoding/json"
 	"os"
-	"path/filepath"
 	"testing"
 
 	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
@@ -64,7 +63,7 @@ func TestGetFlats_Live_FetchesAllAndStores(t *testing.T) {
 		t.Fatalf("unexpected stats.count=%d", stats.Data.Stats.Count)
 	}
 
-	msgs, updateCallback, info, err := GetFlats(blockID)
+	updates, updateCallback, info, err := GetFlats(blockID)
 	if err != nil {
 		t.Fatalf("GetFlats(%d): %v", blockID, err)
 	}
@@ -74,7 +73,7 @@ func TestGetFlats_Live_FetchesAllAndStores(t *testing.T) {
 	if info == nil {
 		t.Fatalf("expected non-nil info")
 	}
-	if len(msgs) == 0 {
+	if updates.Empty() {
 		t.Fatalf("expected some messages (got 0)")
 	}
 
@@ -90,22 +89,19 @@ func TestGetFlats_Live_FetchesAllAndStores(t *testing.T) {
 	}
 
 	// Store and validate we can read the same set back.
-	if err := updateCallback(); err != nil {
+	if _, err := updateCallback(); err != nil {
 		t.Fatalf("updateCallback: %v", err)
 	}
-	if info.StorageFile == "" {
-		t.Fatalf("expected info.StorageFile to be set")
+	if info.StorageBlock == "" {
+		t.Fatalf("expected info.StorageBlock to be set")
 	}
-	if !filepath.IsAbs(info.StorageFile) {
-		// storage file paths are relative to CWD; that's expected here
-		if _, err := os.Stat(info.StorageFile); err != nil {
-			t.Fatalf("stat storage file %q: %v", info.StorageFile, err)
-		}
+	if st, err := flatstorage.Store.Stat(info.StorageBlock); err != nil || !st.Exists {
+		t.Fatalf("Store.Stat(%q): exists=%t, err=%v", info.StorageBlock, st.Exists, err)
 	}
 
-	stored, err := flatstorage.ReadFlatStorage(info.StorageFile)
+	stored, err := flatstorage.Store.Load(info.StorageBlock)
 	if err != nil {
-		t.Fatalf("ReadFlatStorage(%q): %v", info.StorageFile, err)
+		t.Fatalf("Store.Load(%q): %v", info.StorageBlock, err)
 	}
 	if stored == nil {
 		t.Fatalf("expected non-nil stored msg")
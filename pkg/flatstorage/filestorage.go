@@ -1,13 +1,11 @@
 package flatstorage
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/georgri/pik_tg_bot/pkg/util"
 	"os"
 	"strings"
-	"sync"
 	"time"
 )
 
@@ -22,88 +20,58 @@ const (
 	DefaultExtremePriceDropPercentThreshold = 30
 )
 
-var FileMutex sync.RWMutex
+// FlatUpdates is the result of comparing freshly downloaded flats with the local storage
+type FlatUpdates struct {
+	NewFlats *MessageData
+	// DroppedFlats are the known flats with any price drop, notifications are decided by the thresholds
+	DroppedFlats []Flat
 
-func ReadFlatStorage(fileName string) (*MessageData, error) {
-	msgData := &MessageData{}
-
-	FileMutex.RLock()
-	defer FileMutex.RUnlock()
-
-	if !FileExistsNonBlocking(fileName) {
-		return msgData, nil
-	}
-
-	// read all from file fileFlatStorage
-	content, err := os.ReadFile(fileName)
-	if err != nil {
-		return nil, err
-	} else {
-		// unmarshal into json
-		err = json.Unmarshal(content, &msgData)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	return msgData, nil
+	// price drops according to the thresholds, see WithThresholds
+	PriceDrops        *PriceDropMessageData
+	ExtremePriceDrops *PriceDropMessageData
 }
 
-// FilterWithFlatStorage filter through local file (MVP)
-func FilterWithFlatStorage(msg *MessageData) ([]string, error) {
+// FilterWithFlatStorage compares the downloaded flats with the stored ones
+func FilterWithFlatStorage(msg *MessageData) (*FlatUpdates, error) {
 	if msg == nil || len(msg.Flats) == 0 {
-		return []string{msg.String()}, nil
+		return &FlatUpdates{NewFlats: msg}, nil
 	}
 
-	storageFileName := GetStorageFileName(msg)
-	oldMessageData, err := ReadFlatStorage(storageFileName)
+	oldMessageData, err := Store.Load(msg.GetBlockSlug())
 	if err != nil {
 		return nil, err
 	}
 
-	return FilterWithFlatStorageHelper(oldMessageData, msg), nil
+	return GetFlatUpdates(oldMessageData, msg), nil
 }
 
 func FilterWithFlatStorageHelper(oldMsg, newMsg *MessageData) []string {
+	return GetFlatUpdates(oldMsg, newMsg).Strings()
+}
+
+// GetFlatUpdates finds new flats and price drops, the price drops are classified with the default thresholds
+func GetFlatUpdates(oldMsg, newMsg *MessageData) *FlatUpdates {
 	// gen old map
 	oldFlatsMap := make(map[int64]int)
 	for oldIndex := range oldMsg.Flats {
 		oldFlatsMap[oldMsg.Flats[oldIndex].ID] = oldIndex
 	}
 
-	var priceDropList []Flat
-	var extremePriceDropList []Flat
+	var droppedFlats []Flat
 	for i := range newMsg.Flats {
 		oldIndex, ok := oldFlatsMap[newMsg.Flats[i].ID]
 		if !ok {
-			// check if price of new flat is below average
-			if newMsg.Flats[i].GetPriceBelowAveragePercentage() <= -DefaultExtremePriceDropPercentThreshold {
-				extremePriceDropList = append(extremePriceDropList, newMsg.Flats[i])
-			}
 			continue // skip new flats
 		}
 		newMsg.Flats[i].OldPrice = oldMsg.Flats[oldIndex].Price
-		if newMsg.Flats[i].IsPriceDroppedByAtLeast(DefaultExtremePriceDropPercentThreshold) && newMsg.Flats[i].GetPriceBelowAveragePercentage() <= -DefaultBelowAverageThreshold {
-			extremePriceDropList = append(extremePriceDropList, newMsg.Flats[i])
-		} else if newMsg.Flats[i].IsPriceDroppedByAtLeast(DefaultPriceDropPercentThreshold) {
-			priceDropList = append(priceDropList, newMsg.Flats[i])
+		if newMsg.Flats[i].Price < newMsg.Flats[i].OldPrice {
+			droppedFlats = append(droppedFlats, newMsg.Flats[i])
 		}
 	}
 
-	var priceDropMsg *PriceDropMessageData
-	if len(priceDropList) > 0 {
-		priceDropMsg = &PriceDropMessageData{
-			Flats:                     priceDropList,
-			PriceDropPercentThreshold: DefaultPriceDropPercentThreshold,
-		}
-	}
-
-	var extremePriceDropMsg *PriceDropMessageData
-	if len(extremePriceDropList) > 0 {
-		extremePriceDropMsg = &PriceDropMessageData{
-			Flats:                     extremePriceDropList,
-			PriceDropPercentThreshold: DefaultExtremePriceDropPercentThreshold,
-		}
+	res := &FlatUpdates{
+		NewFlats:     newMsg,
+		DroppedFlats: droppedFlats,
 	}
 
 	// filter out existing Flats by ID
@@ -116,33 +84,161 @@ func FilterWithFlatStorageHelper(oldMsg, newMsg *MessageData) []string {
 		return newMsg.Flats[i].ID
 	})
 
-	var res []string
-	msgStr := newMsg.String()
+	return res.WithThresholds(nil)
+}
+
+// WithThresholds returns a copy of the updates with the price drops classified according to the thresholds
+func (u *FlatUpdates) WithThresholds(thresholds *PriceDropThresholds) *FlatUpdates {
+	if u == nil {
+		return nil
+	}
+	res := &FlatUpdates{
+		NewFlats:     u.NewFlats,
+		DroppedFlats: u.DroppedFlats,
+	}
+
+	var newFlats []Flat
+	if u.NewFlats != nil {
+		newFlats = u.NewFlats.Flats
+	}
+	priceDropList, extremePriceDropList := thresholds.classifyPriceDrops(newFlats, u.DroppedFlats)
+
+	if len(priceDropList) > 0 {
+		res.PriceDrops = &PriceDropMessageData{
+			Flats:                     priceDropList,
+			PriceDropPercentThreshold: int8(thresholds.GetPriceDrop()),
+		}
+	}
+
+	if len(extremePriceDropList) > 0 {
+		res.ExtremePriceDrops = &PriceDropMessageData{
+			Flats:                     extremePriceDropList,
+			PriceDropPercentThreshold: int8(thresholds.GetExtremePriceDrop()),
+		}
+	}
+
+	return res
+}
+
+// Filter returns a copy of the updates with the flats matching the filter only
+func (u *FlatUpdates) Filter(filter *FlatFilter) *FlatUpdates {
+	if u == nil {
+		return nil
+	}
+	var droppedFlats []Flat
+	for i := range u.DroppedFlats {
+		if filter.Match(&u.DroppedFlats[i]) {
+			droppedFlats = append(droppedFlats, u.DroppedFlats[i])
+		}
+	}
+	return &FlatUpdates{
+		NewFlats:          filter.FilterMessageData(u.NewFlats),
+		DroppedFlats:      droppedFlats,
+		PriceDrops:        u.PriceDrops.Filter(filter),
+		ExtremePriceDrops: u.ExtremePriceDrops.Filter(filter),
+	}
+}
+
+// WithoutPriceDrops removes the flats from the price drops, e.g. the ones already notified about by other means
+func (u *FlatUpdates) WithoutPriceDrops(flatIDs map[int64]bool) *FlatUpdates {
+	if u == nil || u.PriceDrops == nil || len(flatIDs) == 0 {
+		return u
+	}
+	return &FlatUpdates{
+		NewFlats:          u.NewFlats,
+		DroppedFlats:      u.DroppedFlats,
+		PriceDrops:        u.PriceDrops.Without(flatIDs),
+		ExtremePriceDrops: u.ExtremePriceDrops,
+	}
+}
+
+// WithoutExtremePriceDrops removes the flats from the extreme price drops, e.g. the ones already sent to all known chats
+func (u *FlatUpdates) WithoutExtremePriceDrops(flatIDs map[int64]bool) *FlatUpdates {
+	if u == nil || u.ExtremePriceDrops == nil || len(flatIDs) == 0 {
+		return u
+	}
+	return &FlatUpdates{
+		NewFlats:          u.NewFlats,
+		DroppedFlats:      u.DroppedFlats,
+		PriceDrops:        u.PriceDrops,
+		ExtremePriceDrops: u.ExtremePriceDrops.Without(flatIDs),
+	}
+}
+
+// Empty returns true if there is nothing to notify about with any thresholds
+func (u *FlatUpdates) Empty() bool {
+	return u == nil || (len(u.DroppedFlats) == 0 && len(u.Strings()) == 0)
+}
+
+// Audience tells who the notification is meant for
+type Audience int
+
+const (
+	AudienceSubscribers Audience = iota // the chats subscribed to the complex
+	AudienceAllChats                    // every known chat which didn't opt out, e.g. extreme price drops
+)
+
+// UpdateMessage is a rendered notification together with the flats it mentions
+type UpdateMessage struct {
+	Text     string
+	Flats    []Flat
+	Audience Audience
+}
+
+// Messages renders the updates into messages, extreme price drops are meant for all known chats
+func (u *FlatUpdates) Messages(lang util.Lang) []UpdateMessage {
+	if u == nil {
+		return nil
+	}
+
+	var res []UpdateMessage
+	msgStr := u.NewFlats.StringWithOptions(false, false, lang)
 	if len(strings.TrimSpace(msgStr)) > 0 {
-		res = append(res, msgStr)
+		res = append(res, UpdateMessage{Text: msgStr, Flats: u.NewFlats.Flats})
 	}
 
-	priceDropStr := priceDropMsg.String()
+	priceDropStr := u.PriceDrops.StringWithHeader(MsgPriceDropsHeader, lang)
 	if len(strings.TrimSpace(priceDropStr)) > 0 {
-		res = append(res, priceDropStr)
+		res = append(res, UpdateMessage{Text: priceDropStr, Flats: u.PriceDrops.Flats})
 	}
 
-	extremePriceDropStr := extremePriceDropMsg.StringWithPrompt(fmt.Sprintf("extreme price drops in"))
+	extremePriceDropStr := u.ExtremePriceDrops.StringWithHeader(MsgExtremePriceDropsHeader, lang)
 	if len(strings.TrimSpace(extremePriceDropStr)) > 0 {
-		res = append(res, "!!! "+extremePriceDropStr) // add magic symbol to send to all known chats
+		res = append(res, UpdateMessage{
+			Text:     "!!! " + extremePriceDropStr,
+			Flats:    u.ExtremePriceDrops.Flats,
+			Audience: AudienceAllChats,
+		})
 	}
 
 	return res
 }
 
+// Strings renders the messages in the default language
+func (u *FlatUpdates) Strings() []string {
+	var res []string
+	for _, msg := range u.Messages(util.DefaultLang) {
+		res = append(res, msg.Text)
+	}
+	return res
+}
+
 type oldFlatInfo struct {
-	Created      string
-	Price        int64
-	Status       string
-	PriceHistory []PriceEntry
+	Created        string
+	Price          int64
+	Status         string
+	SettlementDate string
+	FinishType     int8
+	PriceHistory   []PriceEntry
+	Lifecycle      Lifecycle
+
+	SettlementHistory SettlementHistory
+	FinishHistory     FinishHistory
 }
 
-func MergeNewFlatsIntoOld(oldMsg, newMsg *MessageData) *MessageData {
+// MergeNewFlatsIntoOld updates the stored flats with the downloaded ones and tracks their lifecycle,
+// returns the changes of the known flats
+func MergeNewFlatsIntoOld(oldMsg, newMsg *MessageData) (*MessageData, []FlatEvent) {
 	newMsg.Flats = util.FilterUnique(newMsg.Flats, func(i int) int64 {
 		return newMsg.Flats[i].ID
 	})
@@ -166,13 +262,21 @@ func MergeNewFlatsIntoOld(oldMsg, newMsg *MessageData) *MessageData {
 			oldMsg.Flats[i].Updated = oldMsg.Flats[i].Created
 		}
 		oldFlatsMap[oldMsg.Flats[i].ID] = oldFlatInfo{
-			Created:      oldMsg.Flats[i].Created,
-			Price:        oldMsg.Flats[i].Price,
-			Status:       oldMsg.Flats[i].Status,
-			PriceHistory: oldMsg.Flats[i].GetPriceHistory(),
+			Created:        oldMsg.Flats[i].Created,
+			Price:          oldMsg.Flats[i].Price,
+			Status:         oldMsg.Flats[i].Status,
+			SettlementDate: string(oldMsg.Flats[i].SettlementDate),
+			FinishType:     oldMsg.Flats[i].FinishType,
+			PriceHistory:   oldMsg.Flats[i].GetPriceHistory(),
+			Lifecycle:      oldMsg.Flats[i].Lifecycle,
+
+			SettlementHistory: oldMsg.Flats[i].SettlementHistory,
+			FinishHistory:     oldMsg.Flats[i].FinishHistory,
 		}
 	}
 
+	events := goneFlatEvents(oldMsg.Flats, newFlatsMap, now)
+
 	// filter out existing old Flats by ID
 	oldMsg.Flats = util.FilterSliceInPlace(oldMsg.Flats, func(i int) bool {
 		_, ok := newFlatsMap[oldMsg.Flats[i].ID]
@@ -186,6 +290,18 @@ func MergeNewFlatsIntoOld(oldMsg, newMsg *MessageData) *MessageData {
 			newMsg.Flats[i].Created = oldInfo.Created
 			newMsg.Flats[i].OldPrice = oldInfo.Price
 			newMsg.Flats[i].PriceHistory = oldInfo.PriceHistory
+			newMsg.Flats[i].Lifecycle = append(Lifecycle(nil), oldInfo.Lifecycle...)
+			newMsg.Flats[i].recordHandover(&oldInfo, now)
+
+			events = append(events, diffFlats(&oldInfo, &newMsg.Flats[i])...)
+
+			if oldInfo.Lifecycle.Last() == StageGone {
+				newMsg.Flats[i].addStage(now, StageReturned)
+				events = append(events, FlatEvent{Type: FlatEventReturned, Flat: newMsg.Flats[i]})
+			}
+			if stage, ok := statusStage(oldInfo.Status, newMsg.Flats[i].Status); ok {
+				newMsg.Flats[i].addStage(now, stage)
+			}
 
 			size := len(oldInfo.PriceHistory)
 
@@ -205,6 +321,9 @@ func MergeNewFlatsIntoOld(oldMsg, newMsg *MessageData) *MessageData {
 				Price:  newMsg.Flats[i].Price,
 				Status: newMsg.Flats[i].Status,
 			})
+			newMsg.Flats[i].addStage(now, StageAppeared)
+			newMsg.Flats[i].addSettlement(now)
+			newMsg.Flats[i].addFinish(now)
 		}
 		newMsg.Flats[i].Updated = now
 	}
@@ -212,68 +331,22 @@ func MergeNewFlatsIntoOld(oldMsg, newMsg *MessageData) *MessageData {
 	// dump new into old
 	oldMsg.Flats = append(oldMsg.Flats, newMsg.Flats...)
 
-	return oldMsg
+	return oldMsg, events
 }
 
-// UpdateFlatStorage update local file (MVP)
-func UpdateFlatStorage(msg *MessageData) (numUpdated int, err error) {
+// UpdateFlatStorage merges the downloaded flats into the stored ones
+func UpdateFlatStorage(msg *MessageData) (numUpdated int, events []FlatEvent, err error) {
 	if msg == nil || len(msg.Flats) == 0 {
-		return 0, fmt.Errorf("did not update anything")
-	}
-
-	storageFileName := GetStorageFileNameByEnv(msg)
-	oldMessageData, err := ReadFlatStorage(storageFileName)
-	if err != nil {
-		return 0, err
+		return 0, nil, fmt.Errorf("did not update anything")
 	}
 
-	oldMessageData = MergeNewFlatsIntoOld(oldMessageData, msg)
-
 	numUpdated = len(msg.Flats)
-
-	newStorageFileName := GetStorageFileNameByEnv(msg)
-	newContent, err := json.Marshal(oldMessageData)
+	events, err = Store.Merge(msg.GetBlockSlug(), msg)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
-	FileMutex.Lock()
-	defer FileMutex.Unlock()
-
-	err = os.WriteFile(newStorageFileName, newContent, 0644)
-	if err != nil {
-		return 0, err
-	}
-
-	return numUpdated, nil
-}
-
-func GetStorageFileNameByEnv(msg *MessageData) string {
-	blockSlug := msg.GetBlockSlug()
-	return GetStorageFileNameByBlockSlugAndEnv(blockSlug)
-}
-
-func GetStorageFileName(msg *MessageData) string {
-	blockSlug := msg.GetBlockSlug()
-	return GetStorageFileNameByBlockSlug(blockSlug)
-}
-
-func GetStorageFileNameByBlockSlugAndEnv(blockSlug string) string {
-	blockSlug = util.EmbedSlug(blockSlug)
-	return fmt.Sprintf("%v/%v_%v.%v", storageDir, blockSlug, util.GetEnvType().String(), storageFormat)
-}
-
-func GetStorageFileNameByBlockSlug(blockSlug string) string {
-	// First, try find file without any chatID but with envtype
-	targetFileName := GetStorageFileNameByBlockSlugAndEnv(blockSlug)
-	if FileExists(targetFileName) {
-		return targetFileName
-	}
-	fileNameWithChatID := fmt.Sprintf("%v/%v_%v.%v", storageDir, util.EmbedSlug(blockSlug), 0, storageFormat)
-	if FileExists(fileNameWithChatID) {
-		return fileNameWithChatID
-	}
-	return targetFileName
+	return numUpdated, events, nil
 }
 
 func FileExists(filename string) bool {
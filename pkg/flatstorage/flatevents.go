@@ -0,0 +1,130 @@
+package flatstorage
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"strconv"
+)
+
+type FlatEventType string
+
+const (
+	FlatEventPrice      FlatEventType = "price"
+	FlatEventStatus     FlatEventType = "status"
+	FlatEventSettlement FlatEventType = "settlement"
+	FlatEventFinish     FlatEventType = "finish"
+	FlatEventGone       FlatEventType = "gone"     // the flat disappeared from the feed
+	FlatEventReturned   FlatEventType = "returned" // the flat appeared in the feed again after being gone
+)
+
+// FlatEvent is a change of a single known flat detected while merging fresh flats into the storage
+type FlatEvent struct {
+	Type FlatEventType
+	Flat Flat // the new state of the flat, or the last known one for FlatEventGone
+
+	// raw values: price in rub, status, settlement quarter or finish type
+	OldValue string
+	NewValue string
+}
+
+func (e *FlatEvent) String() string {
+	return e.Format(util.DefaultLang)
+}
+
+// Format renders the event in the language
+func (e *FlatEvent) Format(lang util.Lang) string {
+	switch e.Type {
+	case FlatEventGone:
+		return util.Msg(lang, MsgEventGone)
+	case FlatEventReturned:
+		return util.Msg(lang, MsgEventReturned)
+	case FlatEventPrice:
+		return util.Msg(lang, MsgEventChange, util.Msg(lang, MsgEventPrice),
+			formatPriceValue(e.OldValue), formatPriceValue(e.NewValue))
+	case FlatEventStatus:
+		return util.Msg(lang, MsgEventChange, util.Msg(lang, MsgEventStatus), e.OldValue, e.NewValue)
+	case FlatEventSettlement:
+		return util.Msg(lang, MsgEventChange, util.Msg(lang, MsgEventSettlement),
+			FormatSettlementQuarter(e.OldValue, lang), FormatSettlementQuarter(e.NewValue, lang))
+	case FlatEventFinish:
+		return util.Msg(lang, MsgEventChange, util.Msg(lang, MsgEventFinish),
+			formatFinishTypeValue(e.OldValue, lang), formatFinishTypeValue(e.NewValue, lang))
+	}
+	return fmt.Sprintf("%v: %v → %v", e.Type, e.OldValue, e.NewValue)
+}
+
+// IsReleased checks if the flat came back from the reserve
+func (e *FlatEvent) IsReleased() bool {
+	stage, ok := statusStage(e.OldValue, e.NewValue)
+	return e.Type == FlatEventStatus && ok && stage == StageReleased
+}
+
+func formatPriceValue(value string) string {
+	price, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return value
+	}
+	return util.ThousandSep(price, " ") + "R"
+}
+
+func formatFinishTypeValue(value string, lang util.Lang) string {
+	finishType, err := strconv.ParseInt(value, 10, 8)
+	if err != nil {
+		return value
+	}
+	return GetFinishTypeString(int8(finishType), lang)
+}
+
+// diffFlats makes events for all the tracked changes between the old and the new state of the flat
+func diffFlats(oldFlat *oldFlatInfo, newFlat *Flat) []FlatEvent {
+	var events []FlatEvent
+	add := func(eventType FlatEventType, oldValue, newValue string) {
+		events = append(events, FlatEvent{
+			Type:     eventType,
+			Flat:     *newFlat,
+			OldValue: oldValue,
+			NewValue: newValue,
+		})
+	}
+
+	if oldFlat.Price != 0 && oldFlat.Price != newFlat.Price {
+		add(FlatEventPrice, strconv.FormatInt(oldFlat.Price, 10), strconv.FormatInt(newFlat.Price, 10))
+	}
+	// old storage files may have no status or settlement date, don't treat it as a change
+	if oldFlat.Status != "" && oldFlat.Status != newFlat.Status {
+		add(FlatEventStatus, oldFlat.Status, newFlat.Status)
+	}
+	oldQuarter := GetSettlementQuarter(oldFlat.SettlementDate)
+	newQuarter := GetSettlementQuarter(string(newFlat.SettlementDate))
+	if oldFlat.SettlementDate != "" && oldQuarter != newQuarter {
+		add(FlatEventSettlement, oldQuarter, newQuarter)
+	}
+	if oldFlat.FinishType != newFlat.FinishType {
+		add(FlatEventFinish, strconv.Itoa(int(oldFlat.FinishType)), strconv.Itoa(int(newFlat.FinishType)))
+	}
+	return events
+}
+
+// goneFlatEvents makes events for the flats that were present in the latest download but are missing in the new one,
+// the flats are marked gone at the given time
+func goneFlatEvents(oldFlats []Flat, newFlatsMap map[int64]struct{}, now string) []FlatEvent {
+	var latestUpdate string
+	for i := range oldFlats {
+		if oldFlats[i].Updated > latestUpdate {
+			latestUpdate = oldFlats[i].Updated
+		}
+	}
+
+	var events []FlatEvent
+	for i := range oldFlats {
+		if _, ok := newFlatsMap[oldFlats[i].ID]; ok || oldFlats[i].Updated != latestUpdate {
+			continue
+		}
+		oldFlats[i].addStage(now, StageGone)
+		events = append(events, FlatEvent{
+			Type: FlatEventGone,
+			Flat: oldFlats[i],
+		})
+	}
+	return events
+}
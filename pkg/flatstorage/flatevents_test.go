@@ -0,0 +1,57 @@
+package flatstorage
+
+import (
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeNewFlatsIntoOld_Events(t *testing.T) {
+	latest := "2024-06-01T12:00:00Z"
+	oldMsg := &MessageData{
+		Flats: []Flat{
+			{ID: 1, Price: 100, Status: "free", SettlementDate: "2025-06-15", FinishType: 1, Updated: latest},
+			{ID: 2, Price: 100, Status: "free", SettlementDate: "2025-06-15", FinishType: 1, Updated: latest},
+			{ID: 3, Price: 100, Status: "free", Updated: latest},
+			{ID: 4, Price: 100, Status: "free", Updated: "2024-05-01T12:00:00Z"},
+		},
+	}
+	newMsg := &MessageData{
+		Flats: []Flat{
+			{ID: 1, Price: 90, Status: "reserve", SettlementDate: "2025-10-15", FinishType: 2},
+			{ID: 2, Price: 100, Status: "free", SettlementDate: "2025-05-15", FinishType: 1},
+			{ID: 5, Price: 100, Status: "free"},
+		},
+	}
+
+	merged, events := MergeNewFlatsIntoOld(oldMsg, newMsg)
+	require.Len(t, merged.Flats, 5)
+
+	eventTypes := make(map[int64][]FlatEventType)
+	for _, event := range events {
+		eventTypes[event.Flat.ID] = append(eventTypes[event.Flat.ID], event.Type)
+	}
+
+	require.Equal(t, []FlatEventType{FlatEventPrice, FlatEventStatus, FlatEventSettlement, FlatEventFinish}, eventTypes[1])
+	require.Empty(t, eventTypes[2], "same settlement quarter is not a change")
+	require.Equal(t, []FlatEventType{FlatEventGone}, eventTypes[3])
+	require.Empty(t, eventTypes[4], "flat was gone before the latest download")
+	require.Empty(t, eventTypes[5], "new flats have no events")
+
+	for _, event := range events {
+		if event.Flat.ID == 1 && event.Type == FlatEventSettlement {
+			require.Equal(t, "settlement: 25Q2 → 25Q4", event.String())
+		}
+	}
+}
+
+func TestFlatEvent_Format(t *testing.T) {
+	event := &FlatEvent{Type: FlatEventPrice, OldValue: "12000000", NewValue: "11500000"}
+	require.Equal(t, "price: 12 000 000R → 11 500 000R", event.String())
+	require.Equal(t, "цена: 12 000 000R → 11 500 000R", event.Format(util.LangRu))
+
+	event = &FlatEvent{Type: FlatEventFinish, OldValue: "0", NewValue: "1"}
+	require.Equal(t, "finish: no finishing → finishing", event.String())
+	require.Equal(t, "отделка: без отделки → отделка", event.Format(util.LangRu))
+}
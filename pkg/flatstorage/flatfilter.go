@@ -0,0 +1,346 @@
+package flatstorage
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"strconv"
+	"strings"
+)
+
+const (
+	FilterKeyRooms  = "rooms"
+	FilterKeyPrice  = "price"
+	FilterKeyArea   = "area"
+	FilterKeyFloor  = "floor"
+	FilterKeyFinish = "finish"
+	FilterKeySettle = "settle"
+
+	// areaPrecision is the precision of the area returned by PIK API, used for strict comparisons
+	areaPrecision = 0.1
+)
+
+// FilterSyntax is a short human-readable description of the filter arguments
+const FilterSyntax = "rooms=1,2 price>8m price<=15m area>=40 area<60 floor>2 floor<20 finish=0,1,2 settle<=26Q4"
+
+// FlatFilter describes which flats are interesting for a subscriber.
+// All the bounds are inclusive, zero values mean "no restriction".
+type FlatFilter struct {
+	Rooms       []int8  `json:"rooms,omitempty"`
+	MinPrice    int64   `json:"min_price,omitempty"`
+	MaxPrice    int64   `json:"max_price,omitempty"`
+	MinArea     float64 `json:"min_area,omitempty"`
+	MaxArea     float64 `json:"max_area,omitempty"`
+	MinFloor    int64   `json:"min_floor,omitempty"`
+	MaxFloor    int64   `json:"max_floor,omitempty"`
+	FinishTypes []int8  `json:"finish_types,omitempty"`
+
+	// settlement quarters in the GetSettlementQuarter format, e.g. 25Q3
+	MinSettlement string `json:"min_settlement,omitempty"`
+	MaxSettlement string `json:"max_settlement,omitempty"`
+}
+
+// ParseFlatFilter parses args like "rooms=1,2 price<15m area>50 finish=1 settle<=26Q4"
+func ParseFlatFilter(args []string) (*FlatFilter, error) {
+	filter := &FlatFilter{}
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		if len(arg) == 0 {
+			continue
+		}
+		key, op, value, err := splitFilterArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		err = filter.apply(key, op, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", arg, err)
+		}
+	}
+	return filter, nil
+}
+
+func splitFilterArg(arg string) (key, op, value string, err error) {
+	index := strings.IndexAny(arg, "<>=")
+	if index <= 0 {
+		return "", "", "", fmt.Errorf("invalid filter %q, expected something like %q", arg, "price<15m")
+	}
+	key = strings.ToLower(arg[:index])
+	rest := arg[index:]
+	for _, candidate := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasPrefix(rest, candidate) {
+			op = candidate
+			break
+		}
+	}
+	value = strings.TrimSpace(rest[len(op):])
+	if len(value) == 0 {
+		return "", "", "", fmt.Errorf("empty value in filter %q", arg)
+	}
+	return key, op, value, nil
+}
+
+func (f *FlatFilter) apply(key, op, value string) error {
+	switch key {
+	case FilterKeyRooms:
+		if op != "=" {
+			return fmt.Errorf("only '=' is supported for %v", key)
+		}
+		rooms, err := parseInt8List(value)
+		if err != nil {
+			return err
+		}
+		f.Rooms = rooms
+	case FilterKeyFinish:
+		if op != "=" {
+			return fmt.Errorf("only '=' is supported for %v", key)
+		}
+		finishTypes, err := parseInt8List(value)
+		if err != nil {
+			return err
+		}
+		f.FinishTypes = finishTypes
+	case FilterKeyPrice:
+		price, err := ParsePrice(value)
+		if err != nil {
+			return err
+		}
+		f.MinPrice, f.MaxPrice = applyIntBound(f.MinPrice, f.MaxPrice, op, price)
+	case FilterKeyFloor:
+		floor, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		f.MinFloor, f.MaxFloor = applyIntBound(f.MinFloor, f.MaxFloor, op, floor)
+	case FilterKeyArea:
+		area, err := strconv.ParseFloat(strings.TrimSuffix(value, "m2"), 64)
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "<":
+			f.MaxArea = area - areaPrecision
+		case "<=":
+			f.MaxArea = area
+		case ">":
+			f.MinArea = area + areaPrecision
+		case ">=":
+			f.MinArea = area
+		case "=":
+			f.MinArea, f.MaxArea = area, area
+		}
+	case FilterKeySettle:
+		index, ok := settlementQuarterIndex(value)
+		if !ok {
+			return fmt.Errorf("expected quarter like 26Q4, got %v", value)
+		}
+		minIndex, maxIndex := applyIntBound(0, 0, op, index)
+		if minIndex != 0 {
+			f.MinSettlement = formatQuarterIndex(minIndex)
+		}
+		if maxIndex != 0 {
+			f.MaxSettlement = formatQuarterIndex(maxIndex)
+		}
+	default:
+		return fmt.Errorf("unknown filter key %v", key)
+	}
+	return nil
+}
+
+func applyIntBound(minValue, maxValue int64, op string, value int64) (int64, int64) {
+	switch op {
+	case "<":
+		maxValue = value - 1
+	case "<=":
+		maxValue = value
+	case ">":
+		minValue = value + 1
+	case ">=":
+		minValue = value
+	case "=":
+		minValue, maxValue = value, value
+	}
+	return minValue, maxValue
+}
+
+func parseInt8List(value string) ([]int8, error) {
+	var res []int8
+	for _, item := range strings.Split(value, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(item), 10, 8)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, int8(n))
+	}
+	return res, nil
+}
+
+// ParsePrice parses prices like 15000000, 15m, 15.5m or 800k
+func ParsePrice(value string) (int64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	multiplier := 1.0
+	if strings.HasSuffix(value, "m") {
+		multiplier = 1_000_000
+		value = strings.TrimSuffix(value, "m")
+	} else if strings.HasSuffix(value, "k") {
+		multiplier = 1_000
+		value = strings.TrimSuffix(value, "k")
+	}
+	price, err := strconv.ParseFloat(strings.ReplaceAll(value, "_", ""), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid price %v", value)
+	}
+	return int64(price * multiplier), nil
+}
+
+// settlementQuarterIndex converts 26Q4 into a comparable number
+func settlementQuarterIndex(quarter string) (int64, bool) {
+	year, q, ok := strings.Cut(strings.ToUpper(quarter), "Q")
+	if !ok {
+		return 0, false
+	}
+	yearNum, err := strconv.ParseInt(year, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	quarterNum, err := strconv.ParseInt(q, 10, 64)
+	if err != nil || quarterNum < 1 || quarterNum > 4 {
+		return 0, false
+	}
+	return yearNum*4 + quarterNum - 1, true
+}
+
+func formatQuarterIndex(index int64) string {
+	return fmt.Sprintf("%02dQ%v", index/4, index%4+1)
+}
+
+// IsEmpty returns true if the filter passes all the flats
+func (f *FlatFilter) IsEmpty() bool {
+	return f == nil || f.String() == ""
+}
+
+// Match checks if the flat satisfies the filter
+func (f *FlatFilter) Match(flat *Flat) bool {
+	if f == nil {
+		return true
+	}
+	if flat == nil {
+		return false
+	}
+	if len(f.Rooms) > 0 && !containsInt8(f.Rooms, flat.Rooms) {
+		return false
+	}
+	if len(f.FinishTypes) > 0 && !containsInt8(f.FinishTypes, flat.FinishType) {
+		return false
+	}
+	if f.MinPrice != 0 && flat.Price < f.MinPrice {
+		return false
+	}
+	if f.MaxPrice != 0 && flat.Price > f.MaxPrice {
+		return false
+	}
+	if f.MinArea != 0 && flat.Area < f.MinArea {
+		return false
+	}
+	if f.MaxArea != 0 && flat.Area > f.MaxArea {
+		return false
+	}
+	if f.MinFloor != 0 && flat.Floor < f.MinFloor {
+		return false
+	}
+	if f.MaxFloor != 0 && flat.Floor > f.MaxFloor {
+		return false
+	}
+	if f.MinSettlement != "" || f.MaxSettlement != "" {
+		// already settled flats have no quarter and count as the earliest ones
+		var flatIndex int64
+		if quarter := GetSettlementQuarter(string(flat.SettlementDate)); quarter != settledQuarter {
+			flatIndex, _ = settlementQuarterIndex(quarter)
+		}
+		if minIndex, ok := settlementQuarterIndex(f.MinSettlement); ok && flatIndex < minIndex {
+			return false
+		}
+		if maxIndex, ok := settlementQuarterIndex(f.MaxSettlement); ok && flatIndex > maxIndex {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterMessageData returns a copy of md with matching flats only
+func (f *FlatFilter) FilterMessageData(md *MessageData) *MessageData {
+	res := md.Copy()
+	if res == nil || f.IsEmpty() {
+		return res
+	}
+	res.Flats = util.FilterSliceInPlace(res.Flats, func(i int) bool {
+		return f.Match(&res.Flats[i])
+	})
+	return res
+}
+
+// String prints the filter in the same format ParseFlatFilter accepts
+func (f *FlatFilter) String() string {
+	if f == nil {
+		return ""
+	}
+	var parts []string
+	if len(f.Rooms) > 0 {
+		parts = append(parts, fmt.Sprintf("%v=%v", FilterKeyRooms, joinInt8List(f.Rooms)))
+	}
+	parts = append(parts, formatBounds(FilterKeyPrice, f.MinPrice, f.MaxPrice, formatPrice)...)
+	parts = append(parts, formatBounds(FilterKeyArea, f.MinArea, f.MaxArea, func(area float64) string {
+		return strconv.FormatFloat(area, 'f', -1, 64)
+	})...)
+	parts = append(parts, formatBounds(FilterKeyFloor, f.MinFloor, f.MaxFloor, func(floor int64) string {
+		return strconv.FormatInt(floor, 10)
+	})...)
+	if len(f.FinishTypes) > 0 {
+		parts = append(parts, fmt.Sprintf("%v=%v", FilterKeyFinish, joinInt8List(f.FinishTypes)))
+	}
+	parts = append(parts, formatBounds(FilterKeySettle, f.MinSettlement, f.MaxSettlement, func(quarter string) string {
+		return quarter
+	})...)
+	return strings.Join(parts, " ")
+}
+
+func formatBounds[T comparable](key string, minValue, maxValue T, format func(T) string) []string {
+	var zero T
+	if minValue != zero && minValue == maxValue {
+		return []string{fmt.Sprintf("%v=%v", key, format(minValue))}
+	}
+	var res []string
+	if minValue != zero {
+		res = append(res, fmt.Sprintf("%v>=%v", key, format(minValue)))
+	}
+	if maxValue != zero {
+		res = append(res, fmt.Sprintf("%v<=%v", key, format(maxValue)))
+	}
+	return res
+}
+
+func formatPrice(price int64) string {
+	if price%1_000_000 == 0 {
+		return fmt.Sprintf("%vm", price/1_000_000)
+	}
+	if price%1_000 == 0 {
+		return fmt.Sprintf("%vk", price/1_000)
+	}
+	return strconv.FormatInt(price, 10)
+}
+
+func joinInt8List(list []int8) string {
+	res := make([]string, 0, len(list))
+	for _, item := range list {
+		res = append(res, strconv.Itoa(int(item)))
+	}
+	return strings.Join(res, ",")
+}
+
+func containsInt8(list []int8, value int8) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package flatstorage
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFlatFilter_RoundTrip(t *testing.T) {
+	filter, err := ParseFlatFilter(strings.Fields("rooms=1,2 price>8m price<15m area>=40 floor<=20 finish=1 settle<=26Q4"))
+	require.NoError(t, err)
+
+	require.Equal(t, []int8{1, 2}, filter.Rooms)
+	require.Equal(t, int64(8_000_001), filter.MinPrice)
+	require.Equal(t, int64(14_999_999), filter.MaxPrice)
+	require.Equal(t, 40.0, filter.MinArea)
+	require.Equal(t, int64(20), filter.MaxFloor)
+	require.Equal(t, []int8{1}, filter.FinishTypes)
+	require.Equal(t, "26Q4", filter.MaxSettlement)
+
+	reparsed, err := ParseFlatFilter(strings.Fields(filter.String()))
+	require.NoError(t, err)
+	require.Equal(t, filter, reparsed)
+}
+
+func TestParseFlatFilter_Invalid(t *testing.T) {
+	for _, args := range []string{"rooms<2", "price<abc", "settle<26Q5", "color=red", "price"} {
+		_, err := ParseFlatFilter(strings.Fields(args))
+		require.Error(t, err, args)
+	}
+}
+
+func TestFlatFilter_Match(t *testing.T) {
+	flat := &Flat{
+		ID:             1,
+		Price:          12_000_000,
+		Rooms:          2,
+		Area:           55.5,
+		Floor:          7,
+		FinishType:     1,
+		SettlementDate: "2026-05-01",
+	}
+
+	tests := []struct {
+		args     string
+		expected bool
+	}{
+		{"", true},
+		{"rooms=1,2", true},
+		{"rooms=3", false},
+		{"price<15m", true},
+		{"price<12m", false},
+		{"price<=12m", true},
+		{"area>55.5", false},
+		{"area>=55.5", true},
+		{"floor>7", false},
+		{"finish=0,2", false},
+		{"settle<=26Q2", true},
+		{"settle<26Q2", false},
+		{"settle>=25Q1", true},
+	}
+
+	for _, test := range tests {
+		filter, err := ParseFlatFilter(strings.Fields(test.args))
+		require.NoError(t, err, test.args)
+		require.Equal(t, test.expected, filter.Match(flat), test.args)
+	}
+
+	// already settled flats are the earliest ones
+	settled := *flat
+	settled.SettlementDate = ""
+	filter, err := ParseFlatFilter([]string{"settle<=25Q1"})
+	require.NoError(t, err)
+	require.True(t, filter.Match(&settled))
+}
+
+func TestFlatUpdates_Filter(t *testing.T) {
+	oldMsg := &MessageData{
+		Flats: []Flat{
+			{ID: 1, Price: 100, Rooms: 1, BlockName: "TestBlock", BlockSlug: "tb"},
+			{ID: 2, Price: 100, Rooms: 2, BlockName: "TestBlock", BlockSlug: "tb"},
+		},
+	}
+	newMsg := &MessageData{
+		Flats: []Flat{
+			{ID: 1, Price: 80, Rooms: 1, BlockName: "TestBlock", BlockSlug: "tb"},
+			{ID: 2, Price: 80, Rooms: 2, BlockName: "TestBlock", BlockSlug: "tb"},
+			{ID: 3, Price: 100, Rooms: 1, BlockName: "TestBlock", BlockSlug: "tb"},
+			{ID: 4, Price: 100, Rooms: 2, BlockName: "TestBlock", BlockSlug: "tb"},
+		},
+	}
+
+	updates := GetFlatUpdates(oldMsg, newMsg)
+	require.Len(t, updates.NewFlats.Flats, 2)
+	require.Len(t, updates.PriceDrops.Flats, 2)
+
+	filtered := updates.Filter(&FlatFilter{Rooms: []int8{2}})
+	require.Len(t, filtered.NewFlats.Flats, 1)
+	require.Equal(t, int64(4), filtered.NewFlats.Flats[0].ID)
+	require.Len(t, filtered.PriceDrops.Flats, 1)
+	require.Equal(t, int64(2), filtered.PriceDrops.Flats[0].ID)
+
+	// the original updates stay intact
+	require.Len(t, updates.NewFlats.Flats, 2)
+	require.Len(t, updates.Strings(), 2)
+
+	require.True(t, updates.Filter(&FlatFilter{Rooms: []int8{3}}).Empty())
+}
@@ -0,0 +1,219 @@
+package flatstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+)
+
+// FlatStore keeps the known flats of every complex, the complexes are addressed by slug only
+type FlatStore interface {
+	// Load returns all the stored flats of the complex, no flats if the complex is not stored yet
+	Load(blockSlug string) (*MessageData, error)
+	// Merge merges the downloaded flats into the stored ones, see MergeNewFlatsIntoOld
+	Merge(blockSlug string, msg *MessageData) ([]FlatEvent, error)
+	// QueryFlats returns the stored flats of the complex matching the filter, nil filter matches all
+	QueryFlats(blockSlug string, filter *FlatFilter) ([]Flat, error)
+	// History returns the stored flat with its price, settlement, finish and lifecycle history, nil if unknown
+	History(blockSlug string, flatID int64) (*Flat, error)
+	// Stat tells if the complex is stored and when it was last merged
+	Stat(blockSlug string) (StoreStat, error)
+}
+
+type StoreStat struct {
+	Exists  bool
+	ModTime time.Time
+}
+
+// Store is the storage used by the bot and the downloader
+var Store FlatStore = NewJSONStore(storageDir)
+
+func queryFlats(store FlatStore, blockSlug string, filter *FlatFilter) ([]Flat, error) {
+	msgData, err := store.Load(blockSlug)
+	if err != nil {
+		return nil, err
+	}
+	var res []Flat
+	for i := range msgData.Flats {
+		if filter.Match(&msgData.Flats[i]) {
+			res = append(res, msgData.Flats[i])
+		}
+	}
+	return res, nil
+}
+
+func flatHistory(store FlatStore, blockSlug string, flatID int64) (*Flat, error) {
+	msgData, err := store.Load(blockSlug)
+	if err != nil {
+		return nil, err
+	}
+	for i := range msgData.Flats {
+		if msgData.Flats[i].ID == flatID {
+			return &msgData.Flats[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// jsonStore keeps every complex in its own JSON file per env, the whole file is rewritten on merge
+type jsonStore struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+func NewJSONStore(dir string) FlatStore {
+	return &jsonStore{dir: dir}
+}
+
+// fileName returns the file the complex is written to
+func (s *jsonStore) fileName(blockSlug string) string {
+	return fmt.Sprintf("%v/%v_%v.%v", s.dir, util.EmbedSlug(blockSlug), util.GetEnvType().String(), storageFormat)
+}
+
+// existingFileName returns the file the complex is read from, the old files have chat ID 0 instead of the env
+func (s *jsonStore) existingFileName(blockSlug string) string {
+	targetFileName := s.fileName(blockSlug)
+	if FileExists(targetFileName) {
+		return targetFileName
+	}
+	fileNameWithChatID := fmt.Sprintf("%v/%v_%v.%v", s.dir, util.EmbedSlug(blockSlug), 0, storageFormat)
+	if FileExists(fileNameWithChatID) {
+		return fileNameWithChatID
+	}
+	return targetFileName
+}
+
+func (s *jsonStore) read(fileName string) (*MessageData, error) {
+	msgData := &MessageData{}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !FileExistsNonBlocking(fileName) {
+		return msgData, nil
+	}
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &msgData)
+	if err != nil {
+		return nil, err
+	}
+
+	return msgData, nil
+}
+
+func (s *jsonStore) Load(blockSlug string) (*MessageData, error) {
+	return s.read(s.existingFileName(blockSlug))
+}
+
+func (s *jsonStore) Merge(blockSlug string, msg *MessageData) ([]FlatEvent, error) {
+	fileName := s.fileName(blockSlug)
+	oldMessageData, err := s.read(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	oldMessageData, events := MergeNewFlatsIntoOld(oldMessageData, msg)
+
+	newContent, err := json.Marshal(oldMessageData)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = os.WriteFile(fileName, newContent, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (s *jsonStore) QueryFlats(blockSlug string, filter *FlatFilter) ([]Flat, error) {
+	return queryFlats(s, blockSlug, filter)
+}
+
+func (s *jsonStore) History(blockSlug string, flatID int64) (*Flat, error) {
+	return flatHistory(s, blockSlug, flatID)
+}
+
+func (s *jsonStore) Stat(blockSlug string) (StoreStat, error) {
+	stat, err := os.Stat(s.existingFileName(blockSlug))
+	if os.IsNotExist(err) {
+		return StoreStat{}, nil
+	}
+	if err != nil {
+		return StoreStat{}, err
+	}
+	return StoreStat{Exists: true, ModTime: stat.ModTime()}, nil
+}
+
+// memoryStore keeps the flats in memory, for tests
+type memoryStore struct {
+	mu      sync.RWMutex
+	blocks  map[string][]Flat // by embedded slug
+	modTime map[string]time.Time
+}
+
+func NewMemoryStore() FlatStore {
+	return &memoryStore{
+		blocks:  make(map[string][]Flat),
+		modTime: make(map[string]time.Time),
+	}
+}
+
+func (s *memoryStore) Load(blockSlug string) (*MessageData, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &MessageData{Flats: cloneFlats(s.blocks[util.EmbedSlug(blockSlug)])}, nil
+}
+
+func (s *memoryStore) Merge(blockSlug string, msg *MessageData) ([]FlatEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	slug := util.EmbedSlug(blockSlug)
+	merged, events := MergeNewFlatsIntoOld(&MessageData{Flats: cloneFlats(s.blocks[slug])}, msg)
+	s.blocks[slug] = cloneFlats(merged.Flats)
+	s.modTime[slug] = time.Now()
+	return events, nil
+}
+
+func (s *memoryStore) QueryFlats(blockSlug string, filter *FlatFilter) ([]Flat, error) {
+	return queryFlats(s, blockSlug, filter)
+}
+
+func (s *memoryStore) History(blockSlug string, flatID int64) (*Flat, error) {
+	return flatHistory(s, blockSlug, flatID)
+}
+
+func (s *memoryStore) Stat(blockSlug string) (StoreStat, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	modTime, ok := s.modTime[util.EmbedSlug(blockSlug)]
+	return StoreStat{Exists: ok, ModTime: modTime}, nil
+}
+
+// cloneFlats copies the flats with their histories, so the stored ones are not changed through the copies
+func cloneFlats(flats []Flat) []Flat {
+	if flats == nil {
+		return nil
+	}
+	res := make([]Flat, len(flats))
+	for i, flat := range flats {
+		flat.PriceHistory = append(PriceHistory(nil), flat.PriceHistory...)
+		flat.SettlementHistory = append(SettlementHistory(nil), flat.SettlementHistory...)
+		flat.FinishHistory = append(FinishHistory(nil), flat.FinishHistory...)
+		flat.Lifecycle = append(Lifecycle(nil), flat.Lifecycle...)
+		res[i] = flat
+	}
+	return res
+}
@@ -0,0 +1,82 @@
+package flatstorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatStore(t *testing.T) {
+	stores := map[string]func(t *testing.T) FlatStore{
+		"json": func(t *testing.T) FlatStore {
+			return NewJSONStore(t.TempDir())
+		},
+		"memory": func(t *testing.T) FlatStore {
+			return NewMemoryStore()
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+
+			stat, err := store.Stat("2ngt")
+			require.NoError(t, err)
+			require.False(t, stat.Exists)
+			msgData, err := store.Load("2ngt")
+			require.NoError(t, err)
+			require.Empty(t, msgData.Flats)
+
+			events, err := store.Merge("2ngt", &MessageData{Flats: []Flat{
+				{ID: 1, Rooms: 1, Price: 100, Status: FlatStatusFree, BlockSlug: "2ngt"},
+				{ID: 2, Rooms: 2, Price: 200, Status: FlatStatusFree, BlockSlug: "2ngt"},
+			}})
+			require.NoError(t, err)
+			require.Empty(t, events)
+
+			events, err = store.Merge("2ngt", &MessageData{Flats: []Flat{
+				{ID: 1, Rooms: 1, Price: 90, Status: FlatStatusFree, BlockSlug: "2ngt"},
+				{ID: 2, Rooms: 2, Price: 200, Status: FlatStatusFree, BlockSlug: "2ngt"},
+			}})
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			require.Equal(t, FlatEventPrice, events[0].Type)
+
+			stat, err = store.Stat("2ngt")
+			require.NoError(t, err)
+			require.True(t, stat.Exists)
+
+			filter, err := ParseFlatFilter([]string{"rooms=2"})
+			require.NoError(t, err)
+			flats, err := store.QueryFlats("2ngt", filter)
+			require.NoError(t, err)
+			require.Len(t, flats, 1)
+			require.Equal(t, int64(2), flats[0].ID)
+
+			flat, err := store.History("2ngt", 1)
+			require.NoError(t, err)
+			require.Equal(t, int64(90), flat.Price)
+			require.Len(t, flat.PriceHistory, 2)
+
+			// the loaded flats are copies
+			flat.PriceHistory[0].Price = 1
+			flat, err = store.History("2ngt", 1)
+			require.NoError(t, err)
+			require.Equal(t, int64(100), flat.PriceHistory[0].Price)
+
+			flat, err = store.History("amur", 1)
+			require.NoError(t, err)
+			require.Nil(t, flat)
+		})
+	}
+}
+
+func TestJSONStore_OldFileName(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2ngt_0.json"), []byte(`{"flats":[{"id":1}]}`), 0o644))
+
+	msgData, err := NewJSONStore(dir).Load("2ngt")
+	require.NoError(t, err)
+	require.Len(t, msgData.Flats, 1)
+}
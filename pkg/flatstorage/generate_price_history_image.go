@@ -63,6 +63,11 @@ func generatePriceChart(minHistory, maxHistory PriceHistoryWithOptions) ([]byte,
 		Series: []chart.Series{minSeries, maxSeries},
 	}
 
+	return renderChartJPEG(graph)
+}
+
+// renderChartJPEG renders the chart to JPEG, telegram shows it as a photo
+func renderChartJPEG(graph chart.Chart) ([]byte, error) {
 	var pngBuf bytes.Buffer
 	if err := graph.Render(chart.PNG, &pngBuf); err != nil {
 		return nil, err
@@ -95,3 +100,53 @@ func generatePriceChart(minHistory, maxHistory PriceHistoryWithOptions) ([]byte,
 
 	return jpgBuf.Bytes(), nil
 }
+
+func generateVelocityChart(days []DailyVelocity) ([]byte, error) {
+	appeared := chart.TimeSeries{
+		Name: "Appeared",
+		Style: chart.Style{
+			StrokeColor: chart.ColorBlue,
+		},
+	}
+	reserved := chart.TimeSeries{
+		Name: "Reserved",
+		Style: chart.Style{
+			StrokeColor: chart.ColorOrange,
+		},
+	}
+	gone := chart.TimeSeries{
+		Name: "Gone",
+		Style: chart.Style{
+			StrokeColor: chart.ColorRed,
+		},
+	}
+
+	for _, day := range days {
+		t, err := time.Parse(velocityDateFormat, day.Date)
+		if err != nil {
+			continue
+		}
+		appeared.XValues = append(appeared.XValues, t)
+		appeared.YValues = append(appeared.YValues, float64(day.Appeared))
+		reserved.XValues = append(reserved.XValues, t)
+		reserved.YValues = append(reserved.YValues, float64(day.Reserved))
+		gone.XValues = append(gone.XValues, t)
+		gone.YValues = append(gone.YValues, float64(day.Gone))
+	}
+
+	graph := chart.Chart{
+		Title: "Flats Appeared, Reserved and Gone per Day",
+		XAxis: chart.XAxis{
+			Name:           "Date",
+			ValueFormatter: chart.TimeDateValueFormatter,
+		},
+		YAxis: chart.YAxis{
+			Name:           "Flats",
+			ValueFormatter: chart.IntValueFormatter,
+		},
+		Series: []chart.Series{appeared, reserved, gone},
+	}
+	graph.Elements = []chart.Renderable{chart.Legend(&graph)}
+
+	return renderChartJPEG(graph)
+}
@@ -0,0 +1,145 @@
+package flatstorage
+
+import (
+	"sort"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+)
+
+// SettlementHistory keeps every settlement date of the flat, like PriceHistory does for the prices
+type SettlementHistory []SettlementEntry
+
+type SettlementEntry struct {
+	Date           string `json:"date"` // time.RFC3339
+	SettlementDate string `json:"settlementDate"`
+}
+
+// FinishHistory keeps every finish type of the flat
+type FinishHistory []FinishEntry
+
+type FinishEntry struct {
+	Date       string `json:"date"` // time.RFC3339
+	FinishType int8   `json:"finishType"`
+}
+
+// recordHandover appends the current settlement date and finish type to the histories if they changed,
+// the flats stored before the histories were kept get the old values from the time they were created
+func (f *Flat) recordHandover(oldInfo *oldFlatInfo, now string) {
+	f.SettlementHistory = append(SettlementHistory(nil), oldInfo.SettlementHistory...)
+	if len(f.SettlementHistory) == 0 && oldInfo.SettlementDate != "" {
+		f.SettlementHistory = SettlementHistory{{Date: oldInfo.Created, SettlementDate: oldInfo.SettlementDate}}
+	}
+	f.addSettlement(now)
+
+	f.FinishHistory = append(FinishHistory(nil), oldInfo.FinishHistory...)
+	if len(f.FinishHistory) == 0 {
+		f.FinishHistory = FinishHistory{{Date: oldInfo.Created, FinishType: oldInfo.FinishType}}
+	}
+	f.addFinish(now)
+}
+
+func (f *Flat) addSettlement(now string) {
+	size := len(f.SettlementHistory)
+	if f.SettlementDate == "" || (size > 0 && f.SettlementHistory[size-1].SettlementDate == string(f.SettlementDate)) {
+		return
+	}
+	f.SettlementHistory = append(f.SettlementHistory, SettlementEntry{Date: now, SettlementDate: string(f.SettlementDate)})
+}
+
+func (f *Flat) addFinish(now string) {
+	size := len(f.FinishHistory)
+	if size > 0 && f.FinishHistory[size-1].FinishType == f.FinishType {
+		return
+	}
+	f.FinishHistory = append(f.FinishHistory, FinishEntry{Date: now, FinishType: f.FinishType})
+}
+
+// formatHandoverHistory lists the settlement and finish changes for /info, nothing if they never changed
+func (f *Flat) formatHandoverHistory(lang util.Lang) []string {
+	var res []string
+	if len(f.SettlementHistory) > 1 {
+		res = append(res, util.Msg(lang, MsgSettlementHistory))
+		for _, entry := range f.SettlementHistory {
+			res = append(res, util.Msg(lang, MsgHistoryEntry, formatHistoryDate(entry.Date),
+				FormatSettlementQuarter(GetSettlementQuarter(entry.SettlementDate), lang)))
+		}
+	}
+	if len(f.FinishHistory) > 1 {
+		res = append(res, util.Msg(lang, MsgFinishHistory))
+		for _, entry := range f.FinishHistory {
+			res = append(res, util.Msg(lang, MsgHistoryEntry, formatHistoryDate(entry.Date),
+				GetFinishTypeString(entry.FinishType, lang)))
+		}
+	}
+	return res
+}
+
+// formatHistoryDate cuts the time off the RFC3339 date
+func formatHistoryDate(date string) string {
+	if len(date) < 10 {
+		return date
+	}
+	return date[:10]
+}
+
+// BulkChange is the same settlement or finish change of the flats of a single bulk (corpus)
+type BulkChange struct {
+	Type     FlatEventType // FlatEventSettlement or FlatEventFinish
+	BulkName string
+	OldValue string
+	NewValue string
+	Flats    []Flat
+}
+
+// GroupBulkChanges groups the settlement and finish events by the bulk and the change,
+// so postponing the handover of a whole bulk makes a single change instead of one per flat
+func GroupBulkChanges(events []FlatEvent) []BulkChange {
+	type key struct {
+		eventType FlatEventType
+		bulk      string
+		oldValue  string
+		newValue  string
+	}
+	groups := make(map[key]*BulkChange)
+	for _, event := range events {
+		if event.Type != FlatEventSettlement && event.Type != FlatEventFinish {
+			continue
+		}
+		k := key{event.Type, string(event.Flat.BulkName), event.OldValue, event.NewValue}
+		change, ok := groups[k]
+		if !ok {
+			change = &BulkChange{Type: event.Type, BulkName: k.bulk, OldValue: k.oldValue, NewValue: k.newValue}
+			groups[k] = change
+		}
+		change.Flats = append(change.Flats, event.Flat)
+	}
+
+	res := make([]BulkChange, 0, len(groups))
+	for _, change := range groups {
+		res = append(res, *change)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].BulkName != res[j].BulkName {
+			return res[i].BulkName < res[j].BulkName
+		}
+		if res[i].Type != res[j].Type {
+			return res[i].Type > res[j].Type // settlement first
+		}
+		return res[i].OldValue+res[i].NewValue < res[j].OldValue+res[j].NewValue
+	})
+	return res
+}
+
+// Format renders the change like "Корпус 1.3 handover moved 25Q3 → 26Q1 (12 flats)"
+func (c *BulkChange) Format(lang util.Lang) string {
+	bulk := c.BulkName
+	if bulk == "" {
+		bulk = util.Msg(lang, MsgUnknownBulk)
+	}
+	if c.Type == FlatEventSettlement {
+		return util.Msg(lang, MsgBulkSettlementMoved, bulk, FormatSettlementQuarter(c.OldValue, lang),
+			FormatSettlementQuarter(c.NewValue, lang), len(c.Flats))
+	}
+	return util.Msg(lang, MsgBulkFinishChanged, bulk, formatFinishTypeValue(c.OldValue, lang),
+		formatFinishTypeValue(c.NewValue, lang), len(c.Flats))
+}
@@ -0,0 +1,57 @@
+package flatstorage
+
+import (
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergeNewFlatsIntoOld_HandoverHistory(t *testing.T) {
+	created := "2024-05-01T12:00:00Z"
+	latest := "2024-06-01T12:00:00Z"
+	oldMsg := &MessageData{
+		Flats: []Flat{
+			// stored before the histories were kept
+			{ID: 1, Price: 100, BulkName: "Корпус 1.3", SettlementDate: "2025-09-15", FinishType: 1, Created: created, Updated: latest},
+			{ID: 2, Price: 100, BulkName: "Корпус 1.3", SettlementDate: "2025-09-15", FinishType: 1, Created: created, Updated: latest,
+				SettlementHistory: SettlementHistory{{Date: created, SettlementDate: "2025-09-15"}},
+				FinishHistory:     FinishHistory{{Date: created, FinishType: 1}}},
+			{ID: 3, Price: 100, BulkName: "Корпус 2", SettlementDate: "2025-09-15", FinishType: 0, Created: created, Updated: latest},
+		},
+	}
+	newMsg := &MessageData{
+		Flats: []Flat{
+			{ID: 1, Price: 100, BulkName: "Корпус 1.3", SettlementDate: "2026-02-15", FinishType: 1},
+			{ID: 2, Price: 100, BulkName: "Корпус 1.3", SettlementDate: "2026-03-01", FinishType: 1},
+			{ID: 3, Price: 100, BulkName: "Корпус 2", SettlementDate: "2025-09-15", FinishType: 2},
+			{ID: 4, Price: 100, BulkName: "Корпус 2", SettlementDate: "2025-09-15", FinishType: 2},
+		},
+	}
+
+	merged, events := MergeNewFlatsIntoOld(oldMsg, newMsg)
+	flats := make(map[int64]Flat)
+	for _, flat := range merged.Flats {
+		flats[flat.ID] = flat
+	}
+	require.Len(t, flats[1].SettlementHistory, 2)
+	require.Equal(t, SettlementEntry{Date: created, SettlementDate: "2025-09-15"}, flats[1].SettlementHistory[0])
+	require.Equal(t, "2026-02-15", flats[1].SettlementHistory[1].SettlementDate)
+	require.Len(t, flats[1].FinishHistory, 1)
+	require.Len(t, flats[2].SettlementHistory, 2)
+	require.Equal(t, []int8{0, 2}, []int8{flats[3].FinishHistory[0].FinishType, flats[3].FinishHistory[1].FinishType})
+	require.Len(t, flats[4].SettlementHistory, 1)
+	require.Len(t, flats[4].FinishHistory, 1)
+
+	changes := GroupBulkChanges(events)
+	require.Len(t, changes, 2)
+	require.Len(t, changes[0].Flats, 2)
+	require.Equal(t, "Корпус 1.3 handover moved 25Q3 → 26Q1 (2 flats)", changes[0].Format(util.DefaultLang))
+	require.Equal(t, "Корпус 2 finish changed no finishing → whitebox (1 flats)", changes[1].Format(util.DefaultLang))
+
+	flat := flats[1]
+	lines := flat.formatHandoverHistory(util.DefaultLang)
+	require.Len(t, lines, 3)
+	require.Equal(t, []string{"Handover history:", "2024-05-01: 25Q3"}, lines[:2])
+	require.Contains(t, lines[2], ": 26Q1")
+}
@@ -0,0 +1,55 @@
+package flatstorage
+
+const (
+	FlatStatusFree    = "free"
+	FlatStatusReserve = "reserve"
+)
+
+// FlatStage is a step of the flat lifecycle: appeared → reserved → released → ... → gone
+type FlatStage string
+
+const (
+	StageAppeared FlatStage = "appeared" // first seen in the feed
+	StageReserved FlatStage = "reserved" // free → reserve
+	StageReleased FlatStage = "released" // reserve → free, usually a good deal
+	StageGone     FlatStage = "gone"     // disappeared from the feed, most likely sold
+	StageReturned FlatStage = "returned" // appeared in the feed again after being gone
+)
+
+type LifecycleEntry struct {
+	Date  string    `json:"date"` // time.RFC3339
+	Stage FlatStage `json:"stage"`
+}
+
+type Lifecycle []LifecycleEntry
+
+// Last returns the current stage, empty for the flats stored before the lifecycle was tracked
+func (l Lifecycle) Last() FlatStage {
+	if len(l) == 0 {
+		return ""
+	}
+	return l[len(l)-1].Stage
+}
+
+// addStage appends the stage unless the flat is already in it,
+// the flats stored before the lifecycle was tracked get the appearance from Created first
+func (f *Flat) addStage(date string, stage FlatStage) {
+	if len(f.Lifecycle) == 0 && stage != StageAppeared && f.Created != "" {
+		f.Lifecycle = Lifecycle{{Date: f.Created, Stage: StageAppeared}}
+	}
+	if f.Lifecycle.Last() == stage {
+		return
+	}
+	f.Lifecycle = append(f.Lifecycle, LifecycleEntry{Date: date, Stage: stage})
+}
+
+// statusStage returns the stage the status change leads to, the other statuses are not tracked
+func statusStage(oldStatus string, newStatus string) (FlatStage, bool) {
+	switch {
+	case oldStatus == FlatStatusFree && newStatus == FlatStatusReserve:
+		return StageReserved, true
+	case oldStatus == FlatStatusReserve && newStatus == FlatStatusFree:
+		return StageReleased, true
+	}
+	return "", false
+}
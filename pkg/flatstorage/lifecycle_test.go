@@ -0,0 +1,56 @@
+package flatstorage
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stages(lifecycle Lifecycle) []FlatStage {
+	var res []FlatStage
+	for _, entry := range lifecycle {
+		res = append(res, entry.Stage)
+	}
+	return res
+}
+
+func TestMergeNewFlatsIntoOld_Lifecycle(t *testing.T) {
+	created := "2024-05-01T12:00:00Z"
+	latest := "2024-06-01T12:00:00Z"
+	oldMsg := &MessageData{
+		Flats: []Flat{
+			{ID: 1, Price: 100, Status: FlatStatusReserve, Created: created, Updated: latest,
+				Lifecycle: Lifecycle{{Date: created, Stage: StageAppeared}, {Date: latest, Stage: StageReserved}}},
+			{ID: 2, Price: 100, Status: FlatStatusFree, Created: created, Updated: latest}, // stored before the lifecycle was tracked
+			{ID: 3, Price: 100, Status: FlatStatusFree, Created: created, Updated: "2024-05-15T12:00:00Z",
+				Lifecycle: Lifecycle{{Date: created, Stage: StageAppeared}, {Date: "2024-05-16T12:00:00Z", Stage: StageGone}}},
+		},
+	}
+	newMsg := &MessageData{
+		Flats: []Flat{
+			{ID: 1, Price: 100, Status: FlatStatusFree},
+			{ID: 3, Price: 100, Status: FlatStatusFree},
+			{ID: 4, Price: 100, Status: FlatStatusFree},
+		},
+	}
+
+	merged, events := MergeNewFlatsIntoOld(oldMsg, newMsg)
+	flats := make(map[int64]Flat)
+	for _, flat := range merged.Flats {
+		flats[flat.ID] = flat
+	}
+	require.Equal(t, []FlatStage{StageAppeared, StageReserved, StageReleased}, stages(flats[1].Lifecycle))
+	require.Equal(t, []FlatStage{StageAppeared, StageGone}, stages(flats[2].Lifecycle))
+	require.Equal(t, created, flats[2].Lifecycle[0].Date)
+	require.Equal(t, []FlatStage{StageAppeared, StageGone, StageReturned}, stages(flats[3].Lifecycle))
+	require.Equal(t, []FlatStage{StageAppeared}, stages(flats[4].Lifecycle))
+
+	eventTypes := make(map[int64][]FlatEventType)
+	for i, event := range events {
+		eventTypes[event.Flat.ID] = append(eventTypes[event.Flat.ID], event.Type)
+		require.Equal(t, event.Flat.ID == 1, events[i].IsReleased())
+	}
+	require.Equal(t, []FlatEventType{FlatEventStatus}, eventTypes[1])
+	require.Equal(t, []FlatEventType{FlatEventGone}, eventTypes[2])
+	require.Equal(t, []FlatEventType{FlatEventReturned}, eventTypes[3])
+}
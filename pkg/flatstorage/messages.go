@@ -0,0 +1,140 @@
+package flatstorage
+
+import "github.com/georgri/pik_tg_bot/pkg/util"
+
+const (
+	MsgNewFlatsHeader          util.MsgKey = "flatstorage.new_flats_header"
+	MsgPriceDropsHeader        util.MsgKey = "flatstorage.price_drops_header"
+	MsgExtremePriceDropsHeader util.MsgKey = "flatstorage.extreme_price_drops_header"
+
+	MsgFlatLine           util.MsgKey = "flatstorage.flat_line"
+	MsgFlatInfoLink       util.MsgKey = "flatstorage.flat_info_link"
+	MsgAvgPricePercent    util.MsgKey = "flatstorage.avg_price_percent"
+	MsgPriceDropPercent   util.MsgKey = "flatstorage.price_drop_percent"
+	MsgSettled            util.MsgKey = "flatstorage.settled"
+	MsgFinishTypeNone     util.MsgKey = "flatstorage.finish_type_none"
+	MsgFinishTypeFinish   util.MsgKey = "flatstorage.finish_type_finish"
+	MsgFinishTypeWhitebox util.MsgKey = "flatstorage.finish_type_whitebox"
+
+	MsgFlatInfoHeader  util.MsgKey = "flatstorage.flat_info_header"
+	MsgPriceHistory    util.MsgKey = "flatstorage.price_history"
+	MsgNoMinMaxSeries  util.MsgKey = "flatstorage.no_min_max_series"
+	MsgEventGone       util.MsgKey = "flatstorage.event_gone"
+	MsgEventReturned   util.MsgKey = "flatstorage.event_returned"
+	MsgEventChange     util.MsgKey = "flatstorage.event_change"
+	MsgEventPrice      util.MsgKey = "flatstorage.event_price"
+	MsgEventStatus     util.MsgKey = "flatstorage.event_status"
+	MsgEventSettlement util.MsgKey = "flatstorage.event_settlement"
+	MsgEventFinish     util.MsgKey = "flatstorage.event_finish"
+
+	MsgThresholds    util.MsgKey = "flatstorage.thresholds"
+	MsgThresholdsOff util.MsgKey = "flatstorage.thresholds_off"
+
+	MsgVelocityHeader         util.MsgKey = "flatstorage.velocity_header"
+	MsgVelocityTotals         util.MsgKey = "flatstorage.velocity_totals"
+	MsgVelocityLastDays       util.MsgKey = "flatstorage.velocity_last_days"
+	MsgVelocityDay            util.MsgKey = "flatstorage.velocity_day"
+	MsgVelocityInventory      util.MsgKey = "flatstorage.velocity_inventory"
+	MsgVelocityInventoryLine  util.MsgKey = "flatstorage.velocity_inventory_line"
+	MsgVelocityDaysToReserve  util.MsgKey = "flatstorage.velocity_days_to_reserve"
+	MsgVelocityNoReservations util.MsgKey = "flatstorage.velocity_no_reservations"
+
+	MsgSettlementHistory   util.MsgKey = "flatstorage.settlement_history"
+	MsgFinishHistory       util.MsgKey = "flatstorage.finish_history"
+	MsgHistoryEntry        util.MsgKey = "flatstorage.history_entry"
+	MsgUnknownBulk         util.MsgKey = "flatstorage.unknown_bulk"
+	MsgBulkSettlementMoved util.MsgKey = "flatstorage.bulk_settlement_moved"
+	MsgBulkFinishChanged   util.MsgKey = "flatstorage.bulk_finish_changed"
+)
+
+func init() {
+	util.RegisterMessages(util.LangEn, map[util.MsgKey]string{
+		MsgNewFlatsHeader:          "%v new flats in %v:",
+		MsgPriceDropsHeader:        "%v flats dropped prices in %v:",
+		MsgExtremePriceDropsHeader: "%v extreme price drops in %v:",
+
+		MsgFlatLine:           "%v: <a href=\"%v\">%vr, %vm2</a>, %vR, f%v%v, %v, %v",
+		MsgFlatInfoLink:       "info",
+		MsgAvgPricePercent:    "avg%+.1f%%",
+		MsgPriceDropPercent:   ", price%.1f%%",
+		MsgSettled:            "settled",
+		MsgFinishTypeNone:     "no finishing",
+		MsgFinishTypeFinish:   "finishing",
+		MsgFinishTypeWhitebox: "whitebox",
+
+		MsgFlatInfoHeader:  "info about flat #%v in complex %v:",
+		MsgPriceHistory:    "Price history:",
+		MsgNoMinMaxSeries:  "not enough data to calc min/max series :(",
+		MsgEventGone:       "disappeared from the feed (sold or hidden)",
+		MsgEventReturned:   "back on sale after disappearing",
+		MsgEventChange:     "%v: %v → %v",
+		MsgEventPrice:      "price",
+		MsgEventStatus:     "status",
+		MsgEventSettlement: "settlement",
+		MsgEventFinish:     "finish",
+
+		MsgThresholds:    "price drop %v%%, extreme %v%% if %v%% below average",
+		MsgThresholdsOff: "price drop alerts are off",
+
+		MsgVelocityHeader:         "Sales velocity in <b>%v</b> for the last %v days:",
+		MsgVelocityTotals:         "appeared %v, reserved %v, gone %v (%.1f reservations per day)",
+		MsgVelocityLastDays:       "Last %v days (appeared / reserved / gone):",
+		MsgVelocityDay:            "%v: +%v / 🔒%v / ✖%v",
+		MsgVelocityInventory:      "Free now: %v",
+		MsgVelocityInventoryLine:  "%vr, %v: %v",
+		MsgVelocityDaysToReserve:  "Median days to reserve: %v (%v flats)",
+		MsgVelocityNoReservations: "Not enough reservations to calc the median days to reserve",
+
+		MsgSettlementHistory:   "Handover history:",
+		MsgFinishHistory:       "Finish history:",
+		MsgHistoryEntry:        "%v: %v",
+		MsgUnknownBulk:         "Unknown bulk",
+		MsgBulkSettlementMoved: "%v handover moved %v → %v (%v flats)",
+		MsgBulkFinishChanged:   "%v finish changed %v → %v (%v flats)",
+	})
+
+	util.RegisterMessages(util.LangRu, map[util.MsgKey]string{
+		MsgNewFlatsHeader:          "Новые квартиры (%v) в ЖК %v:",
+		MsgPriceDropsHeader:        "Подешевевшие квартиры (%v) в ЖК %v:",
+		MsgExtremePriceDropsHeader: "Сильно подешевевшие квартиры (%v) в ЖК %v:",
+
+		MsgFlatLine:           "%v: <a href=\"%v\">%vк, %vм2</a>, %v₽, эт.%v%v, %v, %v",
+		MsgFlatInfoLink:       "инфо",
+		MsgAvgPricePercent:    "ср%+.1f%%",
+		MsgPriceDropPercent:   ", цена%.1f%%",
+		MsgSettled:            "сдан",
+		MsgFinishTypeNone:     "без отделки",
+		MsgFinishTypeFinish:   "отделка",
+		MsgFinishTypeWhitebox: "whitebox",
+
+		MsgFlatInfoHeader:  "информация о квартире #%v в ЖК %v:",
+		MsgPriceHistory:    "История цен:",
+		MsgNoMinMaxSeries:  "недостаточно данных для расчёта минимальных и максимальных цен :(",
+		MsgEventGone:       "пропала из продажи (продана или скрыта)",
+		MsgEventReturned:   "снова в продаже после исчезновения",
+		MsgEventChange:     "%v: %v → %v",
+		MsgEventPrice:      "цена",
+		MsgEventStatus:     "статус",
+		MsgEventSettlement: "заселение",
+		MsgEventFinish:     "отделка",
+
+		MsgThresholds:    "снижение цены %v%%, сильное %v%% при цене на %v%% ниже средней",
+		MsgThresholdsOff: "уведомления о снижении цен выключены",
+
+		MsgVelocityHeader:         "Скорость продаж в ЖК <b>%v</b> за последние %v дней:",
+		MsgVelocityTotals:         "появилось %v, забронировано %v, пропало %v (%.1f брони в день)",
+		MsgVelocityLastDays:       "Последние %v дней (появилось / забронировано / пропало):",
+		MsgVelocityDay:            "%v: +%v / 🔒%v / ✖%v",
+		MsgVelocityInventory:      "Свободно сейчас: %v",
+		MsgVelocityInventoryLine:  "%vк, %v: %v",
+		MsgVelocityDaysToReserve:  "Медиана дней до брони: %v (квартир: %v)",
+		MsgVelocityNoReservations: "Недостаточно бронирований для расчёта медианы дней до брони",
+
+		MsgSettlementHistory:   "История сроков сдачи:",
+		MsgFinishHistory:       "История отделки:",
+		MsgHistoryEntry:        "%v: %v",
+		MsgUnknownBulk:         "Неизвестный корпус",
+		MsgBulkSettlementMoved: "%v: срок сдачи перенесён %v → %v (квартир: %v)",
+		MsgBulkFinishChanged:   "%v: отделка изменена %v → %v (квартир: %v)",
+	})
+}
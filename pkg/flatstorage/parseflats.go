@@ -46,6 +46,8 @@ const (
 	collapsePricesPeriod = 1 * time.Hour
 
 	minShownPriceHistoryYear = 2023
+
+	settledQuarter = "сдан"
 )
 
 // url example: https://flat.pik-service.ru/api/v1/filter/flat-by-block/1240?type=1,2&location=2,3&flatLimit=80&onlyFlats=1
@@ -78,9 +80,12 @@ type Flat struct {
 	FinishType     int8       `json:"finishType"`
 	SettlementDate NullString `json:"settlementDate"`
 
-	AveragePrice int64        `json:"averagePrice"`
-	OldPrice     int64        `json:"oldPrice"`
-	PriceHistory PriceHistory `json:"priceHistory,omitempty"`
+	AveragePrice      int64             `json:"averagePrice"`
+	OldPrice          int64             `json:"oldPrice"`
+	PriceHistory      PriceHistory      `json:"priceHistory,omitempty"`
+	SettlementHistory SettlementHistory `json:"settlementHistory,omitempty"`
+	FinishHistory     FinishHistory     `json:"finishHistory,omitempty"`
+	Lifecycle         Lifecycle         `json:"lifecycle,omitempty"`
 }
 
 type PriceHistory []PriceEntry
@@ -116,6 +121,7 @@ type PriceDropMessageData struct {
 }
 
 type Metro struct {
+	ID    int64      `json:"id"`
 	Name  NullString `json:"name"`
 	Color NullString `json:"color"`
 }
@@ -306,11 +312,30 @@ func prunePriceHistory(history PriceHistory) PriceHistory {
 // {number of Flats} новых объектов в ЖК "Второй Нагатинский" (м.Нагатинская (color #ACADAF)):
 // Корпус 1.3 #831859[url link to flat]: 32.6m, 1r, f19, 12_756_380rub,
 func (md *MessageData) String() string {
-	return md.StringWithOptions(false, false)
+	return md.StringWithOptions(false, false, util.DefaultLang)
 }
 
-func (md *MessageData) StringWithOptions(sortByAvg bool, withInfo bool) string {
+func (md *MessageData) StringWithOptions(sortByAvg bool, withInfo bool, lang util.Lang) string {
+	if md == nil {
+		return ""
+	}
+
+	md.SortFlats(sortByAvg)
+
+	res := md.MakeHeader(lang)
+
+	flats := make([]string, 0, len(md.Flats))
+	for _, flat := range md.Flats {
+		flats = append(flats, flat.StringWithOptions(lang))
+	}
+
+	res += "\n" + strings.Join(flats, "\n") // try <br>
+
+	return res
+}
 
+// SortFlats sorts by price or by the percentage relative to the average price per m2
+func (md *MessageData) SortFlats(sortByAvg bool) {
 	if sortByAvg {
 		sort.Slice(md.Flats, func(i, j int) bool {
 			return md.Flats[i].GetPriceBelowAveragePercentage() < md.Flats[j].GetPriceBelowAveragePercentage()
@@ -321,40 +346,30 @@ func (md *MessageData) StringWithOptions(sortByAvg bool, withInfo bool) string {
 			return md.Flats[i].Price < md.Flats[j].Price
 		})
 	}
-
-	res := md.MakeHeader()
-
-	flats := make([]string, 0, len(md.Flats))
-	for _, flat := range md.Flats {
-		flats = append(flats, flat.StringWithOptions())
-	}
-
-	res += "\n" + strings.Join(flats, "\n") // try <br>
-
-	return res
 }
 
-func (md *MessageData) GetInfoToSend(stats FlatStats) (string, []byte) {
+func (md *MessageData) GetInfoToSend(stats FlatStats, lang util.Lang) (string, []byte) {
 	if len(md.Flats) == 0 {
 		return "", nil
 	}
 
-	res := fmt.Sprintf("info about flat #%v in compex %v:", md.Flats[0].ID, md.Flats[0].BlockSlug)
+	res := util.Msg(lang, MsgFlatInfoHeader, md.Flats[0].ID, md.Flats[0].BlockSlug)
 
 	flats := make([]string, 0, len(md.Flats))
 	for _, flat := range md.Flats {
-		flats = append(flats, flat.String())
+		flats = append(flats, flat.StringWithOptions(lang))
 		// TODO: format dates and prices nicely
-		flats = append(flats, fmt.Sprintf("Price history:"))
+		flats = append(flats, util.Msg(lang, MsgPriceHistory))
 		for _, priceEntry := range flat.GetPriceHistory() {
 			flats = append(flats, fmt.Sprintf("%v", priceEntry))
 		}
+		flats = append(flats, flat.formatHandoverHistory(lang)...)
 	}
 
 	minSeries, maxSeries := CalcPriceMinMaxRangeSeries(stats.SimilarFlats, md.Flats[0])
 
 	if len(minSeries) == 0 {
-		flats = append(flats, fmt.Sprintf("not enough data to calc min/max series :("))
+		flats = append(flats, util.Msg(lang, MsgNoMinMaxSeries))
 	}
 
 	// TODO: enable later for premium users
@@ -474,7 +489,7 @@ func CalcPriceMinMaxRangeSeries(flats []Flat, origFlat Flat) (PriceHistoryWithOp
 
 // MakeHeader example:
 // // {number of Flats} новых объектов в ЖК "Второй Нагатинский" (м.Нагатинская (color #ACADAF)):
-func (md *MessageData) MakeHeader() string {
+func (md *MessageData) MakeHeader(lang util.Lang) string {
 
 	if md == nil || len(md.Flats) == 0 {
 		return ""
@@ -486,10 +501,7 @@ func (md *MessageData) MakeHeader() string {
 	// metro := flat.Metro.Name // to large message
 	// metroColor := flat.Metro.Color // telegram doesn't support text color :(
 
-	res := fmt.Sprintf("%v new flats in %v:",
-		numFlats, blockName)
-
-	return res
+	return util.Msg(lang, MsgNewFlatsHeader, numFlats, blockName)
 }
 
 func (md *MessageData) GetBlockSlug() string {
@@ -542,43 +554,57 @@ func (f *Flat) RecentlyUpdated(now time.Time) bool {
 	return now.Sub(t) < FlatValidInterval
 }
 
+// GetURL returns the page of the flat on pik.ru
+func (f *Flat) GetURL() string {
+	return fmt.Sprintf("https://www.pik.ru/flat/%v", f.ID)
+}
+
 // String example:
 // Корпус 1.3 #831859[url link to flat]: 32.6m, 1r, f19, 12_756_380rub,
 func (f *Flat) String() string {
-	return f.StringWithOptions()
+	return f.StringWithOptions(util.DefaultLang)
 }
 
-func (f *Flat) StringWithOptions() string {
+func (f *Flat) StringWithOptions(lang util.Lang) string {
 	if f == nil {
 		return ""
 	}
+	return f.stringWithPrice(util.ThousandSep(f.Price, " "), lang)
+}
 
-	var corp string
-	bulkSplit := strings.Split(string(f.BulkName), " ")
-	if len(bulkSplit) > 1 {
-		corp = bulkSplit[1]
-	} else {
-		corp = string(f.BulkName)
+// StringWithOldPrice shows the old price struck through before the current one, e.g. <s>12 500 000</s> 11 900 000
+func (f *Flat) StringWithOldPrice(oldPrice int64, lang util.Lang) string {
+	if f == nil {
+		return ""
+	}
+	if oldPrice == 0 || oldPrice == f.Price {
+		return f.StringWithOptions(lang)
 	}
-	id := f.ID
-	flatURL := fmt.Sprintf("https://www.pik.ru/flat/%v", id)
+	price := fmt.Sprintf("<s>%v</s> %v", util.ThousandSep(oldPrice, " "), util.ThousandSep(f.Price, " "))
+	res := f.stringWithPrice(price, lang)
+	res += util.Msg(lang, MsgPriceDropPercent, (float64(f.Price)/float64(oldPrice)-1)*100)
+	return res
+}
+
+func (f *Flat) stringWithPrice(price string, lang util.Lang) string {
+	corp := f.GetCorpus()
+	flatURL := f.GetURL()
 	area := fmt.Sprintf("%.1f", f.Area)
 	rooms := f.Rooms
 	floor := f.Floor
-	price := util.ThousandSep(f.Price, " ")
 	var reserve string
 	if f.Status == "reserve" {
 		reserve = "🔒"
 	}
 
-	settlementQuarter := GetSettlementQuarter(string(f.SettlementDate))
+	settlementQuarter := FormatSettlementQuarter(GetSettlementQuarter(string(f.SettlementDate)), lang)
 
-	finishTypeString := GetFinishTypeString(f.FinishType)
+	finishTypeString := GetFinishTypeString(f.FinishType, lang)
 
-	res := fmt.Sprintf("%v: <a href=\"%v\">%vr, %vm2</a>, %vR, f%v%v, %v, %v", corp, flatURL, rooms, area, price, floor, reserve, settlementQuarter, finishTypeString)
+	res := util.Msg(lang, MsgFlatLine, corp, flatURL, rooms, area, price, floor, reserve, settlementQuarter, finishTypeString)
 
 	var priceInfo []string
-	avgPrice := f.formatAvgPrice()
+	avgPrice := f.formatAvgPrice(lang)
 	if avgPrice != "" {
 		priceInfo = append(priceInfo, avgPrice)
 	}
@@ -590,7 +616,7 @@ func (f *Flat) StringWithOptions() string {
 
 	priceInfoStr := strings.Join(priceInfo, ", ")
 	if priceInfoStr == "" {
-		priceInfoStr = "info"
+		priceInfoStr = util.Msg(lang, MsgFlatInfoLink)
 	}
 
 	infoCommand := fmt.Sprintf("info_%v_%v", f.BlockSlug, f.ID)
@@ -599,27 +625,32 @@ func (f *Flat) StringWithOptions() string {
 	return res
 }
 
-func (f *Flat) PercentageDropString() string {
+// GetCorpus example: Корпус 1.3 => 1.3
+func (f *Flat) GetCorpus() string {
+	bulkSplit := strings.Split(string(f.BulkName), " ")
+	if len(bulkSplit) > 1 {
+		return bulkSplit[1]
+	}
+	return string(f.BulkName)
+}
+
+func (f *Flat) PercentageDropString(lang util.Lang) string {
 	if f == nil {
 		return ""
 	}
 
-	res := f.String()
-	res += fmt.Sprintf(", price%.1f%%", f.GetPriceDropPercentage())
+	res := f.StringWithOptions(lang)
+	res += util.Msg(lang, MsgPriceDropPercent, f.GetPriceDropPercentage())
 
 	return res
 }
 
-func (f *Flat) formatAvgPrice() string {
+func (f *Flat) formatAvgPrice(lang util.Lang) string {
 	if f.AveragePrice == 0 {
 		return ""
 	}
 
-	percentage := f.GetPriceBelowAveragePercentage()
-	if percentage >= 0 {
-		return fmt.Sprintf("avg+%.1f%%", percentage)
-	}
-	return fmt.Sprintf("avg%.1f%%", percentage)
+	return util.Msg(lang, MsgAvgPricePercent, f.GetPriceBelowAveragePercentage())
 }
 
 func (f *Flat) formatPriceChange() string {
@@ -652,7 +683,7 @@ func (f *Flat) formatPriceChange() string {
 // ex 2025-06-15 => 25Q3
 func GetSettlementQuarter(settlementDate string) string {
 	if len(settlementDate) < 10 {
-		return "сдан"
+		return settledQuarter
 	}
 	year := settlementDate[:4]
 	month, err := strconv.Atoi(settlementDate[5:7])
@@ -664,13 +695,21 @@ func GetSettlementQuarter(settlementDate string) string {
 	return fmt.Sprintf("%vQ%v", year, quarter)
 }
 
-func GetFinishTypeString(finishType int8) string {
+// FormatSettlementQuarter shows the quarter returned by GetSettlementQuarter in the language
+func FormatSettlementQuarter(quarter string, lang util.Lang) string {
+	if quarter == settledQuarter {
+		return util.Msg(lang, MsgSettled)
+	}
+	return quarter
+}
+
+func GetFinishTypeString(finishType int8, lang util.Lang) string {
 	if finishType == 1 {
-		return "отделка"
+		return util.Msg(lang, MsgFinishTypeFinish)
 	} else if finishType == 2 {
-		return "whitebox"
+		return util.Msg(lang, MsgFinishTypeWhitebox)
 	}
-	return "без отделки"
+	return util.Msg(lang, MsgFinishTypeNone)
 }
 
 // String print in human readable telegram friendly format
@@ -682,10 +721,59 @@ func GetFinishTypeString(finishType int8) string {
 // {number of Flats} квартир подешевели более, чем на {price_drop_threshold}% в ЖК "Второй Нагатинский":
 // Корпус 1.3 #831859[url link to flat]: 32.6m, 1r, f19, 12_756_380rub, {(price_new/price_old - 1)*100)%
 func (md *PriceDropMessageData) String() string {
-	return md.StringWithPrompt("flats dropped prices in")
+	return md.StringWithHeader(MsgPriceDropsHeader, util.DefaultLang)
+}
+
+func (md *PriceDropMessageData) Filter(filter *FlatFilter) *PriceDropMessageData {
+	if md == nil {
+		return nil
+	}
+	res := &PriceDropMessageData{
+		PriceDropPercentThreshold: md.PriceDropPercentThreshold,
+	}
+	for i := range md.Flats {
+		if filter.Match(&md.Flats[i]) {
+			res.Flats = append(res.Flats, md.Flats[i])
+		}
+	}
+	if len(res.Flats) == 0 {
+		return nil
+	}
+	return res
 }
 
-func (md *PriceDropMessageData) StringWithPrompt(prompt string) string {
+// Without returns a copy without the flats, nil if no flats are left
+func (md *PriceDropMessageData) Without(flatIDs map[int64]bool) *PriceDropMessageData {
+	if md == nil {
+		return nil
+	}
+	res := &PriceDropMessageData{
+		PriceDropPercentThreshold: md.PriceDropPercentThreshold,
+	}
+	for i := range md.Flats {
+		if !flatIDs[md.Flats[i].ID] {
+			res.Flats = append(res.Flats, md.Flats[i])
+		}
+	}
+	if len(res.Flats) == 0 {
+		return nil
+	}
+	return res
+}
+
+// FlatIDs returns the IDs of the flats
+func (md *PriceDropMessageData) FlatIDs() map[int64]bool {
+	res := make(map[int64]bool)
+	if md == nil {
+		return res
+	}
+	for i := range md.Flats {
+		res[md.Flats[i].ID] = true
+	}
+	return res
+}
+
+func (md *PriceDropMessageData) StringWithHeader(header util.MsgKey, lang util.Lang) string {
 	if md == nil || len(md.Flats) == 0 {
 		return ""
 	}
@@ -695,11 +783,11 @@ func (md *PriceDropMessageData) StringWithPrompt(prompt string) string {
 		return md.Flats[i].GetPriceDropPercentage() < md.Flats[j].GetPriceDropPercentage()
 	})
 
-	res := md.MakeHeader(prompt)
+	res := md.MakeHeader(header, lang)
 
 	flats := make([]string, 0, len(md.Flats))
 	for _, flat := range md.Flats {
-		flats = append(flats, flat.PercentageDropString())
+		flats = append(flats, flat.PercentageDropString(lang))
 	}
 
 	res += "\n" + strings.Join(flats, "\n") // try <br>
@@ -709,7 +797,7 @@ func (md *PriceDropMessageData) StringWithPrompt(prompt string) string {
 
 // MakeHeader example:
 // {number of Flats} квартир подешевели более, чем на {price_drop_threshold}% в ЖК "Второй Нагатинский":
-func (md *PriceDropMessageData) MakeHeader(prompt string) string {
+func (md *PriceDropMessageData) MakeHeader(header util.MsgKey, lang util.Lang) string {
 
 	if md == nil || len(md.Flats) == 0 {
 		return ""
@@ -719,8 +807,5 @@ func (md *PriceDropMessageData) MakeHeader(prompt string) string {
 	numFlats := len(md.Flats)
 	blockName := flat.BlockName
 
-	res := fmt.Sprintf("%v %v %v:",
-		numFlats, prompt, blockName)
-
-	return res
+	return util.Msg(lang, header, numFlats, blockName)
 }
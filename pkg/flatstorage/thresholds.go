@@ -0,0 +1,149 @@
+package flatstorage
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"strconv"
+	"strings"
+)
+
+const (
+	ThresholdKeyPriceDrop        = "drop"
+	ThresholdKeyExtremePriceDrop = "extreme"
+	ThresholdKeyBelowAverage     = "avg"
+
+	ThresholdsOff     = "off"
+	ThresholdsOn      = "on"
+	ThresholdsDefault = "default"
+)
+
+// ThresholdsSyntax is a short human-readable description of the thresholds arguments
+const ThresholdsSyntax = "drop=10 extreme=30 avg=10 | off | on | default"
+
+// PriceDropThresholds decide which price drops are worth a notification, all values are in percent.
+// Zero values mean the defaults.
+type PriceDropThresholds struct {
+	PriceDrop        int64 `json:"price_drop,omitempty"`
+	ExtremePriceDrop int64 `json:"extreme_price_drop,omitempty"`
+	// BelowAverage is how much cheaper than the average price per m2 an extreme price drop must be
+	BelowAverage int64 `json:"below_average,omitempty"`
+
+	Disabled bool `json:"disabled,omitempty"` // no price drop notifications at all
+}
+
+// ParsePriceDropThresholds applies args like "drop=5 extreme=20 avg=15" or "off" to a copy of the thresholds
+func ParsePriceDropThresholds(args []string, thresholds *PriceDropThresholds) (*PriceDropThresholds, error) {
+	res := &PriceDropThresholds{}
+	if thresholds != nil {
+		*res = *thresholds
+	}
+	for _, arg := range args {
+		arg = strings.ToLower(strings.TrimSpace(arg))
+		switch arg {
+		case "":
+			continue
+		case ThresholdsOff:
+			res.Disabled = true
+			continue
+		case ThresholdsOn:
+			res.Disabled = false
+			continue
+		case ThresholdsDefault:
+			res = &PriceDropThresholds{}
+			continue
+		}
+
+		key, valueStr, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid threshold %q, expected something like %q", arg, "drop=5")
+		}
+		value, err := strconv.ParseInt(strings.TrimSuffix(valueStr, "%"), 10, 64)
+		if err != nil || value <= 0 || value >= 100 {
+			return nil, fmt.Errorf("invalid threshold %q, expected percent from 1 to 99", arg)
+		}
+		switch key {
+		case ThresholdKeyPriceDrop:
+			res.PriceDrop = value
+		case ThresholdKeyExtremePriceDrop:
+			res.ExtremePriceDrop = value
+		case ThresholdKeyBelowAverage:
+			res.BelowAverage = value
+		default:
+			return nil, fmt.Errorf("unknown threshold %q, expected %v, %v or %v", key,
+				ThresholdKeyPriceDrop, ThresholdKeyExtremePriceDrop, ThresholdKeyBelowAverage)
+		}
+	}
+	return res, nil
+}
+
+func (t *PriceDropThresholds) GetPriceDrop() int64 {
+	if t == nil || t.PriceDrop == 0 {
+		return DefaultPriceDropPercentThreshold
+	}
+	return t.PriceDrop
+}
+
+func (t *PriceDropThresholds) GetExtremePriceDrop() int64 {
+	if t == nil || t.ExtremePriceDrop == 0 {
+		return DefaultExtremePriceDropPercentThreshold
+	}
+	return t.ExtremePriceDrop
+}
+
+func (t *PriceDropThresholds) GetBelowAverage() int64 {
+	if t == nil || t.BelowAverage == 0 {
+		return DefaultBelowAverageThreshold
+	}
+	return t.BelowAverage
+}
+
+func (t *PriceDropThresholds) IsDisabled() bool {
+	return t != nil && t.Disabled
+}
+
+// IsDefault returns true if nothing differs from the package defaults
+func (t *PriceDropThresholds) IsDefault() bool {
+	return t == nil || *t == PriceDropThresholds{}
+}
+
+func (t *PriceDropThresholds) String() string {
+	if t.IsDisabled() {
+		return "price drop alerts are off"
+	}
+	return fmt.Sprintf("%v=%v %v=%v %v=%v",
+		ThresholdKeyPriceDrop, t.GetPriceDrop(),
+		ThresholdKeyExtremePriceDrop, t.GetExtremePriceDrop(),
+		ThresholdKeyBelowAverage, t.GetBelowAverage())
+}
+
+// Format describes the thresholds in the language of the chat
+func (t *PriceDropThresholds) Format(lang util.Lang) string {
+	if t.IsDisabled() {
+		return util.Msg(lang, MsgThresholdsOff)
+	}
+	return util.Msg(lang, MsgThresholds, t.GetPriceDrop(), t.GetExtremePriceDrop(), t.GetBelowAverage())
+}
+
+// classifyPriceDrops splits the flats into regular and extreme price drops according to the thresholds
+func (t *PriceDropThresholds) classifyPriceDrops(newFlats []Flat, droppedFlats []Flat) (priceDrops, extremePriceDrops []Flat) {
+	if t.IsDisabled() {
+		return nil, nil
+	}
+
+	for i := range newFlats {
+		// check if price of new flat is below average
+		if newFlats[i].GetPriceBelowAveragePercentage() <= -float64(t.GetExtremePriceDrop()) {
+			extremePriceDrops = append(extremePriceDrops, newFlats[i])
+		}
+	}
+
+	for i := range droppedFlats {
+		if droppedFlats[i].IsPriceDroppedByAtLeast(t.GetExtremePriceDrop()) &&
+			droppedFlats[i].GetPriceBelowAveragePercentage() <= -float64(t.GetBelowAverage()) {
+			extremePriceDrops = append(extremePriceDrops, droppedFlats[i])
+		} else if droppedFlats[i].IsPriceDroppedByAtLeast(t.GetPriceDrop()) {
+			priceDrops = append(priceDrops, droppedFlats[i])
+		}
+	}
+	return priceDrops, extremePriceDrops
+}
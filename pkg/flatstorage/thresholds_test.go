@@ -0,0 +1,72 @@
+package flatstorage
+
+import (
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePriceDropThresholds(t *testing.T) {
+	thresholds, err := ParsePriceDropThresholds([]string{"drop=5", "extreme=20%"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(5), thresholds.GetPriceDrop())
+	require.Equal(t, int64(20), thresholds.GetExtremePriceDrop())
+	require.Equal(t, int64(DefaultBelowAverageThreshold), thresholds.GetBelowAverage())
+
+	// the original thresholds stay intact
+	disabled, err := ParsePriceDropThresholds([]string{"off"}, thresholds)
+	require.NoError(t, err)
+	require.True(t, disabled.IsDisabled())
+	require.False(t, thresholds.IsDisabled())
+	require.Equal(t, int64(5), disabled.GetPriceDrop())
+
+	require.Equal(t, "price drop 5%, extreme 20% if 10% below average", thresholds.Format(util.LangEn))
+	require.Equal(t, "снижение цены 5%, сильное 20% при цене на 10% ниже средней", thresholds.Format(util.LangRu))
+	require.Equal(t, "уведомления о снижении цен выключены", disabled.Format(util.LangRu))
+
+	reset, err := ParsePriceDropThresholds([]string{"default"}, disabled)
+	require.NoError(t, err)
+	require.True(t, reset.IsDefault())
+
+	for _, args := range [][]string{{"drop"}, {"drop=0"}, {"drop=100"}, {"size=5"}, {"avg=x"}} {
+		_, err = ParsePriceDropThresholds(args, nil)
+		require.Error(t, err, args)
+	}
+}
+
+func TestFlatUpdates_WithThresholds(t *testing.T) {
+	oldMsg := &MessageData{
+		Flats: []Flat{
+			{ID: 1, Price: 100, Area: 1, BlockName: "TestBlock", BlockSlug: "tb"},
+			{ID: 2, Price: 100, Area: 1, BlockName: "TestBlock", BlockSlug: "tb"},
+		},
+	}
+	newMsg := &MessageData{
+		Flats: []Flat{
+			{ID: 1, Price: 95, Area: 1, AveragePrice: 100, BlockName: "TestBlock", BlockSlug: "tb"},
+			{ID: 2, Price: 75, Area: 1, AveragePrice: 100, BlockName: "TestBlock", BlockSlug: "tb"},
+		},
+	}
+
+	updates := GetFlatUpdates(oldMsg, newMsg)
+	require.False(t, updates.Empty())
+	require.Len(t, updates.DroppedFlats, 2)
+	require.Len(t, updates.PriceDrops.Flats, 1)
+	require.Nil(t, updates.ExtremePriceDrops)
+
+	custom := updates.WithThresholds(&PriceDropThresholds{PriceDrop: 5, ExtremePriceDrop: 20, BelowAverage: 20})
+	require.Len(t, custom.PriceDrops.Flats, 1)
+	require.Equal(t, int64(1), custom.PriceDrops.Flats[0].ID)
+	require.Len(t, custom.ExtremePriceDrops.Flats, 1)
+	require.Equal(t, int64(2), custom.ExtremePriceDrops.Flats[0].ID)
+
+	// the flat already notified about is left out of the extreme price drops only
+	without := custom.WithoutExtremePriceDrops(map[int64]bool{1: true, 2: true})
+	require.Nil(t, without.ExtremePriceDrops)
+	require.Equal(t, custom.PriceDrops, without.PriceDrops)
+	require.Equal(t, map[int64]bool{2: true}, custom.ExtremePriceDrops.FlatIDs())
+
+	disabled := updates.WithThresholds(&PriceDropThresholds{Disabled: true})
+	require.Empty(t, disabled.Strings())
+}
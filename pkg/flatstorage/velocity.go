@@ -0,0 +1,212 @@
+package flatstorage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+)
+
+const (
+	VelocityDays = 30 // the period of the daily counts
+
+	velocityDateFormat = "2006-01-02"
+	shownVelocityDays  = 7
+)
+
+// DailyVelocity counts the flats that changed on the day
+type DailyVelocity struct {
+	Date     string // velocityDateFormat
+	Appeared int
+	Reserved int
+	Gone     int
+}
+
+// InventoryKey groups the free flats by rooms and finish type
+type InventoryKey struct {
+	Rooms      int8
+	FinishType int8
+}
+
+type Velocity struct {
+	Days      []DailyVelocity // oldest first, VelocityDays entries including the empty days
+	Inventory map[InventoryKey]int
+	FreeTotal int
+
+	MedianDaysToReserve float64
+	ReservedSamples     int // flats with known appearance and reservation dates
+}
+
+// CalcVelocity computes the sales velocity of the complex from its stored flats,
+// the reservations come from the status history and the disappearances from the lifecycle
+// or from the flats missing in the latest download
+func CalcVelocity(flats []Flat, now time.Time) *Velocity {
+	res := &Velocity{Inventory: make(map[InventoryKey]int)}
+
+	dayIndex := make(map[string]int, VelocityDays)
+	for i := VelocityDays - 1; i >= 0; i-- {
+		date := now.AddDate(0, 0, -i).Format(velocityDateFormat)
+		dayIndex[date] = len(res.Days)
+		res.Days = append(res.Days, DailyVelocity{Date: date})
+	}
+	dayOf := func(date string) (*DailyVelocity, bool) {
+		t, err := time.Parse(time.RFC3339, date)
+		if err != nil {
+			return nil, false
+		}
+		i, ok := dayIndex[t.In(now.Location()).Format(velocityDateFormat)]
+		if !ok {
+			return nil, false
+		}
+		return &res.Days[i], true
+	}
+
+	var latestUpdate string
+	for i := range flats {
+		if flats[i].Updated > latestUpdate {
+			latestUpdate = flats[i].Updated
+		}
+	}
+
+	var daysToReserve []float64
+	for i := range flats {
+		flat := &flats[i]
+
+		if day, ok := dayOf(flat.Created); ok {
+			day.Appeared++
+		}
+
+		reserveDates := flat.reserveDates()
+		for _, date := range reserveDates {
+			if day, ok := dayOf(date); ok {
+				day.Reserved++
+			}
+		}
+		if days, ok := flat.daysToReserve(reserveDates); ok {
+			daysToReserve = append(daysToReserve, days)
+		}
+
+		if flat.Updated != latestUpdate {
+			if day, ok := dayOf(flat.goneDate()); ok {
+				day.Gone++
+			}
+			continue
+		}
+
+		if flat.Status == FlatStatusFree {
+			res.Inventory[InventoryKey{Rooms: flat.Rooms, FinishType: flat.FinishType}]++
+			res.FreeTotal++
+		}
+	}
+
+	res.ReservedSamples = len(daysToReserve)
+	res.MedianDaysToReserve = median(daysToReserve)
+
+	return res
+}
+
+// reserveDates returns the dates of the free → reserve changes recorded in the price history
+func (f *Flat) reserveDates() []string {
+	var res []string
+	for i := 1; i < len(f.PriceHistory); i++ {
+		if stage, ok := statusStage(f.PriceHistory[i-1].Status, f.PriceHistory[i].Status); ok && stage == StageReserved {
+			res = append(res, f.PriceHistory[i].Date)
+		}
+	}
+	return res
+}
+
+// daysToReserve returns the days from the appearance to the first reservation,
+// the flats created before the dates were tracked are skipped
+func (f *Flat) daysToReserve(reserveDates []string) (float64, bool) {
+	if len(reserveDates) == 0 {
+		return 0, false
+	}
+	created, err := time.Parse(time.RFC3339, f.Created)
+	if err != nil || created.Year() < minShownPriceHistoryYear {
+		return 0, false
+	}
+	reserved, err := time.Parse(time.RFC3339, reserveDates[0])
+	if err != nil || reserved.Before(created) {
+		return 0, false
+	}
+	return reserved.Sub(created).Hours() / 24, true
+}
+
+// goneDate returns when the flat disappeared, the last time it was seen if the lifecycle does not know
+func (f *Flat) goneDate() string {
+	for i := len(f.Lifecycle) - 1; i >= 0; i-- {
+		if f.Lifecycle[i].Stage == StageGone {
+			return f.Lifecycle[i].Date
+		}
+	}
+	return f.Updated
+}
+
+func median(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	if len(sorted)%2 == 1 {
+		return sorted[len(sorted)/2]
+	}
+	return (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+}
+
+// Totals sums the daily counts over the period
+func (v *Velocity) Totals() DailyVelocity {
+	var res DailyVelocity
+	for _, day := range v.Days {
+		res.Appeared += day.Appeared
+		res.Reserved += day.Reserved
+		res.Gone += day.Gone
+	}
+	return res
+}
+
+// GetInfoToSend renders the summary of the complex and the chart of the daily counts
+func (v *Velocity) GetInfoToSend(blockName string, lang util.Lang) (string, []byte) {
+	totals := v.Totals()
+	lines := []string{
+		util.Msg(lang, MsgVelocityHeader, blockName, VelocityDays),
+		util.Msg(lang, MsgVelocityTotals, totals.Appeared, totals.Reserved, totals.Gone,
+			float64(totals.Reserved)/VelocityDays),
+	}
+
+	lines = append(lines, "", util.Msg(lang, MsgVelocityLastDays, shownVelocityDays))
+	for i := len(v.Days) - 1; i >= 0 && i >= len(v.Days)-shownVelocityDays; i-- {
+		day := v.Days[i]
+		lines = append(lines, util.Msg(lang, MsgVelocityDay, day.Date, day.Appeared, day.Reserved, day.Gone))
+	}
+
+	lines = append(lines, "", util.Msg(lang, MsgVelocityInventory, v.FreeTotal))
+	keys := make([]InventoryKey, 0, len(v.Inventory))
+	for key := range v.Inventory {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Rooms != keys[j].Rooms {
+			return keys[i].Rooms < keys[j].Rooms
+		}
+		return keys[i].FinishType < keys[j].FinishType
+	})
+	for _, key := range keys {
+		lines = append(lines, util.Msg(lang, MsgVelocityInventoryLine, key.Rooms,
+			GetFinishTypeString(key.FinishType, lang), v.Inventory[key]))
+	}
+
+	lines = append(lines, "")
+	if v.ReservedSamples > 0 {
+		lines = append(lines, util.Msg(lang, MsgVelocityDaysToReserve, fmt.Sprintf("%.1f", v.MedianDaysToReserve), v.ReservedSamples))
+	} else {
+		lines = append(lines, util.Msg(lang, MsgVelocityNoReservations))
+	}
+
+	img, _ := generateVelocityChart(v.Days)
+
+	return strings.Join(lines, "\n"), img
+}
@@ -0,0 +1,68 @@
+package flatstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCalcVelocity(t *testing.T) {
+	now := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	at := func(daysAgo int) string {
+		return now.AddDate(0, 0, -daysAgo).Format(time.RFC3339)
+	}
+	latest := at(0)
+
+	flats := []Flat{
+		// appeared 5 days ago, reserved 2 days ago
+		{ID: 1, Rooms: 1, FinishType: 1, Status: FlatStatusReserve, Created: at(5), Updated: latest,
+			PriceHistory: PriceHistory{{Date: at(5), Status: FlatStatusFree}, {Date: at(2), Status: FlatStatusReserve}}},
+		// appeared 10 days ago, reserved 6 days ago, released yesterday
+		{ID: 2, Rooms: 2, FinishType: 1, Status: FlatStatusFree, Created: at(10), Updated: latest,
+			PriceHistory: PriceHistory{{Date: at(10), Status: FlatStatusFree}, {Date: at(6), Status: FlatStatusReserve},
+				{Date: at(1), Status: FlatStatusFree}}},
+		{ID: 3, Rooms: 2, FinishType: 2, Status: FlatStatusFree, Created: at(1), Updated: latest},
+		{ID: 4, Rooms: 2, FinishType: 1, Status: FlatStatusFree, Created: at(40), Updated: latest},
+		// gone 3 days ago according to the lifecycle, last seen 4 days ago
+		{ID: 5, Rooms: 1, Status: FlatStatusFree, Created: at(20), Updated: at(4),
+			Lifecycle: Lifecycle{{Date: at(20), Stage: StageAppeared}, {Date: at(3), Stage: StageGone}}},
+		// stored before the lifecycle was tracked, gone after it was last seen
+		{ID: 6, Rooms: 3, Status: FlatStatusFree, Created: "2014-01-01T00:00:00Z", Updated: at(7),
+			PriceHistory: PriceHistory{{Date: at(9), Status: FlatStatusFree}, {Date: at(8), Status: FlatStatusReserve}}},
+	}
+
+	velocity := CalcVelocity(flats, now)
+	require.Len(t, velocity.Days, VelocityDays)
+	require.Equal(t, now.Format(velocityDateFormat), velocity.Days[VelocityDays-1].Date)
+
+	days := make(map[string]DailyVelocity)
+	for _, day := range velocity.Days {
+		days[day.Date] = day
+	}
+	day := func(daysAgo int) DailyVelocity {
+		return days[now.AddDate(0, 0, -daysAgo).Format(velocityDateFormat)]
+	}
+	require.Equal(t, 1, day(5).Appeared)
+	require.Equal(t, 1, day(2).Reserved)
+	require.Equal(t, 1, day(6).Reserved)
+	require.Equal(t, 1, day(8).Reserved)
+	require.Equal(t, 1, day(3).Gone)
+	require.Equal(t, 1, day(7).Gone)
+	require.Equal(t, DailyVelocity{Appeared: 4, Reserved: 3, Gone: 2}, velocity.Totals())
+
+	require.Equal(t, 3, velocity.FreeTotal)
+	require.Equal(t, map[InventoryKey]int{
+		{Rooms: 2, FinishType: 1}: 2,
+		{Rooms: 2, FinishType: 2}: 1,
+	}, velocity.Inventory)
+
+	require.Equal(t, 2, velocity.ReservedSamples)
+	require.InDelta(t, 3.5, velocity.MedianDaysToReserve, 0.001)
+
+	msg, _ := velocity.GetInfoToSend("Второй Нагатинский", util.DefaultLang)
+	require.Contains(t, msg, "appeared 4, reserved 3, gone 2")
+	require.Contains(t, msg, "2r, finishing: 2")
+	require.Contains(t, msg, "Median days to reserve: 3.5 (2 flats)")
+}
@@ -0,0 +1,285 @@
+package telegrambot
+
+import (
+	"flag"
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/backup_data"
+	"github.com/georgri/pik_tg_bot/pkg/logrotator"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	broadcastSend   = "send"
+	broadcastCancel = "cancel"
+
+	statsAuditEvents = 5
+
+	deadLettersAll       = "all"
+	deadLetterSnippetLen = 200
+)
+
+// AdminChatIDs is a comma-separated list of chats allowed to run the admin commands
+var AdminChatIDs string
+
+func init() {
+	flag.StringVar(&AdminChatIDs, "admin_chat_ids", "", "comma-separated chat IDs allowed to run admin commands")
+}
+
+// GetAdminChatIDs parses AdminChatIDs, invalid IDs are skipped
+func GetAdminChatIDs() []int64 {
+	var res []int64
+	for _, idStr := range strings.Split(AdminChatIDs, ",") {
+		idStr = strings.TrimSpace(idStr)
+		if idStr == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			log.Printf("invalid admin chat ID %q: %v", idStr, err)
+			continue
+		}
+		res = append(res, id)
+	}
+	return res
+}
+
+func IsAdmin(chatID int64) bool {
+	for _, adminChatID := range GetAdminChatIDs() {
+		if adminChatID == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	lastDownloads      = make(map[string]time.Time)
+	lastDownloadsMutex sync.RWMutex
+)
+
+func recordDownload(slug string, at time.Time) {
+	lastDownloadsMutex.Lock()
+	defer lastDownloadsMutex.Unlock()
+	lastDownloads[slug] = at
+}
+
+func getLastDownload(slug string) (time.Time, bool) {
+	lastDownloadsMutex.RLock()
+	defer lastDownloadsMutex.RUnlock()
+	at, ok := lastDownloads[slug]
+	return at, ok
+}
+
+func sendStats(chatID int64) {
+	lang := GetChatLang(chatID)
+	subscriptions := getChannels()
+
+	senderStats := GetSenderStats()
+	lines := []string{util.Msg(lang, MsgStatsHeader, len(BlockSlugs), len(subscriptions), len(GetAllKnownChatIDs()),
+		senderStats.QueueDepth, pendingCount(), senderStats.Sent, senderStats.Retried, senderStats.Dropped)}
+	lines = append(lines, util.Msg(lang, MsgStatsLastDownloads))
+	now := time.Now()
+	for _, slug := range util.SortedKeys(BlockSlugs) {
+		lastDownload := util.Msg(lang, MsgStatsNeverDownloaded)
+		if at, ok := getLastDownload(slug); ok {
+			lastDownload = util.Msg(lang, MsgStatsDownloadedAgo, now.Sub(at).Round(time.Second))
+		}
+		lines = append(lines, fmt.Sprintf("%v (%v): %v", BlockSlugs[slug].Name, slug, lastDownload))
+	}
+
+	events := getAuditEvents()
+	if len(events) > 0 {
+		lines = append(lines, util.Msg(lang, MsgStatsAudit, len(events)))
+		for _, event := range events[max(0, len(events)-statsAuditEvents):] {
+			lines = append(lines, fmt.Sprintf("%v %v %v: %v", event.Time.Format(time.DateTime), event.ChatID, event.Action, escapeHTML(event.Details)))
+		}
+	}
+
+	err := SendMessage(chatID, strings.Join(lines, "\n"))
+	if err != nil {
+		log.Printf("failed to send stats to %v: %v", chatID, err)
+	}
+}
+
+var (
+	// pendingBroadcasts keeps the message of every admin until the broadcast is confirmed or cancelled
+	pendingBroadcasts      = make(map[int64]string)
+	pendingBroadcastsMutex sync.Mutex
+)
+
+// prepareBroadcast asks the admin to confirm sending the message to all known chats
+func prepareBroadcast(chatID int64, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		err := SendMessage(chatID, localize(chatID, MsgBroadcastUsage, BroadcastCommand))
+		if err != nil {
+			log.Printf("failed to send broadcast usage to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	pendingBroadcastsMutex.Lock()
+	pendingBroadcasts[chatID] = text
+	pendingBroadcastsMutex.Unlock()
+
+	keyboard := &InlineKeyboardMarkup{}
+	keyboard.AddRow(InlineKeyboardButton{
+		Text:         localize(chatID, MsgBroadcastSendButton),
+		CallbackData: makeCallbackData(CallbackBroadcast, broadcastSend),
+	}, InlineKeyboardButton{
+		Text:         localize(chatID, MsgBroadcastCancelButton),
+		CallbackData: makeCallbackData(CallbackBroadcast, broadcastCancel),
+	})
+	SendMessageWithKeyboardAsync(chatID, localize(chatID, MsgBroadcastConfirm, len(GetAllKnownChatIDs()), text), keyboard)
+}
+
+// confirmBroadcast sends or drops the prepared broadcast, returns the text for the callback answer
+func confirmBroadcast(chatID int64, action string) string {
+	pendingBroadcastsMutex.Lock()
+	text, ok := pendingBroadcasts[chatID]
+	delete(pendingBroadcasts, chatID)
+	pendingBroadcastsMutex.Unlock()
+
+	if !ok {
+		return localize(chatID, MsgBroadcastExpired, BroadcastCommand)
+	}
+	if action != broadcastSend {
+		return localize(chatID, MsgBroadcastCancelled)
+	}
+
+	sent, err := SendToAllKnownChats(BroadcastAnnouncement, "", func(lang util.Lang) string {
+		return text
+	})
+	if err != nil {
+		log.Printf("failed to broadcast from %v: %v", chatID, err)
+		return localize(chatID, MsgSomethingWentWrong, err)
+	}
+	log.Printf("chat %v broadcasted a message to all known chats: %v", chatID, text)
+	return localize(chatID, MsgBroadcastSent, len(sent))
+}
+
+// ReloadStorage re-reads the complexes and the subscriptions changed on disk
+func ReloadStorage() error {
+	blocks, err := ReadBlockStorage(BlocksFile)
+	if err != nil {
+		return fmt.Errorf("unable to read blocks file: %w", err)
+	}
+	_, err = MergeBlocksWithHardcode(blocks)
+	if err != nil {
+		return fmt.Errorf("unable to merge blocks file into hardcode: %w", err)
+	}
+
+	channels, err := ReadChannelStorage(ChannelsFile)
+	if err != nil {
+		return fmt.Errorf("unable to read channels file: %w", err)
+	}
+	// the file keeps all the subscriptions including the hardcoded ones, so it replaces the lists
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+	for envTypeStr, channelList := range channels.ChannelsMap {
+		envType, ok := util.EnvTypeFromString[envTypeStr]
+		if !ok {
+			return fmt.Errorf("unknown envtype: %v", envTypeStr)
+		}
+		ChannelIDs[envType] = channelList
+	}
+	return nil
+}
+
+func reload(chatID int64) {
+	err := ReloadStorage()
+	msg := localize(chatID, MsgReloaded, len(BlockSlugs), len(getChannels()))
+	if err != nil {
+		log.Printf("reload requested by %v failed: %v", chatID, err)
+		msg = localize(chatID, MsgReloadFailed, err)
+	}
+
+	err = SendMessage(chatID, msg)
+	if err != nil {
+		log.Printf("failed to send reload result to %v: %v", chatID, err)
+	}
+}
+
+// runAdminJob runs the job in background and reports the result to the admin
+func runAdminJob(chatID int64, name string, job func(wg *sync.WaitGroup) error) {
+	go func() {
+		msg := localize(chatID, MsgAdminJobDone, name)
+		err := job(wg)
+		if err != nil {
+			log.Printf("%v requested by %v failed: %v", name, chatID, err)
+			msg = localize(chatID, MsgAdminJobFailed, name, err)
+		}
+		err = SendMessage(chatID, msg)
+		if err != nil {
+			log.Printf("failed to send %v result to %v: %v", name, chatID, err)
+		}
+	}()
+}
+
+// sendDeadLetters lists the messages which failed to be sent
+func sendDeadLetters(chatID int64) {
+	lang := GetChatLang(chatID)
+	dead := defaultOutbox.deadLetters()
+	if len(dead) == 0 {
+		err := SendMessage(chatID, util.Msg(lang, MsgNoDeadLetters))
+		if err != nil {
+			log.Printf("failed to send dead letters to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	lines := []string{util.Msg(lang, MsgDeadLettersHeader, len(dead), RetryDeadCommand)}
+	for _, item := range dead {
+		text := []rune(item.Text)
+		if len(text) > deadLetterSnippetLen {
+			text = append(text[:deadLetterSnippetLen], '…')
+		}
+		lines = append(lines, fmt.Sprintf("#%v %v, %v: %v\n%v", item.ID, item.ChatID, item.Failed.Format(time.DateTime),
+			escapeHTML(item.Error), escapeHTML(string(text))))
+	}
+	err := SendMessage(chatID, strings.Join(lines, "\n\n"))
+	if err != nil {
+		log.Printf("failed to send dead letters to %v: %v", chatID, err)
+	}
+}
+
+// retryDeadLetters handles /retry_dead_<id> and /retry_dead_all
+func retryDeadLetters(chatID int64, args string) {
+	args = strings.TrimSpace(args)
+	var id int64
+	if args != deadLettersAll {
+		var err error
+		id, err = strconv.ParseInt(args, 10, 64)
+		if err != nil || id <= 0 {
+			err = SendMessage(chatID, localize(chatID, MsgRetryDeadUsage, RetryDeadCommand))
+			if err != nil {
+				log.Printf("failed to send retry dead usage to %v: %v", chatID, err)
+			}
+			return
+		}
+	}
+
+	count, err := defaultOutbox.retryDead(id)
+	msg := localize(chatID, MsgDeadLettersRetried, count)
+	if err != nil {
+		log.Printf("failed to retry dead letters %q for %v: %v", args, chatID, err)
+		msg = localize(chatID, MsgSomethingWentWrong, err)
+	}
+	err = SendMessage(chatID, msg)
+	if err != nil {
+		log.Printf("failed to send retry dead result to %v: %v", chatID, err)
+	}
+}
+
+func backupNow(chatID int64) {
+	runAdminJob(chatID, BackupNowCommand, backup_data.BackupDataOnce)
+}
+
+func rotateNow(chatID int64) {
+	runAdminJob(chatID, RotateNowCommand, logrotator.RotateLogsOnce)
+}
@@ -99,7 +99,7 @@ func UpdateBlocksOnce(wg *sync.WaitGroup) error {
 	return nil
 }
 
-// NotifyAboutNewBlocks notifies all known chats about the new projects
+// NotifyAboutNewBlocks notifies all known chats which didn't opt out about the new projects
 func NotifyAboutNewBlocks(newBlocks []BlockInfo) error {
 	if len(newBlocks) == 0 {
 		return nil
@@ -109,15 +109,14 @@ func NotifyAboutNewBlocks(newBlocks []BlockInfo) error {
 		return newBlocks[i].Slug < newBlocks[j].Slug
 	})
 
-	var res []string
-	res = append(res, "#NewPikProjects\n")
+	var blocks []string
 	for _, block := range newBlocks {
-		res = append(res, block.String())
+		blocks = append(blocks, block.String())
 	}
-	res = append(res, "\nTo follow new updates, write @pik_checker_bot")
-	msg := strings.Join(res, "\n")
 
-	err := SendToAllKnownChats(msg)
+	_, err := SendToAllKnownChats(BroadcastNewBlocks, "", func(lang util.Lang) string {
+		return "#NewPikProjects\n\n" + strings.Join(blocks, "\n") + "\n\n" + util.Msg(lang, MsgNewBlocksFooter, util.GetBotUsername())
+	})
 	if err != nil {
 		return err
 	}
@@ -125,20 +124,26 @@ func NotifyAboutNewBlocks(newBlocks []BlockInfo) error {
 	return nil
 }
 
-func SendToAllKnownChats(msg string) error {
-	chatIDs := GetAllKnownChatIDs()
-	for _, chatID := range chatIDs {
-		err := SendMessage(chatID, msg)
-		if err != nil {
-			return err
+// SendToAllKnownChats notifies every chat which allows the broadcasts of the kind about the complex
+// with the message rendered in the language of the chat, returns the chats notified
+func SendToAllKnownChats(kind BroadcastKind, blockSlug string, render func(lang util.Lang) string) (map[int64]bool, error) {
+	sent := make(map[int64]bool)
+	for _, chatID := range GetAllKnownChatIDs() {
+		if !GetChatSettings(chatID).Broadcasts.Allows(kind, blockSlug) {
+			continue
 		}
+		Notify(chatID, render(GetChatLang(chatID)), nil)
+		sent[chatID] = true
 	}
-	return nil
+	return sent, nil
 }
 
 func GetAllKnownChatIDs() []int64 {
 	var res []int64
-	for _, chat := range ChannelIDs[util.GetEnvType()] {
+	for _, chat := range getChannels() {
+		if IsChatDisabled(chat.ChatID) {
+			continue
+		}
 		res = append(res, chat.ChatID)
 	}
 	res = util.FilterUnique(res, func(i int) int64 {
@@ -5,7 +5,6 @@ import (
 	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
 	"github.com/georgri/pik_tg_bot/pkg/util"
 	"log"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -16,11 +15,46 @@ const (
 	DumpInfoCommand    = "dumpinfo"
 	SubscribeCommand   = "sub"
 	UnsubscribeCommand = "unsub"
+	UnsubAllCommand    = "unsub_all"
+	MetroCommand       = "metro"
+	SubMetroCommand    = "sub_metro"
+	UnsubMetroCommand  = "unsub_metro"
+	MySubsCommand      = "mysubs"
 	InfoCommand        = "info"
+	MortgageCommand    = "mortgage"
+	VelocityCommand    = "velocity"
+	LifecycleCommand   = "lifecycle"
+	HelloCommand       = "hello"
+	ListCommand        = "list"
+	StartCommand       = "start"
+	HelpCommand        = "help"
+	SearchCommand      = "search"
+	WatchCommand       = "watch"
+	UnwatchCommand     = "unwatch"
+	SettingsCommand    = "settings"
+	LangCommand        = "lang"
+	DeliveryCommand    = "delivery"
+	AlertsCommand      = "alerts"
+	SearchesCommand    = "searches"
+	SearchSaveCommand  = "search_save"
+	SearchDelCommand   = "search_del"
+
+	// admin commands
+	StatsCommand     = "stats"
+	BroadcastCommand = "broadcast"
+	ReloadCommand    = "reload"
+	BackupNowCommand = "backup_now"
+	RotateNowCommand = "rotate_now"
+
+	DeadLettersCommand = "deadletters"
+	RetryDeadCommand   = "retry_dead"
+
+	// resetFilterArg removes the subscription filter: /sub_<slug> all
+	resetFilterArg = "all"
 )
 
 func sendHello(chatID int64, username string) {
-	msg := fmt.Sprintf("Hello, %v!", username)
+	msg := localize(chatID, MsgHello, username)
 	err := SendMessage(chatID, msg)
 	if err != nil {
 		log.Printf("failed to send message %v to chatID %v: %v", msg, chatID, err)
@@ -28,33 +62,23 @@ func sendHello(chatID int64, username string) {
 }
 
 func sendList(chatID int64, command string) {
+	msg, keyboard := renderList(chatID, command, 0)
+	SendMessageWithKeyboardAsync(chatID, msg, keyboard)
+}
 
-	subscribedTo := GetChatSubscriptions(chatID)
-
-	var complexes []string
-	for _, comp := range util.SortedKeysByFunc(BlockSlugs, func(a, b string) bool {
-		return BlockSlugs[a].Name < BlockSlugs[b].Name
-	}) {
-		if strings.Contains(command, "dump") {
-			complexes = append(complexes, BlockSlugs[comp].StringWithCommand(command))
-		} else {
-			isSubscribed := subscribedTo[comp]
-			complexes = append(complexes, BlockSlugs[comp].StringWithSub(isSubscribed))
-		}
-	}
-	msg := fmt.Sprintf("List of known complexes:\n") + strings.Join(complexes, "\n")
-	err := SendMessage(chatID, msg)
-	if err != nil {
-		log.Printf("failed to send list of all blocks to chatID %v: %v", chatID, err)
-	}
+func GetChatSubscriptions(chatID int64) map[string]ChannelInfo {
+	return GetChatsSubscriptions([]int64{chatID})[chatID]
 }
 
-func GetChatSubscriptions(chatID int64) map[string]bool {
-	envtype := util.GetEnvType()
-	res := make(map[string]bool, 10)
-	for _, channel := range ChannelIDs[envtype] {
-		if channel.ChatID == chatID {
-			res[channel.BlockSlug] = true
+// GetChatsSubscriptions returns the subscriptions of every chat by slug in a single pass over all subscriptions
+func GetChatsSubscriptions(chatIDs []int64) map[int64]map[string]ChannelInfo {
+	res := make(map[int64]map[string]ChannelInfo, len(chatIDs))
+	for _, chatID := range chatIDs {
+		res[chatID] = make(map[string]ChannelInfo, 10)
+	}
+	for _, channel := range getChannels() {
+		if subscriptions, ok := res[channel.ChatID]; ok {
+			subscriptions[channel.BlockSlug] = channel
 		}
 	}
 	return res
@@ -72,6 +96,15 @@ func validateSlug(chatID int64, slug string, command string) (string, error) {
 	return slug, nil
 }
 
+// loadBlockFlats reads all the stored flats of the complex, no flats if the complex is not stored yet
+func loadBlockFlats(slug string) (*flatstorage.MessageData, error) {
+	msgData, err := flatstorage.Store.Load(slug)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load flats of %v: %w", slug, err)
+	}
+	return msgData, nil
+}
+
 func sendDump(chatID int64, slug string, command string) {
 
 	slug, err := validateSlug(chatID, slug, command)
@@ -83,13 +116,9 @@ func sendDump(chatID int64, slug string, command string) {
 	var msg string
 
 	// send all known flats for complex with slug "slug"
-	fileName := flatstorage.GetStorageFileNameByBlockSlug(slug)
-	if !flatstorage.FileExists(fileName) {
-		log.Printf("failed to dump flats for slug %v: %v", slug, err)
-	}
-	allFlatsMessageData, err := flatstorage.ReadFlatStorage(fileName)
+	allFlatsMessageData, err := loadBlockFlats(slug)
 	if err != nil {
-		log.Printf("failed to read file with flats %v: %v", fileName, err)
+		log.Printf("failed to dump flats for slug %v: %v", slug, err)
 		return
 	}
 
@@ -99,9 +128,10 @@ func sendDump(chatID int64, slug string, command string) {
 		return allFlatsMessageData.Flats[i].RecentlyUpdated(now)
 	})
 
-	msg = allFlatsMessageData.StringWithOptions(command == DumpAvgCommand, command == DumpInfoCommand)
+	lang := GetChatLang(chatID)
+	msg = allFlatsMessageData.StringWithOptions(command == DumpAvgCommand, command == DumpInfoCommand, lang)
 	if len(allFlatsMessageData.Flats) == 0 {
-		msg = fmt.Sprintf("No known flats for complex %v", slug)
+		msg = util.Msg(lang, MsgNoKnownFlats, slug)
 	}
 
 	SendMessageWithPinAsync(chatID, msg, true)
@@ -109,13 +139,9 @@ func sendDump(chatID int64, slug string, command string) {
 
 func sendInfo(chatID int64, slugAndFlatID string, command string) {
 
-	split := strings.Split(slugAndFlatID, "_")
-	flatIDStr := split[len(split)-1]
-	slug, _ := strings.CutSuffix(slugAndFlatID, "_"+flatIDStr)
-
-	flatID, err := strconv.ParseInt(flatIDStr, 10, 64)
+	slug, flatID, err := splitSlugAndFlatID(slugAndFlatID)
 	if err != nil {
-		log.Printf("failed to parse flatID %v from %v: %v", flatIDStr, slugAndFlatID, err)
+		log.Printf("failed to send info to %v: %v", chatID, err)
 	}
 
 	slug, err = validateSlug(chatID, slug, command)
@@ -127,13 +153,9 @@ func sendInfo(chatID int64, slugAndFlatID string, command string) {
 	var msg string
 
 	// send info about flat with given ID
-	fileName := flatstorage.GetStorageFileNameByBlockSlug(slug)
-	if !flatstorage.FileExists(fileName) {
-		log.Printf("failed to get filename for slug %v: %v", slug, err)
-	}
-	allFlatsMessageData, err := flatstorage.ReadFlatStorage(fileName)
+	allFlatsMessageData, err := loadBlockFlats(slug)
 	if err != nil {
-		log.Printf("failed to read file with flats %v: %v", fileName, err)
+		log.Printf("failed to send info about flat %v: %v", slugAndFlatID, err)
 		return
 	}
 
@@ -146,27 +168,92 @@ func sendInfo(chatID int64, slugAndFlatID string, command string) {
 	})
 
 	if len(allFlatsMessageData.Flats) == 0 {
-		msg = fmt.Sprintf("No flats found with ID %v in complex %v", flatID, slug)
+		msg = localize(chatID, MsgFlatNotFound, flatID, slug)
 		SendMessageWithPinAsync(chatID, msg, false)
 		return
 	}
 
+	stats := findSimilarFlats(allFlats, &allFlatsMessageData.Flats[0], nil)
+	lang := GetChatLang(chatID)
+	msg, img := allFlatsMessageData.GetInfoToSend(stats, lang)
+
+	keyboard := &InlineKeyboardMarkup{}
+	keyboard.AddRow(InlineKeyboardButton{
+		Text:         util.Msg(lang, MsgMortgageButton),
+		CallbackData: makeCallbackData(CallbackMortgage, fmt.Sprintf("%v_%v", util.EmbedSlug(slug), flatID)),
+	})
+	SendMessageWithImgAsync(chatID, msg, img, util.Msg(lang, MsgInfoChartCaption), false, keyboard)
+}
+
+// findSimilarFlats collects the other flats similar to the flat, only the ones passing the check if it's given
+func findSimilarFlats(flats []flatstorage.Flat, flat *flatstorage.Flat, check func(*flatstorage.Flat) bool) flatstorage.FlatStats {
 	stats := flatstorage.FlatStats{}
-	for _, flat := range allFlats[len(allFlatsMessageData.Flats):] {
-		if flat.IsSimilar(allFlatsMessageData.Flats[0]) {
-			stats.SimilarFlats = append(stats.SimilarFlats, flat)
+	for i := range flats {
+		if flats[i].ID == flat.ID || !flats[i].IsSimilar(*flat) {
+			continue
+		}
+		if check == nil || check(&flats[i]) {
+			stats.SimilarFlats = append(stats.SimilarFlats, flats[i])
 		}
 	}
-	msg, img := allFlatsMessageData.GetInfoToSend(stats)
+	return stats
+}
 
-	SendMessageWithImgAsync(chatID, msg, img, "min and max prices (with 2 week window) for similar flats in reserved status", false)
+func sendVelocity(chatID int64, slug string) {
+	slug, err := validateSlug(chatID, slug, VelocityCommand)
+	if err != nil {
+		log.Printf("failed to send velocity to %v: %v", chatID, err)
+		return
+	}
+
+	allFlatsMessageData, err := loadBlockFlats(slug)
+	if err != nil {
+		log.Printf("failed to send velocity for slug %v: %v", slug, err)
+		return
+	}
+
+	lang := GetChatLang(chatID)
+	if len(allFlatsMessageData.Flats) == 0 {
+		SendMessageWithPinAsync(chatID, util.Msg(lang, MsgNoKnownFlats, slug), false)
+		return
+	}
+
+	velocity := flatstorage.CalcVelocity(allFlatsMessageData.Flats, time.Now())
+	msg, img := velocity.GetInfoToSend(BlockSlugs[util.EmbedSlug(slug)].Name, lang)
+	SendMessageWithImgAsync(chatID, msg, img, util.Msg(lang, MsgVelocityChartCaption), false, nil)
+}
+
+func AddNewSubscriber(chatID int64, slug string, filter *flatstorage.FlatFilter) error {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+	return addSubscriber(chatID, slug, filter)
 }
 
-func AddNewSubscriber(chatID int64, slug string) error {
+// addSubscriberIfMissing subscribes the chat to the complex unless it is already subscribed, returns false then;
+// the check and the change are made under the same lock, so a concurrent /sub doesn't add a duplicate
+func addSubscriberIfMissing(chatID int64, slug string) (bool, error) {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+
+	for _, subscription := range ChannelIDs[util.GetEnvType()] {
+		if subscription.BlockSlug == slug && subscription.ChatID == chatID {
+			return false, nil
+		}
+	}
+	err := addSubscriber(chatID, slug, nil)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// addSubscriber must be called with channelsMutex locked
+func addSubscriber(chatID int64, slug string, filter *flatstorage.FlatFilter) error {
 	envtype := util.GetEnvType()
 	ChannelIDs[envtype] = append(ChannelIDs[envtype], ChannelInfo{
 		ChatID:    chatID,
 		BlockSlug: slug,
+		Filter:    filter,
 	})
 
 	err := SyncChannelStorageToFile()
@@ -179,34 +266,103 @@ func AddNewSubscriber(chatID int64, slug string) error {
 	return nil
 }
 
+func UpdateSubscriberFilter(chatID int64, slug string, filter *flatstorage.FlatFilter) error {
+	return updateSubscription(chatID, slug, func(subscription *ChannelInfo) {
+		subscription.Filter = filter
+	})
+}
+
 func RemoveSubscriber(chatID int64, slug string) error {
+	removed, err := RemoveSubscribers(chatID, []string{slug})
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return fmt.Errorf("chat %v was not subscribed to %v", chatID, slug)
+	}
+	return nil
+}
+
+// RemoveSubscribers removes the subscriptions of the chat to the complexes and writes the storage once,
+// returns the number of removed subscriptions
+func RemoveSubscribers(chatID int64, slugs []string) (int, error) {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+
 	envtype := util.GetEnvType()
 
-	indexToRemove := -1
-	for i, subscription := range ChannelIDs[envtype] {
-		if subscription.BlockSlug == slug && subscription.ChatID == chatID {
-			indexToRemove = i
-			break
+	toRemove := make(map[string]bool, len(slugs))
+	for _, slug := range slugs {
+		toRemove[slug] = true
+	}
+
+	oldList := ChannelIDs[envtype]
+	newList := make([]ChannelInfo, 0, len(oldList))
+	for _, subscription := range oldList {
+		if subscription.ChatID == chatID && toRemove[subscription.BlockSlug] {
+			continue
 		}
+		newList = append(newList, subscription)
 	}
-	if indexToRemove < 0 || indexToRemove >= len(ChannelIDs[envtype]) {
-		return fmt.Errorf("chat %v was not subscribed to %v", chatID, slug)
+	removed := len(oldList) - len(newList)
+	if removed == 0 {
+		return 0, nil
 	}
 
-	ChannelIDs[envtype] = util.RemoveSliceElement(ChannelIDs[envtype], indexToRemove)
-
+	ChannelIDs[envtype] = newList
 	err := SyncChannelStorageToFile()
 	if err != nil {
-		return err
+		ChannelIDs[envtype] = oldList
+		return 0, err
 	}
 
-	return nil
+	return removed, nil
 }
 
-func CheckSubscribed(chatID int64, slug string) bool {
+// MigrateSubscriptions moves the subscriptions of the group upgraded to a supergroup to its new ID,
+// returns the number of moved subscriptions
+func MigrateSubscriptions(oldChatID int64, newChatID int64) (int, error) {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+
 	envtype := util.GetEnvType()
+	oldList := ChannelIDs[envtype]
+	subscribed := make(map[string]bool)
+	for _, subscription := range oldList {
+		if subscription.ChatID == newChatID {
+			subscribed[subscription.BlockSlug] = true
+		}
+	}
+	newList := make([]ChannelInfo, 0, len(oldList))
+	var moved int
+	for _, subscription := range oldList {
+		if subscription.ChatID == oldChatID {
+			moved++
+			if subscribed[subscription.BlockSlug] {
+				continue
+			}
+			subscription.ChatID = newChatID
+		}
+		newList = append(newList, subscription)
+	}
+	if moved == 0 {
+		return 0, nil
+	}
+
+	ChannelIDs[envtype] = newList
+	err := SyncChannelStorageToFile()
+	if err != nil {
+		ChannelIDs[envtype] = oldList
+		return 0, err
+	}
+	return moved, nil
+}
 
-	for _, subscription := range ChannelIDs[envtype] {
+func CheckSubscribed(chatID int64, slug string) bool {
+	channelsMutex.RLock()
+	defer channelsMutex.RUnlock()
+
+	for _, subscription := range ChannelIDs[util.GetEnvType()] {
 		if subscription.BlockSlug == slug && subscription.ChatID == chatID {
 			return true
 		}
@@ -214,7 +370,13 @@ func CheckSubscribed(chatID int64, slug string) bool {
 	return false
 }
 
-func subscribeChat(chatID int64, slug string) {
+// subscribeChat handles /sub_<slug> [filter], e.g. /sub_2ngt rooms=1,2 price<15m
+func subscribeChat(chatID int64, args string) {
+	var slug string
+	fields := strings.Fields(args)
+	if len(fields) > 0 {
+		slug, fields = fields[0], fields[1:]
+	}
 	slug = util.EmbedSlug(slug)
 
 	slug, err := validateSlug(chatID, slug, SubscribeCommand)
@@ -225,10 +387,31 @@ func subscribeChat(chatID int64, slug string) {
 
 	embeddedSlug := util.EmbedSlug(slug)
 
+	resetFilter := len(fields) == 1 && fields[0] == resetFilterArg
+	var filter *flatstorage.FlatFilter
+	if !resetFilter {
+		filter, err = flatstorage.ParseFlatFilter(fields)
+		if err != nil {
+			err = SendMessage(chatID, localize(chatID, MsgInvalidFilter, escapeHTML(err.Error()),
+				SubscribeCommand, embeddedSlug, escapeHTML(flatstorage.FilterSyntax)))
+			if err != nil {
+				log.Printf("failed to send invalid filter message to %v: %v", chatID, err)
+			}
+			return
+		}
+		if filter.IsEmpty() {
+			filter = nil
+		}
+	}
+
 	if CheckSubscribed(chatID, slug) {
+		if filter != nil || resetFilter {
+			updateSubscriptionFilter(chatID, slug, filter)
+			return
+		}
 		// send already subscribed message
-		err = SendMessage(chatID, fmt.Sprintf("You are already subscribed to complex %v.\n"+
-			"To view all flats: /%v_%v", slug, DumpCommand, embeddedSlug))
+		err = SendMessage(chatID, localize(chatID, MsgAlreadySubscribed, slug, DumpCommand, embeddedSlug,
+			SubscribeCommand, embeddedSlug, escapeHTML(flatstorage.FilterSyntax)))
 		if err != nil {
 			log.Printf("failed to send already subscribed message to %v: %v", chatID, err)
 		}
@@ -236,12 +419,10 @@ func subscribeChat(chatID int64, slug string) {
 		return
 	}
 
-	err = AddNewSubscriber(chatID, slug)
+	err = AddNewSubscriber(chatID, slug, filter)
 	if err != nil {
 		// send something went wrong while subscribing message
-		err = SendMessage(chatID, fmt.Sprintf("Something went wrong while subscribing to %v:\n"+
-			"error: %v\n"+
-			"You can try again later with /%v_%v", slug, err, SubscribeCommand, embeddedSlug))
+		err = SendMessage(chatID, localize(chatID, MsgSubscribeFailed, slug, err, SubscribeCommand, embeddedSlug))
 		if err != nil {
 			log.Printf("failed to send subscription failed message to %v: %v", chatID, err)
 		}
@@ -249,15 +430,43 @@ func subscribeChat(chatID int64, slug string) {
 		return
 	}
 
+	var filterInfo string
+	if filter != nil {
+		filterInfo = localize(chatID, MsgFilterInfo, escapeHTML(filter.String()))
+	}
+
 	// send message "You are subscribed"
-	err = SendMessage(chatID, fmt.Sprintf("You are now subscribed to new flats from: %v.\n"+
-		"To unsubscribe, click here: /%v_%v\n"+
-		"To get all known flats click here: /%v_%v", slug, UnsubscribeCommand, embeddedSlug, DumpCommand, embeddedSlug))
+	err = SendMessage(chatID, localize(chatID, MsgSubscribed, slug, filterInfo, UnsubscribeCommand, embeddedSlug, DumpCommand, embeddedSlug))
 	if err != nil {
 		log.Printf("failed to send subscribed message to %v: %v", chatID, err)
 	}
 }
 
+func updateSubscriptionFilter(chatID int64, slug string, filter *flatstorage.FlatFilter) {
+	embeddedSlug := util.EmbedSlug(slug)
+
+	err := UpdateSubscriberFilter(chatID, slug, filter)
+	if err != nil {
+		err = SendMessage(chatID, localize(chatID, MsgFilterUpdateFailed, slug, err, SubscribeCommand, embeddedSlug))
+		if err != nil {
+			log.Printf("failed to send filter update failed message to %v: %v", chatID, err)
+		}
+		log.Printf("failed to update filter of %v for %v", chatID, slug)
+		return
+	}
+
+	var msg string
+	if filter == nil {
+		msg = localize(chatID, MsgFilterRemoved, slug)
+	} else {
+		msg = localize(chatID, MsgFilterUpdated, slug, escapeHTML(filter.String()), SubscribeCommand, embeddedSlug, resetFilterArg)
+	}
+	err = SendMessage(chatID, msg)
+	if err != nil {
+		log.Printf("failed to send filter updated message to %v: %v", chatID, err)
+	}
+}
+
 func unsubscribeChat(chatID int64, slug string) {
 	slug, err := validateSlug(chatID, slug, UnsubscribeCommand)
 	if err != nil {
@@ -269,9 +478,7 @@ func unsubscribeChat(chatID int64, slug string) {
 
 	if !CheckSubscribed(chatID, slug) {
 		// send already subscribed message
-		err = SendMessage(chatID, fmt.Sprintf("You are not currently subscribed to complex %v.\n"+
-			"To subscribe: /%v_%v\n"+
-			"To view all flats: /%v_%v", slug, DumpCommand, embeddedSlug, SubscribeCommand, embeddedSlug))
+		err = SendMessage(chatID, localize(chatID, MsgNotSubscribed, slug, SubscribeCommand, embeddedSlug, DumpCommand, embeddedSlug))
 		if err != nil {
 			log.Printf("failed to send already unsubscribed message to %v: %v", chatID, err)
 		}
@@ -282,9 +489,7 @@ func unsubscribeChat(chatID int64, slug string) {
 	err = RemoveSubscriber(chatID, slug)
 	if err != nil {
 		// send something went wrong while unsubscribing message
-		err = SendMessage(chatID, fmt.Sprintf("Something went wrong while unsubscribing from %v:\n"+
-			"error: %v\n"+
-			"You might need to try again later with /%v_%v", slug, err, UnsubscribeCommand, embeddedSlug))
+		err = SendMessage(chatID, localize(chatID, MsgUnsubscribeFailed, slug, err, UnsubscribeCommand, embeddedSlug))
 		if err != nil {
 			log.Printf("failed to send unsubscription failed message to %v: %v", chatID, err)
 		}
@@ -293,9 +498,7 @@ func unsubscribeChat(chatID int64, slug string) {
 	}
 
 	// send message "You are unsubscribed"
-	err = SendMessage(chatID, fmt.Sprintf("You were unsubscribed from: %v.\n"+
-		"To subscribe again, click here: /%v_%v\n"+
-		"To get all known flats click here: /%v_%v", slug, SubscribeCommand, embeddedSlug, DumpCommand, embeddedSlug))
+	err = SendMessage(chatID, localize(chatID, MsgUnsubscribed, slug, SubscribeCommand, embeddedSlug, DumpCommand, embeddedSlug))
 	if err != nil {
 		log.Printf("failed to send unsubscribed message to %v: %v", chatID, err)
 	}
@@ -0,0 +1,172 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strings"
+)
+
+// BroadcastKind is the kind of the message sent to all known chats
+type BroadcastKind int
+
+const (
+	BroadcastAnnouncement BroadcastKind = iota // sent by the admins with /broadcast, nobody can opt out
+	BroadcastExtremeDrops                      // extreme price drops in any complex
+	BroadcastNewBlocks                         // new projects found on the site
+)
+
+const (
+	broadcastKeyDrops = "drops"
+	broadcastKeyNews  = "news"
+	broadcastOn       = "on"
+)
+
+// BroadcastSyntax is a short human-readable description of the alerts arguments
+const BroadcastSyntax = "drops=on | drops=off | drops=2ngt,amur news=on | news=off | default"
+
+// BroadcastSettings decide which messages sent to all known chats the chat gets.
+// Zero values mean the defaults: all of them.
+type BroadcastSettings struct {
+	NoExtremeDrops bool `json:"no_extreme_drops,omitempty"`
+	// ExtremeDropsBlocks limits the extreme price drops to the given complexes, empty means all of them
+	ExtremeDropsBlocks []string `json:"extreme_drops_blocks,omitempty"`
+
+	NoNewBlocks bool `json:"no_new_blocks,omitempty"`
+}
+
+// ParseBroadcastSettings applies args like "drops=2ngt,amur news=off" to a copy of the settings
+func ParseBroadcastSettings(args []string, settings *BroadcastSettings) (*BroadcastSettings, error) {
+	res := &BroadcastSettings{}
+	if settings != nil {
+		*res = *settings
+		res.ExtremeDropsBlocks = append([]string(nil), settings.ExtremeDropsBlocks...)
+	}
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		key, value, _ := strings.Cut(arg, "=")
+		switch strings.ToLower(key) {
+		case "":
+			continue
+		case deliveryDefault:
+			res = &BroadcastSettings{}
+		case broadcastKeyDrops:
+			switch strings.ToLower(value) {
+			case broadcastOn:
+				res.NoExtremeDrops, res.ExtremeDropsBlocks = false, nil
+			case deliveryOff:
+				res.NoExtremeDrops, res.ExtremeDropsBlocks = true, nil
+			default:
+				var slugs []string
+				for _, slug := range strings.Split(value, ",") {
+					slug = util.EmbedSlug(strings.TrimSpace(slug))
+					if slug == "" {
+						continue
+					}
+					if _, ok := BlockSlugs[slug]; !ok {
+						return nil, fmt.Errorf("unknown complex %q, see /%v", slug, ListCommand)
+					}
+					slugs = append(slugs, slug)
+				}
+				if len(slugs) == 0 {
+					return nil, fmt.Errorf("invalid drops value %q, expected on, off or complexes like %q", value, "2ngt,amur")
+				}
+				res.NoExtremeDrops = false
+				res.ExtremeDropsBlocks = util.FilterUnique(slugs, func(i int) string {
+					return slugs[i]
+				})
+			}
+		case broadcastKeyNews:
+			switch strings.ToLower(value) {
+			case broadcastOn:
+				res.NoNewBlocks = false
+			case deliveryOff:
+				res.NoNewBlocks = true
+			default:
+				return nil, fmt.Errorf("invalid news value %q, expected on or off", value)
+			}
+		default:
+			return nil, fmt.Errorf("unknown alerts option %q", arg)
+		}
+	}
+	return res, nil
+}
+
+func (b *BroadcastSettings) IsDefault() bool {
+	return b == nil || (!b.NoExtremeDrops && !b.NoNewBlocks && len(b.ExtremeDropsBlocks) == 0)
+}
+
+// Allows checks if the chat gets the message of the kind about the complex, blockSlug is empty if there is none
+func (b *BroadcastSettings) Allows(kind BroadcastKind, blockSlug string) bool {
+	if b == nil {
+		return true
+	}
+	switch kind {
+	case BroadcastExtremeDrops:
+		if b.NoExtremeDrops {
+			return false
+		}
+		if len(b.ExtremeDropsBlocks) == 0 {
+			return true
+		}
+		for _, slug := range b.ExtremeDropsBlocks {
+			if slug == blockSlug {
+				return true
+			}
+		}
+		return false
+	case BroadcastNewBlocks:
+		return !b.NoNewBlocks
+	default:
+		return true
+	}
+}
+
+// String returns the settings in the same syntax they are set with
+func (b *BroadcastSettings) String() string {
+	drops, news := broadcastOn, broadcastOn
+	if b != nil && b.NoExtremeDrops {
+		drops = deliveryOff
+	} else if b != nil && len(b.ExtremeDropsBlocks) > 0 {
+		drops = strings.Join(b.ExtremeDropsBlocks, ",")
+	}
+	if b != nil && b.NoNewBlocks {
+		news = deliveryOff
+	}
+	return fmt.Sprintf("%v=%v %v=%v", broadcastKeyDrops, drops, broadcastKeyNews, news)
+}
+
+// changeAlerts handles /alerts [settings], without args it shows the current settings
+func changeAlerts(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) > 0 {
+		broadcasts, err := ParseBroadcastSettings(fields, GetChatSettings(chatID).Broadcasts)
+		if err != nil {
+			err = SendMessage(chatID, localize(chatID, MsgInvalidAlerts, escapeHTML(err.Error()), AlertsCommand, BroadcastSyntax))
+			if err != nil {
+				log.Printf("failed to send invalid alerts message to %v: %v", chatID, err)
+			}
+			return
+		}
+		if broadcasts.IsDefault() {
+			broadcasts = nil
+		}
+
+		err = UpdateChatSettings(chatID, func(settings *ChatSettings) {
+			settings.Broadcasts = broadcasts
+		})
+		if err != nil {
+			log.Printf("failed to update alerts settings of %v: %v", chatID, err)
+			err = SendMessage(chatID, localize(chatID, MsgAlertsUpdateFailed, err))
+			if err != nil {
+				log.Printf("failed to send alerts update failed message to %v: %v", chatID, err)
+			}
+			return
+		}
+	}
+
+	err := SendMessage(chatID, localize(chatID, MsgAlertsSettings, GetChatSettings(chatID).Broadcasts, AlertsCommand, BroadcastSyntax))
+	if err != nil {
+		log.Printf("failed to send alerts settings to %v: %v", chatID, err)
+	}
+}
@@ -0,0 +1,78 @@
+package telegrambot
+
+import (
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBroadcastSettings(t *testing.T) {
+	settings, err := ParseBroadcastSettings([]string{"news=off"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, &BroadcastSettings{NoNewBlocks: true}, settings)
+	require.Equal(t, "drops=on news=off", settings.String())
+
+	settings, err = ParseBroadcastSettings([]string{"drops=2ngt,amur,2ngt"}, settings)
+	require.NoError(t, err)
+	require.Equal(t, &BroadcastSettings{ExtremeDropsBlocks: []string{"2ngt", "amur"}, NoNewBlocks: true}, settings)
+	require.Equal(t, "drops=2ngt,amur news=off", settings.String())
+
+	settings, err = ParseBroadcastSettings([]string{"drops=off"}, settings)
+	require.NoError(t, err)
+	require.Equal(t, &BroadcastSettings{NoExtremeDrops: true, NoNewBlocks: true}, settings)
+
+	settings, err = ParseBroadcastSettings([]string{"default"}, settings)
+	require.NoError(t, err)
+	require.True(t, settings.IsDefault())
+
+	for _, args := range [][]string{{"drops=unknown"}, {"drops="}, {"news=maybe"}, {"loud=on"}} {
+		_, err = ParseBroadcastSettings(args, nil)
+		require.Error(t, err, args)
+	}
+}
+
+func TestBroadcastSettingsAllows(t *testing.T) {
+	var settings *BroadcastSettings
+	require.True(t, settings.Allows(BroadcastExtremeDrops, "2ngt"))
+	require.True(t, settings.Allows(BroadcastNewBlocks, ""))
+
+	settings = &BroadcastSettings{ExtremeDropsBlocks: []string{"2ngt"}, NoNewBlocks: true}
+	require.True(t, settings.Allows(BroadcastExtremeDrops, "2ngt"))
+	require.False(t, settings.Allows(BroadcastExtremeDrops, "utnv"))
+	require.False(t, settings.Allows(BroadcastNewBlocks, ""))
+
+	// the announcements of the admins are sent to everyone
+	settings = &BroadcastSettings{NoExtremeDrops: true, NoNewBlocks: true}
+	require.False(t, settings.Allows(BroadcastExtremeDrops, "2ngt"))
+	require.True(t, settings.Allows(BroadcastAnnouncement, ""))
+}
+
+func TestSubscriberUpdatesSkipBroadcastedFlats(t *testing.T) {
+	oldMsg := &flatstorage.MessageData{Flats: []flatstorage.Flat{
+		{ID: 1, Price: 100, Area: 1, BlockSlug: "tb"},
+		{ID: 2, Price: 100, Area: 1, BlockSlug: "tb"},
+	}}
+	newMsg := &flatstorage.MessageData{Flats: []flatstorage.Flat{
+		{ID: 1, Price: 75, Area: 1, AveragePrice: 100, BlockSlug: "tb"},
+		{ID: 2, Price: 60, Area: 1, AveragePrice: 100, BlockSlug: "tb"},
+	}}
+	updates := flatstorage.GetFlatUpdates(oldMsg, newMsg)
+	broadcastedFlats := updates.ExtremePriceDrops.FlatIDs()
+	require.Equal(t, map[int64]bool{2: true}, broadcastedFlats)
+
+	// the chat with a lower extreme threshold still hears about the flat between its threshold and the default
+	lower := ChannelInfo{ChatID: 1, BlockSlug: "tb", Thresholds: &flatstorage.PriceDropThresholds{ExtremePriceDrop: 20, BelowAverage: 20}}
+	channelUpdates := subscriberUpdates(updates, lower, broadcastedFlats)
+	require.Equal(t, map[int64]bool{1: true}, channelUpdates.ExtremePriceDrops.FlatIDs())
+	require.Nil(t, channelUpdates.PriceDrops)
+
+	// the chat with the defaults gets the rest as a regular price drop
+	channelUpdates = subscriberUpdates(updates, ChannelInfo{ChatID: 2, BlockSlug: "tb"}, broadcastedFlats)
+	require.Nil(t, channelUpdates.ExtremePriceDrops)
+	require.Equal(t, map[int64]bool{1: true}, channelUpdates.PriceDrops.FlatIDs())
+
+	// the chat which opted out of the broadcast gets everything as a subscriber
+	channelUpdates = subscriberUpdates(updates, ChannelInfo{ChatID: 3, BlockSlug: "tb"}, nil)
+	require.Equal(t, broadcastedFlats, channelUpdates.ExtremePriceDrops.FlatIDs())
+}
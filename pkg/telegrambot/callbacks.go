@@ -0,0 +1,259 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// callback data format: <action>:<arg1>:<arg2>...
+const (
+	callbackDataSeparator = ":"
+
+	CallbackList  = "list"  // list:<command>:<page>
+	CallbackSub   = "sub"   // sub:<page>:<slug>
+	CallbackUnsub = "unsub" // unsub:<page>:<slug>
+	CallbackDump  = "dump"  // dump:<slug>
+	CallbackInfo  = "info"  // info:<slug>_<flatID>
+	CallbackWatch = "watch" // watch:<slug>_<flatID>
+	CallbackLang  = "lang"  // lang:<code>
+
+	CallbackUnsubAll = "unsuball" // unsuball:confirm|cancel
+	CallbackMortgage = "mortgage" // mortgage:<slug>_<flatID>
+
+	CallbackBroadcast = "broadcast" // broadcast:send|cancel
+)
+
+// CallbackQuery see https://core.telegram.org/bots/api#callbackquery
+type CallbackQuery struct {
+	Id   string `json:"id"`
+	From struct {
+		Id           int64  `json:"id"`
+		Username     string `json:"username"`
+		LanguageCode string `json:"language_code"`
+	} `json:"from"`
+	// Message is empty for the buttons of too old messages
+	Message *struct {
+		MessageId int64 `json:"message_id"`
+		Chat      struct {
+			Id   int64  `json:"id"`
+			Type string `json:"type"`
+		} `json:"chat"`
+	} `json:"message,omitempty"`
+	Data string `json:"data"`
+}
+
+// callbackAction handles the buttons of a single action, returns the text for the callback answer
+type callbackAction struct {
+	Args      int // the number of the args after the action
+	AdminOnly bool
+	Handler   func(req *callbackRequest) (string, error)
+}
+
+// callbackRequest is the pressed button of the message in the chat
+type callbackRequest struct {
+	ChatID    int64
+	MessageID int64
+	Args      []string
+}
+
+var callbackActions map[string]*callbackAction
+
+func init() {
+	callbackActions = map[string]*callbackAction{
+		CallbackList: {
+			Args: 2,
+			Handler: func(req *callbackRequest) (string, error) {
+				page, err := strconv.Atoi(req.Args[1])
+				if err != nil {
+					return "", fmt.Errorf("invalid page %q: %w", req.Args[1], err)
+				}
+				editList(req.ChatID, req.MessageID, req.Args[0], page)
+				return "", nil
+			},
+		},
+		CallbackSub: {
+			Args: 2,
+			Handler: func(req *callbackRequest) (string, error) {
+				return toggleSubscriptionOnPage(req, true)
+			},
+		},
+		CallbackUnsub: {
+			Args: 2,
+			Handler: func(req *callbackRequest) (string, error) {
+				return toggleSubscriptionOnPage(req, false)
+			},
+		},
+		CallbackDump: {
+			Args: 1,
+			Handler: func(req *callbackRequest) (string, error) {
+				sendDump(req.ChatID, req.Args[0], DumpCommand)
+				return "", nil
+			},
+		},
+		CallbackInfo: {
+			Args: 1,
+			Handler: func(req *callbackRequest) (string, error) {
+				sendInfo(req.ChatID, req.Args[0], InfoCommand)
+				return "", nil
+			},
+		},
+		CallbackWatch: {
+			Args: 1,
+			Handler: func(req *callbackRequest) (string, error) {
+				watchFlat(req.ChatID, req.Args[0])
+				return "", nil
+			},
+		},
+		CallbackMortgage: {
+			Args: 1,
+			Handler: func(req *callbackRequest) (string, error) {
+				sendMortgage(req.ChatID, req.Args[0])
+				return "", nil
+			},
+		},
+		CallbackBroadcast: {
+			Args:      1,
+			AdminOnly: true,
+			Handler: func(req *callbackRequest) (string, error) {
+				answer := confirmBroadcast(req.ChatID, req.Args[0])
+				err := EditMessageText(util.GetBotToken(), req.ChatID, req.MessageID, answer, nil)
+				if err != nil {
+					log.Printf("failed to edit broadcast message %v in chat %v: %v", req.MessageID, req.ChatID, err)
+				}
+				return answer, nil
+			},
+		},
+		CallbackUnsubAll: {
+			Args: 1,
+			Handler: func(req *callbackRequest) (string, error) {
+				answer := confirmUnsubAll(req.ChatID, req.Args[0])
+				err := EditMessageText(util.GetBotToken(), req.ChatID, req.MessageID, answer, nil)
+				if err != nil {
+					log.Printf("failed to edit unsub all message %v in chat %v: %v", req.MessageID, req.ChatID, err)
+				}
+				return answer, nil
+			},
+		},
+		CallbackLang: {
+			Args: 1,
+			Handler: func(req *callbackRequest) (string, error) {
+				answer := setChatLang(req.ChatID, req.Args[0])
+				err := EditMessageText(util.GetBotToken(), req.ChatID, req.MessageID,
+					localize(req.ChatID, MsgLangCurrent, currentLangName(req.ChatID)), langKeyboard(req.ChatID))
+				if err != nil && !isMessageNotModifiedError(err) {
+					log.Printf("failed to edit lang message %v in chat %v: %v", req.MessageID, req.ChatID, err)
+				}
+				return answer, nil
+			},
+		},
+	}
+}
+
+func makeCallbackData(action string, args ...string) string {
+	return strings.Join(append([]string{action}, args...), callbackDataSeparator)
+}
+
+// parseCallbackData splits the data made by makeCallbackData, the action must be known and get all its args
+func parseCallbackData(data string) (string, []string, error) {
+	split := strings.Split(data, callbackDataSeparator)
+	action, args := split[0], split[1:]
+	callback, ok := callbackActions[action]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown callback action %q", action)
+	}
+	if len(args) != callback.Args {
+		return "", nil, fmt.Errorf("callback action %q expects %v args, got %v", action, callback.Args, len(args))
+	}
+	return action, args, nil
+}
+
+// dispatchCallback runs the handler of the callback data, returns the text for the callback answer
+func dispatchCallback(chatID int64, messageID int64, data string) (string, error) {
+	action, args, err := parseCallbackData(data)
+	if err != nil {
+		return "", err
+	}
+	callback := callbackActions[action]
+	if callback.AdminOnly && !IsAdmin(chatID) {
+		return "", fmt.Errorf("chat %v is not allowed to use callback %q", chatID, action)
+	}
+	return callback.Handler(&callbackRequest{ChatID: chatID, MessageID: messageID, Args: args})
+}
+
+func processCallbackQuery(query *CallbackQuery) {
+	token := util.GetBotToken()
+
+	if query.Message == nil {
+		err := AnswerCallbackQuery(token, query.Id, util.Msg(util.LangFromLanguageCode(query.From.LanguageCode), MsgMessageTooOld))
+		if err != nil {
+			log.Printf("failed to answer callback query %v: %v", query.Id, err)
+		}
+		return
+	}
+
+	chatID := query.Message.Chat.Id
+	reviveChat(chatID)
+
+	// the answer is sent anyway to stop the loading animation of the button
+	answer, err := dispatchCallback(chatID, query.Message.MessageId, query.Data)
+	if err != nil {
+		log.Printf("failed to process callback data %q from chat %v: %v", query.Data, chatID, err)
+	}
+
+	err = AnswerCallbackQuery(token, query.Id, answer)
+	if err != nil {
+		log.Printf("failed to answer callback query %v: %v", query.Id, err)
+	}
+}
+
+// toggleSubscriptionOnPage handles the sub and unsub buttons of the page of /list
+func toggleSubscriptionOnPage(req *callbackRequest, subscribe bool) (string, error) {
+	page, err := strconv.Atoi(req.Args[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid page %q: %w", req.Args[0], err)
+	}
+	answer := toggleSubscription(req.ChatID, req.Args[1], subscribe)
+	editList(req.ChatID, req.MessageID, ListCommand, page)
+	return answer, nil
+}
+
+func editList(chatID int64, messageID int64, command string, page int) {
+	msg, keyboard := renderList(chatID, command, page)
+	err := EditMessageText(util.GetBotToken(), chatID, messageID, msg, keyboard)
+	if err != nil && !isMessageNotModifiedError(err) {
+		log.Printf("failed to edit list message %v in chat %v: %v", messageID, chatID, err)
+	}
+}
+
+// toggleSubscription subscribes or unsubscribes the chat without filters, returns the text for the callback answer
+func toggleSubscription(chatID int64, slug string, subscribe bool) string {
+	if _, ok := BlockSlugs[slug]; !ok {
+		return localize(chatID, MsgUnknownComplex, slug)
+	}
+
+	if subscribe == CheckSubscribed(chatID, slug) {
+		if subscribe {
+			return localize(chatID, MsgAlreadySubscribedShort, slug)
+		}
+		return localize(chatID, MsgNotSubscribedShort, slug)
+	}
+
+	var err error
+	if subscribe {
+		err = AddNewSubscriber(chatID, slug, nil)
+	} else {
+		err = RemoveSubscriber(chatID, slug)
+	}
+	if err != nil {
+		log.Printf("failed to toggle subscription of %v to %v: %v", chatID, slug, err)
+		return localize(chatID, MsgSomethingWentWrong, err)
+	}
+
+	if subscribe {
+		return localize(chatID, MsgSubscribedShort, slug)
+	}
+	return localize(chatID, MsgUnsubscribedShort, slug)
+}
@@ -0,0 +1,78 @@
+package telegrambot
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCallbackData(t *testing.T) {
+	tests := []struct {
+		action string
+		args   []string
+		data   string
+	}{
+		{CallbackList, []string{DumpCommand, "2"}, "list:dump:2"},
+		{CallbackSub, []string{"0", "2ngt"}, "sub:0:2ngt"},
+		{CallbackUnsub, []string{"1", "utnv"}, "unsub:1:utnv"},
+		{CallbackDump, []string{"2ngt"}, "dump:2ngt"},
+		{CallbackInfo, []string{"zhiloi_raion_yaroslavskii_123"}, "info:zhiloi_raion_yaroslavskii_123"},
+		{CallbackWatch, []string{"2ngt_123"}, "watch:2ngt_123"},
+		{CallbackMortgage, []string{"2ngt_123"}, "mortgage:2ngt_123"},
+		{CallbackLang, []string{"en"}, "lang:en"},
+		{CallbackUnsubAll, []string{unsubAllConfirm}, "unsuball:" + unsubAllConfirm},
+		{CallbackBroadcast, []string{broadcastSend}, "broadcast:" + broadcastSend},
+	}
+	for _, test := range tests {
+		data := makeCallbackData(test.action, test.args...)
+		require.Equal(t, test.data, data)
+		require.LessOrEqual(t, len(data), 64, "callback data is limited to 64 bytes")
+
+		action, args, err := parseCallbackData(data)
+		require.NoError(t, err, data)
+		require.Equal(t, test.action, action, data)
+		require.Equal(t, test.args, args, data)
+	}
+
+	for _, data := range []string{"", ":", "unknown", "unknown:2ngt", "dump", "dump:2ngt:extra", "list:dump", "sub:0:2ngt:extra"} {
+		_, _, err := parseCallbackData(data)
+		require.Error(t, err, data)
+	}
+}
+
+func TestDispatchCallback(t *testing.T) {
+	oldActions := callbackActions
+	t.Cleanup(func() { callbackActions = oldActions })
+
+	var got *callbackRequest
+	handler := func(req *callbackRequest) (string, error) {
+		got = req
+		return "done", nil
+	}
+	callbackActions = map[string]*callbackAction{
+		CallbackSub:       {Args: 2, Handler: handler},
+		CallbackBroadcast: {Args: 1, AdminOnly: true, Handler: handler},
+	}
+
+	answer, err := dispatchCallback(1, 10, "sub:0:2ngt")
+	require.NoError(t, err)
+	require.Equal(t, "done", answer)
+	require.Equal(t, &callbackRequest{ChatID: 1, MessageID: 10, Args: []string{"0", "2ngt"}}, got)
+
+	// unknown and malformed data and the admin buttons pressed by the others don't reach the handlers
+	for _, data := range []string{"dump:2ngt", "sub:0", "broadcast:send"} {
+		got = nil
+		answer, err = dispatchCallback(1, 10, data)
+		require.Error(t, err, data)
+		require.Empty(t, answer, data)
+		require.Nil(t, got, data)
+	}
+}
+
+func TestDispatchCallbackInvalidPage(t *testing.T) {
+	// the page is checked before the message is edited
+	for _, data := range []string{"list:dump:next", "sub:x:2ngt", "unsub::2ngt"} {
+		_, err := dispatchCallback(1, 10, data)
+		require.Error(t, err, data)
+	}
+}
@@ -7,10 +7,15 @@ import (
 	"github.com/georgri/pik_tg_bot/pkg/util"
 	"log"
 	"os"
+	"sync"
 )
 
 const ChannelsFile = "data/channels.json"
 
+// channelsMutex guards ChannelIDs: the subscriptions are changed by the commands,
+// by the sender migrating the upgraded groups and by the daemon subscribing the metro followers
+var channelsMutex sync.RWMutex
+
 type ChannelsFileData struct {
 	ChannelsMap ChannelsFileMap
 }
@@ -22,6 +27,10 @@ type ChannelFileList []ChannelInfo
 type ChannelInfo struct {
 	ChatID    int64  `json:"chat_id"`
 	BlockSlug string `json:"block_slug"` // real estate project, e.g 2ngt, utnv
+
+	Filter     *flatstorage.FlatFilter          `json:"filter,omitempty"`     // nil means all the flats
+	Thresholds *flatstorage.PriceDropThresholds `json:"thresholds,omitempty"` // nil means the defaults
+	Lifecycle  *LifecycleAlerts                 `json:"lifecycle,omitempty"`  // nil means no lifecycle alerts
 }
 
 func NewChannelsFileData() *ChannelsFileData {
@@ -70,6 +79,8 @@ func MergeChannelsWithHardcode(channels *ChannelsFileData) error {
 	if channels == nil || len(channels.ChannelsMap) == 0 {
 		return nil
 	}
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
 	for envTypeStr, channelList := range channels.ChannelsMap {
 		envType, ok := util.EnvTypeFromString[envTypeStr]
 		if !ok {
@@ -88,6 +99,7 @@ func MergeChannelsWithHardcode(channels *ChannelsFileData) error {
 	return nil
 }
 
+// SyncChannelStorageToFile must be called with channelsMutex locked
 func SyncChannelStorageToFile() error {
 	channelsFile := &ChannelsFileData{
 		ChannelsMap: make(ChannelsFileMap, 10),
@@ -105,3 +117,31 @@ func SyncChannelStorageToFile() error {
 	}
 	return nil
 }
+
+// getChannels returns a copy of the subscriptions of the current env
+func getChannels() []ChannelInfo {
+	channelsMutex.RLock()
+	defer channelsMutex.RUnlock()
+	return append([]ChannelInfo(nil), ChannelIDs[util.GetEnvType()]...)
+}
+
+// updateSubscription changes the subscription of the chat to the complex and saves the storage,
+// the change is rolled back if it can't be saved
+func updateSubscription(chatID int64, slug string, update func(subscription *ChannelInfo)) error {
+	channelsMutex.Lock()
+	defer channelsMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	for i, subscription := range ChannelIDs[envtype] {
+		if subscription.BlockSlug == slug && subscription.ChatID == chatID {
+			update(&ChannelIDs[envtype][i])
+			err := SyncChannelStorageToFile()
+			if err != nil {
+				ChannelIDs[envtype][i] = subscription
+				return err
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("chat %v was not subscribed to %v", chatID, slug)
+}
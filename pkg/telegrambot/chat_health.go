@@ -0,0 +1,164 @@
+package telegrambot
+
+import (
+	"flag"
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	AuditFile = "data/audit.json"
+
+	AuditActionMigrated = "migrated"
+	AuditActionDisabled = "disabled"
+	AuditActionEnabled  = "enabled"
+
+	defaultMaxChatFailures = 3
+)
+
+// MaxChatFailures is the number of consecutive failures to deliver to a chat before it is disabled
+var MaxChatFailures int
+
+func init() {
+	flag.IntVar(&MaxChatFailures, "max_chat_failures", defaultMaxChatFailures,
+		"consecutive delivery failures after which the chat which blocked the bot or was deleted is disabled")
+}
+
+// AuditEvent is a change of the chat made by the bot itself, e.g. the chat was disabled after it blocked the bot
+type AuditEvent struct {
+	Time    time.Time `json:"time"`
+	ChatID  int64     `json:"chat_id"`
+	Action  string    `json:"action"`
+	Details string    `json:"details,omitempty"`
+}
+
+var (
+	auditFile   = newEnvFile[AuditEvent](AuditFile)
+	auditEvents = auditFile.load()
+	auditMutex  sync.Mutex
+
+	// chatFailures counts the consecutive failures to deliver to the chat
+	chatFailures      = make(map[int64]int)
+	chatFailuresMutex sync.Mutex
+)
+
+// audit records the event, the failures are only logged since the change itself is already made
+func audit(chatID int64, action string, details string) {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+
+	log.Printf("audit: chat %v %v: %v", chatID, action, details)
+	envtype := util.GetEnvType()
+	auditEvents[envtype] = append(auditEvents[envtype], AuditEvent{
+		Time:    time.Now(),
+		ChatID:  chatID,
+		Action:  action,
+		Details: details,
+	})
+	err := auditFile.write(auditEvents)
+	if err != nil {
+		log.Printf("failed to save audit event %v of chat %v: %v", action, chatID, err)
+	}
+}
+
+func getAuditEvents() []AuditEvent {
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	return append([]AuditEvent(nil), auditEvents[util.GetEnvType()]...)
+}
+
+// recordChatFailure returns the number of consecutive failures of the chat
+func recordChatFailure(chatID int64) int {
+	chatFailuresMutex.Lock()
+	defer chatFailuresMutex.Unlock()
+	chatFailures[chatID]++
+	return chatFailures[chatID]
+}
+
+func resetChatFailures(chatID int64) {
+	chatFailuresMutex.Lock()
+	defer chatFailuresMutex.Unlock()
+	delete(chatFailures, chatID)
+}
+
+// handleChatError is called when the message to the chat failed permanently:
+// migrated chats are moved to their new ID, the chats which blocked the bot or were deleted are disabled
+// after MaxChatFailures attempts. Returns the new chat ID to resend the message to, 0 if there is none.
+func handleChatError(chatID int64, err error) int64 {
+	kind, newChatID := classifyChatError(err)
+	switch kind {
+	case ChatErrorMigrated:
+		migrateErr := MigrateChat(chatID, newChatID)
+		if migrateErr != nil {
+			log.Printf("failed to migrate chat %v to %v: %v", chatID, newChatID, migrateErr)
+			return 0
+		}
+		return newChatID
+	case ChatErrorBlocked, ChatErrorNotFound:
+		failures := recordChatFailure(chatID)
+		if failures < MaxChatFailures || IsChatDisabled(chatID) {
+			return 0
+		}
+		disableErr := UpdateChatSettings(chatID, func(settings *ChatSettings) {
+			settings.Disabled = true
+		})
+		if disableErr != nil {
+			log.Printf("failed to disable chat %v: %v", chatID, disableErr)
+			return 0
+		}
+		audit(chatID, AuditActionDisabled, fmt.Sprintf("%v after %v consecutive failures: %v", kind, failures, err))
+	}
+	return 0
+}
+
+// MigrateChat moves the subscriptions, watches, saved searches, settings and held notifications of the group upgraded to a supergroup
+func MigrateChat(oldChatID int64, newChatID int64) error {
+	moved, err := MigrateSubscriptions(oldChatID, newChatID)
+	if err != nil {
+		return fmt.Errorf("failed to migrate subscriptions: %w", err)
+	}
+	err = MigrateWatches(oldChatID, newChatID)
+	if err != nil {
+		return fmt.Errorf("failed to migrate watches: %w", err)
+	}
+	err = MigrateSavedSearches(oldChatID, newChatID)
+	if err != nil {
+		return fmt.Errorf("failed to migrate saved searches: %w", err)
+	}
+	err = MigrateMetroSubscriptions(oldChatID, newChatID)
+	if err != nil {
+		return fmt.Errorf("failed to migrate metro subscriptions: %w", err)
+	}
+	err = MoveChatSettings(oldChatID, newChatID)
+	if err != nil {
+		return fmt.Errorf("failed to migrate settings: %w", err)
+	}
+	err = migratePending(oldChatID, newChatID)
+	if err != nil {
+		return fmt.Errorf("failed to migrate pending notifications: %w", err)
+	}
+	// only the first message to the old chat does the migration, the rest just follow it
+	if moved > 0 {
+		audit(oldChatID, AuditActionMigrated, fmt.Sprintf("to %v with %v subscriptions", newChatID, moved))
+	}
+	return nil
+}
+
+// reviveChat enables the disabled chat once it writes to the bot again
+func reviveChat(chatID int64) {
+	resetChatFailures(chatID)
+	if !IsChatDisabled(chatID) {
+		return
+	}
+	err := UpdateChatSettings(chatID, func(settings *ChatSettings) {
+		settings.Disabled = false
+	})
+	if err != nil {
+		log.Printf("failed to enable chat %v: %v", chatID, err)
+		return
+	}
+	audit(chatID, AuditActionEnabled, "the chat wrote to the bot again")
+}
@@ -0,0 +1,77 @@
+package telegrambot
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyChatError(t *testing.T) {
+	cases := []struct {
+		err       error
+		kind      ChatErrorKind
+		newChatID int64
+	}{
+		{errors.New("connection reset"), ChatErrorNone, 0},
+		{&TelegramAPIError{StatusCode: 403, TelegramErrorCode: 403, TelegramDescription: "Forbidden: bot was blocked by the user"}, ChatErrorBlocked, 0},
+		{&TelegramAPIError{StatusCode: 400, TelegramErrorCode: 400, TelegramDescription: "Bad Request: chat not found"}, ChatErrorNotFound, 0},
+		{&TelegramAPIError{StatusCode: 400, TelegramErrorCode: 400, TelegramDescription: "Bad Request: group chat was upgraded to a supergroup chat",
+			MigrateToChatID: -1001234567890}, ChatErrorMigrated, -1001234567890},
+		{&TelegramAPIError{StatusCode: 400, TelegramErrorCode: 400, TelegramDescription: "Bad Request: can't parse entities"}, ChatErrorNone, 0},
+	}
+	for _, c := range cases {
+		kind, newChatID := classifyChatError(c.err)
+		require.Equal(t, c.kind, kind, c.err.Error())
+		require.Equal(t, c.newChatID, newChatID, c.err.Error())
+	}
+}
+
+func TestHandleChatError(t *testing.T) {
+	useTestStorage(t, []ChannelInfo{
+		{ChatID: 501, BlockSlug: "2ngt"},
+		{ChatID: -502, BlockSlug: "2ngt"},
+		{ChatID: -502, BlockSlug: "utnv"},
+		{ChatID: -100502, BlockSlug: "utnv"},
+	})
+
+	envtype := util.GetEnvType()
+	oldWatches, oldAudit := Watches[envtype], auditEvents[envtype]
+	t.Cleanup(func() {
+		Watches[envtype], auditEvents[envtype] = oldWatches, oldAudit
+		delete(chatSettings[envtype], 501)
+		delete(chatSettings[envtype], -502)
+		delete(chatSettings[envtype], -100502)
+	})
+	Watches[envtype] = []WatchInfo{{ChatID: -502, BlockSlug: "2ngt", FlatID: 1}}
+	auditEvents[envtype] = nil
+
+	// blocked chats are disabled after MaxChatFailures consecutive failures
+	blocked := &TelegramAPIError{StatusCode: 403, TelegramErrorCode: 403, TelegramDescription: "Forbidden: bot was blocked by the user"}
+	for i := 0; i < MaxChatFailures; i++ {
+		require.False(t, IsChatDisabled(501))
+		require.Zero(t, handleChatError(501, blocked))
+	}
+	require.True(t, IsChatDisabled(501))
+	require.NotContains(t, GetAllKnownChatIDs(), int64(501))
+	require.Len(t, getAuditEvents(), 1)
+	require.Equal(t, AuditActionDisabled, getAuditEvents()[0].Action)
+
+	reviveChat(501)
+	require.False(t, IsChatDisabled(501))
+	require.Equal(t, AuditActionEnabled, getAuditEvents()[1].Action)
+
+	// migrated groups are moved to the new ID
+	migrated := &TelegramAPIError{StatusCode: 400, TelegramErrorCode: 400, MigrateToChatID: -100502}
+	require.Equal(t, int64(-100502), handleChatError(-502, migrated))
+	require.Empty(t, GetChatSubscriptions(-502))
+	require.Len(t, GetChatSubscriptions(-100502), 2)
+	require.Len(t, ChannelIDs[envtype], 3)
+	require.Equal(t, []WatchInfo{{ChatID: -100502, BlockSlug: "2ngt", FlatID: 1}}, Watches[envtype])
+	require.Equal(t, AuditActionMigrated, getAuditEvents()[2].Action)
+
+	// the next messages to the old chat follow without another audit event
+	require.Equal(t, int64(-100502), handleChatError(-502, migrated))
+	require.Len(t, getAuditEvents(), 3)
+}
@@ -0,0 +1,143 @@
+package telegrambot
+
+import (
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"reflect"
+	"sync"
+)
+
+const ChatSettingsFile = "data/chat_settings.json"
+
+// ChatSettings are the per-chat preferences independent of subscriptions
+type ChatSettings struct {
+	ChatID int64 `json:"chat_id"`
+
+	Lang         util.Lang `json:"lang,omitempty"`          // set with /lang, overrides LanguageCode
+	LanguageCode string    `json:"language_code,omitempty"` // detected from the messages of the users
+
+	Delivery   *DeliverySettings  `json:"delivery,omitempty"`
+	Broadcasts *BroadcastSettings `json:"broadcasts,omitempty"` // set with /alerts
+
+	// Disabled chats blocked the bot or were deleted, they get no notifications till they write again, see chat_health.go
+	Disabled bool `json:"disabled,omitempty"`
+}
+
+var (
+	chatSettingsFile  = newEnvFile[ChatSettings](ChatSettingsFile)
+	chatSettings      = make(map[util.EnvType]map[int64]ChatSettings)
+	chatSettingsMutex sync.RWMutex
+)
+
+func init() {
+	for envType, settingsList := range chatSettingsFile.load() {
+		chatSettings[envType] = make(map[int64]ChatSettings, len(settingsList))
+		for _, chat := range settingsList {
+			chatSettings[envType][chat.ChatID] = chat
+		}
+	}
+}
+
+// syncChatSettingsToFile must be called with chatSettingsMutex locked
+func syncChatSettingsToFile() error {
+	settings := make(map[util.EnvType][]ChatSettings, len(chatSettings))
+	for envtype, chats := range chatSettings {
+		for _, chatID := range util.SortedKeys(chats) {
+			settings[envtype] = append(settings[envtype], chats[chatID])
+		}
+	}
+	return chatSettingsFile.write(settings)
+}
+
+func GetChatSettings(chatID int64) ChatSettings {
+	chatSettingsMutex.RLock()
+	defer chatSettingsMutex.RUnlock()
+
+	settings, ok := chatSettings[util.GetEnvType()][chatID]
+	if !ok {
+		return ChatSettings{ChatID: chatID}
+	}
+	return settings
+}
+
+// UpdateChatSettings applies the update and saves the settings, nothing is saved if the settings are unchanged
+func UpdateChatSettings(chatID int64, update func(settings *ChatSettings)) error {
+	chatSettingsMutex.Lock()
+	defer chatSettingsMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	if chatSettings[envtype] == nil {
+		chatSettings[envtype] = make(map[int64]ChatSettings)
+	}
+
+	oldSettings, exists := chatSettings[envtype][chatID]
+	if !exists {
+		oldSettings = ChatSettings{ChatID: chatID}
+	}
+	newSettings := oldSettings
+	update(&newSettings)
+	if reflect.DeepEqual(newSettings, oldSettings) {
+		return nil
+	}
+
+	chatSettings[envtype][chatID] = newSettings
+	err := syncChatSettingsToFile()
+	if err != nil {
+		if exists {
+			chatSettings[envtype][chatID] = oldSettings
+		} else {
+			delete(chatSettings[envtype], chatID)
+		}
+		return err
+	}
+	return nil
+}
+
+// MoveChatSettings copies the settings of the group upgraded to a supergroup to its new ID, unless it already has some
+func MoveChatSettings(oldChatID int64, newChatID int64) error {
+	oldSettings := GetChatSettings(oldChatID)
+	return UpdateChatSettings(newChatID, func(settings *ChatSettings) {
+		if reflect.DeepEqual(*settings, ChatSettings{ChatID: newChatID}) {
+			*settings = oldSettings
+			settings.ChatID = newChatID
+			settings.Disabled = false
+		}
+	})
+}
+
+func IsChatDisabled(chatID int64) bool {
+	return GetChatSettings(chatID).Disabled
+}
+
+// GetChatLang returns the language of the chat: the one set with /lang, or the detected one, or the default
+func GetChatLang(chatID int64) util.Lang {
+	settings := GetChatSettings(chatID)
+	if settings.Lang != "" {
+		return settings.Lang
+	}
+	if settings.LanguageCode != "" {
+		return util.LangFromLanguageCode(settings.LanguageCode)
+	}
+	return util.DefaultLang
+}
+
+// rememberLanguageCode saves the language of the user who wrote to the chat,
+// in group chats only the first detected language is kept
+func rememberLanguageCode(chatID int64, languageCode string, isPrivate bool) {
+	if languageCode == "" {
+		return
+	}
+	err := UpdateChatSettings(chatID, func(settings *ChatSettings) {
+		if isPrivate || settings.LanguageCode == "" {
+			settings.LanguageCode = languageCode
+		}
+	})
+	if err != nil {
+		log.Printf("failed to save language code %v of chat %v: %v", languageCode, chatID, err)
+	}
+}
+
+// localize renders the message in the language of the chat
+func localize(chatID int64, key util.MsgKey, args ...any) string {
+	return util.Msg(GetChatLang(chatID), key, args...)
+}
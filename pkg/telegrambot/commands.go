@@ -0,0 +1,470 @@
+package telegrambot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"net/url"
+	"strings"
+)
+
+const (
+	// see https://core.telegram.org/bots/api#botcommandscope
+	commandScopePrivateChats = "all_private_chats"
+	commandScopeGroupChats   = "all_group_chats"
+	commandScopeChat         = "chat"
+)
+
+// CommandRequest is everything a command handler needs to know about the incoming command
+type CommandRequest struct {
+	ChatID   int64
+	Username string
+	Command  string
+	Args     string
+}
+
+// BotCommand declares a single bot command; the registry below is the only place to add new commands
+type BotCommand struct {
+	Name        string
+	Description util.MsgKey
+	Args        string // argument syntax shown in /help, e.g. <slug>
+	Handler     func(req *CommandRequest)
+
+	Hidden      bool // not shown in /help and in the commands menu
+	PrivateOnly bool // not shown in the commands menu of groups
+	AdminOnly   bool // only for the chats from -admin_chat_ids, the others don't see it at all
+}
+
+var botCommands []*BotCommand
+
+func init() {
+	botCommands = []*BotCommand{
+		{
+			Name:        ListCommand,
+			Description: MsgCmdList,
+			Handler: func(req *CommandRequest) {
+				sendList(req.ChatID, ListCommand)
+			},
+		},
+		{
+			Name:        SubscribeCommand,
+			Description: MsgCmdSub,
+			Args:        "<slug> [" + flatstorage.FilterSyntax + "]",
+			Handler: func(req *CommandRequest) {
+				subscribeChat(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        UnsubscribeCommand,
+			Description: MsgCmdUnsub,
+			Args:        "<slug>",
+			Handler: func(req *CommandRequest) {
+				unsubscribeChat(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        MySubsCommand,
+			Description: MsgCmdMySubs,
+			Handler: func(req *CommandRequest) {
+				sendMySubs(req.ChatID)
+			},
+		},
+		{
+			Name:        UnsubAllCommand,
+			Description: MsgCmdUnsubAll,
+			Handler: func(req *CommandRequest) {
+				prepareUnsubAll(req.ChatID)
+			},
+		},
+		{
+			Name:        MetroCommand,
+			Description: MsgCmdMetro,
+			Handler: func(req *CommandRequest) {
+				sendMetro(req.ChatID)
+			},
+		},
+		{
+			Name:        SubMetroCommand,
+			Description: MsgCmdSubMetro,
+			Args:        "<station>",
+			Handler: func(req *CommandRequest) {
+				subscribeMetro(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        UnsubMetroCommand,
+			Description: MsgCmdUnsubMetro,
+			Args:        "<station>",
+			Handler: func(req *CommandRequest) {
+				unsubscribeMetro(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        DumpCommand,
+			Description: MsgCmdDump,
+			Args:        "<slug>",
+			Handler: func(req *CommandRequest) {
+				sendDump(req.ChatID, req.Args, DumpCommand)
+			},
+		},
+		{
+			Name:        DumpAvgCommand,
+			Description: MsgCmdDumpAvg,
+			Args:        "<slug>",
+			Handler: func(req *CommandRequest) {
+				sendDump(req.ChatID, req.Args, DumpAvgCommand)
+			},
+		},
+		{
+			Name:        DumpInfoCommand,
+			Description: MsgCmdDumpInfo,
+			Args:        "<slug>",
+			Handler: func(req *CommandRequest) {
+				sendDump(req.ChatID, req.Args, DumpInfoCommand)
+			},
+		},
+		{
+			Name:        SearchCommand,
+			Description: MsgCmdSearch,
+			Args:        "[" + searchSyntax + "]",
+			Handler: func(req *CommandRequest) {
+				sendSearch(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        SearchesCommand,
+			Description: MsgCmdSearches,
+			Handler: func(req *CommandRequest) {
+				sendSavedSearches(req.ChatID)
+			},
+		},
+		{
+			Name:        SearchSaveCommand,
+			Description: MsgCmdSearchSave,
+			Args:        "[" + savedSearchSyntax + "]",
+			Handler: func(req *CommandRequest) {
+				saveSearch(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        SearchDelCommand,
+			Description: MsgCmdSearchDel,
+			Args:        "<id>",
+			Handler: func(req *CommandRequest) {
+				deleteSavedSearch(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        InfoCommand,
+			Description: MsgCmdInfo,
+			Args:        "<slug>_<flatID>",
+			Handler: func(req *CommandRequest) {
+				sendInfo(req.ChatID, req.Args, InfoCommand)
+			},
+		},
+		{
+			Name:        MortgageCommand,
+			Description: MsgCmdMortgage,
+			Args:        "<slug>_<flatID>",
+			Handler: func(req *CommandRequest) {
+				sendMortgage(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        VelocityCommand,
+			Description: MsgCmdVelocity,
+			Args:        "<slug>",
+			Handler: func(req *CommandRequest) {
+				sendVelocity(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        WatchCommand,
+			Description: MsgCmdWatch,
+			Args:        "<slug>_<flatID>",
+			Handler: func(req *CommandRequest) {
+				watchFlat(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        UnwatchCommand,
+			Description: MsgCmdUnwatch,
+			Args:        "<slug>_<flatID>",
+			Handler: func(req *CommandRequest) {
+				unwatchFlat(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        SettingsCommand,
+			Description: MsgCmdSettings,
+			Args:        "[<slug>] [" + flatstorage.ThresholdsSyntax + "]",
+			Handler: func(req *CommandRequest) {
+				changeSettings(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        LifecycleCommand,
+			Description: MsgCmdLifecycle,
+			Args:        "<slug> [" + lifecycleSyntax + "]",
+			Handler: func(req *CommandRequest) {
+				changeLifecycle(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        DeliveryCommand,
+			Description: MsgCmdDelivery,
+			Args:        "[" + DeliverySyntax + "]",
+			Handler: func(req *CommandRequest) {
+				changeDelivery(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        AlertsCommand,
+			Description: MsgCmdAlerts,
+			Args:        "[" + BroadcastSyntax + "]",
+			Handler: func(req *CommandRequest) {
+				changeAlerts(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        LangCommand,
+			Description: MsgCmdLang,
+			Args:        "<code>",
+			Handler: func(req *CommandRequest) {
+				changeLang(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        HelpCommand,
+			Description: MsgCmdHelp,
+			Handler: func(req *CommandRequest) {
+				sendHelp(req.ChatID)
+			},
+		},
+		{
+			Name:        HelloCommand,
+			Description: MsgCmdHello,
+			PrivateOnly: true,
+			Handler: func(req *CommandRequest) {
+				sendHello(req.ChatID, req.Username)
+			},
+		},
+		{
+			Name:        StatsCommand,
+			Description: MsgCmdStats,
+			AdminOnly:   true,
+			Handler: func(req *CommandRequest) {
+				sendStats(req.ChatID)
+			},
+		},
+		{
+			Name:        BroadcastCommand,
+			Description: MsgCmdBroadcast,
+			Args:        "[<message>]",
+			AdminOnly:   true,
+			Handler: func(req *CommandRequest) {
+				prepareBroadcast(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:        ReloadCommand,
+			Description: MsgCmdReload,
+			AdminOnly:   true,
+			Handler: func(req *CommandRequest) {
+				reload(req.ChatID)
+			},
+		},
+		{
+			Name:        BackupNowCommand,
+			Description: MsgCmdBackupNow,
+			AdminOnly:   true,
+			Handler: func(req *CommandRequest) {
+				backupNow(req.ChatID)
+			},
+		},
+		{
+			Name:        RotateNowCommand,
+			Description: MsgCmdRotateNow,
+			AdminOnly:   true,
+			Handler: func(req *CommandRequest) {
+				rotateNow(req.ChatID)
+			},
+		},
+		{
+			Name:        DeadLettersCommand,
+			Description: MsgCmdDeadLetters,
+			AdminOnly:   true,
+			Handler: func(req *CommandRequest) {
+				sendDeadLetters(req.ChatID)
+			},
+		},
+		{
+			Name:        RetryDeadCommand,
+			Description: MsgCmdRetryDead,
+			Args:        "<id>",
+			AdminOnly:   true,
+			Handler: func(req *CommandRequest) {
+				retryDeadLetters(req.ChatID, req.Args)
+			},
+		},
+		{
+			Name:   StartCommand,
+			Hidden: true,
+			Handler: func(req *CommandRequest) {
+				sendList(req.ChatID, StartCommand)
+			},
+		},
+	}
+}
+
+// findCommand finds the registered command with the longest name matching the command text,
+// e.g. "sub_2ngt" => "sub" with args "2ngt"
+func findCommand(commandText string) (*BotCommand, string) {
+	var found *BotCommand
+	var args string
+	for _, command := range botCommands {
+		if found != nil && len(command.Name) <= len(found.Name) {
+			continue
+		}
+		if commandText == command.Name {
+			found, args = command, ""
+		} else if rest, ok := strings.CutPrefix(commandText, command.Name+"_"); ok {
+			found, args = command, rest
+		}
+	}
+	return found, args
+}
+
+// dispatchCommand runs the handler of the command; args are appended to the ones embedded into the command
+// if the command takes optional args, otherwise only the first arg is kept, e.g. /dump_2ngt please => "2ngt"
+func dispatchCommand(req *CommandRequest) bool {
+	command, embeddedArgs := findCommand(req.Command)
+	if command == nil {
+		return false
+	}
+	if command.AdminOnly && !IsAdmin(req.ChatID) {
+		log.Printf("chat %v is not allowed to run /%v", req.ChatID, command.Name)
+		return false
+	}
+	req.Command = command.Name
+	req.Args = embeddedArgs + req.Args
+	if !command.hasOptionalArgs() {
+		if fields := strings.Fields(req.Args); len(fields) > 0 {
+			req.Args = fields[0]
+		}
+	}
+	command.Handler(req)
+	return true
+}
+
+// hasOptionalArgs tells if the command takes the args after a space, e.g. the filter of /sub
+func (c *BotCommand) hasOptionalArgs() bool {
+	return strings.Contains(c.Args, "[")
+}
+
+func (c *BotCommand) Usage() string {
+	if c.Args == "" {
+		return "/" + c.Name
+	}
+	// optional args can't be embedded into the command and go after a space
+	if strings.HasPrefix(c.Args, "[") {
+		return fmt.Sprintf("/%v %v", c.Name, c.Args)
+	}
+	return fmt.Sprintf("/%v_%v", c.Name, c.Args)
+}
+
+func sendHelp(chatID int64) {
+	err := SendMessage(chatID, renderHelp(chatID))
+	if err != nil {
+		log.Printf("failed to send help to chatID %v: %v", chatID, err)
+	}
+}
+
+// renderHelp lists the commands available to the chat, the admin commands are shown to the admins only
+func renderHelp(chatID int64) string {
+	lang := GetChatLang(chatID)
+	isAdmin := IsAdmin(chatID)
+	var lines []string
+	for _, command := range botCommands {
+		if command.Hidden || (command.AdminOnly && !isAdmin) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%v - %v", escapeHTML(command.Usage()), util.Msg(lang, command.Description)))
+	}
+	return util.Msg(lang, MsgAvailableCommands) + "\n" + strings.Join(lines, "\n")
+}
+
+func escapeHTML(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+type setMyCommandsItem struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+type botCommandScope struct {
+	Type   string `json:"type"`
+	ChatID int64  `json:"chat_id,omitempty"`
+}
+
+// isPrivate checks if the scope is for private chats only, the IDs of private chats are positive
+func (s botCommandScope) isPrivate() bool {
+	return s.Type == commandScopePrivateChats || (s.Type == commandScopeChat && s.ChatID > 0)
+}
+
+// commandsMenu returns the commands shown in the menu of the scope, admin commands are shown only in admin chats
+func commandsMenu(scope botCommandScope, lang util.Lang) []setMyCommandsItem {
+	var items []setMyCommandsItem
+	for _, command := range botCommands {
+		if command.Hidden || (command.PrivateOnly && !scope.isPrivate()) || (command.AdminOnly && scope.Type != commandScopeChat) {
+			continue
+		}
+		description := util.Msg(lang, command.Description)
+		if command.Args != "" {
+			description += ": " + command.Usage()
+		}
+		items = append(items, setMyCommandsItem{
+			Command:     command.Name,
+			Description: description,
+		})
+	}
+	return items
+}
+
+// RegisterBotCommands publishes the registry for autocomplete, see https://core.telegram.org/bots/api#setmycommands
+// the default language is used for the users with no dedicated translation
+func RegisterBotCommands() error {
+	token := util.GetBotToken()
+	scopes := []botCommandScope{{Type: commandScopePrivateChats}, {Type: commandScopeGroupChats}}
+	for _, chatID := range GetAdminChatIDs() {
+		scopes = append(scopes, botCommandScope{Type: commandScopeChat, ChatID: chatID})
+	}
+	for _, scope := range scopes {
+		for _, lang := range util.Langs {
+			commandsJSON, err := json.Marshal(commandsMenu(scope, lang))
+			if err != nil {
+				return err
+			}
+			scopeJSON, err := json.Marshal(scope)
+			if err != nil {
+				return err
+			}
+
+			params := url.Values{
+				"commands": []string{string(commandsJSON)},
+				"scope":    []string{string(scopeJSON)},
+			}
+			if lang != util.DefaultLang {
+				params.Set("language_code", string(lang))
+			}
+			_, err = callTelegramMethod(token, "setMyCommands", params)
+			if err != nil {
+				return fmt.Errorf("failed to set commands for scope %v and lang %v: %w", string(scopeJSON), lang, err)
+			}
+		}
+	}
+	return nil
+}
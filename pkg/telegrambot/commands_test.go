@@ -0,0 +1,129 @@
+package telegrambot
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindCommand(t *testing.T) {
+	tests := []struct {
+		commandText  string
+		expectedName string
+		expectedArgs string
+	}{
+		{"list", ListCommand, ""},
+		{"dump_2ngt", DumpCommand, "2ngt"},
+		{"dumpavg_2ngt", DumpAvgCommand, "2ngt"},
+		{"info_zhiloi_raion_yaroslavskii_123", InfoCommand, "zhiloi_raion_yaroslavskii_123"},
+		{"sub", SubscribeCommand, ""},
+	}
+
+	for _, test := range tests {
+		command, args := findCommand(test.commandText)
+		require.NotNil(t, command, test.commandText)
+		require.Equal(t, test.expectedName, command.Name, test.commandText)
+		require.Equal(t, test.expectedArgs, args, test.commandText)
+	}
+
+	command, _ := findCommand("unknown_command")
+	require.Nil(t, command)
+
+	command, _ = findCommand("dumpx")
+	require.Nil(t, command)
+}
+
+func TestDispatchCommandArgs(t *testing.T) {
+	oldCommands := botCommands
+	t.Cleanup(func() { botCommands = oldCommands })
+
+	var got string
+	handler := func(req *CommandRequest) {
+		got = req.Args
+	}
+	botCommands = []*BotCommand{
+		{Name: DumpCommand, Args: "<slug>", Handler: handler},
+		{Name: SubscribeCommand, Args: "<slug> [rooms=2]", Handler: handler},
+	}
+
+	tests := []struct {
+		command      string
+		args         string
+		expectedArgs string
+	}{
+		{"dump_2ngt", "", "2ngt"},
+		{"dump_2ngt", " please", "2ngt"},
+		{"dump", " 2ngt please", "2ngt"},
+		{"dump", "", ""},
+		{"sub_2ngt", " rooms=2", "2ngt rooms=2"},
+		{"sub", " 2ngt rooms=2", " 2ngt rooms=2"},
+	}
+	for _, test := range tests {
+		got = "not called"
+		require.True(t, dispatchCommand(&CommandRequest{ChatID: 1, Command: test.command, Args: test.args}), test.command)
+		require.Equal(t, test.expectedArgs, got, test.command+test.args)
+	}
+}
+
+func TestBotCommandsAreValid(t *testing.T) {
+	names := make(map[string]bool)
+	for _, command := range botCommands {
+		require.Regexp(t, `^[a-z0-9_]{1,32}$`, command.Name)
+		require.False(t, names[command.Name], "duplicate command %v", command.Name)
+		require.NotNil(t, command.Handler, command.Name)
+		if !command.Hidden {
+			for _, lang := range util.Langs {
+				require.True(t, util.HasMessage(lang, command.Description), "%v in %v", command.Name, lang)
+			}
+		}
+		names[command.Name] = true
+	}
+
+	for _, lang := range util.Langs {
+		for _, item := range commandsMenu(botCommandScope{Type: commandScopeChat, ChatID: 1}, lang) {
+			require.LessOrEqual(t, utf8.RuneCountInString(item.Description), 256, item.Command)
+		}
+	}
+}
+
+func TestAdminCommands(t *testing.T) {
+	oldAdminChatIDs := AdminChatIDs
+	defer func() { AdminChatIDs = oldAdminChatIDs }()
+
+	AdminChatIDs = "123, -100500,bad"
+	require.Equal(t, []int64{123, -100500}, GetAdminChatIDs())
+	require.True(t, IsAdmin(-100500))
+	require.False(t, IsAdmin(42))
+
+	// the handler is not reached, the command is unknown for the others
+	require.False(t, dispatchCommand(&CommandRequest{ChatID: 42, Command: ReloadCommand}))
+
+	for _, name := range []string{BroadcastCommand, ReloadCommand, BackupNowCommand, RotateNowCommand} {
+		command, _ := findCommand(name)
+		require.NotNil(t, command, name)
+		require.True(t, command.AdminOnly, name)
+
+		oldHandler := command.Handler
+		t.Cleanup(func() { command.Handler = oldHandler })
+		var called bool
+		command.Handler = func(req *CommandRequest) {
+			called = true
+		}
+		require.False(t, dispatchCommand(&CommandRequest{ChatID: 42, Command: name, Args: " hello"}), name)
+		require.False(t, called, name)
+		require.True(t, dispatchCommand(&CommandRequest{ChatID: 123, Command: name}), name)
+		require.True(t, called, name)
+
+		helpLine := escapeHTML(command.Usage()) + " - "
+		require.NotContains(t, renderHelp(42), "\n"+helpLine, name)
+		require.Contains(t, renderHelp(123), "\n"+helpLine, name)
+	}
+
+	for _, command := range commandsMenu(botCommandScope{Type: commandScopePrivateChats}, util.DefaultLang) {
+		found, _ := findCommand(command.Command)
+		require.False(t, found.AdminOnly, command.Command)
+	}
+}
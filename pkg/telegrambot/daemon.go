@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/georgri/pik_tg_bot/pkg/backup_data"
 	"github.com/georgri/pik_tg_bot/pkg/downloader"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
 	"github.com/georgri/pik_tg_bot/pkg/logrotator"
 	"github.com/georgri/pik_tg_bot/pkg/util"
 	"log"
@@ -36,16 +37,31 @@ func RunForever() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGSTOP)
 	defer stop()
 
+	go func() {
+		err := RegisterBotCommands()
+		if err != nil {
+			log.Printf("failed to register bot commands: %v", err)
+		}
+	}()
+
+	go SendMessagesForever(ctx, wg)
+
 	go logrotator.RotateLogsForever(ctx, wg)
 
 	go backup_data.BackupDataForever(ctx, wg)
 
 	go UpdateBlocksForever(ctx, wg)
 
-	go GetUpdatesForever(ctx, wg)
+	if WebhookEnabled() {
+		go ServeWebhookForever(ctx, wg)
+	} else {
+		go GetUpdatesForever(ctx, wg)
+	}
 
 	go RunUpdateFlatsForever(ctx, wg)
 
+	go DeliverPendingForever(ctx, wg)
+
 	<-ctx.Done()
 
 	// wait for max 10 seconds
@@ -68,6 +84,8 @@ func RunForever() {
 }
 
 func RunUpdateFlatsForever(ctx context.Context, wg *sync.WaitGroup) {
+	RebuildMetroIndex()
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -83,21 +101,44 @@ func RunUpdateFlatsForever(ctx context.Context, wg *sync.WaitGroup) {
 func RunUpdateFlatsOnce(ctx context.Context, wg *sync.WaitGroup) {
 	log.Printf("begin to check for updates")
 
-	envType := util.GetEnvType()
-
 	// 1. Get map of block slug => subscribed channels
 	// 2. Update block slug
 	// 3. Send info to all subscribed channels
 
-	slugs := make(map[string][]int64, 10)
+	slugs := make(map[string][]ChannelInfo, 10)
 
-	for _, channelInfo := range ChannelIDs[envType] {
-		slugs[channelInfo.BlockSlug] = append(slugs[channelInfo.BlockSlug], channelInfo.ChatID)
+	for _, channelInfo := range getChannels() {
+		if IsChatDisabled(channelInfo.ChatID) {
+			continue // the chat blocked the bot or was deleted
+		}
+		slugs[channelInfo.BlockSlug] = append(slugs[channelInfo.BlockSlug], channelInfo)
+	}
+
+	// the updates of every complex are collected to notify the saved searches spanning several complexes at once
+	// and to subscribe the metro followers to the complexes found near their stations
+	updatesBySlug := make(map[string]*flatstorage.FlatUpdates)
+	newStations := make(map[string][]MetroStation)
+	updatesMutex := &sync.Mutex{}
+	process := func(slug string, channels []ChannelInfo) {
+		updates := ProcessWithSlugAndChannels(slug, channels)
+		if updates == nil {
+			return
+		}
+		var stations []MetroStation
+		if updates.NewFlats != nil {
+			stations = indexBlockMetro(slug, updates.NewFlats.Flats)
+		}
+		updatesMutex.Lock()
+		defer updatesMutex.Unlock()
+		updatesBySlug[slug] = updates
+		if len(stations) > 0 {
+			newStations[slug] = stations
+		}
 	}
 
 	var count int
 	threadsWg := &sync.WaitGroup{}
-	for slug, chatIDs := range slugs {
+	for slug, channels := range slugs {
 
 		select {
 		case <-ctx.Done():
@@ -108,11 +149,11 @@ func RunUpdateFlatsOnce(ctx context.Context, wg *sync.WaitGroup) {
 		threadsWg.Add(1)
 		wg.Add(1)
 		count += 1
-		go func(slug string, chatIDs []int64, threadsWg, wg *sync.WaitGroup) {
-			ProcessWithSlugAndChatIDs(slug, chatIDs)
+		go func(slug string, channels []ChannelInfo, threadsWg, wg *sync.WaitGroup) {
+			process(slug, channels)
 			threadsWg.Done()
 			wg.Done()
-		}(slug, chatIDs, threadsWg, wg)
+		}(slug, channels, threadsWg, wg)
 
 		if count%maxHttpThreads == 0 {
 			threadsWg.Wait()
@@ -134,8 +175,8 @@ func RunUpdateFlatsOnce(ctx context.Context, wg *sync.WaitGroup) {
 		threadsWg.Add(1)
 		wg.Add(1)
 		count += 1
-		go func(slug string, chatIDs []int64, threadsWg, wg *sync.WaitGroup) {
-			ProcessWithSlugAndChatIDs(slug, chatIDs)
+		go func(slug string, channels []ChannelInfo, threadsWg, wg *sync.WaitGroup) {
+			process(slug, channels)
 			threadsWg.Done()
 			wg.Done()
 		}(slug, nil, threadsWg, wg)
@@ -146,43 +187,81 @@ func RunUpdateFlatsOnce(ctx context.Context, wg *sync.WaitGroup) {
 	}
 	threadsWg.Wait() // wait synchronously before triggering the next job
 	log.Printf("checked updates for %v projects", count)
+
+	notifySavedSearches(updatesBySlug)
+	subscribeMetroFollowers(newStations)
 }
 
-func ProcessWithSlugAndChatIDs(blockSlug string, chatIDs []int64) {
-	msgs, err := DownloadAndUpdateFile(blockSlug)
+// ProcessWithSlugAndChannels downloads new flats for the block and sends every subscriber
+// the messages built from the flats matching the subscription filter, returns the updates if there are any
+func ProcessWithSlugAndChannels(blockSlug string, channels []ChannelInfo) *flatstorage.FlatUpdates {
+	updates, err := DownloadAndUpdateFile(blockSlug)
 	if err != nil {
 		//if err == errorNoNewFlats {
-		//	return
+		//	return nil
 		//}
 		log.Printf("error while updating flats: %v", err)
-		return
+		return nil
 	}
 
-	for i := range msgs {
-		if len(msgs[i]) > 0 && msgs[i][0] == '!' { // let ! be the magic symbol to send to all known chats
-			err = SendToAllKnownChats(msgs[i])
-			if err != nil {
-				log.Printf("error while sending message to all known chats about %v: %v", blockSlug, err)
-				return
+	// extreme price drops by the default thresholds go to all known chats which didn't opt out
+	broadcastMessage := func(lang util.Lang) string {
+		for _, msg := range updates.Messages(lang) {
+			if msg.Audience == flatstorage.AudienceAllChats {
+				return msg.Text
 			}
-		} else {
-			for _, chatID := range chatIDs {
-				err = SendMessage(chatID, msgs[i])
-				if err != nil {
-					log.Printf("error while sending message in %v (chatID %v): %v", blockSlug, chatID, err)
-					return
-				}
+		}
+		return ""
+	}
+	var broadcasted, broadcastedFlats map[int64]bool
+	if broadcastMessage(util.DefaultLang) != "" {
+		broadcasted, err = SendToAllKnownChats(BroadcastExtremeDrops, blockSlug, broadcastMessage)
+		if err != nil {
+			log.Printf("error while sending message to all known chats about %v: %v", blockSlug, err)
+			return updates
+		}
+		broadcastedFlats = updates.ExtremePriceDrops.FlatIDs()
+	}
+
+	for _, channel := range channels {
+		lang := GetChatLang(channel.ChatID)
+		var alreadySent map[int64]bool
+		if broadcasted[channel.ChatID] {
+			alreadySent = broadcastedFlats
+		}
+		channelUpdates := subscriberUpdates(updates, channel, alreadySent)
+		// the flats notified about recently are updated in the previous messages
+		if channelUpdates.PriceDrops != nil {
+			edited := editNotifiedFlats(channel.ChatID, channelUpdates.PriceDrops, lang)
+			if len(edited) > 0 {
+				recordNotification(channel.ChatID, channel.BlockSlug, time.Now())
 			}
+			channelUpdates = channelUpdates.WithoutPriceDrops(edited)
+		}
+		for _, msg := range channelUpdates.Messages(lang) {
+			NotifyAboutFlats(channel.ChatID, msg.Text, msg.Flats)
+			recordNotification(channel.ChatID, channel.BlockSlug, time.Now())
 		}
 	}
+	return updates
 }
 
-func DownloadAndUpdateFile(blockSlug string) ([]string, error) {
+// subscriberUpdates applies the filter and the thresholds of the subscription to the updates.
+// The flats the chat already got with the extreme price drops sent to all known chats are left out,
+// so the chat with custom thresholds gets only the rest, e.g. the flats extreme by its lower threshold.
+func subscriberUpdates(updates *flatstorage.FlatUpdates, channel ChannelInfo, alreadySent map[int64]bool) *flatstorage.FlatUpdates {
+	return updates.Filter(channel.Filter).
+		WithThresholds(channel.Thresholds).
+		WithoutPriceDrops(alreadySent).
+		WithoutExtremePriceDrops(alreadySent)
+}
+
+func DownloadAndUpdateFile(blockSlug string) (*flatstorage.FlatUpdates, error) {
 	blockID := GetBlockIDBySlug(blockSlug)
 
 	envtype := util.GetEnvType().String()
 
-	flatMsgs, updateCallback, filterInfo, err := downloader.GetFlats(blockID)
+	updates, updateCallback, filterInfo, err := downloader.GetFlats(blockID)
 	if err != nil {
 		//if err == downloader.ErrorZeroFlats {
 		//	return nil, errorNoNewFlats
@@ -190,19 +269,25 @@ func DownloadAndUpdateFile(blockSlug string) ([]string, error) {
 		return nil, fmt.Errorf("failed to get flats for zhk %v (blockID %v, envtype %v): %w", blockSlug, blockID, envtype, err)
 	}
 
-	err = updateCallback()
+	events, err := updateCallback()
 	if err != nil {
 		return nil, fmt.Errorf("update callback failed in %v (envtype %v): %v", blockSlug, envtype, err)
 	}
+	recordDownload(blockSlug, time.Now())
+
+	// watched flats, lifecycle and handover alerts are notified about even if there are no new flats or price drops
+	notifyWatchers(events)
+	notifyLifecycle(blockSlug, events)
+	notifyBulkChanges(blockSlug, events)
 
-	if len(flatMsgs) == 0 {
+	if updates.Empty() {
 		if filterInfo != nil {
 			return nil, fmt.Errorf("got 0 new flats after local filtering for zhk %v (blockID %v, envtype %v); local-filter: %v", blockSlug, blockID, envtype, filterInfo)
 		}
 		return nil, fmt.Errorf("got 0 new flats after local filtering for zhk %v (blockID %v, envtype %v)", blockSlug, blockID, envtype)
 	}
 
-	log.Printf("Got flats in %v (envtype %v): %v", blockSlug, envtype, flatMsgs)
+	log.Printf("Got flats in %v (envtype %v): %v", blockSlug, envtype, updates.Strings())
 
-	return flatMsgs, nil
+	return updates, nil
 }
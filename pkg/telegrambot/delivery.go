@@ -0,0 +1,262 @@
+package telegrambot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	_ "time/tzdata" // the bot may run on a host without the timezone database
+)
+
+type DeliveryMode string
+
+const (
+	DeliveryInstant DeliveryMode = "instant"
+	DeliveryHourly  DeliveryMode = "hourly"
+	DeliveryDaily   DeliveryMode = "daily"
+)
+
+const (
+	deliveryKeyQuiet    = "quiet"
+	deliveryKeyTimeZone = "tz"
+	deliveryOff         = "off"
+	deliveryDefault     = "default"
+
+	defaultDailyAt  = "09:00"
+	defaultTimeZone = "Europe/Moscow"
+)
+
+// DeliverySyntax is a short human-readable description of the delivery arguments
+const DeliverySyntax = "instant | hourly | daily=09:00 quiet=23:00-08:00 | quiet=off tz=Europe/Moscow | default"
+
+// DeliverySettings decide when the notifications are sent to the chat, all times are local to TimeZone.
+// Zero values mean the defaults: instant delivery without quiet hours in Moscow time.
+type DeliverySettings struct {
+	Mode    DeliveryMode `json:"mode,omitempty"`
+	DailyAt string       `json:"daily_at,omitempty"` // HH:MM of the daily digest
+
+	// notifications are held from QuietFrom till QuietTo, the window may cross midnight
+	QuietFrom string `json:"quiet_from,omitempty"`
+	QuietTo   string `json:"quiet_to,omitempty"`
+
+	TimeZone string `json:"time_zone,omitempty"`
+}
+
+// ParseDeliverySettings applies args like "daily=21:00 quiet=23:00-08:00" to a copy of the settings
+func ParseDeliverySettings(args []string, settings *DeliverySettings) (*DeliverySettings, error) {
+	res := &DeliverySettings{}
+	if settings != nil {
+		*res = *settings
+	}
+	for _, arg := range args {
+		arg = strings.TrimSpace(arg)
+		key, value, _ := strings.Cut(arg, "=")
+		switch DeliveryMode(strings.ToLower(key)) {
+		case "":
+			continue
+		case deliveryDefault:
+			res = &DeliverySettings{}
+			continue
+		case DeliveryInstant, DeliveryHourly:
+			res.Mode = DeliveryMode(strings.ToLower(key))
+			continue
+		case DeliveryDaily:
+			res.Mode = DeliveryDaily
+			if value != "" {
+				minutes, err := parseClock(value)
+				if err != nil {
+					return nil, err
+				}
+				res.DailyAt = formatClock(minutes)
+			}
+			continue
+		}
+
+		switch strings.ToLower(key) {
+		case deliveryKeyQuiet:
+			if strings.ToLower(value) == deliveryOff {
+				res.QuietFrom, res.QuietTo = "", ""
+				continue
+			}
+			from, to, ok := strings.Cut(value, "-")
+			if !ok {
+				return nil, fmt.Errorf("invalid quiet hours %q, expected something like %q", value, "23:00-08:00")
+			}
+			fromMinutes, err := parseClock(from)
+			if err != nil {
+				return nil, err
+			}
+			toMinutes, err := parseClock(to)
+			if err != nil {
+				return nil, err
+			}
+			if fromMinutes == toMinutes {
+				return nil, fmt.Errorf("invalid quiet hours %q: the window is empty", value)
+			}
+			res.QuietFrom, res.QuietTo = formatClock(fromMinutes), formatClock(toMinutes)
+		case deliveryKeyTimeZone:
+			if _, err := time.LoadLocation(value); err != nil || value == "" {
+				return nil, fmt.Errorf("unknown time zone %q, expected something like %q", value, defaultTimeZone)
+			}
+			res.TimeZone = value
+		default:
+			return nil, fmt.Errorf("unknown delivery option %q", arg)
+		}
+	}
+	return res, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+func (d *DeliverySettings) GetMode() DeliveryMode {
+	if d == nil || d.Mode == "" {
+		return DeliveryInstant
+	}
+	return d.Mode
+}
+
+func (d *DeliverySettings) GetDailyAt() string {
+	if d == nil || d.DailyAt == "" {
+		return defaultDailyAt
+	}
+	return d.DailyAt
+}
+
+func (d *DeliverySettings) GetTimeZone() string {
+	if d == nil || d.TimeZone == "" {
+		return defaultTimeZone
+	}
+	return d.TimeZone
+}
+
+func (d *DeliverySettings) Location() *time.Location {
+	loc, err := time.LoadLocation(d.GetTimeZone())
+	if err != nil {
+		log.Printf("failed to load time zone %v: %v", d.GetTimeZone(), err)
+		return time.UTC
+	}
+	return loc
+}
+
+func (d *DeliverySettings) HasQuietHours() bool {
+	return d != nil && d.QuietFrom != "" && d.QuietTo != ""
+}
+
+func (d *DeliverySettings) IsDefault() bool {
+	return d == nil || *d == DeliverySettings{}
+}
+
+// InQuietHours checks if the notifications must be held at the moment
+func (d *DeliverySettings) InQuietHours(now time.Time) bool {
+	if !d.HasQuietHours() {
+		return false
+	}
+	from, errFrom := parseClock(d.QuietFrom)
+	to, errTo := parseClock(d.QuietTo)
+	if errFrom != nil || errTo != nil {
+		return false
+	}
+
+	local := now.In(d.Location())
+	minutes := local.Hour()*60 + local.Minute()
+	if from < to {
+		return from <= minutes && minutes < to
+	}
+	return minutes >= from || minutes < to
+}
+
+// lastDigestTime returns the latest moment not after now when a digest was due
+func (d *DeliverySettings) lastDigestTime(now time.Time) time.Time {
+	local := now.In(d.Location())
+	switch d.GetMode() {
+	case DeliveryHourly:
+		return time.Date(local.Year(), local.Month(), local.Day(), local.Hour(), 0, 0, 0, local.Location())
+	case DeliveryDaily:
+		minutes, err := parseClock(d.GetDailyAt())
+		if err != nil {
+			minutes, _ = parseClock(defaultDailyAt)
+		}
+		res := time.Date(local.Year(), local.Month(), local.Day(), minutes/60, minutes%60, 0, 0, local.Location())
+		if res.After(local) {
+			res = res.AddDate(0, 0, -1)
+		}
+		return res
+	default:
+		return now
+	}
+}
+
+// Holds checks if a notification created now must be put aside instead of sending it right away
+func (d *DeliverySettings) Holds(now time.Time) bool {
+	return d.GetMode() != DeliveryInstant || d.InQuietHours(now)
+}
+
+// IsDue checks if the notifications pending since the given moment must be sent now
+func (d *DeliverySettings) IsDue(since time.Time, now time.Time) bool {
+	if d.InQuietHours(now) {
+		return false
+	}
+	if d.GetMode() == DeliveryInstant {
+		return true
+	}
+	return since.Before(d.lastDigestTime(now))
+}
+
+// String returns the settings in the same syntax they are set with
+func (d *DeliverySettings) String() string {
+	res := []string{string(d.GetMode())}
+	if d.GetMode() == DeliveryDaily {
+		res[0] += "=" + d.GetDailyAt()
+	}
+	if d.HasQuietHours() {
+		res = append(res, fmt.Sprintf("%v=%v-%v", deliveryKeyQuiet, d.QuietFrom, d.QuietTo))
+	}
+	res = append(res, deliveryKeyTimeZone+"="+d.GetTimeZone())
+	return strings.Join(res, " ")
+}
+
+// changeDelivery handles /delivery [settings], without args it shows the current settings
+func changeDelivery(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) > 0 {
+		delivery, err := ParseDeliverySettings(fields, GetChatSettings(chatID).Delivery)
+		if err != nil {
+			err = SendMessage(chatID, localize(chatID, MsgInvalidDelivery, escapeHTML(err.Error()), DeliveryCommand, DeliverySyntax))
+			if err != nil {
+				log.Printf("failed to send invalid delivery message to %v: %v", chatID, err)
+			}
+			return
+		}
+		if delivery.IsDefault() {
+			delivery = nil
+		}
+
+		err = UpdateChatSettings(chatID, func(settings *ChatSettings) {
+			settings.Delivery = delivery
+		})
+		if err != nil {
+			log.Printf("failed to update delivery settings of %v: %v", chatID, err)
+			err = SendMessage(chatID, localize(chatID, MsgDeliveryUpdateFailed, err))
+			if err != nil {
+				log.Printf("failed to send delivery update failed message to %v: %v", chatID, err)
+			}
+			return
+		}
+	}
+
+	err := SendMessage(chatID, localize(chatID, MsgDeliverySettings, GetChatSettings(chatID).Delivery, DeliveryCommand, DeliverySyntax))
+	if err != nil {
+		log.Printf("failed to send delivery settings to %v: %v", chatID, err)
+	}
+}
@@ -0,0 +1,84 @@
+package telegrambot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeliverySettings(t *testing.T) {
+	settings, err := ParseDeliverySettings([]string{"daily=8:30", "quiet=23:00-08:00"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, &DeliverySettings{Mode: DeliveryDaily, DailyAt: "08:30", QuietFrom: "23:00", QuietTo: "08:00"}, settings)
+	require.Equal(t, "daily=08:30 quiet=23:00-08:00 tz=Europe/Moscow", settings.String())
+
+	changed, err := ParseDeliverySettings([]string{"hourly", "quiet=off", "tz=Asia/Yekaterinburg"}, settings)
+	require.NoError(t, err)
+	require.Equal(t, &DeliverySettings{Mode: DeliveryHourly, DailyAt: "08:30", TimeZone: "Asia/Yekaterinburg"}, changed)
+	require.Equal(t, DeliveryDaily, settings.Mode, "the original settings must not change")
+
+	reset, err := ParseDeliverySettings([]string{"default"}, changed)
+	require.NoError(t, err)
+	require.True(t, reset.IsDefault())
+
+	for _, args := range [][]string{{"weekly"}, {"daily=25:00"}, {"quiet=23:00"}, {"quiet=10:00-10:00"}, {"tz=Mars/Olympus"}} {
+		_, err = ParseDeliverySettings(args, nil)
+		require.Error(t, err, args)
+	}
+}
+
+func TestDeliverySettings_InQuietHours(t *testing.T) {
+	moscow, err := time.LoadLocation(defaultTimeZone)
+	require.NoError(t, err)
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 3, 1, hour, minute, 0, 0, moscow)
+	}
+
+	var instant *DeliverySettings
+	require.False(t, instant.InQuietHours(at(3, 0)))
+
+	overnight := &DeliverySettings{QuietFrom: "23:00", QuietTo: "08:00"}
+	require.True(t, overnight.InQuietHours(at(23, 0)))
+	require.True(t, overnight.InQuietHours(at(3, 0)))
+	require.False(t, overnight.InQuietHours(at(8, 0)))
+	require.False(t, overnight.InQuietHours(at(12, 0)))
+
+	daytime := &DeliverySettings{QuietFrom: "13:00", QuietTo: "15:00"}
+	require.True(t, daytime.InQuietHours(at(14, 59)))
+	require.False(t, daytime.InQuietHours(at(15, 0)))
+	require.False(t, daytime.InQuietHours(at(3, 0)))
+}
+
+func TestDeliverySettings_IsDue(t *testing.T) {
+	moscow, err := time.LoadLocation(defaultTimeZone)
+	require.NoError(t, err)
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 3, day, hour, minute, 0, 0, moscow)
+	}
+
+	var instant *DeliverySettings
+	require.False(t, instant.Holds(at(1, 3, 0)))
+	require.True(t, instant.IsDue(at(1, 3, 0), at(1, 3, 0)))
+
+	hourly := &DeliverySettings{Mode: DeliveryHourly}
+	require.True(t, hourly.Holds(at(1, 10, 15)))
+	require.False(t, hourly.IsDue(at(1, 10, 15), at(1, 10, 59)))
+	require.True(t, hourly.IsDue(at(1, 10, 15), at(1, 11, 0)))
+
+	daily := &DeliverySettings{Mode: DeliveryDaily, DailyAt: "09:00"}
+	require.False(t, daily.IsDue(at(1, 10, 0), at(1, 23, 0)))
+	require.False(t, daily.IsDue(at(1, 10, 0), at(2, 8, 59)))
+	require.True(t, daily.IsDue(at(1, 10, 0), at(2, 9, 0)))
+	require.True(t, daily.IsDue(at(1, 8, 0), at(1, 9, 30)))
+
+	// held during the quiet hours and sent when they end
+	quiet := &DeliverySettings{QuietFrom: "23:00", QuietTo: "08:00"}
+	require.True(t, quiet.Holds(at(1, 23, 30)))
+	require.False(t, quiet.IsDue(at(1, 23, 30), at(2, 7, 59)))
+	require.True(t, quiet.IsDue(at(1, 23, 30), at(2, 8, 0)))
+
+	dailyInQuietHours := &DeliverySettings{Mode: DeliveryDaily, DailyAt: "07:00", QuietFrom: "23:00", QuietTo: "08:00"}
+	require.False(t, dailyInQuietHours.IsDue(at(1, 12, 0), at(2, 7, 0)))
+	require.True(t, dailyInQuietHours.IsDue(at(1, 12, 0), at(2, 8, 0)))
+}
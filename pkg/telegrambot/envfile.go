@@ -0,0 +1,110 @@
+package telegrambot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// envFile keeps a list per env in a single JSON file, the layout is the same as ChannelsFileMap:
+// {"prod": [...], "dev": [...]}
+type envFile[T any] struct {
+	fileName string
+
+	// readErr is set when the existing file can't be read, the file is never overwritten then,
+	// so a corrupted file can still be fixed by hand instead of being replaced with the empty lists
+	readErr error
+}
+
+func newEnvFile[T any](fileName string) *envFile[T] {
+	return &envFile[T]{fileName: fileName}
+}
+
+// read returns the lists by env, no lists if the file doesn't exist; the unknown envs are skipped
+func (f *envFile[T]) read() (map[util.EnvType][]T, error) {
+	lists := make(map[util.EnvType][]T)
+
+	if !flatstorage.FileExists(f.fileName) {
+		return lists, nil
+	}
+
+	content, err := os.ReadFile(f.fileName)
+	if err != nil {
+		f.readErr = err
+		return nil, err
+	}
+	fileLists := make(map[string][]T)
+	err = json.Unmarshal(content, &fileLists)
+	if err != nil {
+		f.readErr = err
+		return nil, err
+	}
+	f.readErr = nil
+
+	for envTypeStr, list := range fileLists {
+		envType, ok := util.EnvTypeFromString[envTypeStr]
+		if !ok {
+			log.Printf("unknown envtype in %v: %v", f.fileName, envTypeStr)
+			continue
+		}
+		lists[envType] = list
+	}
+	return lists, nil
+}
+
+// load is read for the package init, the failure is only logged and no lists are returned
+func (f *envFile[T]) load() map[util.EnvType][]T {
+	lists, err := f.read()
+	if err != nil {
+		log.Printf("unable to read %v: %v", f.fileName, err)
+		return make(map[util.EnvType][]T)
+	}
+	return lists
+}
+
+// write replaces the file with the lists, the caller must hold the lock guarding them
+func (f *envFile[T]) write(lists map[util.EnvType][]T) error {
+	if f.readErr != nil {
+		return fmt.Errorf("refusing to overwrite %v which failed to load: %w", f.fileName, f.readErr)
+	}
+	fileLists := make(map[string][]T, len(lists))
+	for envtype, list := range lists {
+		fileLists[envtype.String()] = list
+	}
+	newContent, err := json.Marshal(fileLists)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.fileName, newContent)
+}
+
+// writeFileAtomic writes the content to a temporary file next to the target and renames it over the target,
+// so a crash in the middle of the write leaves the old file intact
+func writeFileAtomic(fileName string, content []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName) // fails harmlessly once renamed
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(tmpName, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
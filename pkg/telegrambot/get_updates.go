@@ -12,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf16"
 )
 
 const (
@@ -20,8 +21,14 @@ const (
 	getUpdatesLimitMessages      = 100
 )
 
+// allowedUpdates JSON-serialized list of update types to receive
+const allowedUpdates = `["message","channel_post","callback_query","inline_query"]`
+
 var LatestKnownUpdateID int64
 
+// processUpdatesMutex serializes updates processing since webhook requests may come concurrently
+var processUpdatesMutex sync.Mutex
+
 // how to set up a command suggestions:
 // https://core.telegram.org/bots/api#setmycommands
 
@@ -32,50 +39,43 @@ var LatestKnownUpdateID int64
 // "message":{"message_id":4,"from":{"id":258990915,"is_bot":false,"first_name":"Georgy","last_name":"Riskov","username":"georgri","language_code":"ru","is_premium":true},"chat":{"id":258990915,"first_name":"Georgy","last_name":"Riskov","username":"georgri","type":"private"},"date":1716056626,"text":"/hello","entities":[{"offset":0,"length":6,"type":"bot_command"}]}},{"update_id":231999260,
 // "message":{"message_id":5,"from":{"id":258990915,"is_bot":false,"first_name":"Georgy","last_name":"Riskov","username":"georgri","language_code":"ru","is_premium":true},"chat":{"id":258990915,"first_name":"Georgy","last_name":"Riskov","username":"georgri","type":"private"},"date":1716056923,"text":"\u043f\u0440\u0438\u0432\u0435\u0442!"}}]}
 type UpdateStruct struct {
-	UpdateId    int64 `json:"update_id"`
-	ChannelPost struct {
-		MessageId  int64 `json:"message_id"`
-		SenderChat struct {
-			Id       int64  `json:"id"`
-			Title    string `json:"title"`
-			Username string `json:"username"`
-			Type     string `json:"type"`
-		} `json:"sender_chat"`
-		Chat struct {
-			Id       int64  `json:"id"`
-			Title    string `json:"title"`
-			Username string `json:"username"`
-			Type     string `json:"type"`
-		} `json:"chat"`
-		Date int    `json:"date"`
-		Text string `json:"text"`
-	} `json:"channel_post,omitempty"`
-	Message struct {
-		MessageId int64 `json:"message_id"`
-		From      struct {
-			Id           int64  `json:"id"`
-			IsBot        bool   `json:"is_bot"`
-			FirstName    string `json:"first_name"`
-			LastName     string `json:"last_name"`
-			Username     string `json:"username"`
-			LanguageCode string `json:"language_code"`
-			IsPremium    bool   `json:"is_premium"`
-		} `json:"from"`
-		Chat struct {
-			Id        int64  `json:"id"`
-			FirstName string `json:"first_name"`
-			LastName  string `json:"last_name"`
-			Username  string `json:"username"`
-			Type      string `json:"type"`
-		} `json:"chat"`
-		Date     int    `json:"date"`
-		Text     string `json:"text"`
-		Entities []struct {
-			Offset int64  `json:"offset"`
-			Length int64  `json:"length"`
-			Type   string `json:"type"`
-		} `json:"entities,omitempty"`
-	} `json:"message,omitempty"`
+	UpdateId      int64           `json:"update_id"`
+	ChannelPost   TelegramMessage `json:"channel_post,omitempty"`
+	Message       TelegramMessage `json:"message,omitempty"`
+	CallbackQuery *CallbackQuery  `json:"callback_query,omitempty"`
+	InlineQuery   *InlineQuery    `json:"inline_query,omitempty"`
+}
+
+// TelegramMessage is either a message in a private chat or a group, or a post in a channel
+type TelegramMessage struct {
+	MessageId  int64        `json:"message_id"`
+	SenderChat TelegramChat `json:"sender_chat"`
+	From       struct {
+		Id           int64  `json:"id"`
+		IsBot        bool   `json:"is_bot"`
+		FirstName    string `json:"first_name"`
+		LastName     string `json:"last_name"`
+		Username     string `json:"username"`
+		LanguageCode string `json:"language_code"`
+		IsPremium    bool   `json:"is_premium"`
+	} `json:"from"`
+	Chat     TelegramChat `json:"chat"`
+	Date     int          `json:"date"`
+	Text     string       `json:"text"`
+	Entities []struct {
+		Offset int64  `json:"offset"`
+		Length int64  `json:"length"`
+		Type   string `json:"type"`
+	} `json:"entities,omitempty"`
+}
+
+type TelegramChat struct {
+	Id        int64  `json:"id"`
+	Title     string `json:"title"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Username  string `json:"username"`
+	Type      string `json:"type"`
 }
 
 type BotUpdatesStruct struct {
@@ -114,12 +114,12 @@ func GetUpdatesOnce() (*BotUpdatesStruct, error) {
 	// also need params (see https://core.telegram.org/bots/api#getting-updates):
 	// offset = latest known update_id + 1
 	// limit = 100
-	// allowed_updates = ["message"]
+	// allowed_updates = ["message", "channel_post", "callback_query", "inline_query"]
 	// timeout = 300 (seconds)
 	values := url.Values{
 		"offset":          []string{fmt.Sprintf("%v", LatestKnownUpdateID+1)},
 		"limit":           []string{fmt.Sprintf("%v", getUpdatesLimitMessages)},
-		"allowed_updates": []string{"message"},
+		"allowed_updates": []string{allowedUpdates},
 		"timeout":         []string{fmt.Sprintf("%v", getUpdatesPollTimeoutSeconds)},
 	}
 
@@ -215,6 +215,9 @@ func ProcessUpdates(updates *BotUpdatesStruct, wg *sync.WaitGroup) {
 	wg.Add(1)
 	defer wg.Done()
 
+	processUpdatesMutex.Lock()
+	defer processUpdatesMutex.Unlock()
+
 	for _, update := range updates.Result {
 		processUpdate(update)
 		LatestKnownUpdateID = util.Max(LatestKnownUpdateID, update.UpdateId)
@@ -225,45 +228,91 @@ func processUpdate(update *UpdateStruct) {
 	if update == nil {
 		return
 	}
-	for _, entity := range update.Message.Entities {
+	if update.CallbackQuery != nil {
+		processCallbackQuery(update.CallbackQuery)
+		return
+	}
+	if update.InlineQuery != nil {
+		processInlineQuery(update.InlineQuery)
+		return
+	}
+
+	msg := &update.Message
+	if update.ChannelPost.Chat.Id != 0 {
+		msg = &update.ChannelPost
+	}
+	if msg.Chat.Id != 0 {
+		reviveChat(msg.Chat.Id)
+	}
+	rememberLanguageCode(msg.Chat.Id, msg.From.LanguageCode, msg.Chat.Type == "private")
+	for _, req := range messageCommands(msg, util.GetBotUsername()) {
+		dispatchCommand(req)
+	}
+}
+
+// messageCommands extracts the commands addressed to the bot from the message,
+// the commands mentioning other bots, e.g. /help@other_bot, are skipped
+func messageCommands(msg *TelegramMessage, botUsername string) []*CommandRequest {
+	// channel posts have no author, the signature of the channel is used instead
+	username := msg.From.Username
+	if username == "" {
+		username = msg.Chat.Title
+	}
+
+	// entity offsets are in UTF-16 code units
+	text := utf16.Encode([]rune(msg.Text))
+
+	var res []*CommandRequest
+	for _, entity := range msg.Entities {
 		if entity.Type != "bot_command" {
 			continue
 		}
 		offset, length := entity.Offset, entity.Length
-		command := strings.TrimLeft(update.Message.Text[offset:offset+length], "/")
+		if offset < 0 || length < 0 || offset+length > int64(len(text)) {
+			log.Printf("invalid command entity %v+%v in message %v of chat %v", offset, length, msg.MessageId, msg.Chat.Id)
+			continue
+		}
+		command := strings.TrimLeft(string(utf16.Decode(text[offset:offset+length])), "/")
+		command, ok := stripBotMention(command, botUsername)
+		if !ok {
+			continue
+		}
 
-		if command == "start" {
-			further := strings.TrimLeft(update.Message.Text[offset+length:], " ")
+		args := string(utf16.Decode(text[offset+length:]))
+		if command == StartCommand {
+			further := strings.TrimLeft(args, " ")
 			if len(further) > 0 {
 				command = further
+				args = ""
 			}
 		}
 
-		args := update.Message.Text[offset+length:]
-		if strings.Contains(command, "_") {
-			command, args, _ = strings.Cut(command, "_")
-		}
-		//args = util.UnEmbedSlug(args)
-		switch command {
-		case "hello":
-			sendHello(update.Message.Chat.Id, update.Message.From.Username)
-		case "list":
-			sendList(update.Message.Chat.Id, "list")
-		case "start":
-			sendList(update.Message.Chat.Id, "start")
-		case DumpCommand:
-			sendDump(update.Message.Chat.Id, args, DumpCommand)
-		case DumpAvgCommand:
-			sendDump(update.Message.Chat.Id, args, DumpAvgCommand)
-		case DumpInfoCommand:
-			sendDump(update.Message.Chat.Id, args, DumpInfoCommand)
-		case InfoCommand:
-			sendInfo(update.Message.Chat.Id, args, InfoCommand)
-		case SubscribeCommand:
-			subscribeChat(update.Message.Chat.Id, args)
-		case UnsubscribeCommand:
-			unsubscribeChat(update.Message.Chat.Id, args)
-		}
+		// the text after the command is kept, e.g. /sub_2ngt rooms=2 => args "2ngt rooms=2"
+		res = append(res, &CommandRequest{
+			ChatID:   msg.Chat.Id,
+			Username: username,
+			Command:  command,
+			Args:     args,
+		})
+	}
+	return res
+}
 
+// stripBotMention removes the bot username from the group commands: /sub_2ngt@bot => sub_2ngt,
+// the embedded args may go after the mention: /sub@bot_2ngt => sub_2ngt.
+// Returns false if the command mentions another bot.
+func stripBotMention(command string, botUsername string) (string, bool) {
+	name, mention, found := strings.Cut(command, "@")
+	if !found {
+		return command, true
+	}
+
+	botUsername = strings.ToLower(botUsername)
+	if strings.ToLower(mention) == botUsername {
+		return name, true
+	}
+	if strings.HasPrefix(strings.ToLower(mention), botUsername+"_") {
+		return name + mention[len(botUsername):], true
 	}
+	return "", false
 }
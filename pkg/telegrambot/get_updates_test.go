@@ -0,0 +1,60 @@
+package telegrambot
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStripBotMention(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected string
+		ok       bool
+	}{
+		{"sub_2ngt", "sub_2ngt", true},
+		{"sub_2ngt@pik_checker_bot", "sub_2ngt", true},
+		{"help@Pik_Checker_Bot", "help", true},
+		{"sub@pik_checker_bot_2ngt", "sub_2ngt", true},
+		{"info@pik_checker_bot_zhiloi_raion_yaroslavskii_123", "info_zhiloi_raion_yaroslavskii_123", true},
+		{"help@other_bot", "", false},
+		{"help@pik_checker_bot2", "", false},
+	}
+
+	for _, test := range tests {
+		command, ok := stripBotMention(test.command, "pik_checker_bot")
+		require.Equal(t, test.ok, ok, test.command)
+		require.Equal(t, test.expected, command, test.command)
+	}
+}
+
+func TestMessageCommands(t *testing.T) {
+	update := &UpdateStruct{}
+	err := json.Unmarshal([]byte(`{"update_id":231999257,
+		"channel_post":{"message_id":246,"sender_chat":{"id":-1002057808675,"title":"Pik checker bot tester","type":"channel"},
+		"chat":{"id":-1002057808675,"title":"Pik checker bot tester","type":"channel"},"date":1716055758,
+		"text":"Привет /sub@pik_checker_bot_2ngt rooms=2 /help@other_bot",
+		"entities":[{"offset":7,"length":25,"type":"bot_command"},{"offset":41,"length":15,"type":"bot_command"}]}}`), update)
+	require.NoError(t, err)
+
+	reqs := messageCommands(&update.ChannelPost, "pik_checker_bot")
+	require.Len(t, reqs, 1)
+	require.Equal(t, &CommandRequest{
+		ChatID:   -1002057808675,
+		Username: "Pik checker bot tester",
+		Command:  "sub_2ngt",
+		Args:     " rooms=2 /help@other_bot",
+	}, reqs[0])
+
+	err = json.Unmarshal([]byte(`{"update_id":231999258,
+		"message":{"message_id":3,"from":{"id":258990915,"is_bot":false,"username":"georgri","language_code":"ru"},
+		"chat":{"id":258990915,"username":"georgri","type":"private"},"date":1716055868,
+		"text":"/start dump_2ngt","entities":[{"offset":0,"length":6,"type":"bot_command"}]}}`), update)
+	require.NoError(t, err)
+
+	reqs = messageCommands(&update.Message, "pik_checker_bot")
+	require.Len(t, reqs, 1)
+	require.Equal(t, "dump_2ngt", reqs[0].Command)
+	require.Equal(t, "georgri", reqs[0].Username)
+}
@@ -8,9 +8,13 @@ require (
 	github.com/georgri/pik_tg_bot/pkg/downloader v0.0.0-20250106134635-f65b6a608188
 	github.com/georgri/pik_tg_bot/pkg/flatstorage v0.0.0-20250106134635-f65b6a608188
 	github.com/georgri/pik_tg_bot/pkg/util v0.0.0-20250106134635-f65b6a608188
+	github.com/stretchr/testify v1.8.4
 )
 
 require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,42 @@
+package telegrambot
+
+import (
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"strings"
+)
+
+// notifyBulkChanges sends the subscribers of the complex the settlement and finish changes grouped by bulk,
+// only the changes with the flats matching the subscription filter are sent
+func notifyBulkChanges(blockSlug string, events []flatstorage.FlatEvent) {
+	changes := flatstorage.GroupBulkChanges(events)
+	if len(changes) == 0 {
+		return
+	}
+
+	for _, subscription := range getBlockSubscriptions(blockSlug) {
+		text := renderBulkChanges(changes, subscription.Filter, GetChatLang(subscription.ChatID))
+		if text != "" {
+			Notify(subscription.ChatID, text, nil)
+		}
+	}
+}
+
+// renderBulkChanges lists the changes matching the filter under the complex header, empty if none match
+func renderBulkChanges(changes []flatstorage.BulkChange, filter *flatstorage.FlatFilter, lang util.Lang) string {
+	var lines []string
+	for i := range changes {
+		flats := matchFilter(changes[i].Flats, filter)
+		if len(flats) == 0 {
+			continue
+		}
+		change := changes[i]
+		change.Flats = flats
+		lines = append(lines, change.Format(lang))
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	header := util.Msg(lang, MsgBulkChangesHeader, changes[0].Flats[0].BlockName)
+	return header + "\n" + strings.Join(lines, "\n")
+}
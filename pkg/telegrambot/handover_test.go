@@ -0,0 +1,32 @@
+package telegrambot
+
+import (
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderBulkChanges(t *testing.T) {
+	events := []flatstorage.FlatEvent{
+		{Type: flatstorage.FlatEventSettlement, OldValue: "25Q3", NewValue: "26Q1",
+			Flat: flatstorage.Flat{ID: 1, Rooms: 1, BulkName: "Корпус 1.3", BlockName: "Второй Нагатинский"}},
+		{Type: flatstorage.FlatEventSettlement, OldValue: "25Q3", NewValue: "26Q1",
+			Flat: flatstorage.Flat{ID: 2, Rooms: 2, BulkName: "Корпус 1.3", BlockName: "Второй Нагатинский"}},
+		{Type: flatstorage.FlatEventPrice, OldValue: "100", NewValue: "90", Flat: flatstorage.Flat{ID: 3, Rooms: 1}},
+	}
+	changes := flatstorage.GroupBulkChanges(events)
+	require.Len(t, changes, 1)
+
+	require.Equal(t, "Handover changes in Второй Нагатинский:\nКорпус 1.3 handover moved 25Q3 → 26Q1 (2 flats)",
+		renderBulkChanges(changes, nil, util.DefaultLang))
+
+	filter, err := flatstorage.ParseFlatFilter([]string{"rooms=2"})
+	require.NoError(t, err)
+	require.Contains(t, renderBulkChanges(changes, filter, util.DefaultLang), "(1 flats)")
+
+	filter, err = flatstorage.ParseFlatFilter([]string{"rooms=3"})
+	require.NoError(t, err)
+	require.Empty(t, renderBulkChanges(changes, filter, util.DefaultLang))
+}
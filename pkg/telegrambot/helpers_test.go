@@ -0,0 +1,56 @@
+package telegrambot
+
+import (
+	"os"
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+// useTestStorage runs the test in an empty directory with the data dir, so the storage files are written there,
+// and replaces the subscriptions of the current env with the channels until the test ends
+func useTestStorage(t *testing.T, channels []ChannelInfo) {
+	t.Helper()
+
+	oldWD, err := os.Getwd()
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = os.Chdir(oldWD)
+	})
+	require.NoError(t, os.Chdir(t.TempDir()))
+	require.NoError(t, os.MkdirAll("data", 0o755))
+
+	envtype := util.GetEnvType()
+	channelsMutex.Lock()
+	oldChannels := ChannelIDs[envtype]
+	ChannelIDs[envtype] = channels
+	channelsMutex.Unlock()
+	t.Cleanup(func() {
+		channelsMutex.Lock()
+		ChannelIDs[envtype] = oldChannels
+		channelsMutex.Unlock()
+	})
+}
+
+// useTestOutbox empties the outbox of the current env until the test ends, returns the queued messages
+func useTestOutbox(t *testing.T) func() []OutboxItem {
+	t.Helper()
+
+	envtype := util.GetEnvType()
+	defaultOutbox.mu.Lock()
+	oldPending := defaultOutbox.pending[envtype]
+	defaultOutbox.pending[envtype] = nil
+	defaultOutbox.mu.Unlock()
+	t.Cleanup(func() {
+		defaultOutbox.mu.Lock()
+		defaultOutbox.pending[envtype] = oldPending
+		defaultOutbox.mu.Unlock()
+	})
+
+	return func() []OutboxItem {
+		defaultOutbox.mu.Lock()
+		defer defaultOutbox.mu.Unlock()
+		return append([]OutboxItem(nil), defaultOutbox.pending[envtype]...)
+	}
+}
@@ -0,0 +1,204 @@
+package telegrambot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Inline mode must be enabled for the bot with /setinline in @BotFather,
+// then typing "@pik_checker_bot 2ngt 2r <15m" in any chat lists the matching flats.
+// See https://core.telegram.org/bots/inline
+
+const (
+	inlineResultsPerPage = 50 // the maximum allowed by answerInlineQuery
+	inlineCacheTime      = 60 * time.Second
+)
+
+// inlineRoomsRe matches the short form of the rooms filter: 2r => rooms=2, 1,2r => rooms=1,2
+var inlineRoomsRe = regexp.MustCompile(`^(\d+(?:,\d+)*)r$`)
+
+// InlineQuery example:
+// {"id":"1129839021839012","from":{"id":258990915,"is_bot":false,"first_name":"Georgy","username":"georgri","language_code":"ru"},
+// "chat_type":"private","query":"2ngt 2r <15m","offset":""}
+type InlineQuery struct {
+	Id   string `json:"id"`
+	From struct {
+		Id           int64  `json:"id"`
+		Username     string `json:"username"`
+		LanguageCode string `json:"language_code"`
+	} `json:"from"`
+	Query  string `json:"query"`
+	Offset string `json:"offset"`
+}
+
+type InputTextMessageContent struct {
+	MessageText        string              `json:"message_text"`
+	ParseMode          string              `json:"parse_mode,omitempty"`
+	LinkPreviewOptions *LinkPreviewOptions `json:"link_preview_options,omitempty"`
+}
+
+type LinkPreviewOptions struct {
+	IsDisabled bool `json:"is_disabled"`
+}
+
+// InlineQueryResultArticle see https://core.telegram.org/bots/api#inlinequeryresultarticle
+type InlineQueryResultArticle struct {
+	Type                string                  `json:"type"`
+	Id                  string                  `json:"id"`
+	Title               string                  `json:"title"`
+	Description         string                  `json:"description,omitempty"`
+	URL                 string                  `json:"url,omitempty"`
+	InputMessageContent InputTextMessageContent `json:"input_message_content"`
+}
+
+// parseInlineQuery parses the short query syntax: an optional complex slug, then
+// "2r" for the rooms, "<15m" or ">=8m" for the price, and the usual search filters
+func parseInlineQuery(query string) (slug string, filter *flatstorage.FlatFilter, sortByAvg bool, err error) {
+	var searchArgs []string
+	for _, token := range strings.Fields(query) {
+		lowerToken := strings.ToLower(token)
+		if _, ok := BlockSlugs[util.EmbedSlug(lowerToken)]; ok && slug == "" {
+			slug = util.EmbedSlug(lowerToken)
+			continue
+		}
+		if match := inlineRoomsRe.FindStringSubmatch(lowerToken); match != nil {
+			searchArgs = append(searchArgs, flatstorage.FilterKeyRooms+"="+match[1])
+			continue
+		}
+		if strings.HasPrefix(token, "<") || strings.HasPrefix(token, ">") {
+			searchArgs = append(searchArgs, flatstorage.FilterKeyPrice+token)
+			continue
+		}
+		searchArgs = append(searchArgs, token)
+	}
+
+	filter, sortByAvg, err = parseSearchArgs(strings.Join(searchArgs, " "))
+	if err != nil {
+		return "", nil, false, err
+	}
+	return slug, filter, sortByAvg, nil
+}
+
+// searchInline looks for the flats of the complex, or of all the known complexes if the slug is empty
+func searchInline(slug string, filter *flatstorage.FlatFilter, sortByAvg bool) (*flatstorage.MessageData, error) {
+	if slug == "" {
+		return searchFlats(filter, sortByAvg), nil
+	}
+	flats, err := flatstorage.Store.QueryFlats(slug, filter)
+	if err != nil {
+		return nil, err
+	}
+	res := &flatstorage.MessageData{Flats: matchSearch(flats, nil, time.Now())}
+	res.SortFlats(sortByAvg)
+	return res, nil
+}
+
+// inlineLang uses the language chosen in the private chat with the bot, if any
+func inlineLang(query *InlineQuery) util.Lang {
+	settings := GetChatSettings(query.From.Id)
+	if settings.Lang != "" || settings.LanguageCode != "" {
+		return GetChatLang(query.From.Id)
+	}
+	return util.LangFromLanguageCode(query.From.LanguageCode)
+}
+
+func flatInlineResult(flat *flatstorage.Flat, lang util.Lang) InlineQueryResultArticle {
+	blockName := string(flat.BlockName)
+	return InlineQueryResultArticle{
+		Type: "article",
+		Id:   fmt.Sprintf("%v", flat.ID),
+		Title: util.Msg(lang, MsgInlineFlatTitle, blockName, flat.Rooms, fmt.Sprintf("%.1f", flat.Area),
+			util.ThousandSep(flat.Price, " ")),
+		Description: util.Msg(lang, MsgInlineFlatDescription, flat.GetCorpus(), flat.Floor, flat.MaxFloor,
+			flatstorage.FormatSettlementQuarter(flatstorage.GetSettlementQuarter(string(flat.SettlementDate)), lang),
+			flatstorage.GetFinishTypeString(flat.FinishType, lang)),
+		URL: flat.GetURL(),
+		InputMessageContent: InputTextMessageContent{
+			MessageText:        fmt.Sprintf("<b>%v</b>\n%v", escapeHTML(blockName), flat.StringWithOptions(lang)),
+			ParseMode:          "HTML",
+			LinkPreviewOptions: &LinkPreviewOptions{IsDisabled: true},
+		},
+	}
+}
+
+// renderInlineResults returns the page of results starting at offset and the offset of the next page, empty if it is the last one
+func renderInlineResults(flats []flatstorage.Flat, offset int, lang util.Lang) ([]InlineQueryResultArticle, string) {
+	if offset < 0 || offset >= len(flats) {
+		return []InlineQueryResultArticle{}, ""
+	}
+	end := min(offset+inlineResultsPerPage, len(flats))
+	results := make([]InlineQueryResultArticle, 0, end-offset)
+	for i := offset; i < end; i++ {
+		results = append(results, flatInlineResult(&flats[i], lang))
+	}
+
+	var nextOffset string
+	if end < len(flats) {
+		nextOffset = strconv.Itoa(end)
+	}
+	return results, nextOffset
+}
+
+// invalidInlineResult explains the syntax instead of the results
+func invalidInlineResult(err error, lang util.Lang) InlineQueryResultArticle {
+	return InlineQueryResultArticle{
+		Type:        "article",
+		Id:          "invalid",
+		Title:       util.Msg(lang, MsgInlineInvalidQuery),
+		Description: err.Error(),
+		InputMessageContent: InputTextMessageContent{
+			MessageText: util.Msg(lang, MsgInlineSyntax, util.GetBotUsername(), escapeHTML(searchSyntax)),
+			ParseMode:   "HTML",
+		},
+	}
+}
+
+func processInlineQuery(query *InlineQuery) {
+	lang := inlineLang(query)
+
+	var results []InlineQueryResultArticle
+	var nextOffset string
+	slug, filter, sortByAvg, err := parseInlineQuery(query.Query)
+	if err != nil {
+		results = []InlineQueryResultArticle{invalidInlineResult(err, lang)}
+	} else {
+		found, err := searchInline(slug, filter, sortByAvg)
+		if err != nil {
+			log.Printf("failed to search flats for inline query %q of %v: %v", query.Query, query.From.Id, err)
+			found = &flatstorage.MessageData{}
+		}
+		offset, _ := strconv.Atoi(query.Offset)
+		results, nextOffset = renderInlineResults(found.Flats, offset, lang)
+	}
+
+	err = AnswerInlineQuery(util.GetBotToken(), query.Id, results, nextOffset)
+	if err != nil {
+		log.Printf("failed to answer inline query %q of %v: %v", query.Query, query.From.Id, err)
+	}
+}
+
+// AnswerInlineQuery sends the results of the inline query, they are personal since the language depends on the user
+func AnswerInlineQuery(token string, inlineQueryID string, results []InlineQueryResultArticle, nextOffset string) error {
+	resultsJSON, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	values := url.Values{
+		"inline_query_id": []string{inlineQueryID},
+		"results":         []string{string(resultsJSON)},
+		"cache_time":      []string{fmt.Sprintf("%v", int(inlineCacheTime.Seconds()))},
+		"is_personal":     []string{"True"},
+		"next_offset":     []string{nextOffset},
+	}
+
+	_, err = callTelegramMethod(token, "answerInlineQuery", values)
+	return err
+}
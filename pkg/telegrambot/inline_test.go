@@ -0,0 +1,69 @@
+package telegrambot
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInlineQuery(t *testing.T) {
+	slug, filter, sortByAvg, err := parseInlineQuery("2ngt 2r <15m")
+	require.NoError(t, err)
+	require.Equal(t, "2ngt", slug)
+	require.False(t, sortByAvg)
+	require.Equal(t, []int8{2}, filter.Rooms)
+	require.Equal(t, int64(14_999_999), filter.MaxPrice)
+
+	slug, filter, sortByAvg, err = parseInlineQuery("1,2R >=8m area>=40 sort=avg")
+	require.NoError(t, err)
+	require.Empty(t, slug)
+	require.True(t, sortByAvg)
+	require.Equal(t, []int8{1, 2}, filter.Rooms)
+	require.Equal(t, int64(8_000_000), filter.MinPrice)
+	require.Equal(t, 40.0, filter.MinArea)
+
+	slug, filter, _, err = parseInlineQuery("  ")
+	require.NoError(t, err)
+	require.Empty(t, slug)
+	require.True(t, filter.IsEmpty())
+
+	_, _, _, err = parseInlineQuery("2ngt cheap")
+	require.Error(t, err)
+}
+
+func TestRenderInlineResults(t *testing.T) {
+	var flats []flatstorage.Flat
+	for i := 0; i < inlineResultsPerPage+10; i++ {
+		flats = append(flats, flatstorage.Flat{ID: int64(i + 1), Rooms: 2, Area: 55.5, Price: 12_000_000,
+			BlockName: "Второй Нагатинский", BulkName: "Корпус 1.3", BlockSlug: "2ngt"})
+	}
+
+	results, nextOffset := renderInlineResults(flats, 0, util.DefaultLang)
+	require.Len(t, results, inlineResultsPerPage)
+	require.Equal(t, "50", nextOffset)
+
+	results, nextOffset = renderInlineResults(flats, 50, util.DefaultLang)
+	require.Len(t, results, 10)
+	require.Empty(t, nextOffset)
+
+	result := results[0]
+	require.Equal(t, "article", result.Type)
+	require.Equal(t, "51", result.Id)
+	require.Equal(t, "https://www.pik.ru/flat/51", result.URL)
+	require.Equal(t, "HTML", result.InputMessageContent.ParseMode)
+	require.True(t, strings.HasPrefix(result.InputMessageContent.MessageText, "<b>Второй Нагатинский</b>\n"))
+	require.Contains(t, result.InputMessageContent.MessageText, flats[50].StringWithOptions(util.DefaultLang))
+
+	results, nextOffset = renderInlineResults(flats, len(flats), util.DefaultLang)
+	require.Empty(t, results)
+	require.Empty(t, nextOffset)
+
+	// Telegram requires an array even if nothing is found
+	resultsJSON, err := json.Marshal(results)
+	require.NoError(t, err)
+	require.Equal(t, "[]", string(resultsJSON))
+}
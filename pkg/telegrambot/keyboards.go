@@ -0,0 +1,185 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"strings"
+)
+
+const (
+	listPageSize = 10
+
+	// max number of flats in a single message, every flat has two buttons and Telegram accepts up to 100 buttons
+	maxFlatsPerMessage = 50
+)
+
+// InlineKeyboardMarkup see https://core.telegram.org/bots/api#inlinekeyboardmarkup
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// InlineKeyboardButton see https://core.telegram.org/bots/api#inlinekeyboardbutton
+// NOTE: callback_data is limited to 64 bytes
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data,omitempty"`
+	URL          string `json:"url,omitempty"`
+}
+
+func (k *InlineKeyboardMarkup) AddRow(buttons ...InlineKeyboardButton) {
+	if len(buttons) == 0 {
+		return
+	}
+	k.InlineKeyboard = append(k.InlineKeyboard, buttons)
+}
+
+// clone copies the keyboard so the buttons can be changed without affecting the original
+func (k *InlineKeyboardMarkup) clone() *InlineKeyboardMarkup {
+	res := &InlineKeyboardMarkup{}
+	for _, row := range k.InlineKeyboard {
+		res.AddRow(append([]InlineKeyboardButton(nil), row...)...)
+	}
+	return res
+}
+
+// renderList renders a single page of the known complexes with sub/unsub/dump buttons for every complex
+func renderList(chatID int64, command string, page int) (string, *InlineKeyboardMarkup) {
+	subscribedTo := GetChatSubscriptions(chatID)
+
+	slugs := util.SortedKeysByFunc(BlockSlugs, func(a, b string) bool {
+		return BlockSlugs[a].Name < BlockSlugs[b].Name
+	})
+
+	numPages := (len(slugs) + listPageSize - 1) / listPageSize
+	if page >= numPages {
+		page = numPages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+	from := page * listPageSize
+	to := from + listPageSize
+	if to > len(slugs) {
+		to = len(slugs)
+	}
+
+	keyboard := &InlineKeyboardMarkup{}
+	var complexes []string
+	for _, comp := range slugs[from:to] {
+		block := BlockSlugs[comp]
+		subscription, isSubscribed := subscribedTo[comp]
+		if strings.Contains(command, "dump") {
+			complexes = append(complexes, block.StringWithCommand(command))
+		} else {
+			line := block.StringWithSub(isSubscribed)
+			if isSubscribed && !subscription.Filter.IsEmpty() {
+				line += fmt.Sprintf(" (%v)", escapeHTML(subscription.Filter.String()))
+			}
+			complexes = append(complexes, line)
+		}
+
+		subButton := InlineKeyboardButton{
+			Text:         "➕ " + block.Name,
+			CallbackData: makeCallbackData(CallbackSub, fmt.Sprint(page), comp),
+		}
+		if isSubscribed {
+			subButton = InlineKeyboardButton{
+				Text:         "✅ " + block.Name,
+				CallbackData: makeCallbackData(CallbackUnsub, fmt.Sprint(page), comp),
+			}
+		}
+		keyboard.AddRow(subButton, InlineKeyboardButton{
+			Text:         "📋 " + DumpCommand,
+			CallbackData: makeCallbackData(CallbackDump, comp),
+		})
+	}
+
+	var navigation []InlineKeyboardButton
+	if page > 0 {
+		navigation = append(navigation, InlineKeyboardButton{
+			Text:         "◀",
+			CallbackData: makeCallbackData(CallbackList, command, fmt.Sprint(page-1)),
+		})
+	}
+	if page < numPages-1 {
+		navigation = append(navigation, InlineKeyboardButton{
+			Text:         "▶",
+			CallbackData: makeCallbackData(CallbackList, command, fmt.Sprint(page+1)),
+		})
+	}
+	keyboard.AddRow(navigation...)
+
+	lang := GetChatLang(chatID)
+	msg := util.Msg(lang, MsgPage, util.Msg(lang, MsgListHeader), page+1, numPages) + "\n" + strings.Join(complexes, "\n")
+	return msg, keyboard
+}
+
+// flatsKeyboard makes "info" and "watch" buttons for every flat of a single message, see splitFlatsNotification
+func flatsKeyboard(flats []flatstorage.Flat) *InlineKeyboardMarkup {
+	if len(flats) == 0 {
+		return nil
+	}
+	keyboard := &InlineKeyboardMarkup{}
+	for i := range flats {
+		slugAndFlatID := fmt.Sprintf("%v_%v", util.EmbedSlug(string(flats[i].BlockSlug)), flats[i].ID)
+		keyboard.AddRow(InlineKeyboardButton{
+			Text:         "ℹ️ " + flatButtonText(&flats[i]),
+			CallbackData: makeCallbackData(CallbackInfo, slugAndFlatID),
+		}, InlineKeyboardButton{
+			Text:         "👁",
+			CallbackData: makeCallbackData(CallbackWatch, slugAndFlatID),
+		})
+	}
+	return keyboard
+}
+
+// flatButtonText example: 1.3: 2r, 55.5m2, 12 000 000R
+func flatButtonText(flat *flatstorage.Flat) string {
+	return fmt.Sprintf("%v: %vr, %.1fm2, %vR", flat.GetCorpus(), flat.Rooms, flat.Area, util.ThousandSep(flat.Price, " "))
+}
+
+// flatsChunk is a part of the notification sent as a single message with the buttons of the flats it mentions
+type flatsChunk struct {
+	Text  string
+	Flats []flatstorage.Flat
+}
+
+// splitFlatsNotification splits the notification into messages like SplitTextIntoSendableChunks does,
+// so every flat gets its buttons under the message it is listed in, a message lists at most maxFlatsPerMessage flats
+func splitFlatsNotification(text string, flats []flatstorage.Flat) []flatsChunk {
+	if len(text) == 0 {
+		return nil
+	}
+
+	flatsByURL := make(map[string][]flatstorage.Flat, len(flats))
+	for i := range flats {
+		flatsByURL[flats[i].GetURL()] = append(flatsByURL[flats[i].GetURL()], flats[i])
+	}
+
+	var res []flatsChunk
+	var lines []string
+	var chunkFlats []flatstorage.Flat
+	var size int
+	for _, line := range strings.Split(text, "\n") {
+		lineFlats := flatsByURL[lineLink(line)]
+		if len(lines) > 0 && (size+len(line) > messageCharLimit || len(chunkFlats)+len(lineFlats) > maxFlatsPerMessage) {
+			res = append(res, flatsChunk{Text: strings.Join(lines, "\n"), Flats: chunkFlats})
+			lines, chunkFlats, size = nil, nil, 0
+		}
+		lines = append(lines, line)
+		chunkFlats = append(chunkFlats, lineFlats...)
+		size += len(line) + 1
+	}
+	return append(res, flatsChunk{Text: strings.Join(lines, "\n"), Flats: chunkFlats})
+}
+
+// lineLink returns the first link of the line, e.g. the flat link in <a href="https://www.pik.ru/flat/123">
+func lineLink(line string) string {
+	_, link, ok := strings.Cut(line, `href="`)
+	if !ok {
+		return ""
+	}
+	link, _, _ = strings.Cut(link, `"`)
+	return link
+}
@@ -0,0 +1,43 @@
+package telegrambot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitFlatsNotification(t *testing.T) {
+	msgData := &flatstorage.MessageData{}
+	for i := int64(1); i <= 120; i++ {
+		msgData.Flats = append(msgData.Flats, flatstorage.Flat{ID: i, Rooms: 1, Area: 30, Price: 10_000_000 + i,
+			BulkName: "Корпус 1.3", BlockSlug: "2ngt", BlockName: "Второй Нагатинский"})
+	}
+	text := msgData.StringWithOptions(false, false, util.DefaultLang)
+
+	chunks := splitFlatsNotification(text, msgData.Flats)
+	require.Greater(t, len(chunks), 2)
+
+	seen := make(map[int64]bool)
+	var texts []string
+	for _, chunk := range chunks {
+		require.LessOrEqual(t, len(chunk.Text), messageCharLimit)
+		require.LessOrEqual(t, len(chunk.Flats), maxFlatsPerMessage)
+		for _, flat := range chunk.Flats {
+			require.Contains(t, chunk.Text, fmt.Sprintf("href=%q", flat.GetURL()), "the buttons go under the message listing the flat")
+			seen[flat.ID] = true
+		}
+		require.Len(t, flatsKeyboard(chunk.Flats).InlineKeyboard, len(chunk.Flats))
+		texts = append(texts, chunk.Text)
+	}
+	require.Len(t, seen, 120, "every flat gets its buttons")
+	require.Equal(t, text, strings.Join(texts, "\n"))
+
+	// a short notification is a single message
+	chunks = splitFlatsNotification("header\n"+msgData.Flats[0].String(), msgData.Flats[:1])
+	require.Equal(t, []flatsChunk{{Text: "header\n" + msgData.Flats[0].String(), Flats: msgData.Flats[:1]}}, chunks)
+	require.Empty(t, splitFlatsNotification("", nil))
+}
@@ -0,0 +1,80 @@
+package telegrambot
+
+import (
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strings"
+)
+
+// langAuto resets the language chosen with /lang back to the one detected from the messages
+const langAuto = "auto"
+
+// changeLang handles /lang_<code>, plain /lang shows the current language with the buttons to change it
+func changeLang(chatID int64, args string) {
+	args = strings.ToLower(strings.TrimSpace(args))
+	if args == "" {
+		sendLang(chatID)
+		return
+	}
+
+	msg := setChatLang(chatID, args)
+	err := SendMessage(chatID, msg)
+	if err != nil {
+		log.Printf("failed to send lang changed message to %v: %v", chatID, err)
+	}
+}
+
+// setChatLang saves the language of the chat, returns the text for the answer
+func setChatLang(chatID int64, code string) string {
+	var lang util.Lang
+	if code != langAuto {
+		var ok bool
+		lang, ok = util.ParseLang(code)
+		if !ok {
+			codes := make([]string, 0, len(util.Langs)+1)
+			for _, lang := range util.Langs {
+				codes = append(codes, string(lang))
+			}
+			codes = append(codes, langAuto)
+			return localize(chatID, MsgLangUnknown, code, strings.Join(codes, ", "))
+		}
+	}
+
+	err := UpdateChatSettings(chatID, func(settings *ChatSettings) {
+		settings.Lang = lang
+	})
+	if err != nil {
+		log.Printf("failed to save lang %v of chat %v: %v", code, chatID, err)
+		return localize(chatID, MsgLangSaveFailed, err)
+	}
+	return localize(chatID, MsgLangChanged, currentLangName(chatID))
+}
+
+func currentLangName(chatID int64) string {
+	lang := GetChatLang(chatID)
+	if GetChatSettings(chatID).Lang == "" {
+		return util.Msg(lang, MsgLangAuto, util.Msg(lang, MsgLangName))
+	}
+	return util.Msg(lang, MsgLangName)
+}
+
+func sendLang(chatID int64) {
+	SendMessageWithKeyboardAsync(chatID, localize(chatID, MsgLangCurrent, currentLangName(chatID)), langKeyboard(chatID))
+}
+
+func langKeyboard(chatID int64) *InlineKeyboardMarkup {
+	keyboard := &InlineKeyboardMarkup{}
+	var buttons []InlineKeyboardButton
+	for _, lang := range util.Langs {
+		buttons = append(buttons, InlineKeyboardButton{
+			Text:         util.Msg(lang, MsgLangName),
+			CallbackData: makeCallbackData(CallbackLang, string(lang)),
+		})
+	}
+	buttons = append(buttons, InlineKeyboardButton{
+		Text:         localize(chatID, MsgLangAuto, util.Msg(util.LangFromLanguageCode(GetChatSettings(chatID).LanguageCode), MsgLangName)),
+		CallbackData: makeCallbackData(CallbackLang, langAuto),
+	})
+	keyboard.AddRow(buttons...)
+	return keyboard
+}
@@ -0,0 +1,204 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strings"
+)
+
+const (
+	lifecycleKeyReleased = "released"
+	lifecycleKeySold     = "sold"
+
+	maxSoldSummaryFlats = 20
+)
+
+// lifecycleSyntax is a short human-readable description of the lifecycle alerts arguments
+const lifecycleSyntax = "released=on|off sold=on|off"
+
+// LifecycleAlerts are the opt-in alerts of the subscription about the status changes of the flats
+type LifecycleAlerts struct {
+	Released bool `json:"released,omitempty"` // the flat came back from the reserve
+	Sold     bool `json:"sold,omitempty"`     // the summary of the flats that disappeared since the last check
+}
+
+// ParseLifecycleAlerts applies args like "released=on sold=off" to a copy of the alerts
+func ParseLifecycleAlerts(args []string, alerts *LifecycleAlerts) (*LifecycleAlerts, error) {
+	res := &LifecycleAlerts{}
+	if alerts != nil {
+		*res = *alerts
+	}
+	for _, arg := range args {
+		key, value, _ := strings.Cut(strings.TrimSpace(arg), "=")
+		if key == "" {
+			continue
+		}
+		var enabled bool
+		switch strings.ToLower(value) {
+		case broadcastOn:
+			enabled = true
+		case deliveryOff:
+			enabled = false
+		default:
+			return nil, fmt.Errorf("invalid value %q of %v, expected on or off", value, key)
+		}
+		switch strings.ToLower(key) {
+		case lifecycleKeyReleased:
+			res.Released = enabled
+		case lifecycleKeySold:
+			res.Sold = enabled
+		default:
+			return nil, fmt.Errorf("unknown lifecycle option %q", arg)
+		}
+	}
+	return res, nil
+}
+
+func (a *LifecycleAlerts) IsDefault() bool {
+	return a == nil || *a == LifecycleAlerts{}
+}
+
+// String returns the alerts in the same syntax they are set with
+func (a *LifecycleAlerts) String() string {
+	released, sold := deliveryOff, deliveryOff
+	if a != nil && a.Released {
+		released = broadcastOn
+	}
+	if a != nil && a.Sold {
+		sold = broadcastOn
+	}
+	return fmt.Sprintf("%v=%v %v=%v", lifecycleKeyReleased, released, lifecycleKeySold, sold)
+}
+
+func UpdateSubscriberLifecycle(chatID int64, slug string, alerts *LifecycleAlerts) error {
+	return updateSubscription(chatID, slug, func(subscription *ChannelInfo) {
+		subscription.Lifecycle = alerts
+	})
+}
+
+// changeLifecycle handles /lifecycle_<slug> [alerts], without alerts it shows the current ones
+func changeLifecycle(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		fields = []string{""}
+	}
+	slug, err := validateSlug(chatID, fields[0], LifecycleCommand)
+	if err != nil {
+		log.Printf("failed to change lifecycle alerts of %v: %v", chatID, err)
+		return
+	}
+	slug = util.EmbedSlug(slug)
+
+	subscription, ok := GetChatSubscriptions(chatID)[slug]
+	if !ok {
+		err = SendMessage(chatID, localize(chatID, MsgNotSubscribedShort, BlockSlugs[slug].Name))
+		if err != nil {
+			log.Printf("failed to send not subscribed message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	if len(fields) > 1 {
+		alerts, err := ParseLifecycleAlerts(fields[1:], subscription.Lifecycle)
+		if err != nil {
+			err = SendMessage(chatID, localize(chatID, MsgInvalidLifecycle, escapeHTML(err.Error()), LifecycleCommand, slug, lifecycleSyntax))
+			if err != nil {
+				log.Printf("failed to send invalid lifecycle message to %v: %v", chatID, err)
+			}
+			return
+		}
+		if alerts.IsDefault() {
+			alerts = nil
+		}
+
+		err = UpdateSubscriberLifecycle(chatID, slug, alerts)
+		if err != nil {
+			log.Printf("failed to update lifecycle alerts of %v for %v: %v", chatID, slug, err)
+			err = SendMessage(chatID, localize(chatID, MsgLifecycleUpdateFailed, slug, err))
+			if err != nil {
+				log.Printf("failed to send lifecycle update failed message to %v: %v", chatID, err)
+			}
+			return
+		}
+		subscription.Lifecycle = alerts
+	}
+
+	err = SendMessage(chatID, localize(chatID, MsgLifecycleSettings, BlockSlugs[slug].Name, subscription.Lifecycle,
+		LifecycleCommand, slug, lifecycleSyntax))
+	if err != nil {
+		log.Printf("failed to send lifecycle alerts to %v: %v", chatID, err)
+	}
+}
+
+// getBlockSubscriptions returns the subscriptions of the enabled chats to the complex
+func getBlockSubscriptions(slug string) []ChannelInfo {
+	var res []ChannelInfo
+	for _, subscription := range getChannels() {
+		if subscription.BlockSlug == slug && !IsChatDisabled(subscription.ChatID) {
+			res = append(res, subscription)
+		}
+	}
+	return res
+}
+
+// notifyLifecycle sends the flats that came back from the reserve and the summary of the sold ones
+// to the subscribers who opted in
+func notifyLifecycle(blockSlug string, events []flatstorage.FlatEvent) {
+	var released, gone []flatstorage.Flat
+	for i := range events {
+		switch {
+		case events[i].IsReleased():
+			released = append(released, events[i].Flat)
+		case events[i].Type == flatstorage.FlatEventGone:
+			gone = append(gone, events[i].Flat)
+		}
+	}
+	if len(released) == 0 && len(gone) == 0 {
+		return
+	}
+
+	for _, subscription := range getBlockSubscriptions(blockSlug) {
+		if subscription.Lifecycle.IsDefault() {
+			continue
+		}
+		lang := GetChatLang(subscription.ChatID)
+		if subscription.Lifecycle.Released {
+			flats := matchFilter(released, subscription.Filter)
+			if len(flats) > 0 {
+				text := renderLifecycleFlats(util.Msg(lang, MsgReleasedHeader, len(flats), flats[0].BlockName), flats, lang)
+				NotifyAboutFlats(subscription.ChatID, text, flats)
+			}
+		}
+		if subscription.Lifecycle.Sold {
+			flats := matchFilter(gone, subscription.Filter)
+			if len(flats) > 0 {
+				text := renderLifecycleFlats(util.Msg(lang, MsgSoldSummaryHeader, len(flats), flats[0].BlockName), flats, lang)
+				Notify(subscription.ChatID, text, nil)
+			}
+		}
+	}
+}
+
+func matchFilter(flats []flatstorage.Flat, filter *flatstorage.FlatFilter) []flatstorage.Flat {
+	var res []flatstorage.Flat
+	for i := range flats {
+		if filter.Match(&flats[i]) {
+			res = append(res, flats[i])
+		}
+	}
+	return res
+}
+
+// renderLifecycleFlats lists up to maxSoldSummaryFlats flats under the header
+func renderLifecycleFlats(header string, flats []flatstorage.Flat, lang util.Lang) string {
+	lines := []string{header}
+	for i := 0; i < len(flats) && i < maxSoldSummaryFlats; i++ {
+		lines = append(lines, flats[i].StringWithOptions(lang))
+	}
+	if len(flats) > maxSoldSummaryFlats {
+		lines = append(lines, util.Msg(lang, MsgLifecycleMore, len(flats)-maxSoldSummaryFlats))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,46 @@
+package telegrambot
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLifecycleAlerts(t *testing.T) {
+	alerts, err := ParseLifecycleAlerts([]string{"released=on"}, nil)
+	require.NoError(t, err)
+	require.Equal(t, &LifecycleAlerts{Released: true}, alerts)
+	require.Equal(t, "released=on sold=off", alerts.String())
+
+	alerts, err = ParseLifecycleAlerts([]string{"sold=ON", "released=off"}, alerts)
+	require.NoError(t, err)
+	require.Equal(t, &LifecycleAlerts{Sold: true}, alerts)
+
+	alerts, err = ParseLifecycleAlerts([]string{"sold=off"}, alerts)
+	require.NoError(t, err)
+	require.True(t, alerts.IsDefault())
+
+	for _, arg := range []string{"sold=maybe", "reserved=on", "released"} {
+		_, err = ParseLifecycleAlerts([]string{arg}, nil)
+		require.Error(t, err, arg)
+	}
+}
+
+func TestRenderLifecycleFlats(t *testing.T) {
+	var flats []flatstorage.Flat
+	for i := 0; i < maxSoldSummaryFlats+3; i++ {
+		flats = append(flats, flatstorage.Flat{ID: int64(i + 1), Rooms: 1, Price: 10_000_000, BlockSlug: "2ngt"})
+	}
+	msg := renderLifecycleFlats("header", flats, util.DefaultLang)
+	require.Contains(t, msg, fmt.Sprintf("flat/%v", maxSoldSummaryFlats))
+	require.NotContains(t, msg, fmt.Sprintf("flat/%v\"", maxSoldSummaryFlats+1))
+	require.Contains(t, msg, "and 3 more")
+
+	filter, err := flatstorage.ParseFlatFilter([]string{"rooms=2"})
+	require.NoError(t, err)
+	require.Empty(t, matchFilter(flats, filter))
+	require.Len(t, matchFilter(flats, nil), len(flats))
+}
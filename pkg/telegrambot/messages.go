@@ -0,0 +1,653 @@
+package telegrambot
+
+import "github.com/georgri/pik_tg_bot/pkg/util"
+
+const (
+	MsgLangName util.MsgKey = "telegrambot.lang_name"
+
+	MsgHello              util.MsgKey = "telegrambot.hello"
+	MsgPage               util.MsgKey = "telegrambot.page"
+	MsgListHeader         util.MsgKey = "telegrambot.list_header"
+	MsgAvailableCommands  util.MsgKey = "telegrambot.available_commands"
+	MsgSomethingWentWrong util.MsgKey = "telegrambot.something_went_wrong"
+	MsgMessageTooOld      util.MsgKey = "telegrambot.message_too_old"
+	MsgUnknownComplex     util.MsgKey = "telegrambot.unknown_complex"
+
+	MsgNoKnownFlats         util.MsgKey = "telegrambot.no_known_flats"
+	MsgFlatNotFound         util.MsgKey = "telegrambot.flat_not_found"
+	MsgInfoChartCaption     util.MsgKey = "telegrambot.info_chart_caption"
+	MsgVelocityChartCaption util.MsgKey = "telegrambot.velocity_chart_caption"
+
+	MsgInvalidFilter          util.MsgKey = "telegrambot.invalid_filter"
+	MsgAlreadySubscribed      util.MsgKey = "telegrambot.already_subscribed"
+	MsgAlreadySubscribedShort util.MsgKey = "telegrambot.already_subscribed_short"
+	MsgSubscribeFailed        util.MsgKey = "telegrambot.subscribe_failed"
+	MsgSubscribed             util.MsgKey = "telegrambot.subscribed"
+	MsgSubscribedShort        util.MsgKey = "telegrambot.subscribed_short"
+	MsgFilterInfo             util.MsgKey = "telegrambot.filter_info"
+	MsgFilterUpdateFailed     util.MsgKey = "telegrambot.filter_update_failed"
+	MsgFilterUpdated          util.MsgKey = "telegrambot.filter_updated"
+	MsgFilterRemoved          util.MsgKey = "telegrambot.filter_removed"
+	MsgNotSubscribed          util.MsgKey = "telegrambot.not_subscribed"
+	MsgNotSubscribedShort     util.MsgKey = "telegrambot.not_subscribed_short"
+	MsgUnsubscribeFailed      util.MsgKey = "telegrambot.unsubscribe_failed"
+	MsgUnsubscribed           util.MsgKey = "telegrambot.unsubscribed"
+	MsgUnsubscribedShort      util.MsgKey = "telegrambot.unsubscribed_short"
+	MsgMySubsHeader           util.MsgKey = "telegrambot.my_subs_header"
+	MsgMySubsDetails          util.MsgKey = "telegrambot.my_subs_details"
+	MsgMySubsNotifiedAgo      util.MsgKey = "telegrambot.my_subs_notified_ago"
+	MsgMySubsNotNotified      util.MsgKey = "telegrambot.my_subs_not_notified"
+	MsgMySubsFooter           util.MsgKey = "telegrambot.my_subs_footer"
+	MsgUnsubAllConfirm        util.MsgKey = "telegrambot.unsub_all_confirm"
+	MsgUnsubAllButton         util.MsgKey = "telegrambot.unsub_all_button"
+	MsgUnsubAllCancelButton   util.MsgKey = "telegrambot.unsub_all_cancel_button"
+	MsgUnsubAllDone           util.MsgKey = "telegrambot.unsub_all_done"
+	MsgUnsubAllCancelled      util.MsgKey = "telegrambot.unsub_all_cancelled"
+
+	MsgNewBlocksFooter util.MsgKey = "telegrambot.new_blocks_footer"
+
+	MsgSearchFound        util.MsgKey = "telegrambot.search_found"
+	MsgSearchMatching     util.MsgKey = "telegrambot.search_matching"
+	MsgSearchShowingFirst util.MsgKey = "telegrambot.search_showing_first"
+	MsgSearchNothingFound util.MsgKey = "telegrambot.search_nothing_found"
+
+	MsgSavedSearchesHeader  util.MsgKey = "telegrambot.saved_searches_header"
+	MsgSavedSearchLine      util.MsgKey = "telegrambot.saved_search_line"
+	MsgSavedSearchAllBlocks util.MsgKey = "telegrambot.saved_search_all_blocks"
+	MsgSavedSearchesFooter  util.MsgKey = "telegrambot.saved_searches_footer"
+	MsgNoSavedSearches      util.MsgKey = "telegrambot.no_saved_searches"
+	MsgInvalidSavedSearch   util.MsgKey = "telegrambot.invalid_saved_search"
+	MsgSavedSearchFailed    util.MsgKey = "telegrambot.saved_search_failed"
+	MsgSavedSearchSaved     util.MsgKey = "telegrambot.saved_search_saved"
+	MsgSavedSearchNotFound  util.MsgKey = "telegrambot.saved_search_not_found"
+	MsgSavedSearchDeleted   util.MsgKey = "telegrambot.saved_search_deleted"
+	MsgSavedSearchUpdates   util.MsgKey = "telegrambot.saved_search_updates"
+
+	MsgMetroHeader            util.MsgKey = "telegrambot.metro_header"
+	MsgMetroLine              util.MsgKey = "telegrambot.metro_line"
+	MsgNoMetroStations        util.MsgKey = "telegrambot.no_metro_stations"
+	MsgUnknownMetroStation    util.MsgKey = "telegrambot.unknown_metro_station"
+	MsgMetroSubscribeFailed   util.MsgKey = "telegrambot.metro_subscribe_failed"
+	MsgMetroSubscribed        util.MsgKey = "telegrambot.metro_subscribed"
+	MsgMetroAlreadySubscribed util.MsgKey = "telegrambot.metro_already_subscribed"
+	MsgNotSubscribedToMetro   util.MsgKey = "telegrambot.not_subscribed_to_metro"
+	MsgMetroUnsubscribed      util.MsgKey = "telegrambot.metro_unsubscribed"
+	MsgMetroNewBlock          util.MsgKey = "telegrambot.metro_new_block"
+
+	MsgMortgageButton      util.MsgKey = "telegrambot.mortgage_button"
+	MsgMortgageHeader      util.MsgKey = "telegrambot.mortgage_header"
+	MsgMortgageTerms       util.MsgKey = "telegrambot.mortgage_terms"
+	MsgMortgageProgram     util.MsgKey = "telegrambot.mortgage_program"
+	MsgMortgageUnavailable util.MsgKey = "telegrambot.mortgage_unavailable"
+	MsgMortgageMinDown     util.MsgKey = "telegrambot.mortgage_min_down"
+	MsgMortgageMaxLoan     util.MsgKey = "telegrambot.mortgage_max_loan"
+	MsgMortgageSimilar     util.MsgKey = "telegrambot.mortgage_similar"
+	MsgMortgageSimilarFlat util.MsgKey = "telegrambot.mortgage_similar_flat"
+	MsgMortgageNoSimilar   util.MsgKey = "telegrambot.mortgage_no_similar"
+	MsgMortgageFooter      util.MsgKey = "telegrambot.mortgage_footer"
+	MsgInvalidMortgage     util.MsgKey = "telegrambot.invalid_mortgage"
+
+	MsgLifecycleSettings     util.MsgKey = "telegrambot.lifecycle_settings"
+	MsgInvalidLifecycle      util.MsgKey = "telegrambot.invalid_lifecycle"
+	MsgLifecycleUpdateFailed util.MsgKey = "telegrambot.lifecycle_update_failed"
+	MsgReleasedHeader        util.MsgKey = "telegrambot.released_header"
+	MsgSoldSummaryHeader     util.MsgKey = "telegrambot.sold_summary_header"
+	MsgLifecycleMore         util.MsgKey = "telegrambot.lifecycle_more"
+	MsgBulkChangesHeader     util.MsgKey = "telegrambot.bulk_changes_header"
+
+	MsgInlineFlatTitle       util.MsgKey = "telegrambot.inline_flat_title"
+	MsgInlineFlatDescription util.MsgKey = "telegrambot.inline_flat_description"
+	MsgInlineInvalidQuery    util.MsgKey = "telegrambot.inline_invalid_query"
+	MsgInlineSyntax          util.MsgKey = "telegrambot.inline_syntax"
+	MsgInvalidSearch         util.MsgKey = "telegrambot.invalid_search"
+
+	MsgWatching           util.MsgKey = "telegrambot.watching"
+	MsgWatchFailed        util.MsgKey = "telegrambot.watch_failed"
+	MsgUnwatched          util.MsgKey = "telegrambot.unwatched"
+	MsgNoWatches          util.MsgKey = "telegrambot.no_watches"
+	MsgWatchesHeader      util.MsgKey = "telegrambot.watches_header"
+	MsgWatchedFlatChanged util.MsgKey = "telegrambot.watched_flat_changed"
+
+	MsgNoSubscriptions      util.MsgKey = "telegrambot.no_subscriptions"
+	MsgInvalidThresholds    util.MsgKey = "telegrambot.invalid_thresholds"
+	MsgSettingsUpdateFailed util.MsgKey = "telegrambot.settings_update_failed"
+	MsgSettingsHeader       util.MsgKey = "telegrambot.settings_header"
+	MsgSettingsHelp         util.MsgKey = "telegrambot.settings_help"
+
+	MsgInvalidDelivery      util.MsgKey = "telegrambot.invalid_delivery"
+	MsgDeliveryUpdateFailed util.MsgKey = "telegrambot.delivery_update_failed"
+	MsgDeliverySettings     util.MsgKey = "telegrambot.delivery_settings"
+	MsgDigestHeader         util.MsgKey = "telegrambot.digest_header"
+
+	MsgInvalidAlerts      util.MsgKey = "telegrambot.invalid_alerts"
+	MsgAlertsUpdateFailed util.MsgKey = "telegrambot.alerts_update_failed"
+	MsgAlertsSettings     util.MsgKey = "telegrambot.alerts_settings"
+
+	MsgStatsHeader           util.MsgKey = "telegrambot.stats_header"
+	MsgStatsLastDownloads    util.MsgKey = "telegrambot.stats_last_downloads"
+	MsgStatsNeverDownloaded  util.MsgKey = "telegrambot.stats_never_downloaded"
+	MsgStatsDownloadedAgo    util.MsgKey = "telegrambot.stats_downloaded_ago"
+	MsgStatsAudit            util.MsgKey = "telegrambot.stats_audit"
+	MsgBroadcastUsage        util.MsgKey = "telegrambot.broadcast_usage"
+	MsgBroadcastConfirm      util.MsgKey = "telegrambot.broadcast_confirm"
+	MsgBroadcastSendButton   util.MsgKey = "telegrambot.broadcast_send_button"
+	MsgBroadcastCancelButton util.MsgKey = "telegrambot.broadcast_cancel_button"
+	MsgBroadcastSent         util.MsgKey = "telegrambot.broadcast_sent"
+	MsgBroadcastCancelled    util.MsgKey = "telegrambot.broadcast_cancelled"
+	MsgBroadcastExpired      util.MsgKey = "telegrambot.broadcast_expired"
+	MsgReloaded              util.MsgKey = "telegrambot.reloaded"
+	MsgReloadFailed          util.MsgKey = "telegrambot.reload_failed"
+	MsgNoDeadLetters         util.MsgKey = "telegrambot.no_dead_letters"
+	MsgDeadLettersHeader     util.MsgKey = "telegrambot.dead_letters_header"
+	MsgRetryDeadUsage        util.MsgKey = "telegrambot.retry_dead_usage"
+	MsgDeadLettersRetried    util.MsgKey = "telegrambot.dead_letters_retried"
+	MsgAdminJobDone          util.MsgKey = "telegrambot.admin_job_done"
+	MsgAdminJobFailed        util.MsgKey = "telegrambot.admin_job_failed"
+
+	MsgLangCurrent    util.MsgKey = "telegrambot.lang_current"
+	MsgLangAuto       util.MsgKey = "telegrambot.lang_auto"
+	MsgLangChanged    util.MsgKey = "telegrambot.lang_changed"
+	MsgLangUnknown    util.MsgKey = "telegrambot.lang_unknown"
+	MsgLangSaveFailed util.MsgKey = "telegrambot.lang_save_failed"
+
+	MsgCmdList        util.MsgKey = "telegrambot.cmd_list"
+	MsgCmdSub         util.MsgKey = "telegrambot.cmd_sub"
+	MsgCmdUnsub       util.MsgKey = "telegrambot.cmd_unsub"
+	MsgCmdMySubs      util.MsgKey = "telegrambot.cmd_mysubs"
+	MsgCmdUnsubAll    util.MsgKey = "telegrambot.cmd_unsub_all"
+	MsgCmdMetro       util.MsgKey = "telegrambot.cmd_metro"
+	MsgCmdSubMetro    util.MsgKey = "telegrambot.cmd_sub_metro"
+	MsgCmdUnsubMetro  util.MsgKey = "telegrambot.cmd_unsub_metro"
+	MsgCmdDump        util.MsgKey = "telegrambot.cmd_dump"
+	MsgCmdDumpAvg     util.MsgKey = "telegrambot.cmd_dumpavg"
+	MsgCmdDumpInfo    util.MsgKey = "telegrambot.cmd_dumpinfo"
+	MsgCmdSearch      util.MsgKey = "telegrambot.cmd_search"
+	MsgCmdSearches    util.MsgKey = "telegrambot.cmd_searches"
+	MsgCmdSearchSave  util.MsgKey = "telegrambot.cmd_search_save"
+	MsgCmdSearchDel   util.MsgKey = "telegrambot.cmd_search_del"
+	MsgCmdInfo        util.MsgKey = "telegrambot.cmd_info"
+	MsgCmdMortgage    util.MsgKey = "telegrambot.cmd_mortgage"
+	MsgCmdVelocity    util.MsgKey = "telegrambot.cmd_velocity"
+	MsgCmdWatch       util.MsgKey = "telegrambot.cmd_watch"
+	MsgCmdUnwatch     util.MsgKey = "telegrambot.cmd_unwatch"
+	MsgCmdSettings    util.MsgKey = "telegrambot.cmd_settings"
+	MsgCmdLifecycle   util.MsgKey = "telegrambot.cmd_lifecycle"
+	MsgCmdDelivery    util.MsgKey = "telegrambot.cmd_delivery"
+	MsgCmdAlerts      util.MsgKey = "telegrambot.cmd_alerts"
+	MsgCmdLang        util.MsgKey = "telegrambot.cmd_lang"
+	MsgCmdStats       util.MsgKey = "telegrambot.cmd_stats"
+	MsgCmdBroadcast   util.MsgKey = "telegrambot.cmd_broadcast"
+	MsgCmdReload      util.MsgKey = "telegrambot.cmd_reload"
+	MsgCmdBackupNow   util.MsgKey = "telegrambot.cmd_backup_now"
+	MsgCmdRotateNow   util.MsgKey = "telegrambot.cmd_rotate_now"
+	MsgCmdDeadLetters util.MsgKey = "telegrambot.cmd_dead_letters"
+	MsgCmdRetryDead   util.MsgKey = "telegrambot.cmd_retry_dead"
+	MsgCmdHelp        util.MsgKey = "telegrambot.cmd_help"
+	MsgCmdHello       util.MsgKey = "telegrambot.cmd_hello"
+)
+
+func init() {
+	util.RegisterMessages(util.LangEn, map[util.MsgKey]string{
+		MsgLangName: "English",
+
+		MsgHello:              "Hello, %v!",
+		MsgPage:               "%v (page %v/%v):",
+		MsgListHeader:         "List of known complexes",
+		MsgAvailableCommands:  "Available commands:",
+		MsgSomethingWentWrong: "Something went wrong: %v",
+		MsgMessageTooOld:      "The message is too old, please request a new one",
+		MsgUnknownComplex:     "Unknown complex %v",
+
+		MsgNoKnownFlats:         "No known flats for complex %v",
+		MsgFlatNotFound:         "No flats found with ID %v in complex %v",
+		MsgInfoChartCaption:     "min and max prices (with 2 week window) for similar flats in reserved status",
+		MsgVelocityChartCaption: "flats appeared, reserved and gone per day",
+
+		MsgInvalidFilter: "Unable to parse the filter: %v\n" +
+			"Usage: /%v_%v %v",
+		MsgAlreadySubscribed: "You are already subscribed to complex %v.\n" +
+			"To view all flats: /%v_%v\n" +
+			"To filter notifications: /%v_%v %v",
+		MsgAlreadySubscribedShort: "You are already subscribed to %v",
+		MsgSubscribeFailed: "Something went wrong while subscribing to %v:\n" +
+			"error: %v\n" +
+			"You can try again later with /%v_%v",
+		MsgSubscribed: "You are now subscribed to new flats from: %v%v.\n" +
+			"To unsubscribe, click here: /%v_%v\n" +
+			"To get all known flats click here: /%v_%v",
+		MsgSubscribedShort: "You are now subscribed to %v",
+		MsgFilterInfo:      " (filter: %v)",
+		MsgFilterUpdateFailed: "Something went wrong while updating the filter for %v:\n" +
+			"error: %v\n" +
+			"You can try again later with /%v_%v",
+		MsgFilterUpdated: "Filter for %v is updated: %v.\n" +
+			"To receive all the flats again: /%v_%v %v",
+		MsgFilterRemoved: "Filter for %v is removed, you will receive all the flats.",
+		MsgNotSubscribed: "You are not currently subscribed to complex %v.\n" +
+			"To subscribe: /%v_%v\n" +
+			"To view all flats: /%v_%v",
+		MsgNotSubscribedShort: "You are not subscribed to %v",
+		MsgUnsubscribeFailed: "Something went wrong while unsubscribing from %v:\n" +
+			"error: %v\n" +
+			"You might need to try again later with /%v_%v",
+		MsgUnsubscribed: "You were unsubscribed from: %v.\n" +
+			"To subscribe again, click here: /%v_%v\n" +
+			"To get all known flats click here: /%v_%v",
+		MsgUnsubscribedShort:    "You were unsubscribed from %v",
+		MsgMySubsHeader:         "Your subscriptions (%v):",
+		MsgMySubsDetails:        "    alerts: %v; last notification: %v",
+		MsgMySubsNotifiedAgo:    "%v ago",
+		MsgMySubsNotNotified:    "none since the bot restart",
+		MsgMySubsFooter:         "Change the filter with /%v_&lt;slug&gt; &lt;filter&gt;, the alerts with /%v, unsubscribe from everything with /%v",
+		MsgUnsubAllConfirm:      "Unsubscribe from all %v complexes?",
+		MsgUnsubAllButton:       "Unsubscribe from all",
+		MsgUnsubAllCancelButton: "Cancel",
+		MsgUnsubAllDone:         "Unsubscribed from %v complexes, see /%v to subscribe again",
+		MsgUnsubAllCancelled:    "Subscriptions are kept",
+
+		MsgNewBlocksFooter: "To follow new updates, write @%v",
+
+		MsgSearchFound:        "Found %v flats",
+		MsgSearchMatching:     " matching %v",
+		MsgSearchShowingFirst: ", showing the first %v",
+		MsgSearchNothingFound: "No flats found, try to relax the criteria",
+
+		MsgSavedSearchesHeader:  "Your saved searches (%v):",
+		MsgSavedSearchLine:      "%v. <b>%v</b>: %v",
+		MsgSavedSearchAllBlocks: "all complexes",
+		MsgSavedSearchesFooter:  "To edit a search save it again under the same name: /%v %v",
+		MsgNoSavedSearches: "You have no saved searches yet.\n" +
+			"To save one: /%v %v",
+		MsgInvalidSavedSearch: "Unable to parse the search: %v\n" +
+			"Usage: /%v %v",
+		MsgSavedSearchFailed: "Something went wrong while saving the search: %v",
+		MsgSavedSearchSaved: "Search saved: %v\n" +
+			"New flats and price drops in all its complexes come in one message. All searches: /%v",
+		MsgSavedSearchNotFound: "There is no saved search %v, see /%v",
+		MsgSavedSearchDeleted:  "Search %v deleted, the rest: /%v",
+		MsgSavedSearchUpdates:  "🔎 Saved search <b>%v</b>:",
+
+		MsgMetroHeader:          "%v metro stations near the complexes, subscribe to get all the complexes near the station including the new ones:",
+		MsgMetroLine:            "%v%v (complexes: %v) %v",
+		MsgNoMetroStations:      "No metro stations known yet, try again later",
+		MsgUnknownMetroStation:  "Unknown metro station %v, see /%v",
+		MsgMetroSubscribeFailed: "Something went wrong while subscribing to metro station %v: %v",
+		MsgMetroSubscribed: "You are subscribed to metro station %v, the new complexes near it will be added automatically. Subscribed complexes:\n" +
+			"%v\n" +
+			"To stop adding the new complexes: /%v_%v",
+		MsgMetroAlreadySubscribed: "You are already subscribed to metro station %v. Subscribed complexes:\n" +
+			"%v\n" +
+			"To stop adding the new complexes: /%v_%v",
+		MsgNotSubscribedToMetro: "You are not subscribed to metro station %v",
+		MsgMetroUnsubscribed:    "The new complexes near metro station %v will not be added anymore, the current subscriptions are kept: /%v",
+		MsgMetroNewBlock: "New complex near metro station %v, you are subscribed to it:\n" +
+			"%v",
+
+		MsgMortgageButton: "🏦 Mortgage",
+		MsgMortgageHeader: "🏦 Mortgage for the flat in %v:\n" +
+			"%v",
+		MsgMortgageTerms:       "Price %v R, down payment %v R (%v%%), loan %v R for %v years",
+		MsgMortgageProgram:     "<b>%v</b> %v%%: %v R/month, interest %v R, overpayment %v%% of the price",
+		MsgMortgageUnavailable: "<b>%v</b> %v%%: not available, %v",
+		MsgMortgageMinDown:     "the down payment must be at least %v",
+		MsgMortgageMaxLoan:     "the loan must be at most %v R",
+		MsgMortgageSimilar:     "Similar flats on sale: %v, median price %v R, this flat is %v%%. The cheapest ones with the best program:",
+		MsgMortgageSimilarFlat: "%v\n" +
+			"    %v: %v R/month",
+		MsgMortgageNoSimilar: "No similar flats on sale to compare with",
+		MsgMortgageFooter:    "Other terms: /%v_%v_%v %v",
+		MsgInvalidMortgage: "Unable to parse the mortgage request: %v\n" +
+			"Usage: /%v_&lt;slug&gt;_&lt;flatID&gt; %v",
+
+		MsgLifecycleSettings: "Lifecycle alerts for %v: %v\n" +
+			"released: flats that came back from the reserve, usually a good deal; sold: a summary of the flats that disappeared since the last check.\n" +
+			"To change: /%v_%v %v",
+		MsgInvalidLifecycle: "Unable to parse the lifecycle alerts: %v\n" +
+			"Usage: /%v_%v %v",
+		MsgLifecycleUpdateFailed: "Something went wrong while changing the lifecycle alerts for %v: %v",
+		MsgReleasedHeader:        "🔓 %v flats came back from the reserve in %v:",
+		MsgSoldSummaryHeader:     "🏁 %v flats disappeared from sale in %v since the last check:",
+		MsgLifecycleMore:         "and %v more",
+		MsgBulkChangesHeader:     "Handover changes in %v:",
+
+		MsgInlineFlatTitle:       "%v: %vr, %vm2, %vR",
+		MsgInlineFlatDescription: "Building %v, floor %v/%v, %v, %v",
+		MsgInlineInvalidQuery:    "Invalid query",
+		MsgInlineSyntax:          "Type @%v, then optionally a complex, e.g. <code>2ngt 2r &lt;15m</code>. Filters: %v",
+		MsgInvalidSearch: "Unable to parse the search criteria: %v\n" +
+			"Usage: /%v %v",
+
+		MsgWatching: "You are now watching the flat in %v:\n%v\n" +
+			"You will be notified about changes of its price, status, settlement date and finish type.\n" +
+			"To stop watching: /%v_%v_%v",
+		MsgWatchFailed: "Something went wrong while watching the flat: %v",
+		MsgUnwatched: "You are not watching flat %v anymore.\n" +
+			"To watch it again: /%v_%v_%v",
+		MsgNoWatches:          "You are not watching any flats. Use /%v_&lt;slug&gt;_&lt;flatID&gt; or the 👁 buttons",
+		MsgWatchesHeader:      "Watched flats, click to stop watching:",
+		MsgWatchedFlatChanged: "Watched flat in %v changed:",
+
+		MsgNoSubscriptions: "You are not subscribed to any complex yet, see /%v",
+		MsgInvalidThresholds: "Unable to parse the thresholds: %v\n" +
+			"Usage: /%v [slug] %v",
+		MsgSettingsUpdateFailed: "Something went wrong while updating the settings for %v: %v",
+		MsgSettingsHeader:       "Price drop alert settings:",
+		MsgSettingsHelp: "%v: min price drop in percent; %v: min price drop to be extreme, " +
+			"if the price per m2 is also %v percent below average\n" +
+			"To change: /%v [slug] %v",
+
+		MsgInvalidDelivery: "Unable to parse the delivery settings: %v\n" +
+			"Usage: /%v %v",
+		MsgDeliveryUpdateFailed: "Something went wrong while updating the delivery settings: %v",
+		MsgDeliverySettings: "Notifications delivery: %v\n" +
+			"instant: right away; hourly: a digest every hour; daily: a digest at the given time. " +
+			"Notifications during the quiet hours are held and sent together when they end.\n" +
+			"To change: /%v %v",
+		MsgDigestHeader: "📬 %v notifications since the last digest:",
+
+		MsgInvalidAlerts: "Unable to parse the alerts settings: %v\n" +
+			"Usage: /%v %v",
+		MsgAlertsUpdateFailed: "Something went wrong while updating the alerts settings: %v",
+		MsgAlertsSettings: "Alerts sent to all chats: %v\n" +
+			"drops: extreme price drops in any complex, on, off or only in the listed complexes; news: new projects on the site. " +
+			"Your subscriptions are notified regardless.\n" +
+			"To change: /%v %v",
+
+		MsgStatsHeader: "Known complexes: %v\n" +
+			"Subscriptions: %v in %v chats\n" +
+			"Outbox queue: %v, held notifications: %v\n" +
+			"Messages sent: %v, retried: %v, dropped: %v",
+		MsgStatsLastDownloads:    "Last successful downloads:",
+		MsgStatsNeverDownloaded:  "never since start",
+		MsgStatsDownloadedAgo:    "%v ago",
+		MsgStatsAudit:            "Recent chat changes (%v total):",
+		MsgBroadcastUsage:        "Usage: /%v &lt;message&gt;",
+		MsgBroadcastConfirm:      "Send this message to %v chats?\n\n%v",
+		MsgBroadcastSendButton:   "✅ Send",
+		MsgBroadcastCancelButton: "❌ Cancel",
+		MsgBroadcastSent:         "The message is sent to %v chats",
+		MsgBroadcastCancelled:    "The broadcast is cancelled",
+		MsgBroadcastExpired:      "Nothing to broadcast, use /%v again",
+		MsgReloaded:              "Reloaded: %v complexes, %v subscriptions",
+		MsgReloadFailed:          "Reload failed: %v",
+		MsgNoDeadLetters:         "No failed messages",
+		MsgDeadLettersHeader:     "Failed messages (%v), to send them again: /%v_&lt;id&gt; or /%[2]v_all",
+		MsgRetryDeadUsage:        "Usage: /%v_&lt;id&gt; or /%[1]v_all",
+		MsgDeadLettersRetried:    "%v messages are queued again",
+		MsgAdminJobDone:          "/%v is done",
+		MsgAdminJobFailed:        "/%v failed: %v",
+
+		MsgLangCurrent:    "Language: %v",
+		MsgLangAuto:       "auto (%v)",
+		MsgLangChanged:    "Language is set to %v",
+		MsgLangUnknown:    "Unknown language %v, use one of: %v",
+		MsgLangSaveFailed: "Something went wrong while saving the language: %v",
+
+		MsgCmdList:        "list known complexes with subscribe buttons",
+		MsgCmdSub:         "subscribe to new flats and price drops in a complex, optionally filtered",
+		MsgCmdUnsub:       "unsubscribe from a complex",
+		MsgCmdMySubs:      "show your subscriptions",
+		MsgCmdUnsubAll:    "unsubscribe from all complexes",
+		MsgCmdMetro:       "list the metro stations near the complexes",
+		MsgCmdSubMetro:    "subscribe to all the complexes near the metro station, including the new ones",
+		MsgCmdUnsubMetro:  "stop adding the new complexes near the metro station",
+		MsgCmdDump:        "show all known flats in a complex sorted by price",
+		MsgCmdDumpAvg:     "show all known flats in a complex sorted by price per m2 compared to average",
+		MsgCmdDumpInfo:    "show all known flats in a complex with extra info",
+		MsgCmdSearch:      "search recently updated flats in all complexes",
+		MsgCmdSearches:    "list your saved searches",
+		MsgCmdSearchSave:  "save a named search over several complexes and get its updates in one message",
+		MsgCmdSearchDel:   "delete a saved search",
+		MsgCmdInfo:        "show price history of a flat",
+		MsgCmdMortgage:    "calculate the mortgage payments for a flat and compare with similar flats",
+		MsgCmdVelocity:    "show how fast the complex is selling and the free flats left",
+		MsgCmdWatch:       "get notified about any change of a flat",
+		MsgCmdUnwatch:     "stop watching a flat, plain /unwatch lists the watched flats",
+		MsgCmdSettings:    "show or change price drop alert thresholds of your subscriptions",
+		MsgCmdLifecycle:   "opt in to alerts about flats back from the reserve and sold flats",
+		MsgCmdDelivery:    "choose instant notifications or digests, set quiet hours",
+		MsgCmdAlerts:      "opt out of extreme drops in other complexes and new projects alerts",
+		MsgCmdLang:        "choose the language of the bot",
+		MsgCmdStats:       "admin: bot statistics",
+		MsgCmdBroadcast:   "admin: send a message to all known chats",
+		MsgCmdReload:      "admin: re-read complexes and subscriptions from disk",
+		MsgCmdBackupNow:   "admin: back up the data now",
+		MsgCmdRotateNow:   "admin: rotate the logs now",
+		MsgCmdDeadLetters: "admin: list the messages which failed to be sent",
+		MsgCmdRetryDead:   "admin: send a failed message again, or all of them with /retry_dead_all",
+		MsgCmdHelp:        "show all commands",
+		MsgCmdHello:       "say hello",
+	})
+
+	util.RegisterMessages(util.LangRu, map[util.MsgKey]string{
+		MsgLangName: "Русский",
+
+		MsgHello:              "Привет, %v!",
+		MsgPage:               "%v (страница %v/%v):",
+		MsgListHeader:         "Известные ЖК",
+		MsgAvailableCommands:  "Доступные команды:",
+		MsgSomethingWentWrong: "Что-то пошло не так: %v",
+		MsgMessageTooOld:      "Сообщение слишком старое, запросите новое",
+		MsgUnknownComplex:     "Неизвестный ЖК %v",
+
+		MsgNoKnownFlats:         "Нет известных квартир в ЖК %v",
+		MsgFlatNotFound:         "Квартира с ID %v в ЖК %v не найдена",
+		MsgInfoChartCaption:     "минимальные и максимальные цены (окно 2 недели) похожих квартир в резерве",
+		MsgVelocityChartCaption: "квартиры, появившиеся, забронированные и пропавшие по дням",
+
+		MsgInvalidFilter: "Не удалось разобрать фильтр: %v\n" +
+			"Использование: /%v_%v %v",
+		MsgAlreadySubscribed: "Вы уже подписаны на ЖК %v.\n" +
+			"Все квартиры: /%v_%v\n" +
+			"Фильтр уведомлений: /%v_%v %v",
+		MsgAlreadySubscribedShort: "Вы уже подписаны на %v",
+		MsgSubscribeFailed: "Что-то пошло не так при подписке на %v:\n" +
+			"ошибка: %v\n" +
+			"Попробуйте позже: /%v_%v",
+		MsgSubscribed: "Вы подписаны на новые квартиры в ЖК %v%v.\n" +
+			"Отписаться: /%v_%v\n" +
+			"Все известные квартиры: /%v_%v",
+		MsgSubscribedShort: "Вы подписаны на %v",
+		MsgFilterInfo:      " (фильтр: %v)",
+		MsgFilterUpdateFailed: "Что-то пошло не так при изменении фильтра для %v:\n" +
+			"ошибка: %v\n" +
+			"Попробуйте позже: /%v_%v",
+		MsgFilterUpdated: "Фильтр для %v изменён: %v.\n" +
+			"Получать все квартиры: /%v_%v %v",
+		MsgFilterRemoved: "Фильтр для %v удалён, вы будете получать все квартиры.",
+		MsgNotSubscribed: "Вы не подписаны на ЖК %v.\n" +
+			"Подписаться: /%v_%v\n" +
+			"Все квартиры: /%v_%v",
+		MsgNotSubscribedShort: "Вы не подписаны на %v",
+		MsgUnsubscribeFailed: "Что-то пошло не так при отписке от %v:\n" +
+			"ошибка: %v\n" +
+			"Попробуйте позже: /%v_%v",
+		MsgUnsubscribed: "Вы отписались от ЖК %v.\n" +
+			"Подписаться снова: /%v_%v\n" +
+			"Все известные квартиры: /%v_%v",
+		MsgUnsubscribedShort:    "Вы отписались от %v",
+		MsgMySubsHeader:         "Ваши подписки (%v):",
+		MsgMySubsDetails:        "    уведомления: %v; последнее уведомление: %v",
+		MsgMySubsNotifiedAgo:    "%v назад",
+		MsgMySubsNotNotified:    "не было с перезапуска бота",
+		MsgMySubsFooter:         "Фильтр меняется через /%v_&lt;slug&gt; &lt;фильтр&gt;, уведомления через /%v, отписаться от всего: /%v",
+		MsgUnsubAllConfirm:      "Отписаться от всех ЖК (%v)?",
+		MsgUnsubAllButton:       "Отписаться от всех",
+		MsgUnsubAllCancelButton: "Отмена",
+		MsgUnsubAllDone:         "Подписки на ЖК отменены: %v, подписаться снова можно через /%v",
+		MsgUnsubAllCancelled:    "Подписки сохранены",
+
+		MsgNewBlocksFooter: "Чтобы следить за обновлениями, напишите @%v",
+
+		MsgSearchFound:        "Найдено квартир: %v",
+		MsgSearchMatching:     " по запросу %v",
+		MsgSearchShowingFirst: ", показаны первые %v",
+		MsgSearchNothingFound: "Ничего не найдено, попробуйте ослабить условия",
+
+		MsgSavedSearchesHeader:  "Ваши сохранённые поиски (%v):",
+		MsgSavedSearchLine:      "%v. <b>%v</b>: %v",
+		MsgSavedSearchAllBlocks: "все ЖК",
+		MsgSavedSearchesFooter:  "Чтобы изменить поиск, сохраните его под тем же именем: /%v %v",
+		MsgNoSavedSearches: "У вас пока нет сохранённых поисков.\n" +
+			"Сохранить: /%v %v",
+		MsgInvalidSavedSearch: "Не получилось разобрать поиск: %v\n" +
+			"Использование: /%v %v",
+		MsgSavedSearchFailed: "Что-то пошло не так при сохранении поиска: %v",
+		MsgSavedSearchSaved: "Поиск сохранён: %v\n" +
+			"Новые квартиры и снижения цен во всех его ЖК будут приходить одним сообщением. Все поиски: /%v",
+		MsgSavedSearchNotFound: "Нет сохранённого поиска %v, см. /%v",
+		MsgSavedSearchDeleted:  "Поиск %v удалён, остальные: /%v",
+		MsgSavedSearchUpdates:  "🔎 Сохранённый поиск <b>%v</b>:",
+
+		MsgMetroHeader:          "Станции метро рядом с ЖК (%v), подпишитесь, чтобы получать все ЖК рядом со станцией, включая новые:",
+		MsgMetroLine:            "%v%v (ЖК: %v) %v",
+		MsgNoMetroStations:      "Станции метро пока неизвестны, попробуйте позже",
+		MsgUnknownMetroStation:  "Неизвестная станция метро %v, см. /%v",
+		MsgMetroSubscribeFailed: "Что-то пошло не так при подписке на станцию метро %v: %v",
+		MsgMetroSubscribed: "Вы подписаны на станцию метро %v, новые ЖК рядом с ней будут добавляться автоматически. Подписки на ЖК:\n" +
+			"%v\n" +
+			"Чтобы не добавлять новые ЖК: /%v_%v",
+		MsgMetroAlreadySubscribed: "Вы уже подписаны на станцию метро %v. Подписки на ЖК:\n" +
+			"%v\n" +
+			"Чтобы не добавлять новые ЖК: /%v_%v",
+		MsgNotSubscribedToMetro: "Вы не подписаны на станцию метро %v",
+		MsgMetroUnsubscribed:    "Новые ЖК рядом со станцией метро %v больше не будут добавляться, текущие подписки сохранены: /%v",
+		MsgMetroNewBlock: "Новый ЖК рядом со станцией метро %v, вы подписаны на него:\n" +
+			"%v",
+
+		MsgMortgageButton: "🏦 Ипотека",
+		MsgMortgageHeader: "🏦 Ипотека на квартиру в %v:\n" +
+			"%v",
+		MsgMortgageTerms:       "Цена %v Р, первый взнос %v Р (%v%%), кредит %v Р на %v лет",
+		MsgMortgageProgram:     "<b>%v</b> %v%%: %v Р/мес, проценты %v Р, переплата %v%% от цены",
+		MsgMortgageUnavailable: "<b>%v</b> %v%%: недоступна, %v",
+		MsgMortgageMinDown:     "первый взнос должен быть не меньше %v",
+		MsgMortgageMaxLoan:     "кредит должен быть не больше %v Р",
+		MsgMortgageSimilar:     "Похожих квартир в продаже: %v, медианная цена %v Р, эта квартира %v%%. Самые дешёвые с лучшей программой:",
+		MsgMortgageSimilarFlat: "%v\n" +
+			"    %v: %v Р/мес",
+		MsgMortgageNoSimilar: "Нет похожих квартир в продаже для сравнения",
+		MsgMortgageFooter:    "Другие условия: /%v_%v_%v %v",
+		MsgInvalidMortgage: "Не получилось разобрать запрос ипотеки: %v\n" +
+			"Использование: /%v_&lt;slug&gt;_&lt;flatID&gt; %v",
+
+		MsgLifecycleSettings: "Оповещения о статусе квартир в %v: %v\n" +
+			"released: квартиры, вернувшиеся из резерва, обычно выгодные; sold: сводка квартир, пропавших с прошлой проверки.\n" +
+			"Изменить: /%v_%v %v",
+		MsgInvalidLifecycle: "Не получилось разобрать оповещения о статусе: %v\n" +
+			"Использование: /%v_%v %v",
+		MsgLifecycleUpdateFailed: "Что-то пошло не так при изменении оповещений о статусе для %v: %v",
+		MsgReleasedHeader:        "🔓 Вернулись из резерва в %[2]v: %[1]v",
+		MsgSoldSummaryHeader:     "🏁 Пропали из продажи в %[2]v с прошлой проверки: %[1]v",
+		MsgLifecycleMore:         "и ещё %v",
+		MsgBulkChangesHeader:     "Изменения сроков сдачи и отделки в ЖК %v:",
+
+		MsgInlineFlatTitle:       "%v: %vк, %vм2, %v₽",
+		MsgInlineFlatDescription: "Корпус %v, этаж %v/%v, %v, %v",
+		MsgInlineInvalidQuery:    "Неверный запрос",
+		MsgInlineSyntax:          "Наберите @%v, затем при желании комплекс, например <code>2ngt 2r &lt;15m</code>. Фильтры: %v",
+		MsgInvalidSearch: "Не удалось разобрать условия поиска: %v\n" +
+			"Использование: /%v %v",
+
+		MsgWatching: "Вы следите за квартирой в ЖК %v:\n%v\n" +
+			"Вы получите уведомление при изменении цены, статуса, срока заселения и отделки.\n" +
+			"Перестать следить: /%v_%v_%v",
+		MsgWatchFailed: "Что-то пошло не так при добавлении квартиры: %v",
+		MsgUnwatched: "Вы больше не следите за квартирой %v.\n" +
+			"Следить снова: /%v_%v_%v",
+		MsgNoWatches:          "Вы не следите ни за одной квартирой. Используйте /%v_&lt;slug&gt;_&lt;flatID&gt; или кнопки 👁",
+		MsgWatchesHeader:      "Отслеживаемые квартиры, нажмите, чтобы перестать следить:",
+		MsgWatchedFlatChanged: "Изменения квартиры в ЖК %v:",
+
+		MsgNoSubscriptions: "Вы ещё не подписаны ни на один ЖК, см. /%v",
+		MsgInvalidThresholds: "Не удалось разобрать пороги: %v\n" +
+			"Использование: /%v [slug] %v",
+		MsgSettingsUpdateFailed: "Что-то пошло не так при изменении настроек для %v: %v",
+		MsgSettingsHeader:       "Настройки уведомлений о снижении цен:",
+		MsgSettingsHelp: "%v: минимальное снижение цены в процентах; %v: минимальное сильное снижение, " +
+			"если цена за м2 также на %v процентов ниже средней\n" +
+			"Изменить: /%v [slug] %v",
+
+		MsgInvalidDelivery: "Не получилось разобрать настройки доставки: %v\n" +
+			"Использование: /%v %v",
+		MsgDeliveryUpdateFailed: "Что-то пошло не так при сохранении настроек доставки: %v",
+		MsgDeliverySettings: "Доставка уведомлений: %v\n" +
+			"instant: сразу; hourly: сводка раз в час; daily: сводка раз в день в указанное время. " +
+			"Уведомления в тихие часы откладываются и приходят одним сообщением после их окончания.\n" +
+			"Изменить: /%v %v",
+		MsgDigestHeader: "📬 Уведомления (%v) с прошлой сводки:",
+
+		MsgInvalidAlerts: "Не получилось разобрать настройки оповещений: %v\n" +
+			"Использование: /%v %v",
+		MsgAlertsUpdateFailed: "Что-то пошло не так при сохранении настроек оповещений: %v",
+		MsgAlertsSettings: "Оповещения для всех чатов: %v\n" +
+			"drops: сильные снижения цен в любом ЖК: on, off или только в перечисленных ЖК; news: новые проекты на сайте. " +
+			"Уведомления по вашим подпискам приходят в любом случае.\n" +
+			"Изменить: /%v %v",
+
+		MsgStatsHeader: "Известных ЖК: %v\n" +
+			"Подписок: %v в %v чатах\n" +
+			"Очередь отправки: %v, отложенных уведомлений: %v\n" +
+			"Сообщений отправлено: %v, повторов: %v, потеряно: %v",
+		MsgStatsLastDownloads:    "Последние успешные загрузки:",
+		MsgStatsNeverDownloaded:  "не было с момента запуска",
+		MsgStatsDownloadedAgo:    "%v назад",
+		MsgStatsAudit:            "Последние изменения чатов (всего %v):",
+		MsgBroadcastUsage:        "Использование: /%v &lt;сообщение&gt;",
+		MsgBroadcastConfirm:      "Отправить это сообщение в %v чатов?\n\n%v",
+		MsgBroadcastSendButton:   "✅ Отправить",
+		MsgBroadcastCancelButton: "❌ Отмена",
+		MsgBroadcastSent:         "Сообщение отправлено в %v чатов",
+		MsgBroadcastCancelled:    "Рассылка отменена",
+		MsgBroadcastExpired:      "Нечего отправлять, используйте /%v ещё раз",
+		MsgReloaded:              "Перечитано: %v ЖК, %v подписок",
+		MsgReloadFailed:          "Не удалось перечитать: %v",
+		MsgNoDeadLetters:         "Неотправленных сообщений нет",
+		MsgDeadLettersHeader:     "Неотправленные сообщения (%v), отправить снова: /%v_&lt;id&gt; или /%[2]v_all",
+		MsgRetryDeadUsage:        "Использование: /%v_&lt;id&gt; или /%[1]v_all",
+		MsgDeadLettersRetried:    "Сообщений снова в очереди: %v",
+		MsgAdminJobDone:          "/%v выполнено",
+		MsgAdminJobFailed:        "/%v не удалось: %v",
+
+		MsgLangCurrent:    "Язык: %v",
+		MsgLangAuto:       "автоматически (%v)",
+		MsgLangChanged:    "Выбран язык: %v",
+		MsgLangUnknown:    "Неизвестный язык %v, доступны: %v",
+		MsgLangSaveFailed: "Что-то пошло не так при сохранении языка: %v",
+
+		MsgCmdList:        "список ЖК с кнопками подписки",
+		MsgCmdSub:         "подписаться на новые квартиры и снижения цен в ЖК, можно с фильтром",
+		MsgCmdUnsub:       "отписаться от ЖК",
+		MsgCmdMySubs:      "показать ваши подписки",
+		MsgCmdUnsubAll:    "отписаться от всех ЖК",
+		MsgCmdMetro:       "список станций метро рядом с ЖК",
+		MsgCmdSubMetro:    "подписаться на все ЖК рядом со станцией метро, включая новые",
+		MsgCmdUnsubMetro:  "перестать добавлять новые ЖК рядом со станцией метро",
+		MsgCmdDump:        "все известные квартиры в ЖК по цене",
+		MsgCmdDumpAvg:     "все известные квартиры в ЖК по цене за м2 относительно средней",
+		MsgCmdDumpInfo:    "все известные квартиры в ЖК с подробностями",
+		MsgCmdSearch:      "поиск актуальных квартир во всех ЖК",
+		MsgCmdSearches:    "ваши сохранённые поиски",
+		MsgCmdSearchSave:  "сохранить поиск по нескольким ЖК и получать его обновления одним сообщением",
+		MsgCmdSearchDel:   "удалить сохранённый поиск",
+		MsgCmdInfo:        "история цен квартиры",
+		MsgCmdMortgage:    "рассчитать платежи по ипотеке и сравнить с похожими квартирами",
+		MsgCmdVelocity:    "показать скорость продаж в ЖК и оставшиеся свободные квартиры",
+		MsgCmdWatch:       "следить за любыми изменениями квартиры",
+		MsgCmdUnwatch:     "перестать следить за квартирой, /unwatch без аргументов покажет список",
+		MsgCmdSettings:    "пороги уведомлений о снижении цен для ваших подписок",
+		MsgCmdLifecycle:   "оповещения о квартирах из резерва и проданных квартирах",
+		MsgCmdDelivery:    "уведомления сразу или сводкой, тихие часы",
+		MsgCmdAlerts:      "отключить оповещения о сильных снижениях в других ЖК и о новых проектах",
+		MsgCmdLang:        "выбрать язык бота",
+		MsgCmdStats:       "админ: статистика бота",
+		MsgCmdBroadcast:   "админ: сообщение во все известные чаты",
+		MsgCmdReload:      "админ: перечитать ЖК и подписки с диска",
+		MsgCmdBackupNow:   "админ: сделать бэкап данных",
+		MsgCmdRotateNow:   "админ: ротировать логи",
+		MsgCmdDeadLetters: "админ: неотправленные сообщения",
+		MsgCmdRetryDead:   "админ: отправить неотправленное сообщение снова, все — /retry_dead_all",
+		MsgCmdHelp:        "все команды",
+		MsgCmdHello:       "поздороваться",
+	})
+}
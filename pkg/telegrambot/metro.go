@@ -0,0 +1,370 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const MetroSubscriptionsFile = "data/metro_subscriptions.json"
+
+// MetroStation is a station with the complexes whose flats list it, built from the stored flats
+type MetroStation struct {
+	ID     int64
+	Name   string
+	Blocks map[string]bool // embedded slugs
+}
+
+// MetroSubscription subscribes the chat to every complex near the station, including the new ones
+type MetroSubscription struct {
+	ChatID  int64  `json:"chat_id"`
+	MetroID int64  `json:"metro_id"`
+	Name    string `json:"name"` // the station name at the moment of subscribing
+}
+
+var (
+	metroIndex      = make(map[int64]*MetroStation)
+	metroIndexMutex sync.RWMutex
+
+	metroSubscriptionsFile  = newEnvFile[MetroSubscription](MetroSubscriptionsFile)
+	MetroSubscriptions      = metroSubscriptionsFile.load()
+	metroSubscriptionsMutex sync.RWMutex
+)
+
+// syncMetroSubscriptionStorageToFile must be called with metroSubscriptionsMutex locked
+func syncMetroSubscriptionStorageToFile() error {
+	return metroSubscriptionsFile.write(MetroSubscriptions)
+}
+
+// AddMetroSubscription returns false if the chat is already subscribed to the station
+func AddMetroSubscription(chatID int64, station *MetroStation) (bool, error) {
+	metroSubscriptionsMutex.Lock()
+	defer metroSubscriptionsMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	for _, subscription := range MetroSubscriptions[envtype] {
+		if subscription.ChatID == chatID && subscription.MetroID == station.ID {
+			return false, nil
+		}
+	}
+
+	MetroSubscriptions[envtype] = append(MetroSubscriptions[envtype], MetroSubscription{
+		ChatID:  chatID,
+		MetroID: station.ID,
+		Name:    station.Name,
+	})
+
+	err := syncMetroSubscriptionStorageToFile()
+	if err != nil {
+		MetroSubscriptions[envtype] = MetroSubscriptions[envtype][:len(MetroSubscriptions[envtype])-1]
+		return false, err
+	}
+	return true, nil
+}
+
+func RemoveMetroSubscription(chatID int64, metroID int64) error {
+	metroSubscriptionsMutex.Lock()
+	defer metroSubscriptionsMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	for i, subscription := range MetroSubscriptions[envtype] {
+		if subscription.ChatID == chatID && subscription.MetroID == metroID {
+			MetroSubscriptions[envtype] = util.RemoveSliceElement(MetroSubscriptions[envtype], i)
+			return syncMetroSubscriptionStorageToFile()
+		}
+	}
+	return fmt.Errorf("chat %v was not subscribed to metro station %v", chatID, metroID)
+}
+
+// MigrateMetroSubscriptions moves the metro subscriptions of the group upgraded to a supergroup to its new ID
+func MigrateMetroSubscriptions(oldChatID int64, newChatID int64) error {
+	metroSubscriptionsMutex.Lock()
+	defer metroSubscriptionsMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	oldList := MetroSubscriptions[envtype]
+	subscribed := make(map[int64]bool)
+	for _, subscription := range oldList {
+		if subscription.ChatID == newChatID {
+			subscribed[subscription.MetroID] = true
+		}
+	}
+	newList := make([]MetroSubscription, 0, len(oldList))
+	var moved bool
+	for _, subscription := range oldList {
+		if subscription.ChatID == oldChatID {
+			moved = true
+			if subscribed[subscription.MetroID] {
+				continue
+			}
+			subscription.ChatID = newChatID
+		}
+		newList = append(newList, subscription)
+	}
+	if !moved {
+		return nil
+	}
+
+	MetroSubscriptions[envtype] = newList
+	err := syncMetroSubscriptionStorageToFile()
+	if err != nil {
+		MetroSubscriptions[envtype] = oldList
+		return err
+	}
+	return nil
+}
+
+// getMetroSubscriptions returns the subscriptions by station
+func getMetroSubscriptions() map[int64][]MetroSubscription {
+	metroSubscriptionsMutex.RLock()
+	defer metroSubscriptionsMutex.RUnlock()
+
+	res := make(map[int64][]MetroSubscription)
+	for _, subscription := range MetroSubscriptions[util.GetEnvType()] {
+		res[subscription.MetroID] = append(res[subscription.MetroID], subscription)
+	}
+	return res
+}
+
+func getChatMetroSubscriptions(chatID int64) map[int64]bool {
+	metroSubscriptionsMutex.RLock()
+	defer metroSubscriptionsMutex.RUnlock()
+
+	res := make(map[int64]bool)
+	for _, subscription := range MetroSubscriptions[util.GetEnvType()] {
+		if subscription.ChatID == chatID {
+			res[subscription.MetroID] = true
+		}
+	}
+	return res
+}
+
+// RebuildMetroIndex indexes the stations of the stored flats of every known complex
+func RebuildMetroIndex() {
+	index := make(map[int64]*MetroStation)
+	for slug := range BlockSlugs {
+		msgData, err := loadBlockFlats(slug)
+		if err != nil {
+			log.Printf("failed to index metro stations of %v: %v", slug, err)
+			continue
+		}
+		addToMetroIndex(index, slug, msgData.Flats)
+	}
+
+	metroIndexMutex.Lock()
+	defer metroIndexMutex.Unlock()
+	metroIndex = index
+	log.Printf("indexed %v metro stations", len(index))
+}
+
+// addToMetroIndex returns the stations the complex was not known to be near before
+func addToMetroIndex(index map[int64]*MetroStation, slug string, flats []flatstorage.Flat) []*MetroStation {
+	slug = util.EmbedSlug(slug)
+	var added []*MetroStation
+	for i := range flats {
+		metro := flats[i].Metro
+		if metro.ID == 0 || metro.Name == "" {
+			continue
+		}
+		station, ok := index[metro.ID]
+		if !ok {
+			station = &MetroStation{ID: metro.ID, Blocks: make(map[string]bool)}
+			index[metro.ID] = station
+		}
+		station.Name = string(metro.Name)
+		if !station.Blocks[slug] {
+			station.Blocks[slug] = true
+			added = append(added, station)
+		}
+	}
+	return added
+}
+
+// indexBlockMetro adds the stations of the downloaded flats to the index,
+// returns the stations the complex was not known to be near before
+func indexBlockMetro(slug string, flats []flatstorage.Flat) []MetroStation {
+	metroIndexMutex.Lock()
+	defer metroIndexMutex.Unlock()
+
+	var res []MetroStation
+	for _, station := range addToMetroIndex(metroIndex, slug, flats) {
+		res = append(res, MetroStation{ID: station.ID, Name: station.Name})
+	}
+	return res
+}
+
+// getMetroStations returns copies of the indexed stations sorted by name
+func getMetroStations() []MetroStation {
+	metroIndexMutex.RLock()
+	defer metroIndexMutex.RUnlock()
+
+	res := make([]MetroStation, 0, len(metroIndex))
+	for _, station := range metroIndex {
+		blocks := make(map[string]bool, len(station.Blocks))
+		for slug := range station.Blocks {
+			blocks[slug] = true
+		}
+		res = append(res, MetroStation{ID: station.ID, Name: station.Name, Blocks: blocks})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
+}
+
+// findMetroStation finds the station by ID or by name ignoring case
+func findMetroStation(arg string) (*MetroStation, bool) {
+	arg = strings.Join(strings.Fields(arg), " ")
+	id, err := strconv.ParseInt(arg, 10, 64)
+	for _, station := range getMetroStations() {
+		if (err == nil && station.ID == id) || strings.EqualFold(station.Name, arg) {
+			return &station, true
+		}
+	}
+	return nil, false
+}
+
+// SortedBlocks returns the complexes near the station sorted by name
+func (s *MetroStation) SortedBlocks() []BlockInfo {
+	res := make([]BlockInfo, 0, len(s.Blocks))
+	for slug := range s.Blocks {
+		if block, ok := BlockSlugs[slug]; ok {
+			res = append(res, block)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// sendMetro handles /metro
+func sendMetro(chatID int64) {
+	lang := GetChatLang(chatID)
+	stations := getMetroStations()
+	if len(stations) == 0 {
+		SendMessageWithPinAsync(chatID, util.Msg(lang, MsgNoMetroStations), false)
+		return
+	}
+
+	subscribed := getChatMetroSubscriptions(chatID)
+	lines := []string{util.Msg(lang, MsgMetroHeader, len(stations))}
+	for _, station := range stations {
+		command := fmt.Sprintf("/%v_%v", SubMetroCommand, station.ID)
+		var prefix string
+		if subscribed[station.ID] {
+			prefix = "✅"
+			command = fmt.Sprintf("/%v_%v", UnsubMetroCommand, station.ID)
+		}
+		lines = append(lines, util.Msg(lang, MsgMetroLine, prefix, escapeHTML(station.Name), len(station.Blocks), command))
+	}
+	SendMessageWithPinAsync(chatID, strings.Join(lines, "\n"), false)
+}
+
+// subscribeMetro handles /sub_metro_<station>, the station is its ID or name
+func subscribeMetro(chatID int64, args string) {
+	station, ok := findMetroStation(args)
+	if !ok {
+		err := SendMessage(chatID, localize(chatID, MsgUnknownMetroStation, escapeHTML(args), MetroCommand))
+		if err != nil {
+			log.Printf("failed to send unknown metro station message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	added, err := AddMetroSubscription(chatID, station)
+	if err != nil {
+		log.Printf("failed to subscribe %v to metro station %v: %v", chatID, station.ID, err)
+		err = SendMessage(chatID, localize(chatID, MsgMetroSubscribeFailed, escapeHTML(station.Name), err))
+		if err != nil {
+			log.Printf("failed to send metro subscription failed message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	var blocks []string
+	subscriptions := GetChatSubscriptions(chatID)
+	for _, block := range station.SortedBlocks() {
+		slug := util.EmbedSlug(block.Slug)
+		if _, ok := subscriptions[slug]; !ok {
+			err = AddNewSubscriber(chatID, slug, nil)
+			if err != nil {
+				log.Printf("failed to subscribe %v to %v near metro station %v: %v", chatID, slug, station.ID, err)
+				continue
+			}
+		}
+		blocks = append(blocks, block.StringWithCommand(UnsubscribeCommand))
+	}
+
+	key := MsgMetroSubscribed
+	if !added {
+		key = MsgMetroAlreadySubscribed
+	}
+	err = SendMessage(chatID, localize(chatID, key, escapeHTML(station.Name), strings.Join(blocks, "\n"), UnsubMetroCommand, station.ID))
+	if err != nil {
+		log.Printf("failed to send metro subscribed message to %v: %v", chatID, err)
+	}
+}
+
+// unsubscribeMetro handles /unsub_metro_<station>, the subscriptions to the complexes are kept
+func unsubscribeMetro(chatID int64, args string) {
+	station, ok := findMetroStation(args)
+	if !ok {
+		err := SendMessage(chatID, localize(chatID, MsgUnknownMetroStation, escapeHTML(args), MetroCommand))
+		if err != nil {
+			log.Printf("failed to send unknown metro station message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	err := RemoveMetroSubscription(chatID, station.ID)
+	if err != nil {
+		log.Printf("failed to unsubscribe %v from metro station %v: %v", chatID, station.ID, err)
+		err = SendMessage(chatID, localize(chatID, MsgNotSubscribedToMetro, escapeHTML(station.Name)))
+		if err != nil {
+			log.Printf("failed to send not subscribed to metro message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	err = SendMessage(chatID, localize(chatID, MsgMetroUnsubscribed, escapeHTML(station.Name), MySubsCommand))
+	if err != nil {
+		log.Printf("failed to send metro unsubscribed message to %v: %v", chatID, err)
+	}
+}
+
+// subscribeMetroFollowers subscribes the chats following the stations to the complexes found near them
+func subscribeMetroFollowers(newStations map[string][]MetroStation) {
+	if len(newStations) == 0 {
+		return
+	}
+	followers := getMetroSubscriptions()
+	for slug, stations := range newStations {
+		for _, station := range stations {
+			for _, follower := range followers[station.ID] {
+				if IsChatDisabled(follower.ChatID) {
+					continue
+				}
+				added, err := addSubscriberIfMissing(follower.ChatID, slug)
+				if err != nil {
+					log.Printf("failed to subscribe %v to %v near metro station %v: %v", follower.ChatID, slug, station.ID, err)
+					continue
+				}
+				if !added {
+					continue // already subscribed
+				}
+				lang := GetChatLang(follower.ChatID)
+				Notify(follower.ChatID, util.Msg(lang, MsgMetroNewBlock, escapeHTML(station.Name),
+					BlockSlugs[slug].StringWithCommand(UnsubscribeCommand)), nil)
+			}
+		}
+	}
+}
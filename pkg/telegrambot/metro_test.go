@@ -0,0 +1,105 @@
+package telegrambot
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetroIndex(t *testing.T) {
+	oldIndex := metroIndex
+	t.Cleanup(func() {
+		metroIndex = oldIndex
+	})
+	metroIndex = make(map[int64]*MetroStation)
+
+	nagatinskaya := flatstorage.Metro{ID: 148, Name: "Нагатинская"}
+	added := indexBlockMetro("2ngt", []flatstorage.Flat{{ID: 1, Metro: nagatinskaya}, {ID: 2, Metro: nagatinskaya}, {ID: 3}})
+	require.Equal(t, []MetroStation{{ID: 148, Name: "Нагатинская"}}, added)
+	require.Empty(t, indexBlockMetro("2ngt", []flatstorage.Flat{{ID: 4, Metro: nagatinskaya}}))
+	require.Len(t, indexBlockMetro("amur", []flatstorage.Flat{{ID: 5, Metro: nagatinskaya}, {ID: 6, Metro: flatstorage.Metro{ID: 7, Name: "Бабушкинская"}}}), 2)
+
+	stations := getMetroStations()
+	require.Len(t, stations, 2)
+	require.Equal(t, "Бабушкинская", stations[0].Name)
+	require.Equal(t, map[string]bool{"2ngt": true, "amur": true}, stations[1].Blocks)
+
+	station, ok := findMetroStation("148")
+	require.True(t, ok)
+	require.Equal(t, "Нагатинская", station.Name)
+	station, ok = findMetroStation(" нагатинская ")
+	require.True(t, ok)
+	require.Equal(t, int64(148), station.ID)
+	_, ok = findMetroStation("149")
+	require.False(t, ok)
+}
+
+func TestSubscribeMetroFollowers(t *testing.T) {
+	useTestStorage(t, []ChannelInfo{{ChatID: 2, BlockSlug: "amur"}})
+	useTestOutbox(t)
+
+	envtype := util.GetEnvType()
+	oldSubscriptions := MetroSubscriptions[envtype]
+	t.Cleanup(func() {
+		MetroSubscriptions[envtype] = oldSubscriptions
+	})
+	MetroSubscriptions[envtype] = nil
+
+	station := &MetroStation{ID: 148, Name: "Нагатинская"}
+	added, err := AddMetroSubscription(1, station)
+	require.NoError(t, err)
+	require.True(t, added)
+	added, err = AddMetroSubscription(1, station)
+	require.NoError(t, err)
+	require.False(t, added)
+	_, err = AddMetroSubscription(2, station)
+	require.NoError(t, err)
+
+	subscriptions, err := metroSubscriptionsFile.read()
+	require.NoError(t, err)
+	require.Len(t, subscriptions[envtype], 2)
+
+	subscribeMetroFollowers(map[string][]MetroStation{"amur": {*station}})
+	require.True(t, CheckSubscribed(1, "amur"))
+	require.Len(t, ChannelIDs[envtype], 2) // chat 2 was already subscribed
+
+	// the followers are subscribed once even if the complex is found near the station concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			subscribeMetroFollowers(map[string][]MetroStation{"2ngt": {*station}})
+		}()
+	}
+	wg.Wait()
+	require.Len(t, getChannels(), 4)
+
+	require.NoError(t, MigrateMetroSubscriptions(1, 3))
+	require.Equal(t, map[int64]bool{148: true}, getChatMetroSubscriptions(3))
+	require.Empty(t, getChatMetroSubscriptions(1))
+
+	require.NoError(t, RemoveMetroSubscription(3, 148))
+	require.Error(t, RemoveMetroSubscription(3, 148))
+}
+
+func TestRebuildMetroIndex(t *testing.T) {
+	oldStore, oldIndex := flatstorage.Store, metroIndex
+	t.Cleanup(func() {
+		flatstorage.Store, metroIndex = oldStore, oldIndex
+	})
+	flatstorage.Store = flatstorage.NewMemoryStore()
+
+	_, err := flatstorage.Store.Merge("amur", &flatstorage.MessageData{Flats: []flatstorage.Flat{
+		{ID: 1, Metro: flatstorage.Metro{ID: 7, Name: "Бабушкинская"}},
+	}})
+	require.NoError(t, err)
+
+	RebuildMetroIndex()
+	stations := getMetroStations()
+	require.Len(t, stations, 1)
+	require.Equal(t, map[string]bool{"amur": true}, stations[0].Blocks)
+}
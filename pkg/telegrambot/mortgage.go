@@ -0,0 +1,345 @@
+package telegrambot
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MortgageProgramsFile is edited by hand when the rates change, the defaults are used if there is none
+const MortgageProgramsFile = "data/mortgage_programs.json"
+
+const (
+	mortgageKeyDown = "down"
+	mortgageKeyTerm = "term"
+
+	defaultDownPaymentPercent = 20
+	defaultMortgageTermYears  = 30
+	maxMortgageTermYears      = 35
+
+	maxSimilarMortgageFlats = 3
+)
+
+// mortgageSyntax is a short human-readable description of the mortgage options
+const mortgageSyntax = "down=20% | down=3.5m term=30"
+
+// MortgageProgram is a rate program of the banks, e.g. the family mortgage
+type MortgageProgram struct {
+	Name string  `json:"name"`
+	Rate float64 `json:"rate"` // annual percent
+
+	MaxLoan            int64   `json:"max_loan,omitempty"`              // zero means no limit
+	MinDownPaymentRate float64 `json:"min_down_payment_rate,omitempty"` // percent of the price
+}
+
+var defaultMortgagePrograms = []MortgageProgram{
+	{Name: "Family", Rate: 6, MaxLoan: 12_000_000, MinDownPaymentRate: 20},
+	{Name: "IT", Rate: 6, MaxLoan: 18_000_000, MinDownPaymentRate: 20},
+	{Name: "Standard", Rate: 21, MinDownPaymentRate: 20},
+}
+
+// ReadMortgagePrograms reads the programs from the file, it is read on every request to pick up the changes
+func ReadMortgagePrograms(fileName string) ([]MortgageProgram, error) {
+	if !flatstorage.FileExists(fileName) {
+		return defaultMortgagePrograms, nil
+	}
+
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	var programs []MortgageProgram
+	err = json.Unmarshal(content, &programs)
+	if err != nil {
+		return nil, err
+	}
+	if len(programs) == 0 {
+		return nil, fmt.Errorf("no mortgage programs in %v", fileName)
+	}
+	return programs, nil
+}
+
+// MortgageOptions are the down payment and the term, the down payment is either an amount or a percent of the price
+type MortgageOptions struct {
+	DownPayment        int64
+	DownPaymentPercent float64
+	TermYears          int
+}
+
+// parseMortgageOptions parses args like "down=30% term=20" or "down=4m"
+func parseMortgageOptions(args []string) (MortgageOptions, error) {
+	res := MortgageOptions{
+		DownPaymentPercent: defaultDownPaymentPercent,
+		TermYears:          defaultMortgageTermYears,
+	}
+	for _, arg := range args {
+		key, value, _ := strings.Cut(strings.TrimSpace(arg), "=")
+		switch strings.ToLower(key) {
+		case "":
+			continue
+		case mortgageKeyDown:
+			if percentStr, ok := strings.CutSuffix(value, "%"); ok {
+				percent, err := strconv.ParseFloat(percentStr, 64)
+				if err != nil || percent < 0 || percent >= 100 {
+					return res, fmt.Errorf("invalid down payment %q, expected a percent from 0 to 100", value)
+				}
+				res.DownPayment, res.DownPaymentPercent = 0, percent
+				continue
+			}
+			amount, err := flatstorage.ParsePrice(value)
+			if err != nil || amount < 0 {
+				return res, fmt.Errorf("invalid down payment %q, expected something like 20%% or 3.5m", value)
+			}
+			res.DownPayment, res.DownPaymentPercent = amount, 0
+		case mortgageKeyTerm:
+			years, err := strconv.Atoi(value)
+			if err != nil || years < 1 || years > maxMortgageTermYears {
+				return res, fmt.Errorf("invalid term %q, expected years from 1 to %v", value, maxMortgageTermYears)
+			}
+			res.TermYears = years
+		default:
+			return res, fmt.Errorf("unknown mortgage option %q", arg)
+		}
+	}
+	return res, nil
+}
+
+// downPaymentFor returns the down payment for the flat of the price
+func (o MortgageOptions) downPaymentFor(price int64) int64 {
+	if o.DownPaymentPercent > 0 {
+		return int64(math.Round(float64(price) * o.DownPaymentPercent / 100))
+	}
+	return min(o.DownPayment, price)
+}
+
+// MortgagePayment is the result of the program for a flat, the program is unavailable if Unavailable is set
+type MortgagePayment struct {
+	Program       MortgageProgram
+	Loan          int64
+	Monthly       int64
+	TotalInterest int64
+
+	Unavailable util.MsgKey
+	Limit       string // the violated limit of the program
+}
+
+// annuityPayment calculates the fixed monthly payment repaying the loan with the annual rate in the months
+func annuityPayment(loan int64, annualRate float64, months int) float64 {
+	if loan <= 0 || months <= 0 {
+		return 0
+	}
+	r := annualRate / 100 / 12
+	if r == 0 {
+		return float64(loan) / float64(months)
+	}
+	return float64(loan) * r / (1 - math.Pow(1+r, -float64(months)))
+}
+
+func calcMortgage(price int64, options MortgageOptions, program MortgageProgram) MortgagePayment {
+	down := options.downPaymentFor(price)
+	res := MortgagePayment{
+		Program: program,
+		Loan:    price - down,
+	}
+	if program.MinDownPaymentRate > 0 && float64(down)*100 < float64(price)*program.MinDownPaymentRate {
+		res.Unavailable = MsgMortgageMinDown
+		res.Limit = strconv.FormatFloat(program.MinDownPaymentRate, 'f', -1, 64) + "%"
+		return res
+	}
+	if program.MaxLoan > 0 && res.Loan > program.MaxLoan {
+		res.Unavailable = MsgMortgageMaxLoan
+		res.Limit = util.ThousandSep(program.MaxLoan, " ")
+		return res
+	}
+
+	months := options.TermYears * 12
+	monthly := annuityPayment(res.Loan, program.Rate, months)
+	res.Monthly = int64(math.Round(monthly))
+	res.TotalInterest = int64(math.Round(monthly*float64(months))) - res.Loan
+	return res
+}
+
+// bestMortgage returns the available program with the lowest monthly payment
+func bestMortgage(price int64, options MortgageOptions, programs []MortgageProgram) (MortgagePayment, bool) {
+	var best MortgagePayment
+	found := false
+	for _, program := range programs {
+		payment := calcMortgage(price, options, program)
+		if payment.Unavailable != "" {
+			continue
+		}
+		if !found || payment.Monthly < best.Monthly {
+			best, found = payment, true
+		}
+	}
+	return best, found
+}
+
+// renderMortgage shows the payments of every program and compares the flat with the similar flats on sale
+func renderMortgage(flat *flatstorage.Flat, stats flatstorage.FlatStats, options MortgageOptions,
+	programs []MortgageProgram, lang util.Lang) string {
+	down := options.downPaymentFor(flat.Price)
+	lines := []string{
+		util.Msg(lang, MsgMortgageHeader, flat.BlockName, flat.StringWithOptions(lang)),
+		util.Msg(lang, MsgMortgageTerms, util.ThousandSep(flat.Price, " "), util.ThousandSep(down, " "),
+			percentOf(down, flat.Price), util.ThousandSep(flat.Price-down, " "), options.TermYears),
+		"",
+	}
+
+	for _, program := range programs {
+		payment := calcMortgage(flat.Price, options, program)
+		name := escapeHTML(program.Name)
+		rate := strconv.FormatFloat(program.Rate, 'f', -1, 64)
+		if payment.Unavailable != "" {
+			lines = append(lines, util.Msg(lang, MsgMortgageUnavailable, name, rate, util.Msg(lang, payment.Unavailable, payment.Limit)))
+			continue
+		}
+		lines = append(lines, util.Msg(lang, MsgMortgageProgram, name, rate, util.ThousandSep(payment.Monthly, " "),
+			util.ThousandSep(payment.TotalInterest, " "), percentOf(payment.TotalInterest, flat.Price)))
+	}
+
+	lines = append(lines, "")
+	lines = append(lines, renderSimilarMortgages(flat, stats, options, programs, lang)...)
+	lines = append(lines, "", util.Msg(lang, MsgMortgageFooter, MortgageCommand,
+		util.EmbedSlug(string(flat.BlockSlug)), flat.ID, mortgageSyntax))
+	return strings.Join(lines, "\n")
+}
+
+// renderSimilarMortgages compares the price and the best payment with the similar flats
+func renderSimilarMortgages(flat *flatstorage.Flat, stats flatstorage.FlatStats, options MortgageOptions,
+	programs []MortgageProgram, lang util.Lang) []string {
+	if len(stats.SimilarFlats) == 0 {
+		return []string{util.Msg(lang, MsgMortgageNoSimilar)}
+	}
+
+	similar := append([]flatstorage.Flat(nil), stats.SimilarFlats...)
+	sort.Slice(similar, func(i, j int) bool {
+		return similar[i].Price < similar[j].Price
+	})
+	median := similar[len(similar)/2].Price
+	if len(similar)%2 == 0 {
+		median = (similar[len(similar)/2-1].Price + median) / 2
+	}
+	lines := []string{util.Msg(lang, MsgMortgageSimilar, len(similar), util.ThousandSep(median, " "),
+		signedPercentOf(flat.Price-median, median))}
+
+	own, hasOwn := bestMortgage(flat.Price, options, programs)
+	for i := 0; i < len(similar) && i < maxSimilarMortgageFlats; i++ {
+		payment, found := bestMortgage(similar[i].Price, options, programs)
+		if !found {
+			lines = append(lines, similar[i].StringWithOptions(lang))
+			continue
+		}
+		line := util.Msg(lang, MsgMortgageSimilarFlat, similar[i].StringWithOptions(lang),
+			escapeHTML(payment.Program.Name), util.ThousandSep(payment.Monthly, " "))
+		if hasOwn {
+			line += fmt.Sprintf(" (%v)", signedAmount(payment.Monthly-own.Monthly))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func percentOf(part int64, whole int64) string {
+	if whole == 0 {
+		return "0"
+	}
+	return strconv.FormatFloat(float64(part)*100/float64(whole), 'f', 1, 64)
+}
+
+func signedAmount(amount int64) string {
+	if amount >= 0 {
+		return "+" + util.ThousandSep(amount, " ")
+	}
+	return "-" + util.ThousandSep(-amount, " ")
+}
+
+func signedPercentOf(part int64, whole int64) string {
+	if part >= 0 {
+		return "+" + percentOf(part, whole)
+	}
+	return percentOf(part, whole)
+}
+
+// findSimilarOnSale collects the recently updated flats similar to the flat
+func findSimilarOnSale(flats []flatstorage.Flat, flat *flatstorage.Flat, now time.Time) flatstorage.FlatStats {
+	return findSimilarFlats(flats, flat, func(similar *flatstorage.Flat) bool {
+		return similar.RecentlyUpdated(now)
+	})
+}
+
+// sendMortgage handles /mortgage_<slug>_<flatID> [down=20% term=30]
+func sendMortgage(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		fields = []string{""}
+	}
+
+	slug, flatID, err := splitSlugAndFlatID(fields[0])
+	if err != nil {
+		log.Printf("failed to send mortgage to %v: %v", chatID, err)
+		err = SendMessage(chatID, localize(chatID, MsgInvalidMortgage, escapeHTML(err.Error()), MortgageCommand, escapeHTML(mortgageSyntax)))
+		if err != nil {
+			log.Printf("failed to send invalid mortgage message to %v: %v", chatID, err)
+		}
+		return
+	}
+	slug, err = validateSlug(chatID, slug, MortgageCommand)
+	if err != nil {
+		log.Printf("failed to send mortgage to %v about %v: %v", chatID, fields[0], err)
+		return
+	}
+
+	options, err := parseMortgageOptions(fields[1:])
+	if err != nil {
+		err = SendMessage(chatID, localize(chatID, MsgInvalidMortgage, escapeHTML(err.Error()), MortgageCommand, escapeHTML(mortgageSyntax)))
+		if err != nil {
+			log.Printf("failed to send invalid mortgage message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	programs, err := ReadMortgagePrograms(MortgageProgramsFile)
+	if err != nil {
+		log.Printf("failed to read mortgage programs: %v", err)
+		err = SendMessage(chatID, localize(chatID, MsgSomethingWentWrong, err))
+		if err != nil {
+			log.Printf("failed to send something went wrong message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	msgData, err := loadBlockFlats(slug)
+	if err != nil {
+		log.Printf("failed to send mortgage about flat %v: %v", fields[0], err)
+		return
+	}
+	var flat *flatstorage.Flat
+	for i := range msgData.Flats {
+		if msgData.Flats[i].ID == flatID {
+			flat = &msgData.Flats[i]
+			break
+		}
+	}
+	if flat == nil {
+		err = SendMessage(chatID, localize(chatID, MsgFlatNotFound, flatID, slug))
+		if err != nil {
+			log.Printf("failed to send flat not found message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	stats := findSimilarOnSale(msgData.Flats, flat, time.Now())
+	err = SendMessage(chatID, renderMortgage(flat, stats, options, programs, GetChatLang(chatID)))
+	if err != nil {
+		log.Printf("failed to send mortgage to %v: %v", chatID, err)
+	}
+}
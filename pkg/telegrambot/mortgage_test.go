@@ -0,0 +1,96 @@
+package telegrambot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMortgageOptions(t *testing.T) {
+	options, err := parseMortgageOptions(nil)
+	require.NoError(t, err)
+	require.Equal(t, MortgageOptions{DownPaymentPercent: 20, TermYears: 30}, options)
+	require.Equal(t, int64(3_000_000), options.downPaymentFor(15_000_000))
+
+	options, err = parseMortgageOptions([]string{"down=3.5m", "term=20"})
+	require.NoError(t, err)
+	require.Equal(t, MortgageOptions{DownPayment: 3_500_000, TermYears: 20}, options)
+	require.Equal(t, int64(2_000_000), options.downPaymentFor(2_000_000))
+
+	for _, arg := range []string{"down=100%", "down=abc", "term=0", "term=50", "rate=6"} {
+		_, err = parseMortgageOptions([]string{arg})
+		require.Error(t, err, arg)
+	}
+}
+
+func TestCalcMortgage(t *testing.T) {
+	options := MortgageOptions{DownPaymentPercent: 20, TermYears: 30}
+	family := MortgageProgram{Name: "Family", Rate: 6, MaxLoan: 12_000_000, MinDownPaymentRate: 20}
+
+	payment := calcMortgage(12_500_000, options, family)
+	require.Empty(t, payment.Unavailable)
+	require.Equal(t, int64(10_000_000), payment.Loan)
+	require.Equal(t, int64(59_955), payment.Monthly)
+	require.InDelta(t, 11_583_819, payment.TotalInterest, 1)
+
+	payment = calcMortgage(20_000_000, options, family)
+	require.Equal(t, MsgMortgageMaxLoan, payment.Unavailable)
+
+	payment = calcMortgage(12_500_000, MortgageOptions{DownPaymentPercent: 10, TermYears: 30}, family)
+	require.Equal(t, MsgMortgageMinDown, payment.Unavailable)
+
+	standard := MortgageProgram{Name: "Standard", Rate: 21}
+	best, ok := bestMortgage(20_000_000, options, []MortgageProgram{family, standard})
+	require.True(t, ok)
+	require.Equal(t, "Standard", best.Program.Name)
+	require.Equal(t, int64(280_544), best.Monthly)
+
+	require.Equal(t, float64(100_000), annuityPayment(1_200_000, 0, 12))
+}
+
+func TestReadMortgagePrograms(t *testing.T) {
+	dir := t.TempDir()
+	programs, err := ReadMortgagePrograms(filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	require.Equal(t, defaultMortgagePrograms, programs)
+
+	fileName := filepath.Join(dir, "programs.json")
+	require.NoError(t, os.WriteFile(fileName, []byte(`[{"name":"Family","rate":6,"max_loan":12000000}]`), 0o644))
+	programs, err = ReadMortgagePrograms(fileName)
+	require.NoError(t, err)
+	require.Equal(t, []MortgageProgram{{Name: "Family", Rate: 6, MaxLoan: 12_000_000}}, programs)
+
+	require.NoError(t, os.WriteFile(fileName, []byte(`[]`), 0o644))
+	_, err = ReadMortgagePrograms(fileName)
+	require.Error(t, err)
+}
+
+func TestRenderMortgage(t *testing.T) {
+	now := time.Now()
+	updated := now.Add(-10 * time.Minute).Format(time.RFC3339)
+	flats := []flatstorage.Flat{
+		{ID: 1, Rooms: 2, Area: 55, Price: 12_500_000, BlockName: "Второй Нагатинский", BlockSlug: "2ngt", Updated: updated},
+		{ID: 2, Rooms: 2, Area: 55.5, Price: 12_000_000, BlockSlug: "2ngt", Updated: updated},
+		{ID: 3, Rooms: 2, Area: 54.5, Price: 13_000_000, BlockSlug: "2ngt", Updated: updated},
+		{ID: 4, Rooms: 3, Area: 55, Price: 11_000_000, BlockSlug: "2ngt", Updated: updated},
+		{ID: 5, Rooms: 2, Area: 55, Price: 10_000_000, BlockSlug: "2ngt", Updated: now.Add(-30 * 24 * time.Hour).Format(time.RFC3339)},
+	}
+	stats := findSimilarOnSale(flats, &flats[0], now)
+	require.Len(t, stats.SimilarFlats, 2)
+	// /info compares with all the similar flats, the ones sold long ago too
+	require.Len(t, findSimilarFlats(flats, &flats[0], nil).SimilarFlats, 3)
+
+	options := MortgageOptions{DownPaymentPercent: 20, TermYears: 30}
+	msg := renderMortgage(&flats[0], stats, options, defaultMortgagePrograms, util.DefaultLang)
+	require.Contains(t, msg, "Price 12 500 000 R, down payment 2 500 000 R (20.0%), loan 10 000 000 R for 30 years")
+	require.Contains(t, msg, "<b>Family</b> 6%: 59 955 R/month")
+	require.Contains(t, msg, "<b>Standard</b> 21%")
+	require.Contains(t, msg, "median price 12 500 000 R, this flat is +0.0%")
+	require.Contains(t, msg, "(-2 398)")
+	require.Contains(t, msg, "/mortgage_2ngt_1")
+}
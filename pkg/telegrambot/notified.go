@@ -0,0 +1,212 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NotifiedMessagesFile = "data/notified_messages.json"
+
+	// editNotificationWindow a flat changed again within the window is updated in the previous message instead of a new one
+	editNotificationWindow = 24 * time.Hour
+)
+
+// NotifiedFlat is a flat mentioned in the notification with the price it was notified at
+type NotifiedFlat struct {
+	ID    int64 `json:"id"`
+	Price int64 `json:"price"`
+}
+
+// NotifiedMessage is a sent notification which can be edited when its flats change again
+type NotifiedMessage struct {
+	ChatID    int64                 `json:"chat_id"`
+	MessageID int64                 `json:"message_id"`
+	Text      string                `json:"text"`
+	Keyboard  *InlineKeyboardMarkup `json:"keyboard,omitempty"`
+	Flats     []NotifiedFlat        `json:"flats"`
+	Sent      time.Time             `json:"sent"`
+}
+
+var (
+	notifiedFile          = newEnvFile[NotifiedMessage](NotifiedMessagesFile)
+	notifiedMessages      = notifiedFile.load()
+	notifiedMessagesMutex sync.Mutex
+)
+
+// syncNotifiedToFile must be called with notifiedMessagesMutex locked, the messages out of the window are dropped
+func syncNotifiedToFile(now time.Time) error {
+	for envtype, notifiedList := range notifiedMessages {
+		var recent []NotifiedMessage
+		for _, msg := range notifiedList {
+			if now.Sub(msg.Sent) < editNotificationWindow {
+				recent = append(recent, msg)
+			}
+		}
+		notifiedMessages[envtype] = recent
+	}
+	return notifiedFile.write(notifiedMessages)
+}
+
+func notifiedFlats(flats []flatstorage.Flat) []NotifiedFlat {
+	res := make([]NotifiedFlat, 0, len(flats))
+	for _, flat := range flats {
+		res = append(res, NotifiedFlat{ID: flat.ID, Price: flat.Price})
+	}
+	return res
+}
+
+// rememberNotifiedMessage is called by the sender once the notification is sent,
+// only the notifications fitting into a single message can be edited
+func rememberNotifiedMessage(item OutboxItem, messageIDs []int64) {
+	if len(messageIDs) != 1 || len(item.Img) > 0 {
+		return
+	}
+
+	notifiedMessagesMutex.Lock()
+	defer notifiedMessagesMutex.Unlock()
+
+	now := time.Now()
+	envtype := util.GetEnvType()
+	notifiedMessages[envtype] = append(notifiedMessages[envtype], NotifiedMessage{
+		ChatID:    item.ChatID,
+		MessageID: messageIDs[0],
+		Text:      item.Text,
+		Keyboard:  item.Keyboard,
+		Flats:     item.NotifiedFlats,
+		Sent:      now,
+	})
+	err := syncNotifiedToFile(now)
+	if err != nil {
+		log.Printf("failed to remember message %v sent to chat %v: %v", messageIDs[0], item.ChatID, err)
+	}
+}
+
+// recentNotifiedMessages returns the messages of the chat sent within the window, the latest go first
+func recentNotifiedMessages(chatID int64, now time.Time) []NotifiedMessage {
+	notifiedMessagesMutex.Lock()
+	defer notifiedMessagesMutex.Unlock()
+
+	var res []NotifiedMessage
+	notifiedList := notifiedMessages[util.GetEnvType()]
+	for i := len(notifiedList) - 1; i >= 0; i-- {
+		if notifiedList[i].ChatID == chatID && now.Sub(notifiedList[i].Sent) < editNotificationWindow {
+			res = append(res, notifiedList[i])
+		}
+	}
+	return res
+}
+
+func updateNotifiedMessage(chatID int64, messageID int64, text string, keyboard *InlineKeyboardMarkup) error {
+	notifiedMessagesMutex.Lock()
+	defer notifiedMessagesMutex.Unlock()
+
+	notifiedList := notifiedMessages[util.GetEnvType()]
+	for i := range notifiedList {
+		if notifiedList[i].ChatID == chatID && notifiedList[i].MessageID == messageID {
+			notifiedList[i].Text = text
+			notifiedList[i].Keyboard = keyboard
+			return syncNotifiedToFile(time.Now())
+		}
+	}
+	return fmt.Errorf("no notified message %v in chat %v", messageID, chatID)
+}
+
+// replaceFlatLine replaces the line with the link to the flat, returns false if the text has no such line
+func replaceFlatLine(text string, flat *flatstorage.Flat, line string) (string, bool) {
+	link := fmt.Sprintf("href=%q", flat.GetURL())
+	lines := strings.Split(text, "\n")
+	for i := range lines {
+		if strings.Contains(lines[i], link) {
+			lines[i] = line
+			return strings.Join(lines, "\n"), true
+		}
+	}
+	return text, false
+}
+
+// refreshFlatButton updates the price on the info button of the flat
+func refreshFlatButton(keyboard *InlineKeyboardMarkup, flat *flatstorage.Flat) {
+	if keyboard == nil {
+		return
+	}
+	infoData := makeCallbackData(CallbackInfo, fmt.Sprintf("%v_%v", util.EmbedSlug(string(flat.BlockSlug)), flat.ID))
+	for _, row := range keyboard.InlineKeyboard {
+		for i := range row {
+			if row[i].CallbackData == infoData {
+				row[i].Text = "ℹ️ " + flatButtonText(flat)
+			}
+		}
+	}
+}
+
+// editNotifiedFlats queues the edits of the recent notifications of the chat mentioning the dropped flats:
+// the old price is struck through and followed by the new one.
+// The edit is sent by the sender, which falls back to a new notification about the flats if the edit fails.
+// Returns the IDs of the flats queued for the edits, the rest must be notified about with a new message.
+func editNotifiedFlats(chatID int64, drops *flatstorage.PriceDropMessageData, lang util.Lang) map[int64]bool {
+	edited := make(map[int64]bool)
+	if drops == nil || len(drops.Flats) == 0 {
+		return edited
+	}
+	flats := drops.Flats
+
+	for _, msg := range recentNotifiedMessages(chatID, time.Now()) {
+		text := msg.Text
+		var keyboard *InlineKeyboardMarkup
+		if msg.Keyboard != nil {
+			keyboard = msg.Keyboard.clone()
+		}
+
+		var changed []flatstorage.Flat
+		for _, notified := range msg.Flats {
+			for i := range flats {
+				if flats[i].ID != notified.ID || edited[flats[i].ID] {
+					continue
+				}
+				newText, ok := replaceFlatLine(text, &flats[i], flats[i].StringWithOldPrice(notified.Price, lang))
+				if !ok || len(newText) > messageCharLimit {
+					continue
+				}
+				text = newText
+				refreshFlatButton(keyboard, &flats[i])
+				changed = append(changed, flats[i])
+			}
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		fallback := &flatstorage.PriceDropMessageData{
+			Flats:                     changed,
+			PriceDropPercentThreshold: drops.PriceDropPercentThreshold,
+		}
+		defaultOutbox.add(OutboxItem{
+			ChatID:        chatID,
+			Text:          text,
+			Keyboard:      keyboard,
+			EditMessageID: msg.MessageID,
+			Fallback: &OutboxItem{
+				ChatID:        chatID,
+				Text:          fallback.StringWithHeader(flatstorage.MsgPriceDropsHeader, lang),
+				Keyboard:      flatsKeyboard(changed),
+				NotifiedFlats: notifiedFlats(changed),
+			},
+		})
+		for i := range changed {
+			edited[changed[i].ID] = true
+		}
+
+		// the next edits build on the queued text
+		err := updateNotifiedMessage(chatID, msg.MessageID, text, keyboard)
+		if err != nil {
+			log.Printf("failed to save edited message %v in chat %v: %v", msg.MessageID, chatID, err)
+		}
+	}
+	return edited
+}
@@ -0,0 +1,118 @@
+package telegrambot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceFlatLine(t *testing.T) {
+	flats := []flatstorage.Flat{
+		{ID: 1, Rooms: 1, Area: 35.5, Price: 9_000_000, BulkName: "Корпус 1.1", BlockSlug: "2ngt"},
+		{ID: 12, Rooms: 2, Area: 55.5, Price: 12_500_000, BulkName: "Корпус 1.3", BlockSlug: "2ngt"},
+	}
+	lines := []string{"header"}
+	for i := range flats {
+		lines = append(lines, flats[i].StringWithOptions(util.DefaultLang))
+	}
+	text := strings.Join(lines, "\n")
+
+	dropped := flats[1]
+	dropped.Price = 11_900_000
+	newText, ok := replaceFlatLine(text, &dropped, dropped.StringWithOldPrice(12_500_000, util.DefaultLang))
+	require.True(t, ok)
+
+	newLines := strings.Split(newText, "\n")
+	require.Len(t, newLines, 3)
+	require.Equal(t, lines[:2], newLines[:2]) // flat 1 is not confused with flat 12
+	require.Contains(t, newLines[2], "<s>12 500 000</s> 11 900 000")
+
+	_, ok = replaceFlatLine(text, &flatstorage.Flat{ID: 2}, "line")
+	require.False(t, ok)
+}
+
+func TestRecentNotifiedMessages(t *testing.T) {
+	envtype := util.GetEnvType()
+	oldNotified := notifiedMessages[envtype]
+	defer func() {
+		notifiedMessages[envtype] = oldNotified
+	}()
+
+	now := time.Now()
+	notifiedMessages[envtype] = []NotifiedMessage{
+		{ChatID: 1, MessageID: 10, Sent: now.Add(-2 * editNotificationWindow)},
+		{ChatID: 1, MessageID: 11, Sent: now.Add(-time.Hour)},
+		{ChatID: 2, MessageID: 12, Sent: now.Add(-time.Hour)},
+		{ChatID: 1, MessageID: 13, Sent: now.Add(-time.Minute)},
+	}
+
+	recent := recentNotifiedMessages(1, now)
+	require.Len(t, recent, 2)
+	require.Equal(t, int64(13), recent[0].MessageID)
+	require.Equal(t, int64(11), recent[1].MessageID)
+
+	// multi-chunk notifications are not remembered
+	rememberNotifiedMessage(OutboxItem{ChatID: 3, NotifiedFlats: []NotifiedFlat{{ID: 1}}}, []int64{20, 21})
+	require.Empty(t, recentNotifiedMessages(3, now))
+}
+
+func TestRefreshFlatButton(t *testing.T) {
+	flat := flatstorage.Flat{ID: 12, Rooms: 2, Area: 55.5, Price: 12_500_000, BulkName: "Корпус 1.3", BlockSlug: "2ngt"}
+	keyboard := flatsKeyboard([]flatstorage.Flat{flat})
+	copied := keyboard.clone()
+
+	flat.Price = 11_900_000
+	refreshFlatButton(copied, &flat)
+	require.Equal(t, "ℹ️ 1.3: 2r, 55.5m2, 11 900 000R", copied.InlineKeyboard[0][0].Text)
+	require.Equal(t, "ℹ️ 1.3: 2r, 55.5m2, 12 500 000R", keyboard.InlineKeyboard[0][0].Text)
+}
+
+func TestEditNotifiedFlats(t *testing.T) {
+	useTestStorage(t, nil)
+	outboxItems := useTestOutbox(t)
+	envtype := util.GetEnvType()
+	oldNotified := notifiedMessages[envtype]
+	t.Cleanup(func() {
+		notifiedMessages[envtype] = oldNotified
+	})
+
+	flats := []flatstorage.Flat{
+		{ID: 1, Rooms: 1, Area: 35.5, Price: 9_000_000, BulkName: "Корпус 1.1", BlockSlug: "2ngt"},
+		{ID: 12, Rooms: 2, Area: 55.5, Price: 12_500_000, BulkName: "Корпус 1.3", BlockSlug: "2ngt"},
+	}
+	lines := []string{"header"}
+	for i := range flats {
+		lines = append(lines, flats[i].StringWithOptions(util.DefaultLang))
+	}
+	notifiedMessages[envtype] = []NotifiedMessage{{
+		ChatID:    1,
+		MessageID: 10,
+		Text:      strings.Join(lines, "\n"),
+		Keyboard:  flatsKeyboard(flats),
+		Flats:     notifiedFlats(flats),
+		Sent:      time.Now().Add(-time.Hour),
+	}}
+
+	dropped := flats[1]
+	dropped.Price = 11_900_000
+	other := flatstorage.Flat{ID: 2, Rooms: 1, Area: 30, Price: 8_000_000, BulkName: "Корпус 1.2", BlockSlug: "2ngt"}
+	drops := &flatstorage.PriceDropMessageData{Flats: []flatstorage.Flat{dropped, other}, PriceDropPercentThreshold: 5}
+	edited := editNotifiedFlats(1, drops, util.DefaultLang)
+	require.Equal(t, map[int64]bool{12: true}, edited, "the flat not notified about recently goes with a new message")
+
+	// the edit is queued instead of being sent right away
+	items := outboxItems()
+	require.Len(t, items, 1)
+	require.Equal(t, int64(10), items[0].EditMessageID)
+	require.Contains(t, items[0].Text, "<s>12 500 000</s> 11 900 000")
+	require.NotNil(t, items[0].Fallback)
+	require.Equal(t, []NotifiedFlat{{ID: 12, Price: 11_900_000}}, items[0].Fallback.NotifiedFlats)
+	require.Contains(t, items[0].Fallback.Text, "11 900 000")
+
+	// the next edits build on the queued text
+	require.Equal(t, items[0].Text, recentNotifiedMessages(1, time.Now())[0].Text)
+}
@@ -0,0 +1,376 @@
+package telegrambot
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OutboxDir     = "data/outbox"
+	DeadLetterDir = "data/outbox_dead"
+
+	outboxItemExt  = ".json"
+	outboxImageExt = ".img"
+)
+
+// OutboxItem is a message waiting to be sent, it is kept on disk till Telegram accepts it
+type OutboxItem struct {
+	ID         int64                 `json:"id"`
+	ChatID     int64                 `json:"chat_id"`
+	Text       string                `json:"text"`
+	Img        []byte                `json:"-"` // stored in a file of its own beside the item, see ImgFile
+	ImgFile    string                `json:"img_file,omitempty"`
+	ImgCaption string                `json:"img_caption,omitempty"`
+	MustPin    bool                  `json:"must_pin,omitempty"`
+	Keyboard   *InlineKeyboardMarkup `json:"keyboard,omitempty"`
+	Created    time.Time             `json:"created"`
+
+	// the flats of the notification, the message is edited when they change again, see notified.go
+	NotifiedFlats []NotifiedFlat `json:"notified_flats,omitempty"`
+
+	// set for the edits of the sent notifications, the fallback is sent instead if the edit fails
+	EditMessageID int64       `json:"edit_message_id,omitempty"`
+	Fallback      *OutboxItem `json:"fallback,omitempty"`
+
+	// set for the dead letters
+	Failed time.Time `json:"failed,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// outbox is a persistent queue with at-least-once delivery: an item is removed only after it is sent,
+// the items which failed permanently go to the dead letters.
+// Every item is kept in a file of its own, so adding or removing one doesn't rewrite the others:
+// <dir>/<env>/<id>.json and the image in <dir>/<env>/<id>.img
+type outbox struct {
+	mu sync.Mutex
+
+	dir     string
+	deadDir string
+
+	pending map[util.EnvType][]OutboxItem
+	dead    map[util.EnvType][]OutboxItem
+	nextID  int64
+
+	notify chan struct{} // wakes up the sender when a new item is added
+}
+
+var defaultOutbox = newOutbox(OutboxDir, DeadLetterDir)
+
+func init() {
+	err := defaultOutbox.load()
+	if err != nil {
+		log.Printf("unable to read outbox: %v", err)
+	}
+}
+
+func newOutbox(dir string, deadDir string) *outbox {
+	return &outbox{
+		dir:     dir,
+		deadDir: deadDir,
+		pending: make(map[util.EnvType][]OutboxItem),
+		dead:    make(map[util.EnvType][]OutboxItem),
+		nextID:  1,
+		notify:  make(chan struct{}, 1),
+	}
+}
+
+// load reads the items left from the previous run, they are sent again.
+// The files which failed to load are left untouched and their IDs are never reused.
+func (o *outbox) load() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var errs []error
+	for dir, items := range map[string]map[util.EnvType][]OutboxItem{o.dir: o.pending, o.deadDir: o.dead} {
+		for envTypeStr, envType := range util.EnvTypeFromString {
+			itemList, err := o.readItems(filepath.Join(dir, envTypeStr))
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if len(itemList) > 0 {
+				items[envType] = itemList
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readItems must be called with the mutex locked, returns the items sorted by ID
+func (o *outbox) readItems(dir string) ([]OutboxItem, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var items []OutboxItem
+	var errs []error
+	for _, entry := range entries {
+		idStr, ok := strings.CutSuffix(entry.Name(), outboxItemExt)
+		if !ok {
+			continue
+		}
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			continue
+		}
+		o.nextID = max(o.nextID, id+1)
+
+		item, err := readOutboxItem(dir, entry.Name())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read outbox item %v: %w", filepath.Join(dir, entry.Name()), err))
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ID < items[j].ID
+	})
+	return items, errors.Join(errs...)
+}
+
+func readOutboxItem(dir string, name string) (OutboxItem, error) {
+	item := OutboxItem{}
+	content, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return item, err
+	}
+	err = json.Unmarshal(content, &item)
+	if err != nil {
+		return item, err
+	}
+	if item.ImgFile != "" {
+		item.Img, err = os.ReadFile(filepath.Join(dir, item.ImgFile))
+		if err != nil {
+			return item, err
+		}
+	}
+	return item, nil
+}
+
+// envDir returns the directory of the items of the current env, it is created if the parent exists
+func envDir(dir string) (string, error) {
+	res := filepath.Join(dir, util.GetEnvType().String())
+	for _, d := range []string{dir, res} {
+		err := os.Mkdir(d, 0755)
+		if err != nil && !errors.Is(err, fs.ErrExist) {
+			return "", err
+		}
+	}
+	return res, nil
+}
+
+// writeItem saves the item and its image into the directory of the current env
+func writeItem(dir string, item *OutboxItem) error {
+	dir, err := envDir(dir)
+	if err != nil {
+		return err
+	}
+	item.ImgFile = ""
+	if len(item.Img) > 0 {
+		item.ImgFile = fmt.Sprintf("%v%v", item.ID, outboxImageExt)
+		err = writeFileAtomic(filepath.Join(dir, item.ImgFile), item.Img)
+		if err != nil {
+			return err
+		}
+	}
+	content, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, fmt.Sprintf("%v%v", item.ID, outboxItemExt)), content)
+}
+
+// removeItem deletes the files of the item from the directory of the current env
+func removeItem(dir string, item OutboxItem) error {
+	dir = filepath.Join(dir, util.GetEnvType().String())
+	err := os.Remove(filepath.Join(dir, fmt.Sprintf("%v%v", item.ID, outboxItemExt)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if item.ImgFile != "" {
+		err = os.Remove(filepath.Join(dir, item.ImgFile))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// add never blocks on the other items: the item is saved before it is queued,
+// if it can't be saved, it is still sent from memory
+func (o *outbox) add(item OutboxItem) {
+	o.mu.Lock()
+	item.ID = o.nextID
+	o.nextID++
+	o.mu.Unlock()
+
+	if item.Created.IsZero() {
+		item.Created = time.Now()
+	}
+	err := writeItem(o.dir, &item)
+	if err != nil {
+		log.Printf("failed to save message %v to chat %v into outbox: %v", item.ID, item.ChatID, err)
+	}
+
+	o.mu.Lock()
+	envtype := util.GetEnvType()
+	o.pending[envtype] = append(o.pending[envtype], item)
+	o.mu.Unlock()
+
+	o.wake()
+}
+
+func (o *outbox) wake() {
+	select {
+	case o.notify <- struct{}{}:
+	default:
+	}
+}
+
+// nextForChat returns the oldest pending item of the chat
+func (o *outbox) nextForChat(chatID int64) (OutboxItem, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, item := range o.pending[util.GetEnvType()] {
+		if item.ChatID == chatID {
+			return item, true
+		}
+	}
+	return OutboxItem{}, false
+}
+
+// chats returns the chats with pending items
+func (o *outbox) chats() []int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var res []int64
+	seen := make(map[int64]bool)
+	for _, item := range o.pending[util.GetEnvType()] {
+		if !seen[item.ChatID] {
+			seen[item.ChatID] = true
+			res = append(res, item.ChatID)
+		}
+	}
+	return res
+}
+
+// removePending must be called with the mutex locked
+func (o *outbox) removePending(id int64) (OutboxItem, bool) {
+	envtype := util.GetEnvType()
+	for i, item := range o.pending[envtype] {
+		if item.ID == id {
+			o.pending[envtype] = append(o.pending[envtype][:i:i], o.pending[envtype][i+1:]...)
+			return item, true
+		}
+	}
+	return OutboxItem{}, false
+}
+
+// ack removes the sent item
+func (o *outbox) ack(id int64) error {
+	o.mu.Lock()
+	item, ok := o.removePending(id)
+	o.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("no pending message %v in outbox", id)
+	}
+	return removeItem(o.dir, item)
+}
+
+// migrate redirects the pending items of the group upgraded to a supergroup to its new ID
+func (o *outbox) migrate(oldChatID int64, newChatID int64) error {
+	defer o.wake() // the items go to the worker of the new chat
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var errs []error
+	items := o.pending[util.GetEnvType()]
+	for i := range items {
+		if items[i].ChatID == oldChatID {
+			items[i].ChatID = newChatID
+			errs = append(errs, writeItem(o.dir, &items[i]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// fail moves the item to the dead letters
+func (o *outbox) fail(id int64, sendErr error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	item, ok := o.removePending(id)
+	if !ok {
+		return fmt.Errorf("no pending message %v in outbox", id)
+	}
+	item.Failed = time.Now()
+	item.Error = sendErr.Error()
+
+	envtype := util.GetEnvType()
+	o.dead[envtype] = append(o.dead[envtype], item)
+	err := writeItem(o.deadDir, &o.dead[envtype][len(o.dead[envtype])-1])
+	if err != nil {
+		return err
+	}
+	return removeItem(o.dir, item)
+}
+
+// retryDead moves the dead letter back to the queue, all of them if id is 0; returns the number of moved items
+func (o *outbox) retryDead(id int64) (int, error) {
+	o.mu.Lock()
+	envtype := util.GetEnvType()
+	var left []OutboxItem
+	var count int
+	var errs []error
+	for _, item := range o.dead[envtype] {
+		if id != 0 && item.ID != id {
+			left = append(left, item)
+			continue
+		}
+		deadItem := item
+		item.Failed, item.Error = time.Time{}, ""
+		err := writeItem(o.dir, &item)
+		if err == nil {
+			err = removeItem(o.deadDir, deadItem)
+		}
+		errs = append(errs, err)
+		o.pending[envtype] = append(o.pending[envtype], item)
+		count++
+	}
+	o.dead[envtype] = left
+	o.mu.Unlock()
+
+	if count > 0 {
+		o.wake()
+	}
+	return count, errors.Join(errs...)
+}
+
+func (o *outbox) deadLetters() []OutboxItem {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]OutboxItem(nil), o.dead[util.GetEnvType()]...)
+}
+
+func (o *outbox) depth() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.pending[util.GetEnvType()])
+}
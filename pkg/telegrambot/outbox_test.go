@@ -0,0 +1,109 @@
+package telegrambot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutbox(t *testing.T) {
+	dir := t.TempDir()
+	boxDir, deadDir := filepath.Join(dir, "outbox"), filepath.Join(dir, "outbox_dead")
+
+	box := newOutbox(boxDir, deadDir)
+	require.NoError(t, box.load())
+	box.add(OutboxItem{ChatID: 1, Text: "first", Img: []byte{1, 2, 3}, MustPin: true})
+	box.add(OutboxItem{ChatID: 2, Text: "second"})
+	box.add(OutboxItem{ChatID: 3, Text: "third"})
+	require.Equal(t, 3, box.depth())
+
+	item, ok := box.nextForChat(1)
+	require.True(t, ok)
+	require.Equal(t, "first", item.Text)
+	require.Equal(t, []byte{1, 2, 3}, item.Img)
+	require.NoError(t, box.ack(item.ID))
+
+	// the image is kept beside the item and removed with it
+	envDir := filepath.Join(boxDir, util.GetEnvType().String())
+	entries, err := os.ReadDir(envDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 2, "one file per item")
+	require.Error(t, box.ack(item.ID))
+
+	item, _ = box.nextForChat(2)
+	require.NoError(t, box.fail(item.ID, errors.New("forbidden: bot was blocked by the user")))
+
+	// the unsent and the failed messages survive the restart
+	restarted := newOutbox(boxDir, deadDir)
+	require.NoError(t, restarted.load())
+	require.Equal(t, 1, restarted.depth())
+	item, _ = restarted.nextForChat(3)
+	require.Equal(t, "third", item.Text)
+
+	dead := restarted.deadLetters()
+	require.Len(t, dead, 1)
+	require.Equal(t, "second", dead[0].Text)
+	require.Contains(t, dead[0].Error, "blocked")
+
+	restarted.add(OutboxItem{ChatID: 4, Text: "fourth"})
+	item, _ = restarted.nextForChat(3)
+	require.NoError(t, restarted.ack(item.ID))
+	item, _ = restarted.nextForChat(4)
+	require.Equal(t, "fourth", item.Text)
+	require.Greater(t, item.ID, dead[0].ID, "IDs must not repeat after the restart")
+
+	restarted.add(OutboxItem{ChatID: 5, Text: "chart", Img: []byte{4, 5}})
+	restarted = newOutbox(boxDir, deadDir)
+	require.NoError(t, restarted.load())
+	require.Equal(t, 2, restarted.depth())
+	item, _ = restarted.nextForChat(4)
+	require.NoError(t, restarted.ack(item.ID))
+	item, _ = restarted.nextForChat(5)
+	require.Equal(t, []byte{4, 5}, item.Img)
+	require.NoError(t, restarted.ack(item.ID))
+	entries, err = os.ReadDir(envDir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	count, err := restarted.retryDead(0)
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	require.Empty(t, restarted.deadLetters())
+	require.Equal(t, 1, restarted.depth())
+	item, _ = restarted.nextForChat(2)
+	require.Equal(t, "second", item.Text)
+
+	restarted = newOutbox(boxDir, deadDir)
+	require.NoError(t, restarted.load())
+	require.Equal(t, 1, restarted.depth())
+	require.Empty(t, restarted.deadLetters())
+}
+
+func TestOutboxKeepsUnreadableFile(t *testing.T) {
+	dir := t.TempDir()
+	boxDir, deadDir := filepath.Join(dir, "outbox"), filepath.Join(dir, "outbox_dead")
+	envDir := filepath.Join(boxDir, util.GetEnvType().String())
+	require.NoError(t, os.MkdirAll(envDir, 0755))
+	truncated := []byte(`{"id":1,"chat_id":1,"text":"fir`)
+	require.NoError(t, os.WriteFile(filepath.Join(envDir, "1.json"), truncated, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(envDir, "2.json"), []byte(`{"id":2,"chat_id":2,"text":"second"}`), 0644))
+
+	box := newOutbox(boxDir, deadDir)
+	require.Error(t, box.load())
+	require.Equal(t, 1, box.depth(), "the readable items are still sent")
+
+	// the unreadable file is not overwritten and its ID is not reused
+	box.add(OutboxItem{ChatID: 3, Text: "third"})
+	require.Equal(t, 2, box.depth())
+	content, err := os.ReadFile(filepath.Join(envDir, "1.json"))
+	require.NoError(t, err)
+	require.Equal(t, truncated, content)
+
+	entries, err := os.ReadDir(envDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3, "no temporary files must be left")
+}
@@ -0,0 +1,232 @@
+package telegrambot
+
+import (
+	"context"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PendingNotificationsFile = "data/pending_notifications.json"
+
+	deliverPendingEvery = 1 * time.Minute
+)
+
+// PendingNotification is a notification held until the digest or the end of the quiet hours
+type PendingNotification struct {
+	ChatID  int64     `json:"chat_id"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
+}
+
+var (
+	pendingFile          = newEnvFile[PendingNotification](PendingNotificationsFile)
+	pendingNotifications = pendingFile.load()
+	pendingMutex         sync.Mutex
+)
+
+// syncPendingToFile must be called with pendingMutex locked
+func syncPendingToFile() error {
+	return pendingFile.write(pendingNotifications)
+}
+
+func addPending(notification PendingNotification) error {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	pendingNotifications[envtype] = append(pendingNotifications[envtype], notification)
+	err := syncPendingToFile()
+	if err != nil {
+		pendingNotifications[envtype] = pendingNotifications[envtype][:len(pendingNotifications[envtype])-1]
+		return err
+	}
+	return nil
+}
+
+func hasPending(chatID int64) bool {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+
+	for _, notification := range pendingNotifications[util.GetEnvType()] {
+		if notification.ChatID == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// migratePending moves the held notifications of the group upgraded to a supergroup to its new ID
+func migratePending(oldChatID int64, newChatID int64) error {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	oldPending := pendingNotifications[envtype]
+	newPending := make([]PendingNotification, 0, len(oldPending))
+	var moved bool
+	for _, notification := range oldPending {
+		if notification.ChatID == oldChatID {
+			notification.ChatID = newChatID
+			moved = true
+		}
+		newPending = append(newPending, notification)
+	}
+	if !moved {
+		return nil
+	}
+
+	pendingNotifications[envtype] = newPending
+	err := syncPendingToFile()
+	if err != nil {
+		pendingNotifications[envtype] = oldPending
+		return err
+	}
+	return nil
+}
+
+func pendingCount() int {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+	return len(pendingNotifications[util.GetEnvType()])
+}
+
+// takeDuePending removes and returns the notifications of the chats which are due to get them,
+// isDue is called with the creation time of the oldest pending notification of the chat
+func takeDuePending(isDue func(chatID int64, since time.Time) bool) (map[int64][]PendingNotification, error) {
+	pendingMutex.Lock()
+	defer pendingMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	oldPending := pendingNotifications[envtype]
+
+	since := make(map[int64]time.Time)
+	for _, notification := range oldPending {
+		if oldest, ok := since[notification.ChatID]; !ok || notification.Created.Before(oldest) {
+			since[notification.ChatID] = notification.Created
+		}
+	}
+	due := make(map[int64]bool, len(since))
+	for chatID, oldest := range since {
+		due[chatID] = isDue(chatID, oldest)
+	}
+
+	res := make(map[int64][]PendingNotification)
+	var left []PendingNotification
+	for _, notification := range oldPending {
+		if due[notification.ChatID] {
+			res[notification.ChatID] = append(res[notification.ChatID], notification)
+		} else {
+			left = append(left, notification)
+		}
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+
+	pendingNotifications[envtype] = left
+	err := syncPendingToFile()
+	if err != nil {
+		pendingNotifications[envtype] = oldPending
+		return nil, err
+	}
+	return res, nil
+}
+
+// Notify sends the notification according to the delivery settings of the chat:
+// right away, or later with the digest or after the quiet hours
+func Notify(chatID int64, text string, keyboard *InlineKeyboardMarkup) {
+	notify(chatID, text, func() {
+		SendMessageWithKeyboardAsync(chatID, text, keyboard)
+	})
+}
+
+// NotifyAboutFlats is Notify with the info and watch buttons under every flat, the notification sent right away
+// is split into messages with the buttons of their own flats, and the flats are remembered,
+// so the message is edited when they change again, see editNotifiedFlats
+func NotifyAboutFlats(chatID int64, text string, flats []flatstorage.Flat) {
+	notify(chatID, text, func() {
+		for _, chunk := range splitFlatsNotification(text, flats) {
+			defaultOutbox.add(OutboxItem{
+				ChatID:        chatID,
+				Text:          chunk.Text,
+				Keyboard:      flatsKeyboard(chunk.Flats),
+				NotifiedFlats: notifiedFlats(chunk.Flats),
+			})
+		}
+	})
+}
+
+// notify calls send right away or holds the notification according to the delivery settings of the chat,
+// the held notifications go without the buttons
+func notify(chatID int64, text string, send func()) {
+	if IsChatDisabled(chatID) {
+		log.Printf("chat %v is disabled, dropping the notification", chatID)
+		return
+	}
+
+	now := time.Now()
+	// pending notifications of instant chats go first when the quiet hours end
+	if !GetChatSettings(chatID).Delivery.Holds(now) && !hasPending(chatID) {
+		send()
+		return
+	}
+
+	err := addPending(PendingNotification{
+		ChatID:  chatID,
+		Text:    text,
+		Created: now,
+	})
+	if err != nil {
+		log.Printf("failed to hold notification for %v, sending right away: %v", chatID, err)
+		send()
+	}
+}
+
+func DeliverPendingForever(ctx context.Context, wg *sync.WaitGroup) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		err := DeliverPendingOnce(wg)
+		if err != nil {
+			log.Printf("failed to deliver pending notifications: %v", err)
+		}
+		time.Sleep(deliverPendingEvery)
+	}
+}
+
+// DeliverPendingOnce sends every chat due to get its notifications one combined message
+func DeliverPendingOnce(wg *sync.WaitGroup) error {
+	wg.Add(1)
+	defer wg.Done()
+
+	now := time.Now()
+	due, err := takeDuePending(func(chatID int64, since time.Time) bool {
+		return GetChatSettings(chatID).Delivery.IsDue(since, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	for chatID, notifications := range due {
+		SendMessageWithPinAsync(chatID, renderDigest(notifications, GetChatLang(chatID)), false)
+	}
+	return nil
+}
+
+func renderDigest(notifications []PendingNotification, lang util.Lang) string {
+	texts := make([]string, 0, len(notifications)+1)
+	texts = append(texts, util.Msg(lang, MsgDigestHeader, len(notifications)))
+	for _, notification := range notifications {
+		texts = append(texts, notification.Text)
+	}
+	return strings.Join(texts, "\n\n")
+}
@@ -0,0 +1,352 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	SavedSearchesFile = "data/saved_searches.json"
+
+	savedSearchBlocksKey = "blocks"
+	savedSearchAllBlocks = "all"
+
+	maxSavedSearches    = 10
+	maxSavedSearchName  = 64
+	savedSearchNameStop = ":"
+)
+
+// savedSearchSyntax is a short human-readable description of the /search_save arguments
+const savedSearchSyntax = "<name>: blocks=2ngt,amur|all " + flatstorage.FilterSyntax
+
+// SavedSearch is a named filter over several complexes or all of them,
+// the chat gets the updates of all the matching complexes in one message
+type SavedSearch struct {
+	ChatID int64  `json:"chat_id"`
+	ID     int    `json:"id"` // unique within the chat
+	Name   string `json:"name"`
+
+	BlockSlugs []string                `json:"block_slugs,omitempty"` // empty means all complexes
+	Filter     *flatstorage.FlatFilter `json:"filter,omitempty"`
+}
+
+var (
+	savedSearchesFile  = newEnvFile[SavedSearch](SavedSearchesFile)
+	SavedSearches      = savedSearchesFile.load()
+	savedSearchesMutex sync.RWMutex
+)
+
+// syncSavedSearchStorageToFile must be called with savedSearchesMutex locked
+func syncSavedSearchStorageToFile() error {
+	return savedSearchesFile.write(SavedSearches)
+}
+
+// SaveSearch adds the search or replaces the one of the chat with the same name, returns the stored search
+func SaveSearch(search SavedSearch) (SavedSearch, error) {
+	savedSearchesMutex.Lock()
+	defer savedSearchesMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	oldList := SavedSearches[envtype]
+	newList := make([]SavedSearch, 0, len(oldList)+1)
+	var count, maxID int
+	replaced := false
+	for _, saved := range oldList {
+		if saved.ChatID == search.ChatID {
+			count++
+			maxID = max(maxID, saved.ID)
+			if strings.EqualFold(saved.Name, search.Name) {
+				search.ID = saved.ID
+				saved = search
+				replaced = true
+			}
+		}
+		newList = append(newList, saved)
+	}
+	if !replaced {
+		if count >= maxSavedSearches {
+			return search, fmt.Errorf("too many saved searches, the limit is %v", maxSavedSearches)
+		}
+		search.ID = maxID + 1
+		newList = append(newList, search)
+	}
+
+	SavedSearches[envtype] = newList
+	err := syncSavedSearchStorageToFile()
+	if err != nil {
+		SavedSearches[envtype] = oldList
+		return search, err
+	}
+	return search, nil
+}
+
+func RemoveSavedSearch(chatID int64, id int) error {
+	savedSearchesMutex.Lock()
+	defer savedSearchesMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	for i, search := range SavedSearches[envtype] {
+		if search.ChatID == chatID && search.ID == id {
+			SavedSearches[envtype] = util.RemoveSliceElement(SavedSearches[envtype], i)
+			return syncSavedSearchStorageToFile()
+		}
+	}
+	return fmt.Errorf("chat %v has no saved search %v", chatID, id)
+}
+
+// MigrateSavedSearches moves the saved searches of the group upgraded to a supergroup to its new ID
+func MigrateSavedSearches(oldChatID int64, newChatID int64) error {
+	savedSearchesMutex.Lock()
+	defer savedSearchesMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	oldList := SavedSearches[envtype]
+	newList := make([]SavedSearch, 0, len(oldList))
+	var maxID int
+	for _, search := range oldList {
+		if search.ChatID == newChatID {
+			maxID = max(maxID, search.ID)
+		}
+	}
+	var moved bool
+	for _, search := range oldList {
+		if search.ChatID == oldChatID {
+			moved = true
+			maxID++
+			search.ChatID, search.ID = newChatID, maxID
+		}
+		newList = append(newList, search)
+	}
+	if !moved {
+		return nil
+	}
+
+	SavedSearches[envtype] = newList
+	err := syncSavedSearchStorageToFile()
+	if err != nil {
+		SavedSearches[envtype] = oldList
+		return err
+	}
+	return nil
+}
+
+func GetChatSavedSearches(chatID int64) []SavedSearch {
+	savedSearchesMutex.RLock()
+	defer savedSearchesMutex.RUnlock()
+
+	var res []SavedSearch
+	for _, search := range SavedSearches[util.GetEnvType()] {
+		if search.ChatID == chatID {
+			res = append(res, search)
+		}
+	}
+	return res
+}
+
+func getAllSavedSearches() []SavedSearch {
+	savedSearchesMutex.RLock()
+	defer savedSearchesMutex.RUnlock()
+	return append([]SavedSearch(nil), SavedSearches[util.GetEnvType()]...)
+}
+
+// MatchesBlock checks if the search covers the complex
+func (s *SavedSearch) MatchesBlock(slug string) bool {
+	if len(s.BlockSlugs) == 0 {
+		return true
+	}
+	for _, blockSlug := range s.BlockSlugs {
+		if blockSlug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+// BlocksString lists the names of the complexes of the search
+func (s *SavedSearch) BlocksString(lang util.Lang) string {
+	if len(s.BlockSlugs) == 0 {
+		return util.Msg(lang, MsgSavedSearchAllBlocks)
+	}
+	names := make([]string, 0, len(s.BlockSlugs))
+	for _, slug := range s.BlockSlugs {
+		name := slug
+		if block, ok := BlockSlugs[slug]; ok {
+			name = block.Name
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// parseSavedSearch parses args like "2r near south: blocks=2ngt,amur rooms=2 price<16m"
+func parseSavedSearch(chatID int64, args string) (SavedSearch, error) {
+	name, criteria, ok := strings.Cut(args, savedSearchNameStop)
+	name = strings.Join(strings.Fields(name), " ")
+	if !ok || name == "" {
+		return SavedSearch{}, fmt.Errorf("the search must start with a name followed by %q", savedSearchNameStop)
+	}
+	if len([]rune(name)) > maxSavedSearchName {
+		return SavedSearch{}, fmt.Errorf("the name is longer than %v characters", maxSavedSearchName)
+	}
+
+	search := SavedSearch{ChatID: chatID, Name: name}
+	var filterArgs []string
+	for _, arg := range strings.Fields(criteria) {
+		value, ok := strings.CutPrefix(arg, savedSearchBlocksKey+"=")
+		if !ok {
+			filterArgs = append(filterArgs, arg)
+			continue
+		}
+		search.BlockSlugs = nil
+		if strings.ToLower(value) == savedSearchAllBlocks {
+			continue
+		}
+		for _, slug := range strings.Split(value, ",") {
+			slug = util.EmbedSlug(strings.TrimSpace(slug))
+			if slug == "" {
+				continue
+			}
+			if _, ok := BlockSlugs[slug]; !ok {
+				return SavedSearch{}, fmt.Errorf("unknown complex %q, see /%v", slug, ListCommand)
+			}
+			search.BlockSlugs = append(search.BlockSlugs, slug)
+		}
+		search.BlockSlugs = util.FilterUnique(search.BlockSlugs, func(i int) string {
+			return search.BlockSlugs[i]
+		})
+	}
+
+	filter, err := flatstorage.ParseFlatFilter(filterArgs)
+	if err != nil {
+		return SavedSearch{}, err
+	}
+	if !filter.IsEmpty() {
+		search.Filter = filter
+	}
+	return search, nil
+}
+
+// renderSavedSearch describes the search in a single line
+func renderSavedSearch(search *SavedSearch, lang util.Lang) string {
+	line := util.Msg(lang, MsgSavedSearchLine, search.ID, escapeHTML(search.Name), search.BlocksString(lang))
+	if !search.Filter.IsEmpty() {
+		line += util.Msg(lang, MsgFilterInfo, escapeHTML(search.Filter.String()))
+	}
+	return line + fmt.Sprintf(" /%v_%v", SearchDelCommand, search.ID)
+}
+
+// sendSavedSearches handles /searches
+func sendSavedSearches(chatID int64) {
+	lang := GetChatLang(chatID)
+	searches := GetChatSavedSearches(chatID)
+	if len(searches) == 0 {
+		err := SendMessage(chatID, util.Msg(lang, MsgNoSavedSearches, SearchSaveCommand, escapeHTML(savedSearchSyntax)))
+		if err != nil {
+			log.Printf("failed to send no saved searches message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	lines := []string{util.Msg(lang, MsgSavedSearchesHeader, len(searches))}
+	for i := range searches {
+		lines = append(lines, renderSavedSearch(&searches[i], lang))
+	}
+	lines = append(lines, util.Msg(lang, MsgSavedSearchesFooter, SearchSaveCommand, escapeHTML(savedSearchSyntax)))
+	err := SendMessage(chatID, strings.Join(lines, "\n"))
+	if err != nil {
+		log.Printf("failed to send saved searches to %v: %v", chatID, err)
+	}
+}
+
+// saveSearch handles /search_save, the search with the same name is replaced
+func saveSearch(chatID int64, args string) {
+	search, err := parseSavedSearch(chatID, args)
+	if err != nil {
+		err = SendMessage(chatID, localize(chatID, MsgInvalidSavedSearch, escapeHTML(err.Error()), SearchSaveCommand, escapeHTML(savedSearchSyntax)))
+		if err != nil {
+			log.Printf("failed to send invalid saved search message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	search, err = SaveSearch(search)
+	if err != nil {
+		log.Printf("failed to save search %q of %v: %v", search.Name, chatID, err)
+		err = SendMessage(chatID, localize(chatID, MsgSavedSearchFailed, err))
+		if err != nil {
+			log.Printf("failed to send save search failed message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	log.Printf("chat %v saved search %v %q", chatID, search.ID, search.Name)
+	err = SendMessage(chatID, localize(chatID, MsgSavedSearchSaved, renderSavedSearch(&search, GetChatLang(chatID)), SearchesCommand))
+	if err != nil {
+		log.Printf("failed to send saved search message to %v: %v", chatID, err)
+	}
+}
+
+// deleteSavedSearch handles /search_del <id>
+func deleteSavedSearch(chatID int64, args string) {
+	id, err := strconv.Atoi(strings.TrimSpace(args))
+	if err == nil {
+		err = RemoveSavedSearch(chatID, id)
+	}
+	if err != nil {
+		log.Printf("failed to delete saved search %q of %v: %v", args, chatID, err)
+		err = SendMessage(chatID, localize(chatID, MsgSavedSearchNotFound, escapeHTML(args), SearchesCommand))
+		if err != nil {
+			log.Printf("failed to send saved search not found message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	err = SendMessage(chatID, localize(chatID, MsgSavedSearchDeleted, id, SearchesCommand))
+	if err != nil {
+		log.Printf("failed to send saved search deleted message to %v: %v", chatID, err)
+	}
+}
+
+// notifySavedSearches sends every saved search the updates of all its complexes in one message
+func notifySavedSearches(updates map[string]*flatstorage.FlatUpdates) {
+	if len(updates) == 0 {
+		return
+	}
+	slugs := util.SortedKeys(updates)
+	for _, search := range getAllSavedSearches() {
+		if IsChatDisabled(search.ChatID) {
+			continue
+		}
+		text, flats := renderSavedSearchUpdates(&search, slugs, updates, GetChatLang(search.ChatID))
+		if text == "" {
+			continue
+		}
+		NotifyAboutFlats(search.ChatID, text, flats)
+	}
+}
+
+// renderSavedSearchUpdates combines the messages of the matching complexes, the text is empty if nothing matches
+func renderSavedSearchUpdates(search *SavedSearch, slugs []string, updates map[string]*flatstorage.FlatUpdates,
+	lang util.Lang) (string, []flatstorage.Flat) {
+	var parts []string
+	var flats []flatstorage.Flat
+	for _, slug := range slugs {
+		if !search.MatchesBlock(slug) {
+			continue
+		}
+		for _, msg := range updates[slug].Filter(search.Filter).Messages(lang) {
+			parts = append(parts, msg.Text)
+			flats = append(flats, msg.Flats...)
+		}
+	}
+	if len(parts) == 0 {
+		return "", nil
+	}
+	header := util.Msg(lang, MsgSavedSearchUpdates, escapeHTML(search.Name))
+	return header + "\n\n" + strings.Join(parts, "\n\n"), flats
+}
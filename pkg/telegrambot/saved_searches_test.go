@@ -0,0 +1,109 @@
+package telegrambot
+
+import (
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSavedSearch(t *testing.T) {
+	search, err := parseSavedSearch(1, " 2r under 16m  near south: blocks=2ngt,amur,2ngt rooms=2 price<16m")
+	require.NoError(t, err)
+	require.Equal(t, "2r under 16m near south", search.Name)
+	require.Equal(t, []string{"2ngt", "amur"}, search.BlockSlugs)
+	require.Equal(t, []int8{2}, search.Filter.Rooms)
+	require.True(t, search.MatchesBlock("amur"))
+	require.False(t, search.MatchesBlock("utnv"))
+
+	search, err = parseSavedSearch(1, "anything: blocks=all")
+	require.NoError(t, err)
+	require.Empty(t, search.BlockSlugs)
+	require.Nil(t, search.Filter)
+	require.True(t, search.MatchesBlock("utnv"))
+
+	for _, args := range []string{"", "no name rooms=2", ": rooms=2", "south: blocks=unknown", "south: rooms=many"} {
+		_, err = parseSavedSearch(1, args)
+		require.Error(t, err, args)
+	}
+}
+
+func TestSaveSearch(t *testing.T) {
+	useTestStorage(t, nil)
+
+	envtype := util.GetEnvType()
+	oldSearches := SavedSearches[envtype]
+	t.Cleanup(func() {
+		SavedSearches[envtype] = oldSearches
+	})
+	SavedSearches[envtype] = nil
+
+	first, err := SaveSearch(SavedSearch{ChatID: 1, Name: "South"})
+	require.NoError(t, err)
+	require.Equal(t, 1, first.ID)
+	second, err := SaveSearch(SavedSearch{ChatID: 1, Name: "North"})
+	require.NoError(t, err)
+	require.Equal(t, 2, second.ID)
+	other, err := SaveSearch(SavedSearch{ChatID: 2, Name: "South"})
+	require.NoError(t, err)
+	require.Equal(t, 1, other.ID)
+
+	// the search with the same name is replaced
+	edited, err := SaveSearch(SavedSearch{ChatID: 1, Name: "south", BlockSlugs: []string{"2ngt"}})
+	require.NoError(t, err)
+	require.Equal(t, 1, edited.ID)
+	require.Len(t, GetChatSavedSearches(1), 2)
+	require.Equal(t, []string{"2ngt"}, GetChatSavedSearches(1)[0].BlockSlugs)
+
+	searches, err := savedSearchesFile.read()
+	require.NoError(t, err)
+	require.Equal(t, SavedSearches[envtype], searches[envtype])
+
+	require.NoError(t, RemoveSavedSearch(1, 1))
+	require.Error(t, RemoveSavedSearch(1, 1))
+	require.Equal(t, []SavedSearch{second}, GetChatSavedSearches(1))
+
+	// a new ID doesn't clash with the remaining ones
+	third, err := SaveSearch(SavedSearch{ChatID: 1, Name: "East"})
+	require.NoError(t, err)
+	require.Equal(t, 3, third.ID)
+
+	for i := len(GetChatSavedSearches(1)); i < maxSavedSearches; i++ {
+		_, err = SaveSearch(SavedSearch{ChatID: 1, Name: string(rune('a' + i))})
+		require.NoError(t, err)
+	}
+	_, err = SaveSearch(SavedSearch{ChatID: 1, Name: "one too many"})
+	require.Error(t, err)
+}
+
+func TestRenderSavedSearchUpdates(t *testing.T) {
+	updates := map[string]*flatstorage.FlatUpdates{
+		"2ngt": {NewFlats: &flatstorage.MessageData{Flats: []flatstorage.Flat{
+			{ID: 1, Rooms: 2, Area: 55, Price: 15_000_000, BlockName: "Второй Нагатинский", BlockSlug: "2ngt"},
+			{ID: 2, Rooms: 3, Area: 75, Price: 19_000_000, BlockName: "Второй Нагатинский", BlockSlug: "2ngt"},
+		}}},
+		"amur": {NewFlats: &flatstorage.MessageData{Flats: []flatstorage.Flat{
+			{ID: 3, Rooms: 2, Area: 50, Price: 14_000_000, BlockName: "Амурский парк", BlockSlug: "amur"},
+		}}},
+		"utnv": {NewFlats: &flatstorage.MessageData{Flats: []flatstorage.Flat{
+			{ID: 4, Rooms: 2, Area: 50, Price: 12_000_000, BlockName: "Ютново", BlockSlug: "utnv"},
+		}}},
+	}
+	slugs := util.SortedKeys(updates)
+
+	search, err := parseSavedSearch(1, "south: blocks=2ngt,amur rooms=2")
+	require.NoError(t, err)
+	text, flats := renderSavedSearchUpdates(&search, slugs, updates, util.DefaultLang)
+	require.Contains(t, text, "<b>south</b>")
+	require.Contains(t, text, "Второй Нагатинский")
+	require.Contains(t, text, "Амурский парк")
+	require.NotContains(t, text, "Ютново")
+	require.Len(t, flats, 2)
+
+	search, err = parseSavedSearch(1, "big: rooms=4")
+	require.NoError(t, err)
+	text, flats = renderSavedSearchUpdates(&search, slugs, updates, util.DefaultLang)
+	require.Empty(t, text)
+	require.Empty(t, flats)
+}
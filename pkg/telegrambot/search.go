@@ -0,0 +1,125 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	searchSortKey   = "sort"
+	searchSortPrice = "price"
+	searchSortAvg   = "avg"
+
+	searchPageSize   = 20
+	maxSearchResults = 100
+)
+
+const searchSyntax = flatstorage.FilterSyntax + " sort=price|avg"
+
+// parseSearchArgs parses the filter and the sort order, e.g. "rooms=2 price<15m sort=avg"
+func parseSearchArgs(args string) (filter *flatstorage.FlatFilter, sortByAvg bool, err error) {
+	var filterArgs []string
+	for _, arg := range strings.Fields(args) {
+		value, ok := strings.CutPrefix(strings.ToLower(arg), searchSortKey+"=")
+		if !ok {
+			filterArgs = append(filterArgs, arg)
+			continue
+		}
+		switch value {
+		case searchSortPrice:
+			sortByAvg = false
+		case searchSortAvg:
+			sortByAvg = true
+		default:
+			return nil, false, fmt.Errorf("unknown sort order %q, expected %v or %v", value, searchSortPrice, searchSortAvg)
+		}
+	}
+
+	filter, err = flatstorage.ParseFlatFilter(filterArgs)
+	if err != nil {
+		return nil, false, err
+	}
+	return filter, sortByAvg, nil
+}
+
+// matchSearch selects recently updated flats matching the filter
+func matchSearch(flats []flatstorage.Flat, filter *flatstorage.FlatFilter, now time.Time) []flatstorage.Flat {
+	var res []flatstorage.Flat
+	for i := range flats {
+		if flats[i].RecentlyUpdated(now) && filter.Match(&flats[i]) {
+			res = append(res, flats[i])
+		}
+	}
+	return res
+}
+
+// searchFlats looks through the stored flats of all known complexes
+func searchFlats(filter *flatstorage.FlatFilter, sortByAvg bool) *flatstorage.MessageData {
+	res := &flatstorage.MessageData{}
+	now := time.Now()
+	for _, slug := range util.SortedKeys(BlockSlugs) {
+		flats, err := flatstorage.Store.QueryFlats(slug, filter)
+		if err != nil {
+			log.Printf("failed to search flats in %v: %v", slug, err)
+			continue
+		}
+		res.Flats = append(res.Flats, matchSearch(flats, nil, now)...)
+	}
+	res.SortFlats(sortByAvg)
+	return res
+}
+
+// renderSearchPages splits the found flats into messages of searchPageSize flats
+func renderSearchPages(found *flatstorage.MessageData, filter *flatstorage.FlatFilter, lang util.Lang) []string {
+	flats := found.Flats
+	header := util.Msg(lang, MsgSearchFound, len(flats))
+	if !filter.IsEmpty() {
+		header += util.Msg(lang, MsgSearchMatching, escapeHTML(filter.String()))
+	}
+	if len(flats) > maxSearchResults {
+		header += util.Msg(lang, MsgSearchShowingFirst, maxSearchResults)
+		flats = flats[:maxSearchResults]
+	}
+
+	numPages := (len(flats) + searchPageSize - 1) / searchPageSize
+	pages := make([]string, 0, numPages)
+	for page := 0; page < numPages; page++ {
+		from := page * searchPageSize
+		to := min(from+searchPageSize, len(flats))
+
+		lines := make([]string, 0, to-from)
+		for i := from; i < to; i++ {
+			lines = append(lines, fmt.Sprintf("%v, %v", flats[i].BlockName, flats[i].StringWithOptions(lang)))
+		}
+		pages = append(pages, util.Msg(lang, MsgPage, header, page+1, numPages)+"\n"+strings.Join(lines, "\n"))
+	}
+	return pages
+}
+
+func sendSearch(chatID int64, args string) {
+	filter, sortByAvg, err := parseSearchArgs(args)
+	if err != nil {
+		err = SendMessage(chatID, localize(chatID, MsgInvalidSearch, escapeHTML(err.Error()), SearchCommand, escapeHTML(searchSyntax)))
+		if err != nil {
+			log.Printf("failed to send invalid search message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	found := searchFlats(filter, sortByAvg)
+	if len(found.Flats) == 0 {
+		err = SendMessage(chatID, localize(chatID, MsgSearchNothingFound))
+		if err != nil {
+			log.Printf("failed to send empty search result to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	for _, page := range renderSearchPages(found, filter, GetChatLang(chatID)) {
+		SendMessageWithPinAsync(chatID, page, false)
+	}
+}
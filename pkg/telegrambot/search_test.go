@@ -0,0 +1,63 @@
+package telegrambot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSearchArgs(t *testing.T) {
+	filter, sortByAvg, err := parseSearchArgs(" rooms=2 price<15m  sort=avg area>50")
+	require.NoError(t, err)
+	require.True(t, sortByAvg)
+	require.Equal(t, []int8{2}, filter.Rooms)
+	require.Equal(t, int64(14_999_999), filter.MaxPrice)
+
+	filter, sortByAvg, err = parseSearchArgs("sort=price")
+	require.NoError(t, err)
+	require.False(t, sortByAvg)
+	require.True(t, filter.IsEmpty())
+
+	_, _, err = parseSearchArgs("sort=area")
+	require.Error(t, err)
+
+	_, _, err = parseSearchArgs("rooms<2")
+	require.Error(t, err)
+}
+
+func TestMatchSearch(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	recent := now.Add(-10 * time.Minute).Format(time.RFC3339)
+	old := now.Add(-24 * time.Hour).Format(time.RFC3339)
+
+	flats := []flatstorage.Flat{
+		{ID: 1, Rooms: 2, Price: 12_000_000, Updated: recent},
+		{ID: 2, Rooms: 2, Price: 12_000_000, Updated: old},
+		{ID: 3, Rooms: 3, Price: 12_000_000, Updated: recent},
+		{ID: 4, Rooms: 2, Price: 16_000_000, Updated: recent},
+	}
+
+	filter, _, err := parseSearchArgs("rooms=2 price<15m")
+	require.NoError(t, err)
+
+	found := matchSearch(flats, filter, now)
+	require.Len(t, found, 1)
+	require.Equal(t, int64(1), found[0].ID)
+
+	require.Len(t, matchSearch(flats, nil, now), 3)
+}
+
+func TestRenderSearchPages(t *testing.T) {
+	found := &flatstorage.MessageData{}
+	for i := 0; i < maxSearchResults+5; i++ {
+		found.Flats = append(found.Flats, flatstorage.Flat{ID: int64(i), Rooms: 1, Price: 10_000_000})
+	}
+
+	pages := renderSearchPages(found, nil, util.DefaultLang)
+	require.Len(t, pages, maxSearchResults/searchPageSize)
+	require.Contains(t, pages[0], "page 1/5")
+	require.Contains(t, pages[0], "showing the first 100")
+}
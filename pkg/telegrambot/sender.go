@@ -0,0 +1,358 @@
+package telegrambot
+
+import (
+	"context"
+	"errors"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Telegram limits, see https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+const (
+	globalSendInterval = time.Second / 30 // about 30 messages per second overall
+	chatSendInterval   = time.Second      // about 1 message per second to the same chat
+	groupSendInterval  = time.Minute / 20 // no more than 20 messages per minute to the same group
+
+	maxSendAttempts   = 5
+	minSendBackoff    = 1 * time.Second
+	maxSendBackoff    = 1 * time.Minute
+	defaultRetryAfter = 1 * time.Second
+
+	// forget the chats which have not been sent anything for a while
+	maxTrackedChats = 1000
+)
+
+// rateLimiter spaces the requests to Telegram globally and per chat
+type rateLimiter struct {
+	mu     sync.Mutex
+	global time.Time           // the next moment anything may be sent
+	chats  map[int64]time.Time // the next moment the chat may be sent anything
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{
+		chats: make(map[int64]time.Time),
+	}
+}
+
+// chatInterval is stricter for groups and channels, their IDs are negative
+func chatInterval(chatID int64) time.Duration {
+	if chatID < 0 {
+		return groupSendInterval
+	}
+	return chatSendInterval
+}
+
+// reserve books the next global slot for the chat and returns how long to wait for it.
+// Nothing is booked while the chat waits for its own slot, so it doesn't hold up the others,
+// false is returned then with the time left till the slot of the chat.
+func (l *rateLimiter) reserve(chatID int64, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.chats) > maxTrackedChats {
+		for id, next := range l.chats {
+			if next.Before(now) {
+				delete(l.chats, id)
+			}
+		}
+	}
+
+	if next := l.chats[chatID]; next.After(now) {
+		return next.Sub(now), false
+	}
+	at := now
+	if l.global.After(at) {
+		at = l.global
+	}
+	l.global = at.Add(globalSendInterval)
+	l.chats[chatID] = at.Add(chatInterval(chatID))
+	return at.Sub(now), true
+}
+
+// delay postpones the next slot of the chat, e.g. after 429 Too Many Requests
+func (l *rateLimiter) delay(chatID int64, until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.chats[chatID].Before(until) {
+		l.chats[chatID] = until
+	}
+}
+
+// SenderStats are the counters of the message queue
+type SenderStats struct {
+	QueueDepth int
+	Sent       int64
+	Retried    int64
+	Dropped    int64
+}
+
+// sender sends the queued messages respecting the rate limits and retrying the temporary failures.
+// Every chat has a worker of its own, so only the global limit is shared and a throttled chat delays nobody else.
+type sender struct {
+	limiter *rateLimiter
+	sleep   func(ctx context.Context, d time.Duration) error
+
+	// deliver sends the item and returns the IDs of the sent messages, deliverOutboxItem if not set
+	deliver func(ctx context.Context, item OutboxItem) ([]int64, error)
+
+	mu      sync.Mutex
+	workers map[int64]bool // the chats with a running worker
+
+	queue func() int // the number of messages waiting in the queue
+
+	sent    atomic.Int64
+	retried atomic.Int64
+	dropped atomic.Int64
+}
+
+var defaultSender = &sender{
+	limiter: newRateLimiter(),
+	sleep:   sleepContext,
+	queue: func() int {
+		return defaultOutbox.depth()
+	},
+}
+
+func GetSenderStats() SenderStats {
+	return defaultSender.stats()
+}
+
+func (s *sender) stats() SenderStats {
+	res := SenderStats{
+		Sent:    s.sent.Load(),
+		Retried: s.retried.Load(),
+		Dropped: s.dropped.Load(),
+	}
+	if s.queue != nil {
+		res.QueueDepth = s.queue()
+	}
+	return res
+}
+
+// SendMessagesForever sends the messages from the outbox, the messages of every chat are sent one by one,
+// the unsent ones are kept for the next start
+func SendMessagesForever(ctx context.Context, wg *sync.WaitGroup) {
+	wg.Add(1)
+	defer wg.Done()
+
+	defaultSender.run(ctx, defaultOutbox)
+}
+
+func (s *sender) run(ctx context.Context, box *outbox) {
+	workers := &sync.WaitGroup{}
+	defer workers.Wait()
+
+	for {
+		s.startWorkers(ctx, box, workers)
+		select {
+		case <-ctx.Done():
+			return
+		case <-box.notify:
+		}
+	}
+}
+
+// startWorkers starts a worker for every chat with pending items which has none yet
+func (s *sender) startWorkers(ctx context.Context, box *outbox, workers *sync.WaitGroup) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.workers == nil {
+		s.workers = make(map[int64]bool)
+	}
+	for _, chatID := range box.chats() {
+		if s.workers[chatID] {
+			continue
+		}
+		s.workers[chatID] = true
+		workers.Add(1)
+		go func(chatID int64) {
+			defer workers.Done()
+			s.runChat(ctx, box, chatID)
+		}(chatID)
+	}
+}
+
+// runChat sends the items of the chat one by one till there are none left
+func (s *sender) runChat(ctx context.Context, box *outbox, chatID int64) {
+	for {
+		// the worker is stopped under the same lock it is started with, so no new item of the chat is missed
+		s.mu.Lock()
+		item, ok := box.nextForChat(chatID)
+		if !ok || ctx.Err() != nil {
+			delete(s.workers, chatID)
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		s.send(ctx, box, item)
+	}
+}
+
+func (s *sender) send(ctx context.Context, box *outbox, item OutboxItem) {
+	deliver := s.deliver
+	if deliver == nil {
+		deliver = deliverOutboxItem
+	}
+	messageIDs, err := deliver(ctx, item)
+	if err != nil && ctx.Err() != nil {
+		log.Printf("stopped sending message %v to chatID %v, keeping it for the next start: %v", item.ID, item.ChatID, err)
+		return
+	}
+	if err != nil && item.Fallback != nil {
+		log.Printf("failed to edit message %v in chat %v, sending a new one: %v", item.EditMessageID, item.ChatID, err)
+		fallback := *item.Fallback
+		fallback.ChatID = item.ChatID // the chat may have migrated meanwhile
+		notify(fallback.ChatID, fallback.Text, func() {
+			box.add(fallback)
+		})
+		err = box.ack(item.ID)
+		if err != nil {
+			log.Printf("failed to remove failed edit %v from outbox: %v", item.ID, err)
+		}
+		return
+	}
+	if err != nil {
+		if newChatID := handleChatError(item.ChatID, err); newChatID != 0 {
+			log.Printf("chat %v migrated to %v, resending message %v", item.ChatID, newChatID, item.ID)
+			migrateErr := box.migrate(item.ChatID, newChatID)
+			if migrateErr == nil {
+				return
+			}
+			log.Printf("failed to move messages of chat %v to %v in outbox: %v", item.ChatID, newChatID, migrateErr)
+		}
+		s.dropped.Add(1)
+		log.Printf("failed to send message %v to chatID %v, moving it to dead letters: %v", item.ID, item.ChatID, err)
+		err = box.fail(item.ID, err)
+		if err != nil {
+			log.Printf("failed to move message %v to dead letters: %v", item.ID, err)
+		}
+		return
+	}
+	s.sent.Add(1)
+	resetChatFailures(item.ChatID)
+	if len(item.NotifiedFlats) > 0 {
+		rememberNotifiedMessage(item, messageIDs)
+	}
+	err = box.ack(item.ID)
+	if err != nil {
+		log.Printf("failed to remove sent message %v from outbox: %v", item.ID, err)
+	}
+}
+
+// deliverOutboxItem edits the sent message or sends a new one
+func deliverOutboxItem(ctx context.Context, item OutboxItem) ([]int64, error) {
+	if item.EditMessageID == 0 {
+		return SendMessageWithPin(ctx, item.ChatID, item.Text, item.Img, item.ImgCaption, item.MustPin, item.Keyboard)
+	}
+	err := defaultSender.callWithRetry(ctx, item.ChatID, func() error {
+		return EditMessageText(util.GetBotToken(), item.ChatID, item.EditMessageID, item.Text, item.Keyboard)
+	})
+	if err != nil && !isMessageNotModifiedError(err) {
+		return nil, err
+	}
+	return []int64{item.EditMessageID}, nil
+}
+
+// callWithRetry waits for a free slot of the chat and makes the request,
+// retries after 429 and 5xx errors and gives up on the other errors or once ctx is done
+func (s *sender) callWithRetry(ctx context.Context, chatID int64, call func() error) error {
+	var err error
+	for attempt := 0; attempt < maxSendAttempts; attempt++ {
+		if attempt > 0 {
+			s.retried.Add(1)
+		}
+		sleepErr := s.wait(ctx, chatID)
+		if sleepErr != nil {
+			return errors.Join(sleepErr, err)
+		}
+
+		err = call()
+		if err == nil {
+			return nil
+		}
+
+		retryAfter, retry := classifySendError(err)
+		if !retry {
+			return err
+		}
+		if retryAfter > 0 {
+			log.Printf("rate limited while sending to %v, retrying in %v: %v", chatID, retryAfter, err)
+			s.limiter.delay(chatID, time.Now().Add(retryAfter))
+			continue
+		}
+		backoff := sendBackoff(attempt)
+		log.Printf("failed to send to %v, retrying in %v: %v", chatID, backoff, err)
+		sleepErr = s.sleep(ctx, backoff)
+		if sleepErr != nil {
+			return errors.Join(sleepErr, err)
+		}
+	}
+	return err
+}
+
+// wait sleeps till the slot of the chat is free and then till the global one
+func (s *sender) wait(ctx context.Context, chatID int64) error {
+	for {
+		d, booked := s.limiter.reserve(chatID, time.Now())
+		err := s.sleep(ctx, d)
+		if err != nil || booked {
+			return err
+		}
+	}
+}
+
+// sleepContext waits for d or until ctx is done, returns the error of ctx in the latter case
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// classifySendError tells if the request may succeed later and how long Telegram asked to wait
+func classifySendError(err error) (retryAfter time.Duration, retry bool) {
+	var apiErr *TelegramAPIError
+	if !errors.As(err, &apiErr) {
+		return 0, true // network errors
+	}
+
+	code := apiErr.TelegramErrorCode
+	if code == 0 {
+		code = apiErr.StatusCode
+	}
+	switch {
+	case code == 429:
+		if apiErr.RetryAfter > 0 {
+			return apiErr.RetryAfter, true
+		}
+		return defaultRetryAfter, true
+	case code >= 500:
+		return 0, true
+	default:
+		return 0, false // 400 bad request, 403 blocked by the user, etc
+	}
+}
+
+// sendBackoff doubles the delay with every attempt, the jitter spreads the retries of different messages
+func sendBackoff(attempt int) time.Duration {
+	backoff := minSendBackoff << attempt
+	if backoff <= 0 || backoff > maxSendBackoff {
+		backoff = maxSendBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
@@ -0,0 +1,221 @@
+package telegrambot
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimiter_Reserve(t *testing.T) {
+	limiter := newRateLimiter()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	requireReserve := func(chatID int64, now time.Time, wait time.Duration, booked bool) {
+		t.Helper()
+		gotWait, gotBooked := limiter.reserve(chatID, now)
+		require.Equal(t, wait, gotWait)
+		require.Equal(t, booked, gotBooked)
+	}
+
+	requireReserve(1, now, 0, true)
+	// the other chat waits only for the global slot
+	requireReserve(2, now, globalSendInterval, true)
+	// the same chat waits for its own slot and books nothing meanwhile
+	requireReserve(1, now, chatSendInterval, false)
+	requireReserve(3, now, 2*globalSendInterval, true)
+
+	requireReserve(-100, now.Add(time.Hour), 0, true)
+	requireReserve(-100, now.Add(time.Hour), groupSendInterval, false)
+
+	limiter.delay(4, now.Add(2*time.Hour+time.Minute))
+	requireReserve(4, now.Add(2*time.Hour), time.Minute, false)
+}
+
+func TestClassifySendError(t *testing.T) {
+	retryAfter, retry := classifySendError(&TelegramAPIError{TelegramErrorCode: 429, RetryAfter: 7 * time.Second})
+	require.True(t, retry)
+	require.Equal(t, 7*time.Second, retryAfter)
+
+	retryAfter, retry = classifySendError(&TelegramAPIError{StatusCode: 502})
+	require.True(t, retry)
+	require.Zero(t, retryAfter)
+
+	_, retry = classifySendError(&TelegramAPIError{TelegramErrorCode: 403, StatusCode: 403})
+	require.False(t, retry)
+	_, retry = classifySendError(&TelegramAPIError{TelegramErrorCode: 400, StatusCode: 400})
+	require.False(t, retry)
+
+	_, retry = classifySendError(errors.New("connection reset by peer"))
+	require.True(t, retry)
+}
+
+func TestSendBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		backoff := sendBackoff(attempt)
+		require.GreaterOrEqual(t, backoff, minSendBackoff/2, attempt)
+		require.LessOrEqual(t, backoff, maxSendBackoff, attempt)
+	}
+}
+
+func TestSender_CallWithRetry(t *testing.T) {
+	var slept time.Duration
+	s := &sender{
+		limiter: newRateLimiter(),
+		sleep: func(ctx context.Context, d time.Duration) error {
+			slept += d
+			return ctx.Err()
+		},
+	}
+
+	calls := 0
+	err := s.callWithRetry(context.Background(), 1, func() error {
+		calls++
+		if calls == 1 {
+			return &TelegramAPIError{TelegramErrorCode: 429, RetryAfter: 5 * time.Second}
+		}
+		if calls == 2 {
+			return &TelegramAPIError{StatusCode: 500}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Equal(t, int64(2), s.stats().Retried)
+	require.GreaterOrEqual(t, slept, 4*time.Second)
+
+	calls = 0
+	err = s.callWithRetry(context.Background(), 2, func() error {
+		calls++
+		return &TelegramAPIError{TelegramErrorCode: 403, StatusCode: 403}
+	})
+	require.Error(t, err)
+	require.Equal(t, 1, calls, "403 must not be retried")
+
+	calls = 0
+	err = s.callWithRetry(context.Background(), 3, func() error {
+		calls++
+		return &TelegramAPIError{StatusCode: 503}
+	})
+	require.Error(t, err)
+	require.Equal(t, maxSendAttempts, calls)
+
+	// the retries stop once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	calls = 0
+	err = s.callWithRetry(ctx, 4, func() error {
+		calls++
+		cancel()
+		return &TelegramAPIError{StatusCode: 503}
+	})
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, 1, calls)
+}
+
+func TestSender_ThrottledChatDelaysNobody(t *testing.T) {
+	dir := t.TempDir()
+	box := newOutbox(filepath.Join(dir, "outbox"), filepath.Join(dir, "outbox_dead"))
+	require.NoError(t, box.load())
+
+	delivered := make(chan int64, 10)
+	s := &sender{limiter: newRateLimiter(), sleep: sleepContext}
+	s.deliver = func(ctx context.Context, item OutboxItem) ([]int64, error) {
+		err := s.callWithRetry(ctx, item.ChatID, func() error {
+			if item.ChatID == -100 {
+				return &TelegramAPIError{TelegramErrorCode: 429, RetryAfter: time.Hour}
+			}
+			delivered <- item.ChatID
+			return nil
+		})
+		return []int64{item.ID}, err
+	}
+
+	// the throttled group goes first
+	box.add(OutboxItem{ChatID: -100, Text: "group"})
+	box.add(OutboxItem{ChatID: 1, Text: "first"})
+	box.add(OutboxItem{ChatID: 2, Text: "second"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.run(ctx, box)
+	}()
+
+	received := make(map[int64]bool)
+	for len(received) < 2 {
+		select {
+		case chatID := <-delivered:
+			received[chatID] = true
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "the other chats must not wait for the throttled one")
+		}
+	}
+	require.Equal(t, map[int64]bool{1: true, 2: true}, received)
+
+	// a new message goes out while the group is still waiting
+	box.add(OutboxItem{ChatID: 1, Text: "third"})
+	select {
+	case chatID := <-delivered:
+		require.Equal(t, int64(1), chatID)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the new message must be sent")
+	}
+
+	cancel()
+	<-done
+	require.Equal(t, 1, box.depth(), "the message to the group is kept for the next start")
+	item, ok := box.nextForChat(-100)
+	require.True(t, ok)
+	require.Equal(t, "group", item.Text)
+}
+
+func TestSender_EditFallback(t *testing.T) {
+	useTestStorage(t, nil)
+	dir := t.TempDir()
+	boxDir, deadDir := filepath.Join(dir, "outbox"), filepath.Join(dir, "outbox_dead")
+	box := newOutbox(boxDir, deadDir)
+	require.NoError(t, box.load())
+
+	s := &sender{limiter: newRateLimiter(), sleep: sleepContext}
+	s.deliver = func(ctx context.Context, item OutboxItem) ([]int64, error) {
+		return nil, &TelegramAPIError{TelegramErrorCode: 400, StatusCode: 400, TelegramDescription: "Bad Request: message to edit not found"}
+	}
+
+	box.add(OutboxItem{
+		ChatID:        1,
+		Text:          "edited",
+		EditMessageID: 10,
+		Fallback:      &OutboxItem{ChatID: 1, Text: "new", NotifiedFlats: []NotifiedFlat{{ID: 12, Price: 100}}},
+	})
+
+	// the queued edit survives the restart with its fallback
+	restarted := newOutbox(boxDir, deadDir)
+	require.NoError(t, restarted.load())
+	item, ok := restarted.nextForChat(1)
+	require.True(t, ok)
+	require.Equal(t, "new", item.Fallback.Text)
+
+	// the failed edit is replaced by a new notification instead of going to the dead letters
+	s.send(context.Background(), restarted, item)
+	require.Empty(t, restarted.deadLetters())
+	require.Zero(t, s.stats().Dropped)
+	require.Equal(t, 1, restarted.depth())
+	item, _ = restarted.nextForChat(1)
+	require.Equal(t, "new", item.Text)
+	require.Zero(t, item.EditMessageID)
+	require.Equal(t, []NotifiedFlat{{ID: 12, Price: 100}}, item.NotifiedFlats)
+}
+
+func TestSleepContext(t *testing.T) {
+	require.NoError(t, sleepContext(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	require.ErrorIs(t, sleepContext(ctx, time.Hour), context.Canceled)
+	require.Less(t, time.Since(start), time.Second)
+}
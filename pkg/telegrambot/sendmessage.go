@@ -2,14 +2,16 @@ package telegrambot
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/georgri/pik_tg_bot/pkg/util"
-	"log"
+	"io"
 	"mime/multipart"
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 const (
@@ -21,29 +23,6 @@ const (
 // An example of how to send message with test bot:
 // i.e. https://api.telegram.org/bot{token}/sendMessage?chat_id={chat_id}&text={text}
 
-type telegramMessage struct {
-	chatID     int64
-	text       string
-	img        []byte
-	imgCaption string
-	mustPin    bool
-}
-
-var messageChannel chan telegramMessage
-
-func init() {
-	messageChannel = make(chan telegramMessage, 1024)
-	go func() {
-		// TODO: graceful degradation?
-		for msg := range messageChannel {
-			err := SendMessageWithPin(msg.chatID, msg.text, msg.img, msg.imgCaption, msg.mustPin)
-			if err != nil {
-				log.Printf("failed to send message to chatID %v: %v", msg.chatID, err)
-			}
-		}
-	}()
-}
-
 func SendTestMessage(text string) error {
 	return SendMessage(TestChatID, text)
 }
@@ -55,149 +34,128 @@ func SendMessage(chatID int64, text string) error {
 }
 
 func SendMessageWithPinAsync(chatID int64, text string, mustPin bool) {
-	SendMessageWithImgAsync(chatID, text, nil, "", mustPin)
+	SendMessageWithImgAsync(chatID, text, nil, "", mustPin, nil)
 }
 
-func SendMessageWithImgAsync(chatID int64, text string, img []byte, imgCaption string, mustPin bool) {
-	messageChannel <- telegramMessage{
-		chatID:     chatID,
-		text:       text,
-		mustPin:    mustPin,
-		img:        img,
-		imgCaption: imgCaption,
-	}
+// SendMessageWithImgAsync sends the message with the image after it, the keyboard is attached to the last text chunk
+func SendMessageWithImgAsync(chatID int64, text string, img []byte, imgCaption string, mustPin bool, keyboard *InlineKeyboardMarkup) {
+	defaultOutbox.add(OutboxItem{
+		ChatID:     chatID,
+		Text:       text,
+		MustPin:    mustPin,
+		Img:        img,
+		ImgCaption: imgCaption,
+		Keyboard:   keyboard,
+	})
+}
+
+// SendMessageWithKeyboardAsync sends the message with inline keyboard attached to the last chunk
+func SendMessageWithKeyboardAsync(chatID int64, text string, keyboard *InlineKeyboardMarkup) {
+	defaultOutbox.add(OutboxItem{
+		ChatID:   chatID,
+		Text:     text,
+		Keyboard: keyboard,
+	})
 }
 
-func SendMessageWithPin(chatID int64, text string, img []byte, imgCaption string, mustPin bool) error {
+// SendMessageWithPin sends the text split into chunks and returns the IDs of the sent chunks
+func SendMessageWithPin(ctx context.Context, chatID int64, text string, img []byte, imgCaption string, mustPin bool, keyboard *InlineKeyboardMarkup) ([]int64, error) {
 	token := util.GetBotToken()
 
 	chunks := SplitTextIntoSendableChunks(text)
 
 	var messageIDToDefer int64
+	messageIDs := make([]int64, 0, len(chunks))
 	for i, msg := range chunks {
-		messageID, err := sendMessageWithToken(token, chatID, msg)
-		if err != nil {
+		var chunkKeyboard *InlineKeyboardMarkup
+		if i == len(chunks)-1 {
+			chunkKeyboard = keyboard
+		}
+		var messageID int64
+		err := defaultSender.callWithRetry(ctx, chatID, func() error {
+			var err error
+			messageID, err = sendMessageWithToken(token, chatID, msg, chunkKeyboard)
 			return err
+		})
+		if err != nil {
+			return messageIDs, err
 		}
+		messageIDs = append(messageIDs, messageID)
 		if len(chunks) > 1 && i == 0 && mustPin {
 			messageIDToDefer = messageID
 		}
 	}
 
 	if len(img) > 0 {
-		err := sendImageWithToken(token, chatID, imgCaption, img)
+		err := defaultSender.callWithRetry(ctx, chatID, func() error {
+			return sendImageWithToken(token, chatID, imgCaption, img)
+		})
 		if err != nil {
-			return err
+			return messageIDs, err
 		}
 	}
 
 	if mustPin && messageIDToDefer != 0 {
-		err := PinMessage(token, chatID, messageIDToDefer)
+		err := defaultSender.callWithRetry(ctx, chatID, func() error {
+			return PinMessage(token, chatID, messageIDToDefer)
+		})
 		if err != nil {
-			return err
+			return messageIDs, err
 		}
 	}
 
-	return nil
-}
-
-type PinResponse struct {
-	OK          bool   `json:"ok"`
-	Result      bool   `json:"result"`
-	ErrorCode   int64  `json:"error_code"`
-	Description string `json:"description"`
+	return messageIDs, nil
 }
 
 func PinMessage(token string, chatID int64, messageID int64) error {
-	pinMessageUrl := fmt.Sprintf("https://api.telegram.org/bot%v/pinChatMessage", token)
-
 	values := url.Values{
 		"chat_id":    []string{fmt.Sprintf("%v", chatID)},
 		"message_id": []string{fmt.Sprintf("%v", messageID)},
 		//"disable_notification": []string{"False"},
 	}
 
-	// post http request
-	resp, err := http.PostForm(pinMessageUrl, values)
-	if err != nil {
-		return err
-	}
-
-	if resp.ContentLength < 0 {
-		return fmt.Errorf("can't read body because content len < 0: %v", resp.Request.URL)
-	}
-
-	// example of response:
-	// {"ok":true"}}
-	body := make([]byte, resp.ContentLength)
-	_, err = resp.Body.Read(body)
-	if err != nil {
-		return fmt.Errorf("error while reading Body: %v", resp.Request.URL)
-	}
-
-	pinResponse := &PinResponse{}
-	err = json.Unmarshal(body, pinResponse)
-	if err != nil {
-		return fmt.Errorf("error while unmarshalling Body: %v", string(body))
-	}
-
-	if !pinResponse.OK || !pinResponse.Result {
-		return fmt.Errorf("pin response is not OK: %v", string(body))
-	}
-
-	return nil
+	_, err := callTelegramMethod(token, "pinChatMessage", values)
+	return err
 }
 
-type SendResponse struct {
-	OK     bool `json:"ok"`
-	Result struct {
-		MessageId int64 `json:"message_id"`
-	} `json:"result"`
+type SendResult struct {
+	MessageId int64 `json:"message_id"`
 }
 
-func sendMessageWithToken(token string, chatID int64, text string) (int64, error) {
-
-	sendMessageUrl := fmt.Sprintf("https://api.telegram.org/bot%v/sendMessage", token)
-
+func sendMessageWithToken(token string, chatID int64, text string, keyboard *InlineKeyboardMarkup) (int64, error) {
 	values := url.Values{
 		"chat_id":                  []string{fmt.Sprintf("%v", chatID)},
 		"text":                     []string{text},
 		"parse_mode":               []string{"HTML"},
 		"disable_web_page_preview": []string{"True"},
 	}
-	// post http request
-	resp, err := http.PostForm(sendMessageUrl, values)
-	if err != nil {
-		return 0, err
-	}
-
-	if resp.ContentLength < 0 {
-		return 0, fmt.Errorf("can't read body because content len < 0: %v", resp.Request.URL)
+	if keyboard != nil {
+		replyMarkup, err := json.Marshal(keyboard)
+		if err != nil {
+			return 0, err
+		}
+		values.Set("reply_markup", string(replyMarkup))
 	}
 
-	// example of response:
-	// {"ok":true,"result":{"message_id":5,"sender_chat":{"id":-1002057808675,"title":"Pik checker bot tester","username":"pik_checker_bot_tester","type":"channel"},"chat":{"id":-1002057808675,"title":"Pik checker bot tester","username":"pik_checker_bot_tester","type":"channel"},"date":1701824824,"text":"hello_friend"}}
-	body := make([]byte, resp.ContentLength)
-	_, err = resp.Body.Read(body)
+	// example of result:
+	// {"message_id":5,"sender_chat":{"id":-1002057808675,"title":"Pik checker bot tester","username":"pik_checker_bot_tester","type":"channel"},"chat":{"id":-1002057808675,"title":"Pik checker bot tester","username":"pik_checker_bot_tester","type":"channel"},"date":1701824824,"text":"hello_friend"}
+	result, err := callTelegramMethod(token, "sendMessage", values)
 	if err != nil {
-		return 0, fmt.Errorf("error while reading Body: %v", resp.Request.URL)
+		return 0, err
 	}
 
-	sendResponse := &SendResponse{}
-	err = json.Unmarshal(body, sendResponse)
+	sendResult := &SendResult{}
+	err = json.Unmarshal(result, sendResult)
 	if err != nil {
-		return 0, fmt.Errorf("error while unmarshalling Body: %v", string(body))
+		return 0, fmt.Errorf("error while unmarshalling result: %v", string(result))
 	}
 
-	if !sendResponse.OK {
-		return 0, fmt.Errorf("send response is not OK: %v", string(body))
-	}
-
-	return sendResponse.Result.MessageId, nil
+	return sendResult.MessageId, nil
 }
 
 func sendImageWithToken(botToken string, chatID int64, caption string, imageData []byte) error {
 	url := fmt.Sprintf("https://api.telegram.org/bot%s/sendPhoto", botToken)
+	safeURL := telegramSafeMethodURL(botToken, "sendPhoto")
 
 	var body bytes.Buffer
 	writer := multipart.NewWriter(&body)
@@ -231,15 +189,98 @@ func sendImageWithToken(botToken string, chatID int64, caption string, imageData
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return fmt.Errorf("telegram sendPhoto request failed: url=%s; err=%w", safeURL, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("telegram API error while sending image: %s", resp.Status)
+	_, err = readTelegramResponse("sendPhoto", safeURL, resp)
+	return err
+}
+
+// EditMessageText replaces the text and the inline keyboard of the already sent message
+func EditMessageText(token string, chatID int64, messageID int64, text string, keyboard *InlineKeyboardMarkup) error {
+	values := url.Values{
+		"chat_id":                  []string{fmt.Sprintf("%v", chatID)},
+		"message_id":               []string{fmt.Sprintf("%v", messageID)},
+		"text":                     []string{text},
+		"parse_mode":               []string{"HTML"},
+		"disable_web_page_preview": []string{"True"},
+	}
+	if keyboard != nil {
+		replyMarkup, err := json.Marshal(keyboard)
+		if err != nil {
+			return err
+		}
+		values.Set("reply_markup", string(replyMarkup))
 	}
 
-	return nil
+	_, err := callTelegramMethod(token, "editMessageText", values)
+	return err
+}
+
+// AnswerCallbackQuery stops the loading animation on the pressed button and optionally shows a notification
+func AnswerCallbackQuery(token string, callbackQueryID string, text string) error {
+	values := url.Values{
+		"callback_query_id": []string{callbackQueryID},
+	}
+	if len(text) > 0 {
+		values.Set("text", text)
+	}
+
+	_, err := callTelegramMethod(token, "answerCallbackQuery", values)
+	return err
+}
+
+// callTelegramMethod posts the form to the bot API method and returns the raw "result" field
+func callTelegramMethod(token string, method string, values url.Values) (json.RawMessage, error) {
+	methodUrl := fmt.Sprintf("https://api.telegram.org/bot%v/%v", token, method)
+	safeURL := telegramSafeMethodURL(token, method)
+
+	resp, err := http.PostForm(methodUrl, values)
+	if err != nil {
+		return nil, fmt.Errorf("telegram %v request failed: url=%s; err=%w", method, safeURL, err)
+	}
+	defer resp.Body.Close()
+
+	return readTelegramResponse(method, safeURL, resp)
+}
+
+// readTelegramResponse returns the raw "result" field of the response or *TelegramAPIError
+func readTelegramResponse(method string, safeURL string, resp *http.Response) (json.RawMessage, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("telegram %v failed to read response body: url=%s; http=%s; err=%w", method, safeURL, resp.Status, err)
+	}
+
+	var tgResp struct {
+		Ok          bool            `json:"ok"`
+		Result      json.RawMessage `json:"result"`
+		ErrorCode   int             `json:"error_code"`
+		Description string          `json:"description"`
+		Parameters  struct {
+			RetryAfter      int   `json:"retry_after"`
+			MigrateToChatID int64 `json:"migrate_to_chat_id"`
+		} `json:"parameters"`
+	}
+	_ = json.Unmarshal(body, &tgResp)
+
+	if !tgResp.Ok {
+		return nil, &TelegramAPIError{
+			Method:              method,
+			Reason:              telegramReason(resp.StatusCode, tgResp.ErrorCode, tgResp.Description),
+			URL:                 safeURL,
+			StatusCode:          resp.StatusCode,
+			Status:              resp.Status,
+			ContentType:         resp.Header.Get("Content-Type"),
+			TelegramErrorCode:   tgResp.ErrorCode,
+			TelegramDescription: tgResp.Description,
+			BodySnippet:         telegramBodySnippet(body, 400),
+			RetryAfter:          time.Duration(tgResp.Parameters.RetryAfter) * time.Second,
+			MigrateToChatID:     tgResp.Parameters.MigrateToChatID,
+		}
+	}
+
+	return tgResp.Result, nil
 }
 
 func SplitTextIntoSendableChunks(text string) []string {
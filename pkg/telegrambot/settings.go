@@ -0,0 +1,86 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strings"
+)
+
+func UpdateSubscriberThresholds(chatID int64, slug string, thresholds *flatstorage.PriceDropThresholds) error {
+	return updateSubscription(chatID, slug, func(subscription *ChannelInfo) {
+		subscription.Thresholds = thresholds
+	})
+}
+
+// changeSettings handles /settings [slug] [thresholds], without a slug the thresholds are applied to all subscriptions
+func changeSettings(chatID int64, args string) {
+	subscriptions := GetChatSubscriptions(chatID)
+	if len(subscriptions) == 0 {
+		err := SendMessage(chatID, localize(chatID, MsgNoSubscriptions, ListCommand))
+		if err != nil {
+			log.Printf("failed to send no subscriptions message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	fields := strings.Fields(args)
+	slugs := util.SortedKeys(subscriptions)
+	if len(fields) > 0 {
+		if _, ok := subscriptions[util.EmbedSlug(fields[0])]; ok {
+			slugs, fields = []string{util.EmbedSlug(fields[0])}, fields[1:]
+		}
+	}
+
+	if len(fields) > 0 {
+		for _, slug := range slugs {
+			thresholds, err := flatstorage.ParsePriceDropThresholds(fields, subscriptions[slug].Thresholds)
+			if err != nil {
+				err = SendMessage(chatID, localize(chatID, MsgInvalidThresholds, escapeHTML(err.Error()), SettingsCommand, flatstorage.ThresholdsSyntax))
+				if err != nil {
+					log.Printf("failed to send invalid thresholds message to %v: %v", chatID, err)
+				}
+				return
+			}
+			if thresholds.IsDefault() {
+				thresholds = nil
+			}
+
+			err = UpdateSubscriberThresholds(chatID, slug, thresholds)
+			if err != nil {
+				log.Printf("failed to update thresholds of %v for %v: %v", chatID, slug, err)
+				err = SendMessage(chatID, localize(chatID, MsgSettingsUpdateFailed, slug, err))
+				if err != nil {
+					log.Printf("failed to send settings update failed message to %v: %v", chatID, err)
+				}
+				return
+			}
+		}
+		subscriptions = GetChatSubscriptions(chatID)
+	}
+
+	sendSettings(chatID, subscriptions, slugs)
+}
+
+func sendSettings(chatID int64, subscriptions map[string]ChannelInfo, slugs []string) {
+	lang := GetChatLang(chatID)
+	lines := make([]string, 0, len(slugs)+2)
+	lines = append(lines, util.Msg(lang, MsgSettingsHeader))
+	for _, slug := range slugs {
+		subscription := subscriptions[slug]
+		line := fmt.Sprintf("%v: %v", BlockSlugs[slug].Name, subscription.Thresholds.Format(lang))
+		if !subscription.Filter.IsEmpty() {
+			line += util.Msg(lang, MsgFilterInfo, escapeHTML(subscription.Filter.String()))
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, util.Msg(lang, MsgSettingsHelp,
+		flatstorage.ThresholdKeyPriceDrop, flatstorage.ThresholdKeyExtremePriceDrop, flatstorage.ThresholdKeyBelowAverage,
+		SettingsCommand, escapeHTML(flatstorage.ThresholdsSyntax)))
+
+	err := SendMessage(chatID, strings.Join(lines, "\n"))
+	if err != nil {
+		log.Printf("failed to send settings to %v: %v", chatID, err)
+	}
+}
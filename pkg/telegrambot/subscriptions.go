@@ -0,0 +1,162 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LastNotificationsFile = "data/last_notifications.json"
+
+	unsubAllConfirm = "confirm"
+	unsubAllCancel  = "cancel"
+)
+
+// LastNotification is the time the subscription was last notified at, shown by /mysubs
+type LastNotification struct {
+	ChatID    int64     `json:"chat_id"`
+	BlockSlug string    `json:"block_slug"`
+	Time      time.Time `json:"time"`
+}
+
+var (
+	lastNotificationsFile  = newEnvFile[LastNotification](LastNotificationsFile)
+	lastNotifications      = make(map[util.EnvType]map[string]LastNotification) // by subscriptionKey
+	lastNotificationsMutex sync.RWMutex
+)
+
+func init() {
+	for envType, notificationList := range lastNotificationsFile.load() {
+		lastNotifications[envType] = make(map[string]LastNotification, len(notificationList))
+		for _, notification := range notificationList {
+			lastNotifications[envType][subscriptionKey(notification.ChatID, notification.BlockSlug)] = notification
+		}
+	}
+}
+
+func subscriptionKey(chatID int64, slug string) string {
+	return fmt.Sprintf("%v_%v", chatID, slug)
+}
+
+// syncLastNotificationsToFile must be called with lastNotificationsMutex locked
+func syncLastNotificationsToFile() error {
+	notifications := make(map[util.EnvType][]LastNotification, len(lastNotifications))
+	for envtype, byKey := range lastNotifications {
+		for _, key := range util.SortedKeys(byKey) {
+			notifications[envtype] = append(notifications[envtype], byKey[key])
+		}
+	}
+	return lastNotificationsFile.write(notifications)
+}
+
+// recordNotification saves the time of the notification, the failure is only logged since the notification is already sent
+func recordNotification(chatID int64, slug string, at time.Time) {
+	lastNotificationsMutex.Lock()
+	defer lastNotificationsMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	if lastNotifications[envtype] == nil {
+		lastNotifications[envtype] = make(map[string]LastNotification)
+	}
+	lastNotifications[envtype][subscriptionKey(chatID, slug)] = LastNotification{ChatID: chatID, BlockSlug: slug, Time: at}
+	err := syncLastNotificationsToFile()
+	if err != nil {
+		log.Printf("failed to save the last notification of chat %v about %v: %v", chatID, slug, err)
+	}
+}
+
+func getLastNotification(chatID int64, slug string) (time.Time, bool) {
+	lastNotificationsMutex.RLock()
+	defer lastNotificationsMutex.RUnlock()
+	notification, ok := lastNotifications[util.GetEnvType()][subscriptionKey(chatID, slug)]
+	return notification.Time, ok
+}
+
+// renderMySubs lists the subscriptions of the chat with their filters, alert settings and unsub links
+func renderMySubs(chatID int64, subscriptions map[string]ChannelInfo, lang util.Lang, now time.Time) string {
+	slugs := util.SortedKeysByFunc(subscriptions, func(a, b string) bool {
+		return BlockSlugs[a].Name < BlockSlugs[b].Name
+	})
+
+	lines := make([]string, 0, 2*len(slugs)+2)
+	lines = append(lines, util.Msg(lang, MsgMySubsHeader, len(slugs)))
+	for _, slug := range slugs {
+		subscription := subscriptions[slug]
+		block, ok := BlockSlugs[slug]
+		if !ok {
+			block = BlockInfo{Slug: slug, Name: slug}
+		}
+
+		line := block.StringWithSub(true)
+		if !subscription.Filter.IsEmpty() {
+			line += util.Msg(lang, MsgFilterInfo, escapeHTML(subscription.Filter.String()))
+		}
+		lines = append(lines, line)
+
+		lastNotification := util.Msg(lang, MsgMySubsNotNotified)
+		if at, ok := getLastNotification(chatID, slug); ok {
+			lastNotification = util.Msg(lang, MsgMySubsNotifiedAgo, now.Sub(at).Round(time.Minute))
+		}
+		lines = append(lines, util.Msg(lang, MsgMySubsDetails, subscription.Thresholds.Format(lang), lastNotification))
+	}
+	lines = append(lines, util.Msg(lang, MsgMySubsFooter, SubscribeCommand, SettingsCommand, UnsubAllCommand))
+	return strings.Join(lines, "\n")
+}
+
+// sendMySubs handles /mysubs
+func sendMySubs(chatID int64) {
+	subscriptions := GetChatSubscriptions(chatID)
+	if len(subscriptions) == 0 {
+		err := SendMessage(chatID, localize(chatID, MsgNoSubscriptions, ListCommand))
+		if err != nil {
+			log.Printf("failed to send no subscriptions message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	err := SendMessage(chatID, renderMySubs(chatID, subscriptions, GetChatLang(chatID), time.Now()))
+	if err != nil {
+		log.Printf("failed to send subscriptions to %v: %v", chatID, err)
+	}
+}
+
+// prepareUnsubAll asks to confirm removing all the subscriptions of the chat
+func prepareUnsubAll(chatID int64) {
+	subscriptions := GetChatSubscriptions(chatID)
+	if len(subscriptions) == 0 {
+		err := SendMessage(chatID, localize(chatID, MsgNoSubscriptions, ListCommand))
+		if err != nil {
+			log.Printf("failed to send no subscriptions message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	keyboard := &InlineKeyboardMarkup{}
+	keyboard.AddRow(InlineKeyboardButton{
+		Text:         localize(chatID, MsgUnsubAllButton),
+		CallbackData: makeCallbackData(CallbackUnsubAll, unsubAllConfirm),
+	}, InlineKeyboardButton{
+		Text:         localize(chatID, MsgUnsubAllCancelButton),
+		CallbackData: makeCallbackData(CallbackUnsubAll, unsubAllCancel),
+	})
+	SendMessageWithKeyboardAsync(chatID, localize(chatID, MsgUnsubAllConfirm, len(subscriptions)), keyboard)
+}
+
+// confirmUnsubAll removes all the subscriptions of the chat or keeps them, returns the text for the callback answer
+func confirmUnsubAll(chatID int64, action string) string {
+	if action != unsubAllConfirm {
+		return localize(chatID, MsgUnsubAllCancelled)
+	}
+
+	removed, err := RemoveSubscribers(chatID, util.SortedKeys(GetChatSubscriptions(chatID)))
+	if err != nil {
+		log.Printf("failed to unsubscribe %v from all complexes: %v", chatID, err)
+		return localize(chatID, MsgSomethingWentWrong, err)
+	}
+	log.Printf("chat %v unsubscribed from all %v complexes", chatID, removed)
+	return localize(chatID, MsgUnsubAllDone, removed, ListCommand)
+}
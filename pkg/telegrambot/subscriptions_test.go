@@ -0,0 +1,95 @@
+package telegrambot
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveSubscribers(t *testing.T) {
+	useTestStorage(t, []ChannelInfo{
+		{ChatID: 1, BlockSlug: "2ngt"},
+		{ChatID: 2, BlockSlug: "2ngt"},
+		{ChatID: 1, BlockSlug: "utnv"},
+		{ChatID: 1, BlockSlug: "bnab"},
+	})
+	envtype := util.GetEnvType()
+
+	subscriptions := GetChatsSubscriptions([]int64{1, 2, 3})
+	require.Len(t, subscriptions[1], 3)
+	require.Len(t, subscriptions[2], 1)
+	require.NotNil(t, subscriptions[3])
+	require.Empty(t, subscriptions[3])
+
+	removed, err := RemoveSubscribers(1, []string{"2ngt", "utnv", "unknown"})
+	require.NoError(t, err)
+	require.Equal(t, 2, removed)
+	require.Equal(t, []ChannelInfo{{ChatID: 2, BlockSlug: "2ngt"}, {ChatID: 1, BlockSlug: "bnab"}}, ChannelIDs[envtype])
+
+	// written once with the result
+	channels, err := ReadChannelStorage(ChannelsFile)
+	require.NoError(t, err)
+	require.Equal(t, ChannelFileList(ChannelIDs[envtype]), channels.ChannelsMap[envtype.String()])
+
+	require.Error(t, RemoveSubscriber(1, "2ngt"))
+	require.NoError(t, RemoveSubscriber(1, "bnab"))
+	require.Empty(t, GetChatSubscriptions(1))
+}
+
+func TestSubscriptionsConcurrentChanges(t *testing.T) {
+	useTestStorage(t, []ChannelInfo{{ChatID: 1, BlockSlug: "2ngt"}})
+	envtype := util.GetEnvType()
+
+	// the sender migrates the group while the commands subscribe other chats
+	var wg sync.WaitGroup
+	for i := int64(0); i < 20; i++ {
+		wg.Add(2)
+		go func(chatID int64) {
+			defer wg.Done()
+			require.NoError(t, AddNewSubscriber(chatID, "utnv", nil))
+		}(100 + i)
+		go func() {
+			defer wg.Done()
+			_, err := MigrateSubscriptions(1, -1001)
+			require.NoError(t, err)
+			_ = getChannels()
+		}()
+	}
+	wg.Wait()
+
+	require.Len(t, getChannels(), 21)
+	require.True(t, CheckSubscribed(-1001, "2ngt"))
+	channels, err := ReadChannelStorage(ChannelsFile)
+	require.NoError(t, err)
+	require.Len(t, channels.ChannelsMap[envtype.String()], 21)
+}
+
+func TestRenderMySubs(t *testing.T) {
+	useTestStorage(t, nil)
+
+	now := time.Now()
+	filter, err := flatstorage.ParseFlatFilter([]string{"rooms=2"})
+	require.NoError(t, err)
+	subscriptions := map[string]ChannelInfo{
+		"2ngt": {ChatID: 42, BlockSlug: "2ngt", Filter: filter},
+	}
+	recordNotification(42, "2ngt", now.Add(-90*time.Minute))
+
+	msg := renderMySubs(42, subscriptions, util.DefaultLang, now)
+	require.Contains(t, msg, "Your subscriptions (1):")
+	require.Contains(t, msg, BlockSlugs["2ngt"].Name)
+	require.Contains(t, msg, "start=unsub_2ngt")
+	require.Contains(t, msg, "rooms=2")
+	require.Contains(t, msg, "last notification: 1h30m0s ago")
+	require.Contains(t, msg, "/unsub_all")
+
+	// the time survives the restart
+	notifications, err := lastNotificationsFile.read()
+	require.NoError(t, err)
+	require.Len(t, notifications[util.GetEnvType()], 1)
+	require.True(t, notifications[util.GetEnvType()][0].Time.Equal(now.Add(-90*time.Minute)))
+}
@@ -1,10 +1,12 @@
 package telegrambot
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/georgri/pik_tg_bot/pkg/util"
 )
@@ -24,6 +26,11 @@ type TelegramAPIError struct {
 
 	BodySnippet string
 
+	// RetryAfter is how long to wait before repeating the request, set for 429 responses
+	RetryAfter time.Duration
+	// MigrateToChatID is the new ID of the group upgraded to a supergroup
+	MigrateToChatID int64
+
 	// Debug-only, safe token metadata (never the token itself).
 	TokenInfo string
 
@@ -72,6 +79,63 @@ func (e *TelegramAPIError) Error() string {
 	return strings.Join(parts, "; ")
 }
 
+// isMessageNotModifiedError checks for the harmless editMessageText error when the new content is the same
+func isMessageNotModifiedError(err error) bool {
+	var apiErr *TelegramAPIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return strings.Contains(apiErr.TelegramDescription, "message is not modified")
+}
+
+// ChatErrorKind tells why the chat can't get messages anymore
+type ChatErrorKind int
+
+const (
+	ChatErrorNone     ChatErrorKind = iota
+	ChatErrorBlocked                // 403: the bot was blocked by the user or kicked from the group, the user is deactivated
+	ChatErrorNotFound               // 400: the chat was deleted
+	ChatErrorMigrated               // 400: the group was upgraded to a supergroup with a new ID
+)
+
+func (k ChatErrorKind) String() string {
+	switch k {
+	case ChatErrorBlocked:
+		return "blocked"
+	case ChatErrorNotFound:
+		return "chat_not_found"
+	case ChatErrorMigrated:
+		return "migrated"
+	default:
+		return "none"
+	}
+}
+
+// classifyChatError tells if the error is caused by the chat itself rather than by the message,
+// for the migrated chats the new chat ID is returned
+func classifyChatError(err error) (ChatErrorKind, int64) {
+	var apiErr *TelegramAPIError
+	if !errors.As(err, &apiErr) {
+		return ChatErrorNone, 0
+	}
+	if apiErr.MigrateToChatID != 0 {
+		return ChatErrorMigrated, apiErr.MigrateToChatID
+	}
+
+	code := apiErr.TelegramErrorCode
+	if code == 0 {
+		code = apiErr.StatusCode
+	}
+	switch {
+	case code == 403:
+		return ChatErrorBlocked, 0
+	case code == 400 && strings.Contains(strings.ToLower(apiErr.TelegramDescription), "chat not found"):
+		return ChatErrorNotFound, 0
+	default:
+		return ChatErrorNone, 0
+	}
+}
+
 func telegramBodySnippet(body []byte, maxLen int) string {
 	if maxLen <= 0 || len(body) == 0 {
 		return ""
@@ -0,0 +1,283 @@
+package telegrambot
+
+import (
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const WatchesFile = "data/watches.json"
+
+// WatchInfo is a single flat followed by a chat
+type WatchInfo struct {
+	ChatID    int64  `json:"chat_id"`
+	BlockSlug string `json:"block_slug"`
+	FlatID    int64  `json:"flat_id"`
+}
+
+var (
+	watchesFile  = newEnvFile[WatchInfo](WatchesFile)
+	Watches      = watchesFile.load()
+	watchesMutex sync.RWMutex
+)
+
+// syncWatchStorageToFile must be called with watchesMutex locked
+func syncWatchStorageToFile() error {
+	return watchesFile.write(Watches)
+}
+
+func AddWatch(chatID int64, slug string, flatID int64) error {
+	watchesMutex.Lock()
+	defer watchesMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	for _, watch := range Watches[envtype] {
+		if watch.ChatID == chatID && watch.FlatID == flatID {
+			return nil
+		}
+	}
+
+	Watches[envtype] = append(Watches[envtype], WatchInfo{
+		ChatID:    chatID,
+		BlockSlug: slug,
+		FlatID:    flatID,
+	})
+
+	err := syncWatchStorageToFile()
+	if err != nil {
+		Watches[envtype] = Watches[envtype][:len(Watches[envtype])-1]
+		return err
+	}
+	return nil
+}
+
+func RemoveWatch(chatID int64, flatID int64) error {
+	watchesMutex.Lock()
+	defer watchesMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	oldList := Watches[envtype]
+	newList := make([]WatchInfo, 0, len(oldList))
+	for _, watch := range oldList {
+		if watch.ChatID != chatID || watch.FlatID != flatID {
+			newList = append(newList, watch)
+		}
+	}
+	if len(newList) == len(oldList) {
+		return fmt.Errorf("chat %v was not watching flat %v", chatID, flatID)
+	}
+
+	Watches[envtype] = newList
+	err := syncWatchStorageToFile()
+	if err != nil {
+		Watches[envtype] = oldList
+		return err
+	}
+	return nil
+}
+
+// MigrateWatches moves the watches of the group upgraded to a supergroup to its new ID
+func MigrateWatches(oldChatID int64, newChatID int64) error {
+	watchesMutex.Lock()
+	defer watchesMutex.Unlock()
+
+	envtype := util.GetEnvType()
+	oldList := Watches[envtype]
+	newList := make([]WatchInfo, 0, len(oldList))
+	watched := make(map[int64]bool)
+	for _, watch := range oldList {
+		if watch.ChatID == newChatID {
+			watched[watch.FlatID] = true
+		}
+	}
+	var moved bool
+	for _, watch := range oldList {
+		if watch.ChatID == oldChatID {
+			moved = true
+			if watched[watch.FlatID] {
+				continue
+			}
+			watch.ChatID = newChatID
+		}
+		newList = append(newList, watch)
+	}
+	if !moved {
+		return nil
+	}
+
+	Watches[envtype] = newList
+	err := syncWatchStorageToFile()
+	if err != nil {
+		Watches[envtype] = oldList
+		return err
+	}
+	return nil
+}
+
+func GetChatWatches(chatID int64) []WatchInfo {
+	watchesMutex.RLock()
+	defer watchesMutex.RUnlock()
+
+	var res []WatchInfo
+	for _, watch := range Watches[util.GetEnvType()] {
+		if watch.ChatID == chatID {
+			res = append(res, watch)
+		}
+	}
+	return res
+}
+
+// getFlatWatchers returns the chats watching every flat
+func getFlatWatchers() map[int64][]int64 {
+	watchesMutex.RLock()
+	defer watchesMutex.RUnlock()
+
+	res := make(map[int64][]int64)
+	for _, watch := range Watches[util.GetEnvType()] {
+		res[watch.FlatID] = append(res[watch.FlatID], watch.ChatID)
+	}
+	return res
+}
+
+// splitSlugAndFlatID splits "zhiloi_raion_yaroslavskii_123" into the slug and the flat ID
+func splitSlugAndFlatID(slugAndFlatID string) (string, int64, error) {
+	split := strings.Split(slugAndFlatID, "_")
+	flatIDStr := split[len(split)-1]
+	slug, _ := strings.CutSuffix(slugAndFlatID, "_"+flatIDStr)
+
+	flatID, err := strconv.ParseInt(flatIDStr, 10, 64)
+	if err != nil {
+		return slug, 0, fmt.Errorf("failed to parse flatID %v from %v: %w", flatIDStr, slugAndFlatID, err)
+	}
+	return slug, flatID, nil
+}
+
+// findStoredFlat looks up the flat in the storage of the complex
+func findStoredFlat(slug string, flatID int64) (*flatstorage.Flat, error) {
+	return flatstorage.Store.History(slug, flatID)
+}
+
+func watchFlat(chatID int64, slugAndFlatID string) {
+	slug, flatID, err := splitSlugAndFlatID(strings.TrimSpace(slugAndFlatID))
+	if err != nil {
+		log.Printf("failed to watch flat for %v: %v", chatID, err)
+		sendWatches(chatID)
+		return
+	}
+
+	slug, err = validateSlug(chatID, slug, WatchCommand)
+	if err != nil {
+		log.Printf("failed to watch flat %v for %v: %v", slugAndFlatID, chatID, err)
+		return
+	}
+	embeddedSlug := util.EmbedSlug(slug)
+
+	flat, err := findStoredFlat(slug, flatID)
+	if err != nil {
+		log.Printf("failed to find flat %v: %v", slugAndFlatID, err)
+		return
+	}
+	if flat == nil {
+		err = SendMessage(chatID, localize(chatID, MsgFlatNotFound, flatID, slug))
+		if err != nil {
+			log.Printf("failed to send flat not found message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	err = AddWatch(chatID, embeddedSlug, flatID)
+	if err != nil {
+		log.Printf("failed to add watch of %v for %v: %v", slugAndFlatID, chatID, err)
+		err = SendMessage(chatID, localize(chatID, MsgWatchFailed, err))
+		if err != nil {
+			log.Printf("failed to send watch failed message to %v: %v", chatID, err)
+		}
+		return
+	}
+
+	lang := GetChatLang(chatID)
+	err = SendMessage(chatID, util.Msg(lang, MsgWatching, flat.BlockName, flat.StringWithOptions(lang), UnwatchCommand, embeddedSlug, flatID))
+	if err != nil {
+		log.Printf("failed to send watching message to %v: %v", chatID, err)
+	}
+}
+
+// unwatchFlat handles /unwatch_<slug>_<flatID>, plain /unwatch lists the watched flats
+func unwatchFlat(chatID int64, slugAndFlatID string) {
+	slugAndFlatID = strings.TrimSpace(slugAndFlatID)
+	if slugAndFlatID == "" {
+		sendWatches(chatID)
+		return
+	}
+
+	slug, flatID, err := splitSlugAndFlatID(slugAndFlatID)
+	if err != nil {
+		log.Printf("failed to unwatch flat for %v: %v", chatID, err)
+		sendWatches(chatID)
+		return
+	}
+
+	err = RemoveWatch(chatID, flatID)
+	if err != nil {
+		log.Printf("failed to unwatch flat %v for %v: %v", slugAndFlatID, chatID, err)
+		sendWatches(chatID)
+		return
+	}
+
+	err = SendMessage(chatID, localize(chatID, MsgUnwatched, flatID, WatchCommand, util.EmbedSlug(slug), flatID))
+	if err != nil {
+		log.Printf("failed to send unwatched message to %v: %v", chatID, err)
+	}
+}
+
+func sendWatches(chatID int64) {
+	watches := GetChatWatches(chatID)
+	msg := localize(chatID, MsgNoWatches, WatchCommand)
+	if len(watches) > 0 {
+		lines := make([]string, 0, len(watches))
+		for _, watch := range watches {
+			lines = append(lines, fmt.Sprintf("%v %v: /%v_%v_%v", BlockSlugs[watch.BlockSlug].Name, watch.FlatID,
+				UnwatchCommand, watch.BlockSlug, watch.FlatID))
+		}
+		msg = localize(chatID, MsgWatchesHeader) + "\n" + strings.Join(lines, "\n")
+	}
+	err := SendMessage(chatID, msg)
+	if err != nil {
+		log.Printf("failed to send watches to %v: %v", chatID, err)
+	}
+}
+
+// notifyWatchers sends the changes of the flats to the chats watching them
+func notifyWatchers(events []flatstorage.FlatEvent) {
+	if len(events) == 0 {
+		return
+	}
+	watchers := getFlatWatchers()
+
+	eventsByFlat := make(map[int64][]flatstorage.FlatEvent)
+	for _, event := range events {
+		if _, ok := watchers[event.Flat.ID]; ok {
+			eventsByFlat[event.Flat.ID] = append(eventsByFlat[event.Flat.ID], event)
+		}
+	}
+
+	for flatID, flatEvents := range eventsByFlat {
+		keyboard := flatsKeyboard([]flatstorage.Flat{flatEvents[0].Flat})
+		for _, chatID := range watchers[flatID] {
+			Notify(chatID, renderWatchEvents(flatEvents, GetChatLang(chatID)), keyboard)
+		}
+	}
+}
+
+func renderWatchEvents(events []flatstorage.FlatEvent, lang util.Lang) string {
+	flat := &events[0].Flat
+	lines := []string{util.Msg(lang, MsgWatchedFlatChanged, flat.BlockName), flat.StringWithOptions(lang)}
+	for i := range events {
+		lines = append(lines, "• "+events[i].Format(lang))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,97 @@
+package telegrambot
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/georgri/pik_tg_bot/pkg/flatstorage"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitSlugAndFlatID(t *testing.T) {
+	slug, flatID, err := splitSlugAndFlatID("zhiloi_raion_yaroslavskii_123")
+	require.NoError(t, err)
+	require.Equal(t, "zhiloi_raion_yaroslavskii", slug)
+	require.Equal(t, int64(123), flatID)
+
+	_, _, err = splitSlugAndFlatID("2ngt")
+	require.Error(t, err)
+}
+
+func TestAddRemoveWatch(t *testing.T) {
+	useTestStorage(t, nil)
+
+	envtype := util.GetEnvType()
+	oldWatches := Watches[envtype]
+	t.Cleanup(func() {
+		Watches[envtype] = oldWatches
+	})
+	Watches[envtype] = nil
+
+	require.NoError(t, AddWatch(1, "2ngt", 10))
+	require.NoError(t, AddWatch(1, "2ngt", 10))
+	require.NoError(t, AddWatch(2, "2ngt", 10))
+	require.NoError(t, AddWatch(1, "amur", 20))
+	require.Equal(t, []WatchInfo{{ChatID: 1, BlockSlug: "2ngt", FlatID: 10}, {ChatID: 1, BlockSlug: "amur", FlatID: 20}}, GetChatWatches(1))
+
+	stored, err := watchesFile.read()
+	require.NoError(t, err)
+	require.Equal(t, Watches[envtype], stored[envtype])
+
+	require.NoError(t, RemoveWatch(1, 10))
+	require.Error(t, RemoveWatch(1, 10))
+	require.Equal(t, map[int64][]int64{10: {2}, 20: {1}}, getFlatWatchers())
+
+	// the watches stay as they were if the file can't be written
+	watches := append([]WatchInfo(nil), Watches[envtype]...)
+	require.NoError(t, os.RemoveAll("data"))
+	require.Error(t, AddWatch(3, "2ngt", 30))
+	require.Error(t, RemoveWatch(2, 10))
+	require.Equal(t, watches, Watches[envtype])
+}
+
+func TestNotifyWatchers(t *testing.T) {
+	useTestStorage(t, nil)
+	outboxItems := useTestOutbox(t)
+
+	envtype := util.GetEnvType()
+	oldWatches := Watches[envtype]
+	t.Cleanup(func() {
+		Watches[envtype] = oldWatches
+	})
+	Watches[envtype] = []WatchInfo{
+		{ChatID: 1, BlockSlug: "2ngt", FlatID: 10},
+		{ChatID: 2, BlockSlug: "2ngt", FlatID: 10},
+		{ChatID: 2, BlockSlug: "2ngt", FlatID: 20},
+	}
+
+	notifyWatchers([]flatstorage.FlatEvent{
+		{Type: flatstorage.FlatEventPrice, Flat: flatstorage.Flat{ID: 10, BlockSlug: "2ngt"}, OldValue: "10000000", NewValue: "9000000"},
+		{Type: flatstorage.FlatEventStatus, Flat: flatstorage.Flat{ID: 10, BlockSlug: "2ngt"}, OldValue: "free", NewValue: "reserve"},
+		{Type: flatstorage.FlatEventGone, Flat: flatstorage.Flat{ID: 20, BlockSlug: "2ngt"}},
+		{Type: flatstorage.FlatEventGone, Flat: flatstorage.Flat{ID: 30, BlockSlug: "2ngt"}}, // nobody watches it
+	})
+
+	texts := make(map[int64][]string)
+	for _, item := range outboxItems() {
+		require.NotNil(t, item.Keyboard, item.Text)
+		texts[item.ChatID] = append(texts[item.ChatID], item.Text)
+	}
+	require.Len(t, texts, 2)
+
+	// both changes of the flat come in a single message
+	require.Len(t, texts[1], 1)
+	require.Equal(t, 2, strings.Count(texts[1][0], "• "))
+
+	require.Len(t, texts[2], 2)
+	gone := "• " + util.Msg(GetChatLang(2), flatstorage.MsgEventGone)
+	var goneCount int
+	for _, text := range texts[2] {
+		if strings.Contains(text, gone) {
+			goneCount++
+		}
+	}
+	require.Equal(t, 1, goneCount)
+}
@@ -0,0 +1,222 @@
+package telegrambot
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/georgri/pik_tg_bot/pkg/util"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// webhookSecretHeader is set by Telegram to the secret_token passed to setWebhook
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+	webhookMaxBodySize       = 1 << 20
+	webhookShutdownTimeout   = 5 * time.Second
+	webhookReadHeaderTimeout = 10 * time.Second
+)
+
+var (
+	// webhookRestartCooldown is the pause before the failed webhook server is listened again
+	webhookRestartCooldown = getUpdatesErrorCooldown
+)
+
+var (
+	// WebhookURL is the public https URL of the bot behind the reverse proxy; empty means long polling
+	WebhookURL    string
+	WebhookListen string
+	WebhookSecret string
+)
+
+func init() {
+	flag.StringVar(&WebhookURL, "webhook_url", "", "public URL to receive updates via webhook instead of long polling")
+	flag.StringVar(&WebhookListen, "webhook_listen", "127.0.0.1:8443", "address for the webhook HTTP server")
+	flag.StringVar(&WebhookSecret, "webhook_secret", "", "secret token to check in webhook requests (random if empty)")
+}
+
+func WebhookEnabled() bool {
+	return WebhookURL != ""
+}
+
+// WebhookHandler accepts Telegram updates and feeds them into the same pipeline as getUpdates
+type WebhookHandler struct {
+	Secret string
+	Wg     *sync.WaitGroup
+}
+
+func (h *WebhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secret := r.Header.Get(webhookSecretHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(h.Secret)) != 1 {
+		log.Printf("webhook request from %v with invalid secret token", r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBodySize))
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	update := &UpdateStruct{}
+	err = json.Unmarshal(body, update)
+	if err != nil {
+		log.Printf("failed to parse webhook update: %v; body=%q", err, telegramBodySnippet(body, 400))
+		http.Error(w, "invalid update", http.StatusBadRequest)
+		return
+	}
+
+	ProcessUpdates(&BotUpdatesStruct{Ok: true, Result: []*UpdateStruct{update}}, h.Wg)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ServeWebhookForever registers the webhook and serves updates until ctx is done,
+// falls back to long polling if the webhook address can't be listened on
+func ServeWebhookForever(ctx context.Context, wg *sync.WaitGroup) {
+	token := util.GetBotToken()
+
+	listener, err := net.Listen("tcp", WebhookListen)
+	if err != nil {
+		log.Printf("failed to listen for webhook on %v, falling back to getUpdates: %v", WebhookListen, err)
+		// getUpdates doesn't work while the webhook of the previous run is set
+		err = DeleteWebhook(token)
+		if err != nil {
+			log.Printf("failed to delete webhook: %v", err)
+		}
+		GetUpdatesForever(ctx, wg)
+		return
+	}
+
+	wg.Add(1)
+	defer wg.Done()
+
+	secret := WebhookSecret
+	if secret == "" {
+		secret, err = generateWebhookSecret()
+		if err != nil {
+			log.Printf("failed to generate webhook secret: %v", err)
+			_ = listener.Close()
+			return
+		}
+	}
+	handler := &WebhookHandler{Secret: secret, Wg: wg}
+
+	// the server accepts updates before they are requested from Telegram, so none of them is refused
+	served := make(chan struct{})
+	go func() {
+		runWebhookServer(ctx, listener, handler)
+		close(served)
+	}()
+
+	for {
+		err = SetWebhook(token, WebhookURL, secret)
+		if err == nil {
+			break
+		}
+		log.Printf("failed to set webhook: %v", err)
+		select {
+		case <-ctx.Done():
+			<-served
+			return
+		case <-time.After(getUpdatesErrorCooldown):
+		}
+	}
+	log.Printf("receiving updates via webhook %v on %v", WebhookURL, WebhookListen)
+
+	<-served
+
+	err = DeleteWebhook(token)
+	if err != nil {
+		log.Printf("failed to delete webhook: %v", err)
+	}
+}
+
+// runWebhookServer serves updates on the listener until ctx is done, the failed server is restarted on the same address
+func runWebhookServer(ctx context.Context, listener net.Listener, handler http.Handler) {
+	addr := listener.Addr().String()
+	for {
+		server := &http.Server{
+			Handler:           handler,
+			ReadHeaderTimeout: webhookReadHeaderTimeout,
+		}
+		serverErr := make(chan error, 1)
+		go func(listener net.Listener) {
+			serverErr <- server.Serve(listener)
+		}(listener)
+
+		select {
+		case <-ctx.Done():
+			shutdownWebhookServer(server)
+			return
+		case err := <-serverErr:
+			log.Printf("webhook server failed, restarting in %v: %v", webhookRestartCooldown, err)
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(webhookRestartCooldown):
+			}
+			var err error
+			listener, err = net.Listen("tcp", addr)
+			if err == nil {
+				break
+			}
+			log.Printf("failed to listen for webhook on %v: %v", addr, err)
+		}
+	}
+}
+
+func shutdownWebhookServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		log.Printf("failed to shutdown webhook server: %v", err)
+	}
+}
+
+// SetWebhook see https://core.telegram.org/bots/api#setwebhook
+func SetWebhook(token string, webhookURL string, secret string) error {
+	values := url.Values{
+		"url":             []string{webhookURL},
+		"secret_token":    []string{secret},
+		"allowed_updates": []string{allowedUpdates},
+	}
+	_, err := callTelegramMethod(token, "setWebhook", values)
+	return err
+}
+
+// DeleteWebhook see https://core.telegram.org/bots/api#deletewebhook
+func DeleteWebhook(token string) error {
+	_, err := callTelegramMethod(token, "deleteWebhook", url.Values{})
+	return err
+}
+
+// generateWebhookSecret returns a random secret, allowed characters are A-Z, a-z, 0-9, _ and -
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 32)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", fmt.Errorf("failed to read random bytes: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
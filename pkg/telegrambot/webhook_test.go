@@ -0,0 +1,98 @@
+package telegrambot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testWebhookSecret = "test_secret"
+
+func postWebhookUpdate(t *testing.T, method string, secret string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := &WebhookHandler{Secret: testWebhookSecret, Wg: &sync.WaitGroup{}}
+	req := httptest.NewRequest(method, "/", strings.NewReader(body))
+	if secret != "" {
+		req.Header.Set(webhookSecretHeader, secret)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWebhookHandler_ProcessesUpdate(t *testing.T) {
+	oldUpdateID := LatestKnownUpdateID
+	t.Cleanup(func() { LatestKnownUpdateID = oldUpdateID })
+
+	// plain text without commands, must not trigger any replies
+	body := `{"update_id":231999260,"message":{"message_id":5,"from":{"id":258990915,"is_bot":false,"first_name":"Georgy","language_code":"ru"},` +
+		`"chat":{"id":258990915,"first_name":"Georgy","type":"private"},"date":1716056923,"text":"привет!"}}`
+
+	rec := postWebhookUpdate(t, http.MethodPost, testWebhookSecret, body)
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, int64(231999260), LatestKnownUpdateID)
+}
+
+func TestWebhookHandler_RejectsInvalidRequests(t *testing.T) {
+	body := `{"update_id":1}`
+
+	rec := postWebhookUpdate(t, http.MethodPost, "", body)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = postWebhookUpdate(t, http.MethodPost, "wrong_secret", body)
+	require.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = postWebhookUpdate(t, http.MethodGet, testWebhookSecret, body)
+	require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = postWebhookUpdate(t, http.MethodPost, testWebhookSecret, "not a json")
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestRunWebhookServer_RestartsFailedServer(t *testing.T) {
+	oldCooldown := webhookRestartCooldown
+	t.Cleanup(func() { webhookRestartCooldown = oldCooldown })
+	webhookRestartCooldown = 10 * time.Millisecond
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	url := "http://" + listener.Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan struct{})
+	go func() {
+		runWebhookServer(ctx, listener, &WebhookHandler{Secret: testWebhookSecret, Wg: &sync.WaitGroup{}})
+		close(served)
+	}()
+
+	// a GET is refused by the handler without processing anything, every request opens a new connection
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	serverAnswers := func() bool {
+		resp, err := client.Get(url)
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusMethodNotAllowed
+	}
+	require.Eventually(t, serverAnswers, time.Second, 5*time.Millisecond)
+
+	// the server fails once its listener is closed and must be listening again on the same address
+	require.NoError(t, listener.Close())
+	require.Eventually(t, serverAnswers, time.Second, 5*time.Millisecond)
+
+	cancel()
+	select {
+	case <-served:
+	case <-time.After(time.Second):
+		t.Fatal("webhook server didn't stop after the context was done")
+	}
+	require.False(t, serverAnswers())
+}
@@ -0,0 +1,89 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+type Lang string
+
+const (
+	LangEn Lang = "en"
+	LangRu Lang = "ru"
+
+	DefaultLang = LangEn
+)
+
+// Langs are the languages with message bundles
+var Langs = []Lang{LangEn, LangRu}
+
+// MsgKey identifies a user-facing message in the catalog
+type MsgKey string
+
+var (
+	messageCatalog      = make(map[Lang]map[MsgKey]string)
+	messageCatalogMutex sync.RWMutex
+)
+
+// RegisterMessages adds the messages (fmt format strings, "%" must be escaped) to the bundle of the language,
+// every package registers its own messages in init()
+func RegisterMessages(lang Lang, messages map[MsgKey]string) {
+	messageCatalogMutex.Lock()
+	defer messageCatalogMutex.Unlock()
+
+	bundle, ok := messageCatalog[lang]
+	if !ok {
+		bundle = make(map[MsgKey]string, len(messages))
+		messageCatalog[lang] = bundle
+	}
+	for key, msg := range messages {
+		if _, exists := bundle[key]; exists {
+			panic(fmt.Sprintf("duplicate message %v for lang %v", key, lang))
+		}
+		bundle[key] = msg
+	}
+}
+
+// Msg renders the message in the language, falls back to DefaultLang and then to the key itself
+func Msg(lang Lang, key MsgKey, args ...any) string {
+	messageCatalogMutex.RLock()
+	format, ok := messageCatalog[lang][key]
+	if !ok {
+		format, ok = messageCatalog[DefaultLang][key]
+	}
+	messageCatalogMutex.RUnlock()
+
+	if !ok {
+		return string(key)
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// HasMessage checks if the bundle of the language has the message
+func HasMessage(lang Lang, key MsgKey) bool {
+	messageCatalogMutex.RLock()
+	defer messageCatalogMutex.RUnlock()
+	_, ok := messageCatalog[lang][key]
+	return ok
+}
+
+// ParseLang parses the language supported by the catalog, e.g. "RU" => LangRu
+func ParseLang(lang string) (Lang, bool) {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	for _, supported := range Langs {
+		if Lang(lang) == supported {
+			return supported, true
+		}
+	}
+	return "", false
+}
+
+// LangFromLanguageCode converts IETF language tag of a Telegram user into the supported language, e.g. "ru-RU" => LangRu
+func LangFromLanguageCode(languageCode string) Lang {
+	base, _, _ := strings.Cut(languageCode, "-")
+	if lang, ok := ParseLang(base); ok {
+		return lang
+	}
+	return DefaultLang
+}
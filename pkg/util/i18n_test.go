@@ -0,0 +1,40 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsg(t *testing.T) {
+	RegisterMessages(LangEn, map[MsgKey]string{
+		"test_hello": "Hello, %v!",
+		"test_only":  "only english",
+	})
+	RegisterMessages(LangRu, map[MsgKey]string{
+		"test_hello": "Привет, %v!",
+	})
+
+	require.Equal(t, "Hello, bot!", Msg(LangEn, "test_hello", "bot"))
+	require.Equal(t, "Привет, bot!", Msg(LangRu, "test_hello", "bot"))
+	require.Equal(t, "only english", Msg(LangRu, "test_only"))
+	require.Equal(t, "test_unknown", Msg(LangRu, "test_unknown"))
+
+	require.Panics(t, func() {
+		RegisterMessages(LangEn, map[MsgKey]string{"test_only": "again"})
+	})
+}
+
+func TestLangFromLanguageCode(t *testing.T) {
+	require.Equal(t, LangRu, LangFromLanguageCode("ru"))
+	require.Equal(t, LangRu, LangFromLanguageCode("ru-RU"))
+	require.Equal(t, LangEn, LangFromLanguageCode("en-US"))
+	require.Equal(t, DefaultLang, LangFromLanguageCode("de"))
+	require.Equal(t, DefaultLang, LangFromLanguageCode(""))
+
+	lang, ok := ParseLang(" RU ")
+	require.True(t, ok)
+	require.Equal(t, LangRu, lang)
+	_, ok = ParseLang("de")
+	require.False(t, ok)
+}